@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+// serviceConfig is loaded by config.Load from the environment and the
+// optional CONFIG_FILE.
+type serviceConfig struct {
+	Port int `env:"PORT" required:"true" validate:"port"`
+	// GRPCPort serves the gRPC API alongside the REST routes; 0 doesn't.
+	GRPCPort int    `env:"GRPC_PORT" validate:"port"`
+	DBHost   string `env:"DBHOST" required:"true" validate:"url" secret:"userinfo"`
+	DBName   string `env:"DBNAME" default:"video-streaming"`
+	// Auth verifies callers' bearer tokens; no keys leave the API open.
+	Auth auth.Config
+	// TLS, when a certificate is set; see serverTLS.
+	TLSCertFile     string `env:"TLS_CERT_FILE"`
+	TLSKeyFile      string `env:"TLS_KEY_FILE"`
+	TLSClientCAFile string `env:"TLS_CLIENT_CA_FILE"`
+	// TLSReloadInterval is how often the files are checked for a renewed
+	// certificate; 0 never reloads.
+	TLSReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" default:"1m"`
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/openapi"
+)
+
+// brokenStore fails every call.
+type brokenStore struct{}
+
+func (brokenStore) record(context.Context, viewedMessageBody) error { return errors.New(`broken`) }
+func (brokenStore) each(context.Context, string, int64, int64, func(viewedMessageBody) error) error {
+	return errors.New(`broken`)
+}
+func (brokenStore) stats(context.Context, string) ([]videoStat, error) {
+	return nil, errors.New(`broken`)
+}
+
+// signed returns an Authorization value with a token for user signed with
+// method, rather than the HS256 bearer uses.
+func signed(t *testing.T, method jwt.SigningMethod, user string) string {
+	t.Helper()
+	key := any([]byte(testSecret))
+	if method == jwt.SigningMethodNone {
+		key = jwt.UnsafeAllowNoneSignatureType
+	}
+	token, err := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Subject:   user,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return `Bearer ` + token
+}
+
+// TestContract sends the REST routes requests good and bad, checking every
+// response against openapi.json and that every operation in it is tried.
+func TestContract(t *testing.T) {
+	spec, err := openapi.Load(openAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	store := &memStore{}
+	store.record(context.Background(), viewedMessageBody{VideoPath: `old.mp4`})
+	handler := newHandler(log, testVerifier(t), spec, store)
+	broken := newHandler(log, testVerifier(t), spec, brokenStore{})
+	alice := bearer(t, `alice`)
+
+	tried := map[string]bool{}
+	for _, c := range []struct {
+		handler                             http.Handler
+		method, target, authorization, body string
+		want                                int
+		wantBody                            string
+	}{
+		{handler, `POST`, `/viewed`, alice, `{"videoPath": "a.mp4"}`, 200, ``},
+		{handler, `POST`, `/viewed`, alice, `{"videoPath": "b.mp4"}`, 200, ``},
+		{handler, `POST`, `/viewed`, alice, `{"videoPath": ""}`, 400, ``},
+		{handler, `POST`, `/viewed`, alice, `{}`, 400, ``},
+		{handler, `POST`, `/viewed`, alice, `not json`, 400, ``},
+		{handler, `POST`, `/viewed`, ``, `{"videoPath": "a.mp4"}`, 401, ``},
+		{broken, `POST`, `/viewed`, alice, `{"videoPath": "a.mp4"}`, 500, ``},
+		{handler, `GET`, `/history?skip=0&limit=0`, alice, ``, 200, `[{"videoPath":"a.mp4","userId":"alice"},{"videoPath":"b.mp4","userId":"alice"}]`},
+		{handler, `GET`, `/history?skip=1&limit=1`, alice, ``, 200, `[{"videoPath":"b.mp4","userId":"alice"}]`},
+		{handler, `GET`, `/history?skip=0&limit=0`, bearer(t, `bob`), ``, 200, `[]`},
+		{handler, `GET`, `/history?skip=0`, alice, ``, 400, ``},
+		{handler, `GET`, `/history?skip=-1&limit=0`, alice, ``, 400, ``},
+		{handler, `GET`, `/history?skip=0&limit=ten`, alice, ``, 400, ``},
+		{handler, `GET`, `/history?skip=0&limit=0`, `Bearer forged`, ``, 401, ``},
+		{handler, `GET`, `/history?skip=0&limit=0`, signed(t, jwt.SigningMethodHS512, `bob`), ``, 200, `[]`},
+		{handler, `GET`, `/history?skip=0&limit=0`, signed(t, jwt.SigningMethodNone, `alice`), ``, 401, ``},
+		{broken, `GET`, `/history?skip=0&limit=0`, alice, ``, 500, ``},
+		{handler, `GET`, `/openapi.json`, ``, ``, 200, ``},
+	} {
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		if c.body != `` {
+			req.Header.Set(`Content-Type`, `application/json`)
+		}
+		if c.authorization != `` {
+			req.Header.Set(`Authorization`, c.authorization)
+		}
+		w := httptest.NewRecorder()
+		c.handler.ServeHTTP(w, req)
+		resp := w.Result()
+
+		name := c.method + ` ` + c.target
+		if resp.StatusCode != c.want {
+			t.Errorf(`%s: %d, want %d (%s)`, name, resp.StatusCode, c.want, w.Body)
+			continue
+		}
+		if err := spec.CheckResponse(req, resp); err != nil {
+			t.Error(err)
+		}
+		if got := strings.TrimSpace(w.Body.String()); c.wantBody != `` && got != c.wantBody {
+			t.Errorf(`%s: body %s, want %s`, name, got, c.wantBody)
+		}
+		tried[c.method+` `+req.URL.Path] = true
+	}
+
+	for _, op := range spec.Operations() {
+		if !tried[op] {
+			t.Errorf(`%s: not tried`, op)
+		}
+	}
+}
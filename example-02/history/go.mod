@@ -1,8 +1,14 @@
 module bootstrapping-microservices-in-go/chapter-05/example-2/history
 
-go 1.23.0
+go 1.23.1
 
-require go.mongodb.org/mongo-driver v1.16.0
+require (
+	bootstrapping-microservices-in-go/chapter-05/example-2/historypb v0.0.0
+	bootstrapping-microservices-in-go/chapter-05/shared v0.0.0
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	go.mongodb.org/mongo-driver v1.16.0
+	google.golang.org/grpc v1.73.0
+)
 
 require (
 	github.com/golang/snappy v0.0.4 // indirect
@@ -12,7 +18,17 @@ require (
 	github.com/xdg-go/scram v1.1.2 // indirect
 	github.com/xdg-go/stringprep v1.0.4 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
-	golang.org/x/crypto v0.22.0 // indirect
-	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	go.opentelemetry.io/otel v1.37.0 // indirect
+	go.opentelemetry.io/otel/trace v1.37.0 // indirect
+	golang.org/x/crypto v0.39.0 // indirect
+	golang.org/x/net v0.41.0 // indirect
+	golang.org/x/sync v0.15.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250603155806-513f23925822 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
 )
+
+replace bootstrapping-microservices-in-go/chapter-05/example-2/historypb => ../historypb
+
+replace bootstrapping-microservices-in-go/chapter-05/shared => ../../shared
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-2/historypb"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+// newGRPCServer serves the history gRPC API over store, over TLS if
+// tlsConfig isn't nil, with the same tokens the REST routes take.
+func newGRPCServer(log *slog.Logger, verifier *auth.Verifier, tlsConfig *tls.Config, store viewStore) *grpc.Server {
+	var opts []grpc.ServerOption
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	if verifier != nil {
+		a := &grpcAuth{log: log, verifier: verifier}
+		opts = append(opts, grpc.UnaryInterceptor(a.unary), grpc.StreamInterceptor(a.stream))
+	}
+	s := grpc.NewServer(opts...)
+	historypb.RegisterHistoryServer(s, &historyServer{log: log, store: store})
+	return s
+}
+
+// historyServer implements the gRPC API.
+type historyServer struct {
+	historypb.UnimplementedHistoryServer
+	log   *slog.Logger
+	store viewStore
+}
+
+func (s *historyServer) RecordView(ctx context.Context, req *historypb.RecordViewRequest) (*historypb.RecordViewResponse, error) {
+	if req.GetVideoPath() == `` {
+		return nil, status.Error(codes.InvalidArgument, `video_path is required`)
+	}
+	view := viewedMessageBody{VideoPath: req.GetVideoPath(), UserID: auth.Subject(ctx)}
+	if err := s.store.record(ctx, view); err != nil {
+		s.log.Error(`RecordView.store.record`, `err`, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	s.log.Info(`add`, `videoPath`, view.VideoPath, `userId`, view.UserID)
+	return &historypb.RecordViewResponse{}, nil
+}
+
+func (s *historyServer) ListHistory(req *historypb.ListHistoryRequest, stream grpc.ServerStreamingServer[historypb.View]) error {
+	if req.GetSkip() < 0 || req.GetLimit() < 0 {
+		return status.Error(codes.InvalidArgument, `skip and limit must not be negative`)
+	}
+	ctx := stream.Context()
+	// Callers only ever see their own views.
+	err := s.store.each(ctx, auth.Subject(ctx), req.GetSkip(), req.GetLimit(), func(view viewedMessageBody) error {
+		return stream.Send(&historypb.View{VideoPath: view.VideoPath, UserId: view.UserID})
+	})
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		s.log.Error(`ListHistory.store.each`, `err`, err.Error())
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+func (s *historyServer) GetStats(ctx context.Context, _ *historypb.GetStatsRequest) (*historypb.Stats, error) {
+	// Like ListHistory, only the caller's own views are counted.
+	stats, err := s.store.stats(ctx, auth.Subject(ctx))
+	if err != nil {
+		s.log.Error(`GetStats.store.stats`, `err`, err.Error())
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp := &historypb.Stats{}
+	for _, stat := range stats {
+		resp.Total += stat.Views
+		resp.Videos = append(resp.Videos, &historypb.VideoStat{VideoPath: stat.VideoPath, Views: stat.Views})
+	}
+	return resp, nil
+}
+
+// grpcAuth checks the bearer token in each call's authorization metadata,
+// as auth.Middleware does the Authorization header, and puts its identity
+// in the call's context.
+type grpcAuth struct {
+	log      *slog.Logger
+	verifier *auth.Verifier
+}
+
+func (a *grpcAuth) authenticate(ctx context.Context) (context.Context, error) {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(`authorization`); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	token, found := strings.CutPrefix(authorization, `Bearer `)
+	if !found || token == `` {
+		return nil, status.Error(codes.Unauthenticated, `no bearer token`)
+	}
+	id, err := a.verifier.Verify(token)
+	if err != nil {
+		a.log.Info(`rejected token`, `err`, err.Error())
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return auth.WithIdentity(ctx, id), nil
+}
+
+func (a *grpcAuth) unary(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *grpcAuth) stream(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+// authenticatedStream is a stream whose context carries its caller.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-2/historypb"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+// memStore keeps views in memory.
+type memStore struct {
+	mu    sync.Mutex
+	views []viewedMessageBody
+}
+
+func (s *memStore) record(_ context.Context, view viewedMessageBody) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.views = append(s.views, view)
+	return nil
+}
+
+func (s *memStore) each(_ context.Context, user string, skip, limit int64, fn func(viewedMessageBody) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, view := range s.views {
+		if user != `` && view.UserID != user {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if err := fn(view); err != nil {
+			return err
+		}
+		if limit--; limit == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memStore) stats(_ context.Context, user string) ([]videoStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := map[string]int64{}
+	for _, view := range s.views {
+		if user != `` && view.UserID != user {
+			continue
+		}
+		counts[view.VideoPath]++
+	}
+	var stats []videoStat
+	for path, views := range counts {
+		stats = append(stats, videoStat{VideoPath: path, Views: views})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Views != stats[j].Views {
+			return stats[i].Views > stats[j].Views
+		}
+		return stats[i].VideoPath < stats[j].VideoPath
+	})
+	return stats, nil
+}
+
+const testSecret = `test-secret`
+
+// testVerifier accepts tokens signed with testSecret.
+func testVerifier(t *testing.T) *auth.Verifier {
+	t.Helper()
+	v, err := auth.NewVerifier(auth.Config{HMACSecret: testSecret, Leeway: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// serveGRPC runs the gRPC API over store and returns a client for it.
+func serveGRPC(t *testing.T, store viewStore) historypb.HistoryClient {
+	t.Helper()
+	lis, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newGRPCServer(slog.New(slog.NewTextHandler(io.Discard, nil)), testVerifier(t), nil, store)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return historypb.NewHistoryClient(conn)
+}
+
+// bearer returns an Authorization value with a token for user.
+func bearer(t *testing.T, user string) string {
+	t.Helper()
+	token, err := auth.Sign(testSecret, auth.Identity{Subject: user}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return `Bearer ` + token
+}
+
+// as returns a context whose calls carry a token for user.
+func as(t *testing.T, user string) context.Context {
+	t.Helper()
+	return metadata.AppendToOutgoingContext(context.Background(), `authorization`, bearer(t, user))
+}
+
+func list(ctx context.Context, client historypb.HistoryClient, skip, limit int64) ([]string, error) {
+	stream, err := client.ListHistory(ctx, &historypb.ListHistoryRequest{Skip: skip, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for {
+		view, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return paths, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, view.GetUserId()+`:`+view.GetVideoPath())
+	}
+}
+
+func TestGRPCHistory(t *testing.T) {
+	store := &memStore{}
+	client := serveGRPC(t, store)
+
+	for _, v := range []struct{ user, path string }{
+		{`alice`, `a.mp4`}, {`bob`, `b.mp4`}, {`alice`, `b.mp4`}, {`alice`, `c.mp4`}, {`bob`, `b.mp4`},
+	} {
+		if _, err := client.RecordView(as(t, v.user), &historypb.RecordViewRequest{VideoPath: v.path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Views are streamed back to their own viewer only.
+	got, err := list(as(t, `alice`), client, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`alice:a.mp4`, `alice:b.mp4`, `alice:c.mp4`}; !slices.Equal(got, want) {
+		t.Errorf(`ListHistory = %v, want %v`, got, want)
+	}
+	if got, err := list(as(t, `alice`), client, 1, 1); err != nil || !slices.Equal(got, []string{`alice:b.mp4`}) {
+		t.Errorf(`ListHistory skip 1 limit 1 = %v, %v`, got, err)
+	}
+	if _, err := list(as(t, `alice`), client, -1, 0); status.Code(err) != codes.InvalidArgument {
+		t.Errorf(`ListHistory skip -1: %v, want InvalidArgument`, err)
+	}
+
+	stats, err := client.GetStats(as(t, `bob`), &historypb.GetStatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stats count the caller's views only.
+	if stats.GetTotal() != 2 || len(stats.GetVideos()) != 1 ||
+		stats.GetVideos()[0].GetVideoPath() != `b.mp4` || stats.GetVideos()[0].GetViews() != 2 {
+		t.Errorf(`GetStats as bob = %v`, stats)
+	}
+	stats, err = client.GetStats(as(t, `alice`), &historypb.GetStatsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.GetTotal() != 3 || len(stats.GetVideos()) != 3 || stats.GetVideos()[0].GetVideoPath() != `a.mp4` {
+		t.Errorf(`GetStats as alice = %v`, stats)
+	}
+
+	if _, err := client.RecordView(as(t, `alice`), &historypb.RecordViewRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf(`RecordView without a path: %v, want InvalidArgument`, err)
+	}
+}
+
+func TestGRPCRequiresToken(t *testing.T) {
+	store := &memStore{}
+	client := serveGRPC(t, store)
+
+	if _, err := client.RecordView(context.Background(), &historypb.RecordViewRequest{VideoPath: `a.mp4`}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf(`RecordView without a token: %v, want Unauthenticated`, err)
+	}
+	forged := metadata.AppendToOutgoingContext(context.Background(), `authorization`, `Bearer not.a.token`)
+	if _, err := list(forged, client, 0, 0); status.Code(err) != codes.Unauthenticated {
+		t.Errorf(`ListHistory with a bad token: %v, want Unauthenticated`, err)
+	}
+	if len(store.views) != 0 {
+		t.Errorf(`recorded %v`, store.views)
+	}
+}
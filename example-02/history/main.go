@@ -2,16 +2,21 @@ package main
 
 import (
 	"context"
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/openapi"
 )
 
 const (
@@ -19,8 +24,15 @@ const (
 	contentType   = "Content-Type"
 )
 
+// openAPI describes the REST routes.
+//
+//go:embed openapi.json
+var openAPI []byte
+
 type viewedMessageBody struct {
 	VideoPath string `json:"videoPath" bson:"videoPath"`
+	// UserID is taken from the caller's token, never from the body.
+	UserID string `json:"userId,omitempty" bson:"userId,omitempty"`
 }
 
 func main() {
@@ -32,20 +44,70 @@ func main() {
 }
 
 func run(log *slog.Logger) error {
-	port := os.Getenv(`PORT`)
-	dbhost := os.Getenv(`DBHOST`)
-	dbname := os.Getenv(`DBNAME`)
+	var cfg serviceConfig
+	if err := config.Load(&cfg); err != nil {
+		return err
+	}
+	log.Info(`effective config`, `config`, config.Redacted(cfg))
 
 	// Connect to Mongo
 	// https://pkg.go.dev/go.mongodb.org/mongo-driver/mongo
 	clientOpts := options.Client().
-		ApplyURI(dbhost)
+		ApplyURI(cfg.DBHost)
 	client, err := mongo.Connect(context.TODO(), clientOpts)
 	if err != nil {
 		return fmt.Errorf(`failed to connect to MongoDB: %s`, err)
 	}
-	collection := client.Database(dbname).Collection(`history`)
+	store := &mongoStore{collection: client.Database(cfg.DBName).Collection(`history`)}
+
+	spec, err := openapi.Load(openAPI)
+	if err != nil {
+		return err
+	}
+
+	verifier, err := auth.NewVerifier(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	if verifier == nil {
+		log.Warn(`authentication disabled; set AUTH_HMAC_SECRET or AUTH_JWKS_FILE`)
+	}
+
+	tlsConfig, err := serverTLS(context.Background(), log, cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile, cfg.TLSReloadInterval)
+	if err != nil {
+		return fmt.Errorf(`failed to load TLS certificates: %s`, err)
+	}
+	srv := &http.Server{
+		Addr:      fmt.Sprintf(`:%d`, cfg.Port),
+		Handler:   newHandler(log, verifier, spec, store),
+		TLSConfig: tlsConfig,
+	}
+
+	// The gRPC API is served on a port of its own, alongside the REST routes.
+	errs := make(chan error, 2)
+	if cfg.GRPCPort != 0 {
+		lis, err := net.Listen(`tcp`, fmt.Sprintf(`:%d`, cfg.GRPCPort))
+		if err != nil {
+			return fmt.Errorf(`failed to listen for gRPC: %s`, err)
+		}
+		grpcServer := newGRPCServer(log, verifier, tlsConfig, store)
+		go func() { errs <- grpcServer.Serve(lis) }()
+	}
+
+	log.Info(`Microservice online!`, `tls`, tlsConfig != nil, `grpcPort`, cfg.GRPCPort)
+	go func() {
+		if tlsConfig != nil {
+			errs <- srv.ListenAndServeTLS(``, ``)
+			return
+		}
+		errs <- srv.ListenAndServe()
+	}()
+	return <-errs
+}
 
+// newHandler serves the REST routes over store, checking requests against
+// spec, which is served at /openapi.json for anyone to read.
+func newHandler(log *slog.Logger, verifier *auth.Verifier, spec *openapi.Document, store viewStore) http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc(`POST /viewed`, func(w http.ResponseWriter, r *http.Request) {
 		// use json.NewDecoder().Decode() to get videoPath
@@ -58,53 +120,42 @@ func run(log *slog.Logger) error {
 		}
 
 		// insertOne in history collection.
-		_, err = collection.InsertOne(r.Context(), bson.M{`videoPath`: messageBody.VideoPath})
+		messageBody.UserID = auth.Subject(r.Context())
+		err = store.record(r.Context(), messageBody)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			log.Error(`/viewed.collection.Insert`, `err`, err.Error())
+			log.Error(`/viewed.store.record`, `err`, err.Error())
 			return
 		}
 
-		log.Info(`add`, `videoPath`, messageBody.VideoPath)
+		log.Info(`add`, `videoPath`, messageBody.VideoPath, `userId`, messageBody.UserID)
 		w.WriteHeader(http.StatusOK)
 	})
 
 	mux.HandleFunc(`GET /history`, func(w http.ResponseWriter, r *http.Request) {
-		skip := r.FormValue(`skip`)
-		skipInt, err := strconv.Atoi(skip)
+		// spec.Validate has already answered 400 to a skip or limit that
+		// isn't a non-negative integer.
+		skipInt, _ := strconv.Atoi(r.FormValue(`skip`))
+		limitInt, _ := strconv.Atoi(r.FormValue(`limit`))
+
+		// Callers only ever see their own views.
+		history := []viewedMessageBody{}
+		err := store.each(r.Context(), auth.Subject(r.Context()), int64(skipInt), int64(limitInt), func(view viewedMessageBody) error {
+			history = append(history, view)
+			return nil
+		})
 		if err != nil {
-			w.WriteHeader(http.StatusNotAcceptable)
-			return
-		}
-		limit := r.FormValue(`limit`)
-		limitInt, err := strconv.Atoi(limit)
-		if err != nil {
-			w.WriteHeader(http.StatusNotAcceptable)
-			return
-		}
-
-		findOptions := options.Find().
-			SetSkip(int64(skipInt)).
-			SetLimit(int64(limitInt))
-
-		cursor, err := collection.Find(context.Background(), bson.D{}, findOptions)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Error(`/history.collection.Find`, `err`, err.Error())
-			return
-		}
-		defer cursor.Close(context.Background())
-
-		var history []viewedMessageBody
-		if err := cursor.All(context.Background(), &history); err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			log.Error(`/history.cursor.All`, `err`, err.Error())
+			log.Error(`/history.store.each`, `err`, err.Error())
 			return
 		}
 
+		w.Header().Set(contentType, `application/json`)
 		json.NewEncoder(w).Encode(history)
 	})
 
-	log.Info(`Microservice online!`)
-	return http.ListenAndServe(fmt.Sprintf(`:%s`, port), mux)
+	root := http.NewServeMux()
+	root.Handle(`GET /openapi.json`, spec)
+	root.Handle(`/`, auth.Middleware(log, verifier, spec.Validate(mux)))
+	return root
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// viewStore keeps the views history is told about. The REST routes and the
+// gRPC service both go through it.
+type viewStore interface {
+	// record adds a view.
+	record(ctx context.Context, view viewedMessageBody) error
+	// each calls fn with user's views, or everyone's if user is empty,
+	// oldest first, skipping skip and stopping after limit unless it is 0.
+	each(ctx context.Context, user string, skip, limit int64, fn func(viewedMessageBody) error) error
+	// stats counts user's views of each video, or everyone's if user is
+	// empty, most watched first.
+	stats(ctx context.Context, user string) ([]videoStat, error)
+}
+
+type videoStat struct {
+	VideoPath string `bson:"_id"`
+	Views     int64  `bson:"views"`
+}
+
+// mongoStore keeps views in a MongoDB collection.
+type mongoStore struct {
+	collection *mongo.Collection
+}
+
+func (s *mongoStore) record(ctx context.Context, view viewedMessageBody) error {
+	_, err := s.collection.InsertOne(ctx, view)
+	return err
+}
+
+func (s *mongoStore) each(ctx context.Context, user string, skip, limit int64, fn func(viewedMessageBody) error) error {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: `_id`, Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	filter := bson.D{}
+	if user != `` {
+		filter = bson.D{{Key: `userId`, Value: user}}
+	}
+	cursor, err := s.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var view viewedMessageBody
+		if err := cursor.Decode(&view); err != nil {
+			return err
+		}
+		if err := fn(view); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func (s *mongoStore) stats(ctx context.Context, user string) ([]videoStat, error) {
+	var pipeline mongo.Pipeline
+	if user != `` {
+		pipeline = append(pipeline, bson.D{{Key: `$match`, Value: bson.D{{Key: `userId`, Value: user}}}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: `$group`, Value: bson.D{
+			{Key: `_id`, Value: `$videoPath`},
+			{Key: `views`, Value: bson.D{{Key: `$sum`, Value: 1}}},
+		}}},
+		bson.D{{Key: `$sort`, Value: bson.D{{Key: `views`, Value: -1}, {Key: `_id`, Value: 1}}}},
+	)
+	cursor, err := s.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stats []videoStat
+	if err := cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
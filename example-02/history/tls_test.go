@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"slices"
+	"testing"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx/tlsxtest"
+)
+
+// Serving, mutual TLS and reloading are tested in tlsx; these check that
+// serverTLS asks it for the right thing.
+
+func TestServerTLS(t *testing.T) {
+	ca := tlsxtest.NewCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.Issue(t, dir, `history`, 2)
+	cfg, err := serverTLS(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), certFile, keyFile, ca.WriteCA(t, dir), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(cfg.NextProtos, `h2`) {
+		t.Errorf(`NextProtos = %v, want h2 offered`, cfg.NextProtos)
+	}
+	handshake, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handshake.ClientAuth != tls.RequireAndVerifyClientCert || handshake.ClientCAs == nil || len(handshake.Certificates) != 1 {
+		t.Errorf(`handshake config: client auth %v, CAs %v, %d certificates`, handshake.ClientAuth, handshake.ClientCAs, len(handshake.Certificates))
+	}
+}
+
+func TestServerTLSOff(t *testing.T) {
+	if cfg, err := serverTLS(context.Background(), slog.Default(), ``, ``, ``, 0); cfg != nil || err != nil {
+		t.Errorf(`serverTLS = %v, %v; want plain HTTP`, cfg, err)
+	}
+	if _, err := serverTLS(context.Background(), slog.Default(), ``, ``, `ca.crt`, 0); err == nil {
+		t.Error(`client CA without a certificate accepted`)
+	}
+}
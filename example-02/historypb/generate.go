@@ -0,0 +1,6 @@
+// Package historypb is the Go code generated from history.proto, shared by
+// the history microservice, which serves it, and video-streaming, which
+// calls it.
+package historypb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative history.proto
@@ -0,0 +1,444 @@
+// The history microservice's gRPC API, an alternative to its REST routes
+// with the same storage behind it. Calls carry the viewer's bearer token
+// in the authorization metadata, as the REST routes take it in the
+// Authorization header, and only ever see or record that viewer's views.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: history.proto
+
+package historypb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type View struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VideoPath     string                 `protobuf:"bytes,1,opt,name=video_path,json=videoPath,proto3" json:"video_path,omitempty"`
+	UserId        string                 `protobuf:"bytes,2,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *View) Reset() {
+	*x = View{}
+	mi := &file_history_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *View) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*View) ProtoMessage() {}
+
+func (x *View) ProtoReflect() protoreflect.Message {
+	mi := &file_history_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use View.ProtoReflect.Descriptor instead.
+func (*View) Descriptor() ([]byte, []int) {
+	return file_history_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *View) GetVideoPath() string {
+	if x != nil {
+		return x.VideoPath
+	}
+	return ""
+}
+
+func (x *View) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type RecordViewRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VideoPath     string                 `protobuf:"bytes,1,opt,name=video_path,json=videoPath,proto3" json:"video_path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordViewRequest) Reset() {
+	*x = RecordViewRequest{}
+	mi := &file_history_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordViewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordViewRequest) ProtoMessage() {}
+
+func (x *RecordViewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_history_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordViewRequest.ProtoReflect.Descriptor instead.
+func (*RecordViewRequest) Descriptor() ([]byte, []int) {
+	return file_history_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RecordViewRequest) GetVideoPath() string {
+	if x != nil {
+		return x.VideoPath
+	}
+	return ""
+}
+
+type RecordViewResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RecordViewResponse) Reset() {
+	*x = RecordViewResponse{}
+	mi := &file_history_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RecordViewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RecordViewResponse) ProtoMessage() {}
+
+func (x *RecordViewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_history_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RecordViewResponse.ProtoReflect.Descriptor instead.
+func (*RecordViewResponse) Descriptor() ([]byte, []int) {
+	return file_history_proto_rawDescGZIP(), []int{2}
+}
+
+type ListHistoryRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// skip views before streaming; limit them to at most limit, or all if 0.
+	Skip          int64 `protobuf:"varint,1,opt,name=skip,proto3" json:"skip,omitempty"`
+	Limit         int64 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListHistoryRequest) Reset() {
+	*x = ListHistoryRequest{}
+	mi := &file_history_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListHistoryRequest) ProtoMessage() {}
+
+func (x *ListHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_history_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListHistoryRequest.ProtoReflect.Descriptor instead.
+func (*ListHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_history_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListHistoryRequest) GetSkip() int64 {
+	if x != nil {
+		return x.Skip
+	}
+	return 0
+}
+
+func (x *ListHistoryRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type GetStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsRequest) Reset() {
+	*x = GetStatsRequest{}
+	mi := &file_history_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsRequest) ProtoMessage() {}
+
+func (x *GetStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_history_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return file_history_proto_rawDescGZIP(), []int{4}
+}
+
+type VideoStat struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VideoPath     string                 `protobuf:"bytes,1,opt,name=video_path,json=videoPath,proto3" json:"video_path,omitempty"`
+	Views         int64                  `protobuf:"varint,2,opt,name=views,proto3" json:"views,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VideoStat) Reset() {
+	*x = VideoStat{}
+	mi := &file_history_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VideoStat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VideoStat) ProtoMessage() {}
+
+func (x *VideoStat) ProtoReflect() protoreflect.Message {
+	mi := &file_history_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VideoStat.ProtoReflect.Descriptor instead.
+func (*VideoStat) Descriptor() ([]byte, []int) {
+	return file_history_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *VideoStat) GetVideoPath() string {
+	if x != nil {
+		return x.VideoPath
+	}
+	return ""
+}
+
+func (x *VideoStat) GetViews() int64 {
+	if x != nil {
+		return x.Views
+	}
+	return 0
+}
+
+type Stats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Total         int64                  `protobuf:"varint,1,opt,name=total,proto3" json:"total,omitempty"`
+	Videos        []*VideoStat           `protobuf:"bytes,2,rep,name=videos,proto3" json:"videos,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_history_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_history_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_history_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Stats) GetTotal() int64 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *Stats) GetVideos() []*VideoStat {
+	if x != nil {
+		return x.Videos
+	}
+	return nil
+}
+
+var File_history_proto protoreflect.FileDescriptor
+
+const file_history_proto_rawDesc = "" +
+	"\n" +
+	"\rhistory.proto\x12\n" +
+	"history.v1\">\n" +
+	"\x04View\x12\x1d\n" +
+	"\n" +
+	"video_path\x18\x01 \x01(\tR\tvideoPath\x12\x17\n" +
+	"\auser_id\x18\x02 \x01(\tR\x06userId\"2\n" +
+	"\x11RecordViewRequest\x12\x1d\n" +
+	"\n" +
+	"video_path\x18\x01 \x01(\tR\tvideoPath\"\x14\n" +
+	"\x12RecordViewResponse\">\n" +
+	"\x12ListHistoryRequest\x12\x12\n" +
+	"\x04skip\x18\x01 \x01(\x03R\x04skip\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x03R\x05limit\"\x11\n" +
+	"\x0fGetStatsRequest\"@\n" +
+	"\tVideoStat\x12\x1d\n" +
+	"\n" +
+	"video_path\x18\x01 \x01(\tR\tvideoPath\x12\x14\n" +
+	"\x05views\x18\x02 \x01(\x03R\x05views\"L\n" +
+	"\x05Stats\x12\x14\n" +
+	"\x05total\x18\x01 \x01(\x03R\x05total\x12-\n" +
+	"\x06videos\x18\x02 \x03(\v2\x15.history.v1.VideoStatR\x06videos2\xd5\x01\n" +
+	"\aHistory\x12K\n" +
+	"\n" +
+	"RecordView\x12\x1d.history.v1.RecordViewRequest\x1a\x1e.history.v1.RecordViewResponse\x12A\n" +
+	"\vListHistory\x12\x1e.history.v1.ListHistoryRequest\x1a\x10.history.v1.View0\x01\x12:\n" +
+	"\bGetStats\x12\x1b.history.v1.GetStatsRequest\x1a\x11.history.v1.StatsBBZ@bootstrapping-microservices-in-go/chapter-05/example-2/historypbb\x06proto3"
+
+var (
+	file_history_proto_rawDescOnce sync.Once
+	file_history_proto_rawDescData []byte
+)
+
+func file_history_proto_rawDescGZIP() []byte {
+	file_history_proto_rawDescOnce.Do(func() {
+		file_history_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_history_proto_rawDesc), len(file_history_proto_rawDesc)))
+	})
+	return file_history_proto_rawDescData
+}
+
+var file_history_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_history_proto_goTypes = []any{
+	(*View)(nil),               // 0: history.v1.View
+	(*RecordViewRequest)(nil),  // 1: history.v1.RecordViewRequest
+	(*RecordViewResponse)(nil), // 2: history.v1.RecordViewResponse
+	(*ListHistoryRequest)(nil), // 3: history.v1.ListHistoryRequest
+	(*GetStatsRequest)(nil),    // 4: history.v1.GetStatsRequest
+	(*VideoStat)(nil),          // 5: history.v1.VideoStat
+	(*Stats)(nil),              // 6: history.v1.Stats
+}
+var file_history_proto_depIdxs = []int32{
+	5, // 0: history.v1.Stats.videos:type_name -> history.v1.VideoStat
+	1, // 1: history.v1.History.RecordView:input_type -> history.v1.RecordViewRequest
+	3, // 2: history.v1.History.ListHistory:input_type -> history.v1.ListHistoryRequest
+	4, // 3: history.v1.History.GetStats:input_type -> history.v1.GetStatsRequest
+	2, // 4: history.v1.History.RecordView:output_type -> history.v1.RecordViewResponse
+	0, // 5: history.v1.History.ListHistory:output_type -> history.v1.View
+	6, // 6: history.v1.History.GetStats:output_type -> history.v1.Stats
+	4, // [4:7] is the sub-list for method output_type
+	1, // [1:4] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_history_proto_init() }
+func file_history_proto_init() {
+	if File_history_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_history_proto_rawDesc), len(file_history_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_history_proto_goTypes,
+		DependencyIndexes: file_history_proto_depIdxs,
+		MessageInfos:      file_history_proto_msgTypes,
+	}.Build()
+	File_history_proto = out.File
+	file_history_proto_goTypes = nil
+	file_history_proto_depIdxs = nil
+}
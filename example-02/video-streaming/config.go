@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+// serviceConfig is loaded by config.Load from the environment and the
+// optional CONFIG_FILE.
+type serviceConfig struct {
+	Port       int    `env:"PORT" required:"true" validate:"port"`
+	HistoryURL string `env:"HISTORY_URL" default:"http://history" validate:"url"`
+	// HistoryGRPCAddr, when set, sends views to history's gRPC API instead.
+	HistoryGRPCAddr string `env:"HISTORY_GRPC_ADDR"`
+	// Auth verifies viewers' bearer tokens; no keys leave GET /video open.
+	Auth auth.Config
+	// TLS for calls to history, when a certificate is set; see clientTLS.
+	TLSCertFile string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  string `env:"TLS_KEY_FILE"`
+	TLSCAFile   string `env:"TLS_CA_FILE"`
+	// TLSReloadInterval is how often the files are checked for a renewed
+	// certificate; 0 never reloads.
+	TLSReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" default:"1m"`
+}
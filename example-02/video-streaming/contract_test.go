@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/openapi"
+)
+
+// fakeRecorder collects the views it is told about.
+type fakeRecorder struct {
+	videoPaths []string
+}
+
+func (f *fakeRecorder) sendViewedMessage(_ *slog.Logger, videoPath, _ string) {
+	f.videoPaths = append(f.videoPaths, videoPath)
+}
+
+// TestContract checks every response against openapi.json, and that every
+// operation in it is tried.
+func TestContract(t *testing.T) {
+	spec, err := openapi.Load(openAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	recorder := &fakeRecorder{}
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: `test-secret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.Sign(`test-secret`, auth.Identity{Subject: `alice`}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := newHandler(log, verifier, spec, recorder)
+
+	tried := map[string]bool{}
+	authorization := `Bearer ` + token
+	try := func(target string, want int) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if authorization != `` {
+			req.Header.Set(`Authorization`, authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf(`GET %s: %d, want %d`, target, w.Code, want)
+			return
+		}
+		if err := spec.CheckResponse(req, w.Result()); err != nil {
+			t.Error(err)
+		}
+		tried[`GET `+target] = true
+	}
+
+	try(`/video`, http.StatusOK)
+	if len(recorder.videoPaths) != 1 {
+		t.Errorf(`recorded %v, want one view`, recorder.videoPaths)
+	}
+	try(`/openapi.json`, http.StatusOK)
+
+	// Viewers need a token, and aren't counted without one.
+	authorization = ``
+	try(`/video`, http.StatusUnauthorized)
+	authorization = `Bearer forged`
+	try(`/video`, http.StatusUnauthorized)
+	if len(recorder.videoPaths) != 1 {
+		t.Errorf(`recorded %v after refusals, want one view`, recorder.videoPaths)
+	}
+	authorization = `Bearer ` + token
+
+	// Without the videos directory there is nothing to stream.
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(dir)
+	try(`/video`, http.StatusNotFound)
+
+	for _, op := range spec.Operations() {
+		if !tried[op] {
+			t.Errorf(`%s: not tried`, op)
+		}
+	}
+}
+
+// TestViewedMessageContract checks that POST /viewed, as sent to history,
+// keeps to history's openapi.json.
+func TestViewedMessageContract(t *testing.T) {
+	data, err := os.ReadFile(`../history/openapi.json`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := openapi.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reached := make(chan bool, 1)
+	history := httptest.NewServer(spec.Validate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached <- r.URL.Path == `/viewed`
+	})))
+	defer history.Close()
+
+	client := &historyClient{url: history.URL, client: history.Client()}
+	client.sendViewedMessage(slog.New(slog.NewTextHandler(io.Discard, nil)), `./videos/a.mp4`, `Bearer token`)
+	select {
+	case ok := <-reached:
+		if !ok {
+			t.Error(`request went to the wrong route`)
+		}
+	default:
+		t.Error(`history's spec rejected POST /viewed`)
+	}
+}
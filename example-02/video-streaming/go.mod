@@ -1,3 +1,24 @@
 module bootstrapping-microservices-in-go/chapter-05/example-2/video-streaming
 
-go 1.23.0
+go 1.23.1
+
+require (
+	bootstrapping-microservices-in-go/chapter-05/example-2/historypb v0.0.0
+	bootstrapping-microservices-in-go/chapter-05/shared v0.0.0
+	google.golang.org/grpc v1.73.0
+)
+
+require (
+	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
+	go.opentelemetry.io/otel v1.37.0 // indirect
+	go.opentelemetry.io/otel/trace v1.37.0 // indirect
+	golang.org/x/net v0.41.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
+	golang.org/x/text v0.26.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250603155806-513f23925822 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
+)
+
+replace bootstrapping-microservices-in-go/chapter-05/example-2/historypb => ../historypb
+
+replace bootstrapping-microservices-in-go/chapter-05/shared => ../../shared
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-2/historypb"
+)
+
+// viewRecorder tells the history microservice about a view.
+type viewRecorder interface {
+	sendViewedMessage(log *slog.Logger, videoPath, authorization string)
+}
+
+// recordTimeout bounds a RecordView call.
+const recordTimeout = 10 * time.Second
+
+// dialHistory connects to history's gRPC API at addr, over TLS if tlsConfig
+// isn't nil. Calls are only made once a view needs recording.
+func dialHistory(addr string, tlsConfig *tls.Config) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+}
+
+// grpcHistory records views with history's RecordView rather than
+// POST /viewed.
+type grpcHistory struct {
+	client historypb.HistoryClient
+}
+
+// The viewer's Authorization header is passed along as metadata so history
+// records the view against them.
+func (h *grpcHistory) sendViewedMessage(log *slog.Logger, videoPath, authorization string) {
+	ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+	defer cancel()
+	if authorization != `` {
+		ctx = metadata.AppendToOutgoingContext(ctx, `authorization`, authorization)
+	}
+
+	_, err := h.client.RecordView(ctx, &historypb.RecordViewRequest{VideoPath: videoPath})
+	if err != nil {
+		log.Error(`Failed to record view!`, `err`, err.Error())
+		return
+	}
+	log.Info(`Recorded view with history microservice.`)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-2/historypb"
+)
+
+// fakeHistory records the RecordView calls it gets.
+type fakeHistory struct {
+	historypb.UnimplementedHistoryServer
+	calls chan recorded
+}
+
+type recorded struct {
+	videoPath, authorization string
+}
+
+func (f *fakeHistory) RecordView(ctx context.Context, req *historypb.RecordViewRequest) (*historypb.RecordViewResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	call := recorded{videoPath: req.GetVideoPath()}
+	if values := md.Get(`authorization`); len(values) > 0 {
+		call.authorization = values[0]
+	}
+	f.calls <- call
+	return &historypb.RecordViewResponse{}, nil
+}
+
+func TestGRPCHistory(t *testing.T) {
+	lis, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeHistory{calls: make(chan recorded, 2)}
+	s := grpc.NewServer()
+	historypb.RegisterHistoryServer(s, fake)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := dialHistory(lis.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	history := &grpcHistory{client: historypb.NewHistoryClient(conn)}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	history.sendViewedMessage(log, `./videos/a.mp4`, `Bearer token`)
+	history.sendViewedMessage(log, `./videos/b.mp4`, ``)
+	if got, want := <-fake.calls, (recorded{`./videos/a.mp4`, `Bearer token`}); got != want {
+		t.Errorf(`RecordView got %+v, want %+v`, got, want)
+	}
+	if got, want := <-fake.calls, (recorded{`./videos/b.mp4`, ``}); got != want {
+		t.Errorf(`RecordView got %+v, want %+v`, got, want)
+	}
+}
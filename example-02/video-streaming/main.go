@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	_ "embed"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +11,11 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-2/historypb"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/openapi"
 )
 
 const (
@@ -16,6 +23,11 @@ const (
 	contentType   = "Content-Type"
 )
 
+// openAPI describes the routes.
+//
+//go:embed openapi.json
+var openAPI []byte
+
 type viewedMessageBody struct {
 	VideoPath string `json:"videoPath"`
 }
@@ -29,13 +41,53 @@ func main() {
 }
 
 func run(log *slog.Logger) error {
-	port, found := os.LookupEnv(`PORT`)
-	if !found {
-		return fmt.Errorf(`Please specify the port number for the HTTP server with the environment variable PORT.`)
+	var cfg serviceConfig
+	if err := config.Load(&cfg); err != nil {
+		return err
 	}
+	log.Info(`effective config`, `config`, config.Redacted(cfg))
 
+	verifier, err := auth.NewVerifier(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	if verifier == nil {
+		log.Warn(`authentication disabled; set AUTH_HMAC_SECRET or AUTH_JWKS_FILE`)
+	}
+
+	tlsConfig, err := clientTLS(context.Background(), log, cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile, cfg.TLSReloadInterval)
+	if err != nil {
+		return fmt.Errorf(`failed to load TLS certificates: %s`, err)
+	}
+	var history viewRecorder = &historyClient{
+		url:    cfg.HistoryURL,
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true}},
+	}
+	// With HISTORY_GRPC_ADDR set, views go to history's gRPC API instead.
+	if cfg.HistoryGRPCAddr != `` {
+		conn, err := dialHistory(cfg.HistoryGRPCAddr, tlsConfig)
+		if err != nil {
+			return fmt.Errorf(`failed to set up gRPC client: %s`, err)
+		}
+		defer conn.Close()
+		history = &grpcHistory{client: historypb.NewHistoryClient(conn)}
+	}
+
+	spec, err := openapi.Load(openAPI)
+	if err != nil {
+		return err
+	}
+
+	log.Info(`Microservice online!`)
+	return http.ListenAndServe(fmt.Sprintf(`:%d`, cfg.Port), newHandler(log, verifier, spec, history))
+}
+
+// newHandler serves the routes, checking requests against spec, which is
+// served at /openapi.json. Videos are only streamed to callers with a token
+// verifier accepts.
+func newHandler(log *slog.Logger, verifier *auth.Verifier, spec *openapi.Document, history viewRecorder) http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc(`GET /video`, func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle(`GET /video`, auth.Middleware(log, verifier, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		videoPath := `./videos/SampleVideo_1280x720_1mb.mp4`
 		videoReader, err := os.Open(videoPath)
 		if err != nil {
@@ -55,42 +107,52 @@ func run(log *slog.Logger) error {
 		w.Header().Add(contentType, `video/mp4`)
 		// use io.Copy for streaming.
 		io.Copy(w, videoReader)
-		sendViewedMessage(log, videoPath)
-	})
+		history.sendViewedMessage(log, videoPath, r.Header.Get(`Authorization`))
+	})))
 
-	log.Info(`Microservice online!`)
-	return http.ListenAndServe(fmt.Sprint(`:`, port), mux)
+	mux.Handle(`GET /openapi.json`, spec)
+	return spec.Validate(mux)
+}
+
+// historyClient calls the history microservice at url, over mutual TLS if
+// TLS_CERT_FILE and TLS_KEY_FILE are set.
+type historyClient struct {
+	url    string
+	client *http.Client
 }
 
 // Attempt to log the watched video to the history microservice upon a view.
-func sendViewedMessage(log *slog.Logger, videoPath string) {
+// The viewer's Authorization header is passed along so history records the
+// view against them.
+func (h *historyClient) sendViewedMessage(log *slog.Logger, videoPath, authorization string) {
 	// Create the request body
 	messageBody := viewedMessageBody{
 		VideoPath: videoPath,
 	}
 
-	// Create a new POST request
-	req, _ := http.NewRequest(http.MethodPost, `http://history/viewed`, nil)
-
-	// Set the content type header
-	req.Header.Set(`Content-Type`, `application/json`)
-
 	// Create a buffer to hold the JSON data
 	var jsonBuffer bytes.Buffer
 
 	// Use json.NewEncoder to encode the request body directly into the request
 	json.NewEncoder(&jsonBuffer).Encode(messageBody)
 
+	// Create a new POST request
+	req, _ := http.NewRequest(http.MethodPost, h.url+`/viewed`, &jsonBuffer)
+
+	// Set the content type header
+	req.Header.Set(`Content-Type`, `application/json`)
+	if authorization != `` {
+		req.Header.Set(`Authorization`, authorization)
+	}
+
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := h.client.Do(req)
 	if err != nil {
-		log.Error(`Failed to send 'viewed' message!`)
-		log.Error(err.Error())
+		log.Error(`Failed to send 'viewed' message!`, `err`, err.Error())
 		return
 	}
 	defer resp.Body.Close()
 
 	// Log the success.
-	log.Error(`Sent 'viewed' message to history microservice.`)
+	log.Info(`Sent 'viewed' message to history microservice.`, `status`, resp.StatusCode)
 }
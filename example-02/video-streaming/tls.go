@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+)
+
+// clientTLS returns the TLS config for calls to history: the certificate in
+// certFile and keyFile, presented when history asks for one, and history's
+// own checked against the CAs in caFile, or the system's if that isn't set.
+// The files are checked for a renewal every reload until ctx is done. It
+// returns nil, for plain HTTP, if no certificate is set.
+func clientTLS(ctx context.Context, log *slog.Logger, certFile, keyFile, caFile string, reload time.Duration) (*tls.Config, error) {
+	if certFile == `` && keyFile == `` {
+		if caFile != `` {
+			return nil, errors.New(`TLS_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE`)
+		}
+		return nil, nil
+	}
+	certs, err := tlsx.LoadCertificates(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	if reload > 0 {
+		go certs.Watch(ctx, log, reload)
+	}
+	return certs.ClientConfig(), nil
+}
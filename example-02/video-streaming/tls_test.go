@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"testing"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx/tlsxtest"
+)
+
+// Mutual TLS and reloading are tested in tlsx; this checks that clientTLS
+// asks it for the right thing.
+
+func TestClientTLS(t *testing.T) {
+	ca := tlsxtest.NewCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := ca.Issue(t, dir, `video-streaming`, 2)
+	cfg, err := clientTLS(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), certFile, keyFile, ca.WriteCA(t, dir), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert, err := cfg.GetClientCertificate(&tls.CertificateRequestInfo{}); err != nil || len(cert.Certificate) != 1 {
+		t.Errorf(`client certificate = %v, %v; want video-streaming's`, cert, err)
+	}
+	// history's certificate is checked against TLS_CA_FILE.
+	if cfg.VerifyConnection == nil {
+		t.Error(`history's certificate isn't checked against the CA file`)
+	}
+
+	if cfg, err := clientTLS(context.Background(), slog.Default(), ``, ``, ``, 0); cfg != nil || err != nil {
+		t.Errorf(`clientTLS = %v, %v; want plain HTTP`, cfg, err)
+	}
+}
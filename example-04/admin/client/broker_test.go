@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging/memory"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPublishView(t *testing.T) {
+	broker := memory.New()
+	defer broker.Close()
+	ch := broker.Channel()
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(`views`, false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := messaging.Bind(ch, `views`, messaging.Bindings{Exchange: `events`, Patterns: []string{messaging.VideoViewed}}, ``); err != nil {
+		t.Fatal(err)
+	}
+	if err := PublishView(context.Background(), ch, `events`, `a.mp4`, `alice`, `req-1`); err != nil {
+		t.Fatal(err)
+	}
+
+	d, ok, err := ch.Get(`views`, true)
+	if err != nil || !ok {
+		t.Fatalf(`Get = %v, %v`, ok, err)
+	}
+	var body viewedMessageBody
+	if err := bson.Unmarshal(d.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body != (viewedMessageBody{VideoPath: `a.mp4`, RequestID: `req-1`, UserID: `alice`}) ||
+		d.Headers[messaging.PartitionHeader] != `user:alice` || d.CorrelationId != `req-1` {
+		t.Errorf(`published %+v with headers %v`, body, d.Headers)
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	broker := memory.New()
+	defer broker.Close()
+	ch := broker.Channel()
+	defer ch.Close()
+
+	if _, err := messaging.DeclareQueue(ch, `work`, messaging.QueueConfig{Type: messaging.Quorum, DeadLetter: true}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		err := ch.PublishWithContext(context.Background(), ``, `work`, false, false, amqp.Publishing{
+			Headers: amqp.Table{`traceparent`: fmt.Sprint(i)},
+			Body:    []byte(fmt.Sprint(i)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reject them all, as a consumer does with messages it can't handle.
+	rejectAll := func() {
+		for {
+			d, ok, err := ch.Get(`work`, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return
+			}
+			d.Nack(false, false)
+		}
+	}
+	rejectAll()
+
+	moved, err := ReplayDeadLetters(context.Background(), ch, `work`, 2)
+	if err != nil || moved != 2 {
+		t.Fatalf(`ReplayDeadLetters = %d, %v; want 2`, moved, err)
+	}
+	if ready, _ := broker.QueueDepth(`work`); ready != 2 {
+		t.Errorf(`work has %d messages, want 2`, ready)
+	}
+	d, _, _ := ch.Get(`work`, true)
+	if string(d.Body) != `0` || d.Headers[`traceparent`] != `0` || d.Headers[`x-death`] != nil || d.Headers[`x-first-death-queue`] != nil {
+		t.Errorf(`replayed %q with headers %v`, d.Body, d.Headers)
+	}
+
+	// Rejected again straight away, they go to the back of the dead letter
+	// queue; replaying everything still moves each message once.
+	rejectAll()
+	replay := broker.Channel()
+	defer replay.Close()
+	moved, err = ReplayDeadLetters(context.Background(), replay, `work`, 0)
+	if err != nil || moved != 4 {
+		t.Fatalf(`ReplayDeadLetters = %d, %v; want 4`, moved, err)
+	}
+	if ready, _ := broker.QueueDepth(messaging.DeadLetterQueue(`work`)); ready != 0 {
+		t.Errorf(`%d messages left dead`, ready)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	broker := memory.New()
+	defer broker.Close()
+	ch := broker.Channel()
+	defer ch.Close()
+
+	// A classic queue, as the services used to declare, and its quorum
+	// replacement.
+	if _, err := messaging.DeclareQueue(ch, `work`, messaging.QueueConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	next, err := messaging.DeclareQueue(ch, `work`, messaging.QueueConfig{Type: messaging.Quorum, Suffix: `.quorum`})
+	if err != nil || next.Name != `work.quorum` {
+		t.Fatalf(`DeclareQueue with a suffix = %q, %v`, next.Name, err)
+	}
+	for i := range 3 {
+		err := ch.PublishWithContext(context.Background(), ``, `work`, false, false, amqp.Publishing{MessageId: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	moved, err := Drain(context.Background(), ch, `work`, next.Name)
+	if err != nil || moved != 3 {
+		t.Fatalf(`Drain = %d, %v; want 3`, moved, err)
+	}
+	if ready, _ := broker.QueueDepth(`work`); ready != 0 {
+		t.Errorf(`%d messages left in work`, ready)
+	}
+	d, _, _ := ch.Get(next.Name, true)
+	if d.MessageId != `0` || d.DeliveryMode != amqp.Persistent {
+		t.Errorf(`moved message %q, delivery mode %d`, d.MessageId, d.DeliveryMode)
+	}
+}
+
+// nackingChannel is a memory channel whose broker refuses every publish.
+type nackingChannel struct {
+	*memory.Channel
+	confirms chan amqp.Confirmation
+}
+
+func (c *nackingChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.confirms = confirm
+	return confirm
+}
+
+func (c *nackingChannel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: false}
+	return nil
+}
+
+func TestDrainKeepsNackedMessage(t *testing.T) {
+	broker := memory.New()
+	defer broker.Close()
+	ch := broker.Channel()
+	defer ch.Close()
+
+	for _, name := range []string{`work`, `work.quorum`} {
+		if _, err := ch.QueueDeclare(name, true, false, false, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ch.PublishWithContext(context.Background(), ``, `work`, false, false, amqp.Publishing{MessageId: `0`}); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := Drain(context.Background(), &nackingChannel{Channel: ch}, `work`, `work.quorum`)
+	if !errors.Is(err, errNacked) || moved != 0 {
+		t.Fatalf(`Drain = %d, %v; want 0, errNacked`, moved, err)
+	}
+	if ready, _ := broker.QueueDepth(`work`); ready != 1 {
+		t.Errorf(`work has %d messages after the nack, want 1`, ready)
+	}
+}
@@ -0,0 +1,178 @@
+// Package client is what the admin CLI does, kept apart from flag parsing
+// and output: calls to the services' HTTP APIs, the RabbitMQ management
+// API and the broker itself.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+// tokenTTL is how long the tokens minted for each request last.
+const tokenTTL = 5 * time.Minute
+
+// Client calls the services' HTTP APIs.
+type Client struct {
+	HTTP *http.Client
+	// Base URLs of the services, e.g. http://localhost:4001.
+	VideoStreamingURL, HistoryURL, RecommendationsURL string
+	// Secret is the services' AUTH_HMAC_SECRET, used to mint tokens for
+	// Subject with the admin claim. Empty sends no tokens, for services
+	// running without auth.
+	Secret  string
+	Subject string
+}
+
+// StatusError is returned for a response other than the one expected.
+type StatusError struct {
+	Method, URL string
+	Status      int
+	// Body is the start of the response body, usually http.Error's text.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf(`%s %s: %d %s: %s`, e.Method, e.URL, e.Status, http.StatusText(e.Status), e.Body)
+}
+
+// Video is an entry in video-streaming's GET /videos.
+type Video struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// View is an entry in history's GET /history.
+type View struct {
+	VideoPath string `json:"videoPath"`
+	RequestID string `json:"requestId,omitempty"`
+	UserID    string `json:"userId,omitempty"`
+}
+
+// VideoStat counts the views of one video, as in history's
+// GET /history/stats and recommendations' model.
+type VideoStat struct {
+	VideoPath string `json:"videoPath"`
+	Views     int64  `json:"views"`
+}
+
+// Stats is history's GET /history/stats.
+type Stats struct {
+	Total  int64       `json:"total"`
+	Videos []VideoStat `json:"videos"`
+}
+
+// Videos lists the videos video-streaming serves.
+func (c *Client) Videos(ctx context.Context) ([]Video, error) {
+	var videos []Video
+	err := c.do(ctx, c.admin(), http.MethodGet, c.VideoStreamingURL+`/videos`, nil, http.StatusOK, &videos)
+	return videos, err
+}
+
+// Upload stores the video read from r, size bytes long, as name,
+// replacing any video of that name.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader, size int64) (Video, error) {
+	var video Video
+	err := c.do(ctx, c.admin(), http.MethodPut, c.VideoStreamingURL+`/videos/`+url.PathEscape(name), sized{r, size}, http.StatusCreated, &video)
+	return video, err
+}
+
+// DeleteVideo deletes the named video.
+func (c *Client) DeleteVideo(ctx context.Context, name string) error {
+	return c.do(ctx, c.admin(), http.MethodDelete, c.VideoStreamingURL+`/videos/`+url.PathEscape(name), nil, http.StatusNoContent, nil)
+}
+
+// History returns the views recorded for user, asking as an admin.
+func (c *Client) History(ctx context.Context, user string, skip, limit int) ([]View, error) {
+	q := url.Values{`userId`: {user}}
+	if skip > 0 {
+		q.Set(`skip`, strconv.Itoa(skip))
+	}
+	if limit > 0 {
+		q.Set(`limit`, strconv.Itoa(limit))
+	}
+	var views []View
+	err := c.do(ctx, c.admin(), http.MethodGet, c.HistoryURL+`/history?`+q.Encode(), nil, http.StatusOK, &views)
+	return views, err
+}
+
+// Stats returns history's view counts.
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	err := c.do(ctx, c.admin(), http.MethodGet, c.HistoryURL+`/history/stats`, nil, http.StatusOK, &stats)
+	return stats, err
+}
+
+// RebuildRecommendations replaces the recommendations model with the view
+// counts history has recorded, returning how many videos it now knows.
+func (c *Client) RebuildRecommendations(ctx context.Context) (int, error) {
+	stats, err := c.Stats(ctx)
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(stats.Videos)
+	if err != nil {
+		return 0, err
+	}
+	err = c.do(ctx, c.admin(), http.MethodPut, c.RecommendationsURL+`/recommendations/model`, bytes.NewReader(body), http.StatusNoContent, nil)
+	return len(stats.Videos), err
+}
+
+func (c *Client) admin() auth.Identity {
+	return auth.Identity{Subject: c.Subject, Admin: true}
+}
+
+// sized is a request body of known length, so uploads aren't chunked.
+type sized struct {
+	io.Reader
+	size int64
+}
+
+// do sends a request as id and decodes a JSON response with the wanted
+// status into out, if out isn't nil.
+func (c *Client) do(ctx context.Context, id auth.Identity, method, target string, body io.Reader, want int, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	switch b := body.(type) {
+	case sized:
+		req.ContentLength = b.size
+	case *bytes.Reader:
+		req.Header.Set(`Content-Type`, `application/json`)
+	}
+	if c.Secret != `` {
+		token, err := auth.Sign(c.Secret, id, tokenTTL)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(`Authorization`, `Bearer `+token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != want {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &StatusError{Method: method, URL: target, Status: resp.StatusCode, Body: strings.TrimSpace(string(text))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf(`%s %s: %w`, method, target, err)
+	}
+	return nil
+}
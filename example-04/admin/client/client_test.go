@@ -0,0 +1,233 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/openapi"
+)
+
+func TestClient(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: `s3cret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var model []VideoStat
+	mux := http.NewServeMux()
+	mux.HandleFunc(`GET /history`, func(w http.ResponseWriter, r *http.Request) {
+		if !auth.Admin(r.Context()) {
+			http.Error(w, `not an admin`, http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode([]View{{VideoPath: `a.mp4`, UserID: r.FormValue(`userId`)}})
+	})
+	mux.HandleFunc(`GET /history/stats`, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Stats{Total: 3, Videos: []VideoStat{{`a.mp4`, 2}, {`b.mp4`, 1}}})
+	})
+	mux.HandleFunc(`PUT /recommendations/model`, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&model)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.Handle(`PUT /videos/{id}`, auth.RequireAdmin(log, verifier, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf(`Content-Length %d for %d bytes`, r.ContentLength, len(body))
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(Video{Name: r.PathValue(`id`), Size: int64(len(body))})
+	})))
+	srv := httptest.NewServer(auth.Middleware(log, verifier, mux))
+	defer srv.Close()
+
+	c := &Client{
+		HTTP:               srv.Client(),
+		VideoStreamingURL:  srv.URL,
+		HistoryURL:         srv.URL,
+		RecommendationsURL: srv.URL,
+		Secret:             `s3cret`,
+		Subject:            `ops`,
+	}
+	ctx := context.Background()
+
+	views, err := c.History(ctx, `alice`, 0, 10)
+	if err != nil || len(views) != 1 || views[0].UserID != `alice` {
+		t.Errorf(`History = %+v, %v; want alice's views`, views, err)
+	}
+
+	video, err := c.Upload(ctx, `new video.mp4`, strings.NewReader(`data`), 4)
+	if err != nil || video.Name != `new video.mp4` || video.Size != 4 {
+		t.Errorf(`Upload = %+v, %v`, video, err)
+	}
+
+	n, err := c.RebuildRecommendations(ctx)
+	if err != nil || n != 2 || len(model) != 2 || model[0] != (VideoStat{`a.mp4`, 2}) {
+		t.Errorf(`RebuildRecommendations = %d, %v; model %+v`, n, err, model)
+	}
+
+	c.Secret = `wrong`
+	var statusErr *StatusError
+	if _, err := c.Stats(ctx); !errors.As(err, &statusErr) || statusErr.Status != http.StatusUnauthorized {
+		t.Errorf(`Stats with the wrong secret = %v, want 401`, err)
+	}
+}
+
+// TestClientKeepsToOpenAPI calls fakes of the services that check each
+// request, and their own answers, against the services' openapi.json.
+func TestClientKeepsToOpenAPI(t *testing.T) {
+	serve := func(file string, routes map[string]http.HandlerFunc) string {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spec, err := openapi.Load(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		for pattern, h := range routes {
+			mux.Handle(pattern, h)
+		}
+		checked := spec.Validate(mux)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if spec.Pattern(r) == `` {
+				t.Errorf(`%s %s: not in %s`, r.Method, r.URL.Path, file)
+			}
+			rec := httptest.NewRecorder()
+			checked.ServeHTTP(rec, r)
+			if rec.Code == http.StatusBadRequest || rec.Code == http.StatusUnsupportedMediaType {
+				t.Errorf(`%s %s: %d %s`, r.Method, r.URL, rec.Code, rec.Body)
+			}
+			if err := spec.CheckResponse(r, rec.Result()); err != nil {
+				t.Errorf(`fake for %s: %v`, file, err)
+			}
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+	writeJSON := func(w http.ResponseWriter, status int, v any) {
+		w.Header().Set(`Content-Type`, `application/json`)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+	video := Video{Name: `a.mp4`, Size: 4, Modified: time.Now()}
+
+	c := &Client{
+		HTTP: http.DefaultClient,
+		VideoStreamingURL: serve(`../../video-streaming/service/openapi.json`, map[string]http.HandlerFunc{
+			`GET /videos`: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, []Video{video})
+			},
+			`PUT /videos/{id}`: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusCreated, video)
+			},
+			`DELETE /videos/{id}`: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+		}),
+		HistoryURL: serve(`../../history/service/openapi.json`, map[string]http.HandlerFunc{
+			`GET /history`: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, []View{{VideoPath: `a.mp4`, UserID: r.FormValue(`userId`)}})
+			},
+			`GET /history/stats`: func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, Stats{Total: 2, Videos: []VideoStat{{`a.mp4`, 2}}})
+			},
+		}),
+		RecommendationsURL: serve(`../../recommendations/service/openapi.json`, map[string]http.HandlerFunc{
+			`PUT /recommendations/model`: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+		}),
+		Secret:  `s3cret`,
+		Subject: `ops`,
+	}
+	ctx := context.Background()
+
+	if _, err := c.Videos(ctx); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.Upload(ctx, `a.mp4`, strings.NewReader(`data`), 4); err != nil {
+		t.Error(err)
+	}
+	if err := c.DeleteVideo(ctx, `a.mp4`); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.History(ctx, `alice`, 1, 10); err != nil {
+		t.Error(err)
+	}
+	if _, err := c.RebuildRecommendations(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQueues(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != `guest` || pass != `guest` || r.URL.Path != `/api/queues` {
+			http.Error(w, `no`, http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, `[{"name":"historyQueue","vhost":"/","type":"quorum","state":"running","messages_ready":7,"messages_unacknowledged":2,"consumers":3}]`)
+	}))
+	defer srv.Close()
+
+	base := strings.Replace(srv.URL, `http://`, `http://guest:guest@`, 1)
+	queues, err := Queues(context.Background(), srv.Client(), base)
+	want := Queue{Name: `historyQueue`, VHost: `/`, Type: `quorum`, State: `running`, Ready: 7, Unacked: 2, Consumers: 3}
+	if err != nil || len(queues) != 1 || queues[0] != want {
+		t.Errorf(`Queues = %+v, %v; want %+v`, queues, err, want)
+	}
+
+	_, err = Queues(context.Background(), srv.Client(), strings.Replace(srv.URL, `http://`, `http://guest:wrong@`, 1))
+	if err == nil || strings.Contains(err.Error(), `wrong`) {
+		t.Errorf(`Queues with bad credentials = %v; want an error without them`, err)
+	}
+}
+
+func TestUnbindAndDeleteQueue(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+` `+r.URL.EscapedPath()+`?`+r.URL.RawQuery)
+		if r.Method == http.MethodGet {
+			io.WriteString(w, `[
+				{"source":"","routing_key":"historyQueue","properties_key":"historyQueue"},
+				{"source":"events","routing_key":"video.viewed","properties_key":"video.viewed"},
+				{"source":"Viewed","routing_key":"","properties_key":"~"}
+			]`)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	removed, err := Unbind(context.Background(), srv.Client(), srv.URL, `/`, `historyQueue`)
+	if err != nil || len(removed) != 2 {
+		t.Fatalf(`Unbind = %+v, %v; want 2 bindings removed`, removed, err)
+	}
+	if err := DeleteQueue(context.Background(), srv.Client(), srv.URL, `/`, `historyQueue`); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`GET /api/queues/%2F/historyQueue/bindings?`,
+		`DELETE /api/bindings/%2F/e/events/q/historyQueue/video.viewed?`,
+		`DELETE /api/bindings/%2F/e/Viewed/q/historyQueue/~?`,
+		`DELETE /api/queues/%2F/historyQueue?if-empty=true`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", strings.Join(requests, "\n"), strings.Join(want, "\n"))
+	}
+}
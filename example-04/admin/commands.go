@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/admin/client"
+)
+
+// errUsage is returned by a command given the wrong arguments.
+var errUsage = errors.New(`usage`)
+
+// table writes tab-separated rows as aligned columns.
+func (a *app) table(header string, rows func(w *tabwriter.Writer)) error {
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	rows(w)
+	return w.Flush()
+}
+
+func listVideos(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	videos, err := a.client.Videos(ctx)
+	if err != nil {
+		return err
+	}
+	return a.table("NAME\tSIZE\tMODIFIED", func(w *tabwriter.Writer) {
+		for _, v := range videos {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", v.Name, v.Size, v.Modified.Local().Format(time.DateTime))
+		}
+	})
+}
+
+func uploadVideo(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	name := flags.String(`name`, ``, `name to store the video as; default the file's name`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	path := flags.Arg(0)
+	if *name == `` {
+		*name = filepath.Base(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	video, err := a.client.Upload(ctx, *name, f, info.Size())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "uploaded %s, %d bytes\n", video.Name, video.Size)
+	return nil
+}
+
+func deleteVideo(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errUsage
+	}
+	if err := a.client.DeleteVideo(ctx, flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "deleted %s\n", flags.Arg(0))
+	return nil
+}
+
+func showHistory(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	user := flags.String(`user`, ``, `user ID, the subject of their tokens`)
+	skip := flags.Int(`skip`, 0, `views to skip`)
+	limit := flags.Int(`limit`, 20, `views to show; 0 shows all`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *user == `` || flags.NArg() != 0 {
+		return errUsage
+	}
+	views, err := a.client.History(ctx, *user, *skip, *limit)
+	if err != nil {
+		return err
+	}
+	return a.table("VIDEO\tREQUEST", func(w *tabwriter.Writer) {
+		for _, v := range views {
+			fmt.Fprintf(w, "%s\t%s\n", v.VideoPath, v.RequestID)
+		}
+	})
+}
+
+func showQueues(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	queues, err := client.Queues(ctx, a.http, a.cfg.Management)
+	if err != nil {
+		return err
+	}
+	return a.table("QUEUE\tTYPE\tSTATE\tREADY\tUNACKED\tCONSUMERS", func(w *tabwriter.Writer) {
+		for _, q := range queues {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", q.Name, q.Type, q.State, q.Ready, q.Unacked, q.Consumers)
+		}
+	})
+}
+
+func publishView(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	video := flags.String(`video`, ``, `video name, as in GET /video?v=`)
+	user := flags.String(`user`, ``, `user who watched it; default none`)
+	requestID := flags.String(`request-id`, ``, `request ID to correlate the event with; default a new one`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *video == `` || flags.NArg() != 0 {
+		return errUsage
+	}
+	if *requestID == `` {
+		*requestID = fmt.Sprintf(`admin-%d`, time.Now().UnixNano())
+	}
+
+	conn, ch, err := a.channel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := client.PublishView(ctx, ch, a.cfg.Events.Exchange, *video, *user, *requestID); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "published video.viewed for %s, request %s\n", *video, *requestID)
+	return nil
+}
+
+func replayDead(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	queue := flags.String(`queue`, ``, `queue whose dead letters to replay, e.g. historyQueue`)
+	limit := flags.Int(`limit`, 0, `messages to replay; 0 replays them all`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *queue == `` || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	conn, ch, err := a.channel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	moved, err := client.ReplayDeadLetters(ctx, ch, *queue, *limit)
+	fmt.Fprintf(a.out, "replayed %d messages to %s\n", moved, *queue)
+	return err
+}
+
+// drainQueue finishes moving a service to queues declared another way,
+// e.g. from classic to quorum with QUEUE_SUFFIX: once the service consumes
+// the new queue and nothing consumes the old one, the old queue is unbound,
+// emptied into the new one and deleted. Events published to both while
+// they were bound are dropped by the consumers' message ID dedupe.
+func drainQueue(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	from := flags.String(`from`, ``, `queue to empty and delete, e.g. historyQueue`)
+	to := flags.String(`to`, ``, `queue to move its messages to, e.g. historyQueue.quorum`)
+	vhost := flags.String(`vhost`, `/`, `virtual host of both queues`)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == `` || *to == `` || *from == *to || flags.NArg() != 0 {
+		return errUsage
+	}
+
+	queues, err := client.Queues(ctx, a.http, a.cfg.Management)
+	if err != nil {
+		return err
+	}
+	found := map[string]client.Queue{}
+	for _, q := range queues {
+		if q.VHost == *vhost {
+			found[q.Name] = q
+		}
+	}
+	switch {
+	case found[*from].Name == ``:
+		return fmt.Errorf(`no queue %s in %s`, *from, *vhost)
+	case found[*to].Name == ``:
+		return fmt.Errorf(`no queue %s in %s; deploy the service declaring it first`, *to, *vhost)
+	case found[*from].Consumers > 0:
+		return fmt.Errorf(`%s still has %d consumers; stop them first`, *from, found[*from].Consumers)
+	}
+
+	removed, err := client.Unbind(ctx, a.http, a.cfg.Management, *vhost, *from)
+	for _, b := range removed {
+		fmt.Fprintf(a.out, "unbound %s from %s %s\n", *from, b.Source, b.RoutingKey)
+	}
+	if err != nil {
+		return err
+	}
+	conn, ch, err := a.channel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	moved, err := client.Drain(ctx, ch, *from, *to)
+	fmt.Fprintf(a.out, "moved %d messages to %s\n", moved, *to)
+	if err != nil {
+		return err
+	}
+	if err := client.DeleteQueue(ctx, a.http, a.cfg.Management, *vhost, *from); err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "deleted %s\n", *from)
+	return nil
+}
+
+func rebuildRecommendations(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	videos, err := a.client.RebuildRecommendations(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(a.out, "rebuilt recommendations for %d videos\n", videos)
+	return nil
+}
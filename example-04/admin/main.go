@@ -0,0 +1,146 @@
+// Command admin manages the video platform from the command line, through
+// the services' HTTP APIs and the broker. Run it without arguments for a
+// list of commands.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/admin/client"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// command is one admin subcommand.
+type command struct {
+	name, usage, summary string
+	run                  func(ctx context.Context, a *app, flags *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{`videos`, ``, `list the videos`, listVideos},
+	{`upload`, `[-name NAME] FILE`, `upload a video, replacing any of the same name`, uploadVideo},
+	{`delete`, `NAME`, `delete a video`, deleteVideo},
+	{`history`, `-user ID [-skip N] [-limit N]`, `show a user's views`, showHistory},
+	{`queues`, ``, `show queue depths and consumer counts`, showQueues},
+	{`publish-view`, `-video NAME [-user ID]`, `publish a synthetic video.viewed event`, publishView},
+	{`replay-dead`, `-queue NAME [-limit N]`, `move dead-lettered messages back to their queue`, replayDead},
+	{`drain-queue`, `-from NAME -to NAME [-vhost VHOST]`, `move a queue's messages to its replacement and delete it`, drainQueue},
+	{`rebuild-recommendations`, ``, `rebuild the recommendations model from history`, rebuildRecommendations},
+}
+
+// app is what every command has to work with.
+type app struct {
+	cfg    cliConfig
+	log    *slog.Logger
+	out    io.Writer
+	http   *http.Client
+	client *client.Client
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the command named by args[0] and returns the exit status.
+func run(args []string) int {
+	if len(args) == 0 || args[0] == `help` || args[0] == `-h` || args[0] == `--help` {
+		usage(os.Stderr)
+		return 2
+	}
+	cmd, ok := lookup(args[0])
+	if !ok {
+		fmt.Fprintf(os.Stderr, "admin: unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		return 2
+	}
+
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: admin %s %s\n", cmd.name, cmd.usage)
+		flags.PrintDefaults()
+	}
+
+	var cfg cliConfig
+	if err := config.Load(&cfg); err != nil {
+		slog.Error(`config.Load`, logging.KeyError, err)
+		return 1
+	}
+	log, err := logging.New(os.Stderr, `admin`, cfg.Log)
+	if err != nil {
+		slog.Error(`logging.New`, logging.KeyError, err)
+		return 1
+	}
+	httpClient := &http.Client{Timeout: cfg.Timeout}
+	a := &app{
+		cfg:  cfg,
+		log:  log,
+		out:  os.Stdout,
+		http: httpClient,
+		client: &client.Client{
+			HTTP:               httpClient,
+			VideoStreamingURL:  cfg.VideoStreaming,
+			HistoryURL:         cfg.History,
+			RecommendationsURL: cfg.Recommendations,
+			Secret:             cfg.HMACSecret,
+			Subject:            cfg.Subject,
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = cmd.run(ctx, a, flags, args[1:])
+	switch {
+	case err == flag.ErrHelp:
+		return 0
+	case err == errUsage:
+		flags.Usage()
+		return 2
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "admin %s: %v\n", cmd.name, err)
+		return 1
+	}
+	return 0
+}
+
+func lookup(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, "usage: admin COMMAND [FLAGS] [ARGS]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-24s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprint(w, "\nRun admin COMMAND -h for a command's flags. Service URLs, RABBIT,\n"+
+		"RABBIT_MANAGEMENT and AUTH_HMAC_SECRET come from the environment.\n")
+}
+
+// channel connects to the broker for commands that need it. Closing the
+// returned connection closes the channel too.
+func (a *app) channel() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(a.cfg.Rabbit)
+	if err != nil {
+		return nil, nil, fmt.Errorf(`amqp.Dial: %w`, err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf(`conn.Channel: %w`, err)
+	}
+	return conn, ch, nil
+}
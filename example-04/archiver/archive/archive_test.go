@@ -0,0 +1,144 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var t0 = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func record(i int) Record {
+	return Record{
+		At:         t0.Add(time.Duration(i) * time.Minute),
+		Exchange:   `events`,
+		RoutingKey: `video.viewed`,
+		Headers:    map[string]string{`traceparent`: fmt.Sprint(i)},
+		Body:       []byte(fmt.Sprintf(`event %d`, i)),
+	}
+}
+
+// readAll returns the bodies of the records Read passes on.
+func readAll(t *testing.T, dir string, from, to time.Time) []string {
+	t.Helper()
+	var bodies []string
+	err := Read(dir, from, to, func(r Record) error {
+		bodies = append(bodies, string(r.Body))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bodies
+}
+
+func TestRotateAndRead(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{MaxAge: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 30 {
+		if err := l.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf(`got %d segments, want 3: %v`, len(segments), segments)
+	}
+	if !segments[1].Start.Equal(t0.Add(10 * time.Minute)) {
+		t.Errorf(`second segment starts at %v`, segments[1].Start)
+	}
+
+	got := readAll(t, dir, t0.Add(8*time.Minute), t0.Add(12*time.Minute))
+	want := []string{`event 8`, `event 9`, `event 10`, `event 11`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf(`read %q, want %q`, got, want)
+	}
+	if got := readAll(t, dir, time.Time{}, time.Time{}); len(got) != 30 {
+		t.Errorf(`read %d records in all, want 30`, len(got))
+	}
+}
+
+func TestRotateBySize(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{MaxBytes: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := range 3 {
+		if err := l.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if segments, _ := List(dir); len(segments) != 3 {
+		t.Errorf(`got %d segments, want one per record`, len(segments))
+	}
+}
+
+// A segment still being written, or left by a crash, has no gzip trailer;
+// every flushed record in it must still be readable.
+func TestReadUnfinishedSegment(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := range 5 {
+		if err := l.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := readAll(t, dir, time.Time{}, time.Time{}); len(got) != 5 {
+		t.Errorf(`read %d records, want 5`, len(got))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, Options{MaxAge: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 30 {
+		if err := l.Append(record(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+	if err := os.WriteFile(filepath.Join(dir, `notes.txt`), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first segment ends before minute 15; the second doesn't.
+	if err := Prune(dir, t0.Add(15*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	segments, _ := List(dir)
+	if len(segments) != 2 || !segments[0].Start.Equal(t0.Add(10*time.Minute)) {
+		t.Errorf(`kept %v`, segments)
+	}
+	if _, err := os.Stat(filepath.Join(dir, `notes.txt`)); err != nil {
+		t.Errorf(`pruned a file that isn't a segment: %v`, err)
+	}
+
+	// The newest segment is kept however old.
+	if err := Prune(dir, t0.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := List(dir); len(segments) != 1 {
+		t.Errorf(`kept %d segments, want 1`, len(segments))
+	}
+}
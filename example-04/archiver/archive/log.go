@@ -0,0 +1,219 @@
+// Package archive keeps events in append-only segment files of gzipped JSON
+// lines. Segments are rotated by size and age, named after the time of
+// their first record, and read back by time range.
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Segment files are named prefix + start time + suffix, which sorts them
+// oldest first.
+const (
+	prefix     = `events-`
+	suffix     = `.jsonl.gz`
+	timeFormat = `20060102T150405.000000000Z`
+)
+
+// Record is one archived event: the message as the broker delivered it and
+// when it was archived.
+type Record struct {
+	At            time.Time `json:"at"`
+	Exchange      string    `json:"exchange"`
+	RoutingKey    string    `json:"routingKey"`
+	ContentType   string    `json:"contentType,omitempty"`
+	MessageID     string    `json:"messageId,omitempty"`
+	CorrelationID string    `json:"correlationId,omitempty"`
+	// Headers keeps the string-valued message headers, which is all the
+	// services set: trace context and the partition key.
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    []byte            `json:"body"`
+}
+
+// Options control rotation and retention.
+type Options struct {
+	// MaxBytes starts a new segment once this many uncompressed bytes have
+	// gone into the current one; 0 means no limit.
+	MaxBytes int64
+	// MaxAge starts a new segment once the current one's first record is
+	// this old; 0 means no limit.
+	MaxAge time.Duration
+	// Retention deletes segments whose records are all older than this; 0
+	// keeps everything.
+	Retention time.Duration
+}
+
+// Log appends records to the current segment in a directory.
+type Log struct {
+	dir  string
+	opts Options
+
+	mu      sync.Mutex
+	f       *os.File
+	gz      *gzip.Writer
+	start   time.Time
+	written int64
+}
+
+// Open returns a log writing to dir, creating it if need be. Records always
+// go to a new segment: earlier ones are never appended to.
+func Open(dir string, opts Options) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Log{dir: dir, opts: opts}, nil
+}
+
+// Append writes r to the current segment, rotating first if it is full or
+// old enough, and flushes it to the file so that a crash loses at most the
+// gzip trailer.
+func (l *Log) Append(r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil && l.full(r.At) {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if l.f == nil {
+		if err := l.create(r.At); err != nil {
+			return err
+		}
+	}
+	if _, err := l.gz.Write(line); err != nil {
+		return err
+	}
+	l.written += int64(len(line))
+	return l.gz.Flush()
+}
+
+func (l *Log) full(at time.Time) bool {
+	return (l.opts.MaxBytes > 0 && l.written >= l.opts.MaxBytes) ||
+		(l.opts.MaxAge > 0 && at.Sub(l.start) >= l.opts.MaxAge)
+}
+
+// create starts a segment whose first record is at start.
+func (l *Log) create(start time.Time) error {
+	// Names must keep increasing, even if the clock doesn't.
+	start = start.UTC()
+	if !start.After(l.start) {
+		start = l.start.Add(time.Nanosecond)
+	}
+	f, err := os.OpenFile(filepath.Join(l.dir, segmentName(start)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f, l.gz, l.start, l.written = f, gzip.NewWriter(f), start, 0
+	return nil
+}
+
+// rotate finishes the current segment and applies the retention period.
+func (l *Log) rotate() error {
+	if err := l.finish(); err != nil {
+		return err
+	}
+	if l.opts.Retention <= 0 {
+		return nil
+	}
+	return Prune(l.dir, time.Now().Add(-l.opts.Retention))
+}
+
+// finish closes the current segment, syncing it to disk.
+func (l *Log) finish() error {
+	err := l.gz.Close()
+	if syncErr := l.f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	l.f, l.gz = nil, nil
+	return err
+}
+
+// Close finishes the current segment, if any.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.finish()
+}
+
+// Segment is one file of the archive.
+type Segment struct {
+	Name string `json:"name"`
+	// Start is the time of the first record; the last is before the next
+	// segment's Start.
+	Start time.Time `json:"start"`
+	// Size is the compressed size in bytes.
+	Size int64 `json:"size"`
+}
+
+// List returns the segments in dir, oldest first.
+func List(dir string) ([]Segment, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []Segment
+	for _, e := range entries {
+		start, ok := parseName(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, Segment{Name: e.Name(), Start: start, Size: info.Size()})
+	}
+	slices.SortFunc(segments, func(a, b Segment) int { return a.Start.Compare(b.Start) })
+	return segments, nil
+}
+
+// Prune deletes the segments in dir holding nothing at or after before:
+// those followed by a segment that started before it. The newest segment
+// is always kept.
+func Prune(dir string, before time.Time) error {
+	segments, err := List(dir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := 0; i+1 < len(segments) && !segments[i+1].Start.After(before); i++ {
+		errs = append(errs, os.Remove(filepath.Join(dir, segments[i].Name)))
+	}
+	return errors.Join(errs...)
+}
+
+func segmentName(start time.Time) string {
+	return prefix + start.UTC().Format(timeFormat) + suffix
+}
+
+func parseName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, prefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	if stamp, ok = strings.CutSuffix(stamp, suffix); !ok {
+		return time.Time{}, false
+	}
+	start, err := time.Parse(timeFormat, stamp)
+	return start, err == nil
+}
@@ -0,0 +1,73 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Read calls fn with every record in dir archived at or after from and
+// before to, oldest first; a zero to means no end. It stops at the first
+// error fn returns.
+//
+// A segment cut short by a crash is read up to its last whole record.
+func Read(dir string, from, to time.Time, fn func(Record) error) error {
+	segments, err := List(dir)
+	if err != nil {
+		return err
+	}
+	for i, s := range segments {
+		if !to.IsZero() && !s.Start.Before(to) {
+			break
+		}
+		if i+1 < len(segments) && !segments[i+1].Start.After(from) {
+			continue
+		}
+		if err := readSegment(filepath.Join(dir, s.Name), from, to, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSegment(path string, from, to time.Time, fn func(Record) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if errors.Is(err, io.EOF) {
+		// Created but nothing flushed yet.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf(`%s: %w`, path, err)
+	}
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return fmt.Errorf(`%s:%d: %w`, path, line, err)
+		}
+		if r.At.Before(from) || (!to.IsZero() && !r.At.Before(to)) {
+			continue
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	// The segment being written, or one left by a crash, has no trailer.
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf(`%s: %w`, path, err)
+	}
+	return nil
+}
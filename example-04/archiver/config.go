@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+)
+
+// serviceConfig is loaded by config.Load from the environment and the
+// optional CONFIG_FILE.
+type serviceConfig struct {
+	Port   int    `env:"PORT" required:"true" validate:"port"`
+	Rabbit string `env:"RABBIT" required:"true" validate:"url" secret:"userinfo"`
+	// Events reach archiveQueue from the EVENTS_EXCHANGE topic exchange by
+	// Bindings; # archives everything.
+	Events   messaging.Config
+	Bindings []string `env:"ARCHIVE_BINDINGS" default:"#"`
+	Queue    messaging.QueueConfig
+	// Prefetch caps the deliveries RabbitMQ sends before they are acked.
+	Prefetch int `env:"ARCHIVE_PREFETCH" default:"64"`
+
+	Archive archiveConfig
+
+	TLS     tlsx.Config
+	Log     logging.Config
+	Tracing tracing.Config
+}
+
+// Validate checks settings that depend on each other.
+func (c *serviceConfig) Validate() error {
+	if c.Prefetch < 0 {
+		return errors.New(`ARCHIVE_PREFETCH must not be negative`)
+	}
+	if err := c.Queue.Validate(); err != nil {
+		return err
+	}
+	return c.TLS.Validate()
+}
+
+// archiveConfig says where segments go and when they are rotated and
+// deleted.
+type archiveConfig struct {
+	Dir string `env:"ARCHIVE_DIR" default:"./data/archive"`
+	// SegmentBytes and SegmentAge start a new segment once the current one
+	// holds this many uncompressed bytes or is this old.
+	SegmentBytes int           `env:"ARCHIVE_SEGMENT_BYTES" default:"67108864"`
+	SegmentAge   time.Duration `env:"ARCHIVE_SEGMENT_AGE" default:"1h"`
+	// Retention deletes older segments; 0 keeps them forever.
+	Retention time.Duration `env:"ARCHIVE_RETENTION" default:"0"`
+}
+
+// replayConfig is what the replay command reads from the environment;
+// the rest comes from its flags.
+type replayConfig struct {
+	Rabbit  string `env:"RABBIT" required:"true" validate:"url" secret:"userinfo"`
+	Events  messaging.Config
+	Archive archiveConfig
+	Log     logging.Config
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/archiver/archive"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/archiver/service"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"golang.org/x/sync/errgroup"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == `replay` {
+		os.Exit(replayMain(os.Args[2:]))
+	}
+
+	var cfg serviceConfig
+	if err := config.Load(&cfg); err != nil {
+		slog.Error(`config.Load`, logging.KeyError, err)
+		os.Exit(1)
+	}
+
+	logger, err := logging.New(os.Stderr, `archiver`, cfg.Log)
+	if err != nil {
+		slog.Error(`logging.New`, logging.KeyError, err)
+		os.Exit(1)
+	}
+
+	if err := run(logger, cfg); err != nil {
+		logger.Error(`run failed`, logging.KeyError, err)
+		os.Exit(1)
+	}
+}
+
+func run(log *slog.Logger, cfg serviceConfig) error {
+	log.Info(`effective config`, `config`, config.Redacted(cfg))
+
+	// Send spans to stdout or an OTLP collector, per OTEL_TRACES_EXPORTER.
+	cfg.Tracing.ServiceName = `archiver`
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	archiveLog, err := archive.Open(cfg.Archive.Dir, archive.Options{
+		MaxBytes:  int64(cfg.Archive.SegmentBytes),
+		MaxAge:    cfg.Archive.SegmentAge,
+		Retention: cfg.Archive.Retention,
+	})
+	if err != nil {
+		return fmt.Errorf(`archive.Open: %w`, err)
+	}
+	defer archiveLog.Close()
+
+	// Connect to RabbitMQ
+	conn, err := amqp.Dial(cfg.Rabbit)
+	if err != nil {
+		return fmt.Errorf(`amqp.Dial: %w`, err)
+	}
+	defer conn.Close()
+
+	// Create a channel to communicate with RabbitMQ.
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf(`conn.Channel: %w`, err)
+	}
+	defer ch.Close()
+
+	svc := service.New(log, archiveLog, cfg.Archive.Dir, cfg.Prefetch)
+	msgs, err := svc.Subscribe(ch, messaging.Bindings{
+		Exchange: cfg.Events.Exchange,
+		Patterns: cfg.Bindings,
+	}, cfg.Queue)
+	if err != nil {
+		return err
+	}
+
+	// Stop on SIGINT/SIGTERM, or as soon as either the consumer or the HTTP
+	// server fails; the deferred Closes above then run before main exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	g, ctx := errgroup.WithContext(ctx)
+
+	g.Go(func() error {
+		return svc.Consume(ctx, msgs)
+	})
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(`:%d`, cfg.Port),
+		Handler: svc.Handler(),
+	}
+	if err := tlsx.Secure(ctx, log, srv, cfg.TLS); err != nil {
+		return err
+	}
+	g.Go(func() error {
+		log.Info(`Microservice online!`, `tls`, cfg.TLS.Enabled(), `h2c`, cfg.TLS.H2C && !cfg.TLS.Enabled())
+		return httpx.Serve(ctx, srv)
+	})
+
+	return g.Wait()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/archiver/service"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const replayUsage = `usage: archiver replay -from TIME [-to TIME] [-keys PATTERNS] [-exchange NAME | -queue NAME] [-rate N] [-dry-run]
+
+Republishes the events archived in ARCHIVE_DIR between -from and -to,
+times in RFC 3339, to an exchange or straight to one consumer's queue.
+RABBIT and EVENTS_EXCHANGE come from the environment, as for the service.
+History skips views whose request it has recorded already, so replaying
+views it has is harmless; recommendations counts them again.
+`
+
+// replayMain runs the replay command and returns the exit status.
+func replayMain(args []string) int {
+	flags := flag.NewFlagSet(`replay`, flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(flags.Output(), replayUsage); flags.PrintDefaults() }
+	var (
+		from     = flags.String(`from`, ``, `replay events archived at or after this time`)
+		to       = flags.String(`to`, ``, `and before this time; default now`)
+		keys     = flags.String(`keys`, ``, `comma-separated routing key patterns, e.g. video.*; default all`)
+		exchange = flags.String(`exchange`, ``, `exchange to republish to, keeping routing keys; default EVENTS_EXCHANGE`)
+		queue    = flags.String(`queue`, ``, `queue to send every event to instead, e.g. historyQueue`)
+		perSec   = flags.Float64(`rate`, 0, `events per second; 0 is unlimited`)
+		dryRun   = flags.Bool(`dry-run`, false, `count the events without publishing them`)
+	)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var cfg replayConfig
+	if err := config.Load(&cfg); err != nil {
+		slog.Error(`config.Load`, logging.KeyError, err)
+		return 1
+	}
+	log, err := logging.New(os.Stderr, `archiver`, cfg.Log)
+	if err != nil {
+		slog.Error(`logging.New`, logging.KeyError, err)
+		return 1
+	}
+
+	opts := service.ReplayOptions{Exchange: *exchange, Queue: *queue, Rate: *perSec, DryRun: *dryRun}
+	if opts.Exchange == `` && opts.Queue == `` {
+		opts.Exchange = cfg.Events.Exchange
+	}
+	if *keys != `` {
+		opts.Keys = strings.Split(*keys, `,`)
+	}
+	if opts.From, err = time.Parse(time.RFC3339, *from); err != nil {
+		fmt.Fprintf(os.Stderr, "-from: %v\n", err)
+		return 2
+	}
+	if *to != `` {
+		if opts.To, err = time.Parse(time.RFC3339, *to); err != nil {
+			fmt.Fprintf(os.Stderr, "-to: %v\n", err)
+			return 2
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := replay(ctx, log, cfg, opts); err != nil {
+		log.Error(`replay failed`, logging.KeyError, err)
+		return 1
+	}
+	return 0
+}
+
+func replay(ctx context.Context, log *slog.Logger, cfg replayConfig, opts service.ReplayOptions) error {
+	var pub service.Publisher = discard{}
+	if !opts.DryRun {
+		conn, err := amqp.Dial(cfg.Rabbit)
+		if err != nil {
+			return fmt.Errorf(`amqp.Dial: %w`, err)
+		}
+		defer conn.Close()
+		ch, err := conn.Channel()
+		if err != nil {
+			return fmt.Errorf(`conn.Channel: %w`, err)
+		}
+		defer ch.Close()
+		pub = ch
+	}
+
+	sent, err := service.Replay(ctx, pub, cfg.Archive.Dir, opts)
+	log.Info(`replayed events`, `count`, sent, `exchange`, opts.Exchange, `queue`, opts.Queue, `dryRun`, opts.DryRun)
+	return err
+}
+
+// discard is the publisher for a dry run.
+type discard struct{}
+
+func (discard) PublishWithContext(context.Context, string, string, bool, bool, amqp.Publishing) error {
+	return nil
+}
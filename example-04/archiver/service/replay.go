@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/archiver/archive"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"golang.org/x/time/rate"
+)
+
+// ReplayedHeader marks republished events with when they were first
+// archived, so consumers can tell a replay from live traffic.
+const ReplayedHeader = `x-replayed-from`
+
+// Publisher is the part of messaging.Channel Replay needs.
+type Publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// ReplayOptions selects archived events and where to send them.
+type ReplayOptions struct {
+	// From and To bound when the events were archived; a zero To means up
+	// to now.
+	From, To time.Time
+	// Keys are routing key patterns, as in topic bindings; empty replays
+	// every event.
+	Keys []string
+	// Exchange is the exchange to republish to, each event keeping its
+	// routing key. Alternatively Queue sends every event straight to one
+	// queue through the default exchange, reaching just that consumer.
+	Exchange, Queue string
+	// Rate caps the events published per second; 0 is no cap.
+	Rate float64
+	// DryRun counts the events without publishing them.
+	DryRun bool
+}
+
+// Replay republishes the events archived in dir that opts selects, oldest
+// first, and returns how many it sent.
+func Replay(ctx context.Context, pub Publisher, dir string, opts ReplayOptions) (int, error) {
+	if (opts.Exchange == ``) == (opts.Queue == ``) {
+		return 0, errors.New(`replay needs an exchange or a queue, not both`)
+	}
+	limiter := rate.NewLimiter(rate.Inf, 1)
+	if opts.Rate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(opts.Rate), 1)
+	}
+
+	sent := 0
+	err := archive.Read(dir, opts.From, opts.To, func(r archive.Record) error {
+		if len(opts.Keys) > 0 && !slices.ContainsFunc(opts.Keys, func(p string) bool { return messaging.Match(p, r.RoutingKey) }) {
+			return nil
+		}
+		if opts.DryRun {
+			sent++
+			return nil
+		}
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		exchange, key := opts.Exchange, r.RoutingKey
+		if opts.Queue != `` {
+			exchange, key = ``, opts.Queue
+		}
+		headers := amqp.Table{ReplayedHeader: r.At.Format(time.RFC3339Nano)}
+		for k, v := range r.Headers {
+			headers[k] = v
+		}
+		err := pub.PublishWithContext(ctx, exchange, key, false, false, amqp.Publishing{
+			ContentType:   r.ContentType,
+			DeliveryMode:  amqp.Persistent,
+			MessageId:     r.MessageID,
+			CorrelationId: r.CorrelationID,
+			Headers:       headers,
+			Body:          r.Body,
+		})
+		if err != nil {
+			return fmt.Errorf(`channel.Publish: %w`, err)
+		}
+		sent++
+		return nil
+	})
+	return sent, err
+}
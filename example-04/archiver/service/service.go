@@ -0,0 +1,158 @@
+// Package service is the archiver microservice: it appends every event it
+// is bound to to an archive.Log, so that acked events can be replayed later
+// to rebuild a read model or reprocess them after a bug fix.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/archiver/archive"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ArchiveQueue receives a copy of every event archived.
+const ArchiveQueue = `archiveQueue`
+
+var tracer = tracing.Tracer(`archiver`)
+
+// errDeliveriesClosed is returned by Consume when RabbitMQ closes the
+// delivery channel, which happens when the connection or channel dies.
+var errDeliveriesClosed = errors.New(`delivery channel closed`)
+
+// Service archives events from ArchiveQueue to a log in dir.
+type Service struct {
+	log      *slog.Logger
+	archive  *archive.Log
+	dir      string
+	prefetch int
+}
+
+// New returns an archiver appending to log, which keeps its segments in
+// dir. prefetch caps the deliveries RabbitMQ sends before they are acked;
+// 0 means no cap.
+func New(log *slog.Logger, archive *archive.Log, dir string, prefetch int) *Service {
+	return &Service{log: log, archive: archive, dir: dir, prefetch: prefetch}
+}
+
+// Subscribe declares archiveQueue as q describes, binds it to the topic
+// exchange with b's patterns, usually #, and starts consuming. Pass the
+// returned channel to Consume.
+func (s *Service) Subscribe(ch messaging.Channel, b messaging.Bindings, q messaging.QueueConfig) (<-chan amqp.Delivery, error) {
+	queue, err := messaging.DeclareQueue(ch, ArchiveQueue, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := messaging.Bind(ch, queue.Name, b, ``); err != nil {
+		return nil, err
+	}
+	// Don't let RabbitMQ send more than we'll archive before acking.
+	if err := ch.Qos(s.prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf(`ch.Qos: %w`, err)
+	}
+	msgs, err := ch.Consume(queue.Name, ``, false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf(`ch.Consume: %w`, err)
+	}
+	return msgs, nil
+}
+
+// Consume archives deliveries from msgs until ctx is cancelled or the
+// broker closes msgs. Each is acked once it is in the log; replayed events
+// are acked without archiving them twice. Unlike the other
+// consumers it stops at the first write that fails, leaving the message in
+// the queue: dropping it would leave a gap in the archive.
+func (s *Service) Consume(ctx context.Context, msgs <-chan amqp.Delivery) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf(`%s: %w`, ArchiveQueue, errDeliveriesClosed)
+			}
+			if err := s.archiveDelivery(ctx, d); err != nil {
+				d.Nack(false, true)
+				return err
+			}
+			d.Ack(false)
+		}
+	}
+}
+
+func (s *Service) archiveDelivery(ctx context.Context, d amqp.Delivery) error {
+	// A replay to the events exchange comes back here; it is archived
+	// already.
+	if _, ok := d.Headers[ReplayedHeader]; ok {
+		return nil
+	}
+	ctx, span := tracer.Start(tracing.ExtractAMQP(ctx, d.Headers), ArchiveQueue+` process`,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String(`messaging.system`, `rabbitmq`),
+			attribute.String(`messaging.destination.name`, ArchiveQueue),
+			attribute.String(`messaging.rabbitmq.destination.routing_key`, d.RoutingKey),
+		))
+	defer span.End()
+
+	if err := s.archive.Append(recordOf(d, time.Now())); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, `archive.Append`)
+		return fmt.Errorf(`archive.Append: %w`, err)
+	}
+	s.log.DebugContext(ctx, `archived event`, `routingKey`, d.RoutingKey)
+	return nil
+}
+
+// recordOf is what gets archived of d.
+func recordOf(d amqp.Delivery, at time.Time) archive.Record {
+	r := archive.Record{
+		At:            at.UTC(),
+		Exchange:      d.Exchange,
+		RoutingKey:    d.RoutingKey,
+		ContentType:   d.ContentType,
+		MessageID:     d.MessageId,
+		CorrelationID: d.CorrelationId,
+		Body:          d.Body,
+	}
+	for k, v := range d.Headers {
+		if s, ok := v.(string); ok {
+			if r.Headers == nil {
+				r.Headers = map[string]string{}
+			}
+			r.Headers[k] = s
+		}
+	}
+	return r
+}
+
+// Handler serves GET /archive/segments, listing the segment files, wrapped
+// in the shared tracing and logging middleware.
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(`GET /archive/segments`, func(w http.ResponseWriter, r *http.Request) {
+		segments, err := archive.List(s.dir)
+		if err != nil {
+			s.log.ErrorContext(r.Context(), `/archive/segments.archive.List`, logging.KeyError, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if segments == nil {
+			segments = []archive.Segment{}
+		}
+		w.Header().Set(`Content-Type`, `application/json`)
+		json.NewEncoder(w).Encode(segments)
+	})
+	return tracing.Middleware(logging.Middleware(s.log, mux))
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/archiver/archive"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging/memory"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// archiver runs the service against broker, archiving to a temporary
+// directory it returns.
+func archiver(t *testing.T, broker *memory.Broker) string {
+	t.Helper()
+	dir := t.TempDir()
+	l, err := archive.Open(dir, archive.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(slog.New(slog.NewTextHandler(io.Discard, nil)), l, dir, 8)
+	ch := broker.Channel()
+	msgs, err := svc.Subscribe(ch, messaging.Bindings{Exchange: messaging.DefaultExchange, Patterns: []string{`#`}}, messaging.QueueConfig{Type: messaging.Quorum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- svc.Consume(ctx, msgs) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+		ch.Close()
+		l.Close()
+	})
+	return dir
+}
+
+func TestArchiveAndReplay(t *testing.T) {
+	broker := memory.New()
+	t.Cleanup(func() { broker.Close() })
+	dir := archiver(t, broker)
+	start := time.Now()
+
+	pub := broker.Channel()
+	for i, key := range []string{messaging.VideoViewed, messaging.PlaybackProgress, messaging.VideoViewed} {
+		err := pub.PublishWithContext(context.Background(), messaging.DefaultExchange, key, false, false, amqp.Publishing{
+			ContentType: `application/bson`,
+			MessageId:   fmt.Sprint(`m`, i),
+			Headers:     amqp.Table{messaging.PartitionHeader: `user:1`},
+			Body:        []byte(key),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if ready, unacked := broker.QueueDepth(ArchiveQueue); ready+unacked == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(`timed out waiting for the events to be archived`)
+		}
+	}
+
+	// Straight to one consumer's queue, only views.
+	if _, err := pub.QueueDeclare(`replayQueue`, false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := Replay(context.Background(), pub, dir, ReplayOptions{
+		From:  start,
+		Keys:  []string{`video.*`},
+		Queue: `replayQueue`,
+		Rate:  1000,
+	})
+	if err != nil || sent != 2 {
+		t.Fatalf(`Replay sent %d, %v; want 2`, sent, err)
+	}
+	msgs, err := pub.Consume(`replayQueue`, ``, true, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{`m0`, `m2`} {
+		d := <-msgs
+		if string(d.Body) != messaging.VideoViewed || d.Headers[messaging.PartitionHeader] != `user:1` ||
+			d.Headers[ReplayedHeader] == nil || d.ContentType != `application/bson` {
+			t.Errorf(`replayed %q with headers %v`, d.Body, d.Headers)
+		}
+		// Consumers recognise the replay of an event they stored by its ID.
+		if d.MessageId != id {
+			t.Errorf(`replayed message ID %q, want %q`, d.MessageId, id)
+		}
+	}
+
+	// Back through the exchange, which the archiver mustn't archive again.
+	sent, err = Replay(context.Background(), pub, dir, ReplayOptions{From: start, Exchange: messaging.DefaultExchange})
+	if err != nil || sent != 3 {
+		t.Fatalf(`Replay sent %d, %v; want 3`, sent, err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if ready, unacked := broker.QueueDepth(ArchiveQueue); ready+unacked == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(`timed out waiting for the replay to be consumed`)
+		}
+	}
+	n := 0
+	archive.Read(dir, start, time.Time{}, func(archive.Record) error { n++; return nil })
+	if n != 3 {
+		t.Errorf(`archive holds %d events after replaying, want 3`, n)
+	}
+
+	if _, err := Replay(context.Background(), pub, dir, ReplayOptions{From: start}); err == nil {
+		t.Error(`Replay with neither exchange nor queue succeeded`)
+	}
+}
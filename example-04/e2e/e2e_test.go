@@ -0,0 +1,402 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	historysvc "bootstrapping-microservices-in-go/chapter-05/example-4/history/service"
+	recsvc "bootstrapping-microservices-in-go/chapter-05/example-4/recommendations/service"
+	streamingsvc "bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/service"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestViewIsRecordedAndRecommended(t *testing.T) {
+	sys := Start(t)
+	token := sys.Token(t, `alice`)
+
+	resp := get(t, sys.Streaming.URL+`/video`, token)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf(`GET /video: status %d`, resp.StatusCode)
+	}
+	want, err := os.ReadFile(filepath.Join(videosDir(), `SampleVideo_1280x720_1mb.mp4`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != len(want) {
+		t.Fatalf(`GET /video: got %d bytes, want %d`, len(body), len(want))
+	}
+	requestID := resp.Header.Get(`X-Request-ID`)
+
+	var history []historysvc.View
+	eventually(t, func() bool {
+		getJSON(t, sys.History.URL+`/history`, token, &history)
+		return len(history) == 1
+	})
+	if history[0].UserID != `alice` {
+		t.Errorf(`history userId = %q, want alice`, history[0].UserID)
+	}
+	if history[0].RequestID != requestID {
+		t.Errorf(`history requestId = %q, want %q`, history[0].RequestID, requestID)
+	}
+	if filepath.Base(history[0].VideoPath) != `SampleVideo_1280x720_1mb.mp4` {
+		t.Errorf(`history videoPath = %q`, history[0].VideoPath)
+	}
+
+	var stats historysvc.Stats
+	getJSON(t, sys.History.URL+`/history/stats`, token, &stats)
+	if stats.Total != 1 || len(stats.Videos) != 1 || stats.Videos[0].VideoPath != history[0].VideoPath {
+		t.Errorf(`GET /history/stats = %+v`, stats)
+	}
+
+	var recs []recsvc.Recommendation
+	eventually(t, func() bool {
+		getJSON(t, sys.Recommendations.URL+`/recommendations`, ``, &recs)
+		return len(recs) == 1 && recs[0].Views == 1
+	})
+	if recs[0].VideoPath != history[0].VideoPath {
+		t.Errorf(`recommended %q, want %q`, recs[0].VideoPath, history[0].VideoPath)
+	}
+
+	for _, queue := range []string{historysvc.HistoryQueue, recsvc.RecommendationsQueue} {
+		if ready, unacked := sys.Broker.QueueDepth(queue); ready != 0 || unacked != 0 {
+			t.Errorf(`%s: %d ready, %d unacked; want everything acked`, queue, ready, unacked)
+		}
+	}
+}
+
+func TestMalformedMessageDoesNotStopHistory(t *testing.T) {
+	sys := Start(t)
+
+	ch := sys.Broker.Channel()
+	defer ch.Close()
+	publishRaw(t, ch, []byte(`not bson`))
+
+	token := sys.Token(t, `alice`)
+	resp := get(t, sys.Streaming.URL+`/video`, token)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var history []historysvc.View
+	eventually(t, func() bool {
+		getJSON(t, sys.History.URL+`/history`, token, &history)
+		return len(history) == 1
+	})
+}
+
+func TestHistoryIsScopedToCaller(t *testing.T) {
+	sys := Start(t)
+	alice, bob := sys.Token(t, `alice`), sys.Token(t, `bob`)
+
+	for _, token := range []string{alice, alice, bob} {
+		resp := get(t, sys.Streaming.URL+`/video`, token)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	var history []historysvc.View
+	eventually(t, func() bool {
+		getJSON(t, sys.History.URL+`/history`, alice, &history)
+		return len(history) == 2
+	})
+	eventually(t, func() bool {
+		getJSON(t, sys.History.URL+`/history`, bob, &history)
+		return len(history) == 1
+	})
+	if history[0].UserID != `bob` {
+		t.Errorf(`bob's history holds %q's view`, history[0].UserID)
+	}
+
+	// Only admins may read someone else's history.
+	resp := get(t, sys.History.URL+`/history?userId=alice`, bob)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf(`bob reading alice's history: status %d, want 403`, resp.StatusCode)
+	}
+	getJSON(t, sys.History.URL+`/history?userId=alice`, sys.AdminToken(t, `ops`), &history)
+	if len(history) != 2 || history[0].UserID != `alice` {
+		t.Errorf(`admin reading alice's history got %+v`, history)
+	}
+}
+
+func TestUnauthenticatedRequestsAreRejected(t *testing.T) {
+	sys := Start(t)
+
+	for _, url := range []string{sys.Streaming.URL + `/video`, sys.History.URL + `/history`} {
+		for _, token := range []string{``, `not-a-jwt`} {
+			resp := get(t, url, token)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf(`GET %s with token %q: status %d, want 401`, url, token, resp.StatusCode)
+			}
+		}
+	}
+	if n, _ := sys.HistoryStore.Count(context.Background()); n != 0 {
+		t.Errorf(`%d views recorded for rejected requests`, n)
+	}
+}
+
+func TestSignedLinkStreamsWithoutToken(t *testing.T) {
+	sys := Start(t)
+
+	body := strings.NewReader(`{"ttl": "5m", "bindIp": true}`)
+	req, err := http.NewRequest(http.MethodPost, sys.Streaming.URL+`/video/links`, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(`Content-Type`, `application/json`)
+	req.Header.Set(`Authorization`, `Bearer `+sys.Token(t, `alice`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var link struct {
+		URL string `json:"url"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&link)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf(`POST /video/links: status %d, %v`, resp.StatusCode, err)
+	}
+
+	resp = get(t, sys.Streaming.URL+link.URL, ``)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf(`GET signed link: status %d`, resp.StatusCode)
+	}
+
+	resp = get(t, sys.Streaming.URL+strings.Replace(link.URL, `v=`, `v=other`, 1), ``)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf(`GET tampered link: status %d, want 403`, resp.StatusCode)
+	}
+
+	// The view is still attributed to whoever minted the link.
+	var history []historysvc.View
+	eventually(t, func() bool {
+		getJSON(t, sys.History.URL+`/history`, sys.Token(t, `alice`), &history)
+		return len(history) == 1
+	})
+}
+
+func TestThumbnail(t *testing.T) {
+	sys := Start(t)
+
+	resp := get(t, sys.Streaming.URL+`/thumbnail/SampleVideo_1280x720_1mb.mp4?t=1.5`, sys.Token(t, `alice`))
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(`Content-Type`) != `video/h264` || len(body) == 0 {
+		t.Errorf(`GET /thumbnail: status %d, %s, %d bytes`, resp.StatusCode, resp.Header.Get(`Content-Type`), len(body))
+	}
+
+	resp = get(t, sys.Streaming.URL+`/thumbnail/missing.mp4`, sys.Token(t, `alice`))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf(`GET /thumbnail of a missing video: status %d, want 404`, resp.StatusCode)
+	}
+}
+
+func TestHeartbeatSetsResumePosition(t *testing.T) {
+	sys := Start(t)
+	alice, bob := sys.Token(t, `alice`), sys.Token(t, `bob`)
+	const video = `SampleVideo_1280x720_1mb.mp4`
+
+	for _, position := range []float64{2, 4.5} {
+		body := fmt.Sprintf(`{"video": %q, "position": %g, "duration": 5.3}`, video, position)
+		req, _ := http.NewRequest(http.MethodPost, sys.Streaming.URL+`/playback/heartbeat`, strings.NewReader(body))
+		req.Header.Set(`Content-Type`, `application/json`)
+		req.Header.Set(`Authorization`, `Bearer `+alice)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf(`POST /playback/heartbeat: status %d`, resp.StatusCode)
+		}
+	}
+
+	resume := sys.History.URL + `/history/alice/resume/` + video
+	var progress historysvc.Progress
+	eventually(t, func() bool {
+		resp := get(t, resume, alice)
+		defer resp.Body.Close()
+		return resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&progress) == nil && progress.Position == 4.5
+	})
+	if progress.VideoID != video || progress.UserID != `alice` || progress.Duration != 5.3 {
+		t.Errorf(`GET %s = %+v`, resume, progress)
+	}
+
+	// Bob can neither see Alice's position nor has one of his own.
+	for url, want := range map[string]int{
+		resume: http.StatusForbidden,
+		sys.History.URL + `/history/bob/resume/` + video: http.StatusNotFound,
+	} {
+		resp := get(t, url, bob)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf(`GET %s as bob: status %d, want %d`, url, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestLegacyConsumersStillGetViews(t *testing.T) {
+	sys := Start(t)
+
+	// An un-migrated consumer's queue on the old fanout.
+	ch := sys.Broker.Channel()
+	defer ch.Close()
+	if _, err := ch.QueueDeclare(`legacy`, false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.QueueBind(`legacy`, ``, streamingsvc.ViewedExchange, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	token := sys.Token(t, `alice`)
+	resp := get(t, sys.Streaming.URL+`/video`, token)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	eventually(t, func() bool {
+		ready, _ := sys.Broker.QueueDepth(`legacy`)
+		return ready == 1
+	})
+	if ready, _ := sys.Broker.QueueDepth(streamingsvc.LegacyViewedQueue); ready != 1 {
+		t.Errorf(`example-03's viewed queue has %d messages, want 1`, ready)
+	}
+
+	// History is bound to the topic exchange and the Viewed fanout, so it
+	// takes both copies of the view but records it once.
+	var history []historysvc.View
+	eventually(t, func() bool {
+		getJSON(t, sys.History.URL+`/history`, token, &history)
+		return len(history) > 0
+	})
+	time.Sleep(50 * time.Millisecond)
+	getJSON(t, sys.History.URL+`/history`, token, &history)
+	if len(history) != 1 {
+		t.Errorf(`history has %d views, want 1`, len(history))
+	}
+}
+
+func TestMixedVersionRollout(t *testing.T) {
+	sys := Start(t)
+	token := sys.Token(t, `alice`)
+
+	// A video-streaming replica that predates the topic exchange publishes
+	// to the Viewed fanout alone, without a message ID.
+	ch := sys.Broker.Channel()
+	defer ch.Close()
+	old, err := bson.Marshal(historysvc.View{VideoPath: `old.mp4`, UserID: `alice`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ch.PublishWithContext(context.Background(), streamingsvc.ViewedExchange, ``, false, false, amqp.Publishing{
+		ContentType: `application/bson`,
+		Body:        old,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A new one publishes to both.
+	resp := get(t, sys.Streaming.URL+`/video`, token)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Both views are recorded and counted, each once.
+	var history []historysvc.View
+	eventually(t, func() bool {
+		getJSON(t, sys.History.URL+`/history`, token, &history)
+		return len(history) >= 2
+	})
+	var recs []recsvc.Recommendation
+	eventually(t, func() bool {
+		getJSON(t, sys.Recommendations.URL+`/recommendations`, ``, &recs)
+		return len(recs) == 2
+	})
+	time.Sleep(50 * time.Millisecond)
+	getJSON(t, sys.History.URL+`/history`, token, &history)
+	if len(history) != 2 {
+		t.Errorf(`history has %d views, want 2`, len(history))
+	}
+	getJSON(t, sys.Recommendations.URL+`/recommendations`, ``, &recs)
+	for _, rec := range recs {
+		if rec.Views != 1 {
+			t.Errorf(`%s has %d views, want 1`, rec.VideoPath, rec.Views)
+		}
+	}
+	for _, queue := range []string{historysvc.HistoryQueue, recsvc.RecommendationsQueue} {
+		if ready, unacked := sys.Broker.QueueDepth(queue); ready != 0 || unacked != 0 {
+			t.Errorf(`%s: %d ready, %d unacked; want everything acked`, queue, ready, unacked)
+		}
+	}
+}
+
+// get issues a GET with token as its bearer token, if not empty.
+func get(t *testing.T, url, token string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != `` {
+		req.Header.Set(`Authorization`, `Bearer `+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func getJSON(t *testing.T, url, token string, v any) {
+	t.Helper()
+	resp := get(t, url, token)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf(`GET %s: status %d`, url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf(`GET %s: %v`, url, err)
+	}
+}
+
+// eventually polls cond until it holds, failing the test after a few
+// seconds.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(`condition not met before deadline`)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func publishRaw(t *testing.T, ch messaging.Channel, body []byte) {
+	t.Helper()
+	err := ch.PublishWithContext(context.Background(), messaging.DefaultExchange, messaging.VideoViewed, false, false, amqp.Publishing{
+		ContentType: `application/bson`,
+		Body:        body,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
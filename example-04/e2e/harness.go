@@ -0,0 +1,183 @@
+// Package e2e boots the whole example-04 system in one process: the
+// video-streaming, history and recommendations services run behind httptest
+// servers, talk through the in-memory broker and keep history in a
+// MemoryStore. Nothing needs Docker or a network.
+package e2e
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	historysvc "bootstrapping-microservices-in-go/chapter-05/example-4/history/service"
+	recsvc "bootstrapping-microservices-in-go/chapter-05/example-4/recommendations/service"
+	streamingsvc "bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/service"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/thumbnail"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging/memory"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/errgroup"
+)
+
+// Secrets for the tokens handed out by System.Token and for signed links.
+const (
+	tokenSecret = `e2e-secret`
+	linkSecret  = `e2e-link-secret`
+)
+
+// System is a running example-04 deployment.
+type System struct {
+	Broker       *memory.Broker
+	HistoryStore *historysvc.MemoryStore
+	Model        *recsvc.Model
+
+	Streaming       *httptest.Server
+	History         *httptest.Server
+	Recommendations *httptest.Server
+}
+
+// Start boots every service and registers their shutdown with t.Cleanup.
+// If any consumer fails the test fails.
+func Start(t testing.TB) *System {
+	t.Helper()
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	broker := memory.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	g, ctx := errgroup.WithContext(ctx)
+
+	sys := &System{
+		Broker:       broker,
+		HistoryStore: historysvc.NewMemoryStore(),
+	}
+
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: tokenSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each service gets its own channel, as it would its own connection.
+	streamingCh := broker.Channel()
+	videos := store.Dir(videosDir())
+	thumbnails, err := thumbnail.New(videos, thumbnail.Raw{}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Publish the legacy way too, as during the move to the topic
+	// exchange, so tests can check consumers don't see events twice.
+	streaming := streamingsvc.New(log, streamingCh, streamingsvc.Options{
+		LegacyEvents:      true,
+		LegacyViewedQueue: true,
+		Store:             videos,
+		Thumbnails:        thumbnails,
+		Verifier:          verifier,
+		Signer:            streamingsvc.NewURLSigner([]streamingsvc.SigningKey{{ID: `e2e`, Secret: []byte(linkSecret)}}),
+		LinkTTL:           time.Minute,
+		MaxLinkTTL:        time.Hour,
+	})
+	if err := streaming.Declare(streamingCh); err != nil {
+		t.Fatal(err)
+	}
+
+	historyCh := broker.Channel()
+	progressCh := broker.Channel()
+	history := historysvc.New(log, sys.HistoryStore, verifier, consumer)
+	historyMsgs, err := history.Subscribe(historyCh, bindings(messaging.VideoViewed), queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Go(func() error { return history.Consume(ctx, historyMsgs) })
+	progressMsgs, err := history.SubscribeProgress(progressCh, bindings(messaging.PlaybackProgress), queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Go(func() error { return history.ConsumeProgress(ctx, progressMsgs) })
+
+	recsCh := broker.Channel()
+	recs := recsvc.New(log, verifier)
+	recsMsgs, err := recs.Subscribe(recsCh, bindings(messaging.VideoViewed), queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Go(func() error { return recs.Consume(ctx, recsMsgs) })
+	sys.Model = recs.Model()
+
+	sys.Streaming = httptest.NewServer(streaming.Handler())
+	sys.History = httptest.NewServer(history.Handler())
+	sys.Recommendations = httptest.NewServer(recs.Handler())
+
+	t.Cleanup(func() {
+		sys.Streaming.Close()
+		sys.History.Close()
+		sys.Recommendations.Close()
+
+		cancel()
+		if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
+			t.Errorf(`consumer failed: %v`, err)
+		}
+		streamingCh.Close()
+		historyCh.Close()
+		progressCh.Close()
+		recsCh.Close()
+		broker.Close()
+	})
+
+	return sys
+}
+
+// queue is how the consumers declare their queues, as in production.
+var queue = messaging.QueueConfig{Type: messaging.Quorum, MaxLength: 100000, Overflow: `reject-publish`}
+
+// consumer batches views as in production, but flushes sooner.
+var consumer = historysvc.ConsumerConfig{
+	Prefetch:      64,
+	Workers:       4,
+	BatchSize:     16,
+	BatchInterval: 5 * time.Millisecond,
+	AckMode:       historysvc.AckDelivery,
+}
+
+// bindings routes the events patterns match from the default topic
+// exchange, and keeps the legacy fanout bindings as in production.
+func bindings(patterns ...string) messaging.Bindings {
+	return messaging.Bindings{Exchange: messaging.DefaultExchange, Patterns: patterns, KeepLegacy: true}
+}
+
+// Token returns a bearer token for subject accepted by the streaming and
+// history services.
+func (sys *System) Token(t testing.TB, subject string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   subject,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}).SignedString([]byte(tokenSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// AdminToken returns a bearer token for subject with the admin claim.
+func (sys *System) AdminToken(t testing.TB, subject string) string {
+	t.Helper()
+	token, err := auth.Sign(tokenSecret, auth.Identity{Subject: subject, Admin: true}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// videosDir locates video-streaming's sample videos relative to this file
+// so tests work from any working directory.
+func videosDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), `..`, `video-streaming`, `videos`)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/history/service"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+)
+
+// History store backends accepted by HISTORY_STORE.
+const (
+	storeMongo  = `mongo`
+	storeMemory = `memory`
+	storeFile   = `file`
+)
+
+// serviceConfig is loaded by config.Load from the environment and the
+// optional CONFIG_FILE.
+type serviceConfig struct {
+	Port int `env:"PORT" required:"true" validate:"port"`
+	// Store picks the HistoryStore: mongo, memory or file. The last two
+	// need no database and are meant for local development.
+	Store  string `env:"HISTORY_STORE" default:"mongo" validate:"oneof=mongo memory file"`
+	File   string `env:"HISTORY_FILE" default:"history.jsonl"`
+	DBHost string `env:"DBHOST" validate:"url" secret:"userinfo"`
+	DBName string `env:"DBNAME" default:"video-streaming"`
+	Rabbit string `env:"RABBIT" required:"true" validate:"url" secret:"userinfo"`
+
+	// Events come from the EVENTS_EXCHANGE topic exchange: views to
+	// historyQueue by HistoryBindings, playback progress to playbackQueue
+	// by PlaybackBindings. LegacyBinding keeps both queues bound to the
+	// Viewed and PlaybackProgress fanouts too; turn it off only once every
+	// publisher sends to the topic exchange.
+	Events           messaging.Config
+	HistoryBindings  []string `env:"HISTORY_BINDINGS" default:"video.viewed"`
+	PlaybackBindings []string `env:"PLAYBACK_BINDINGS" default:"playback.progress"`
+	LegacyBinding    bool     `env:"EVENTS_LEGACY_BINDING" default:"true"`
+	// Queue is how both queues are declared.
+	Queue messaging.QueueConfig
+	// Consumer sets prefetch, workers and batching for both consumers.
+	Consumer service.ConsumerConfig
+	// Partitions splits the queues between replicas.
+	Partitions service.PartitionConfig
+
+	Auth    auth.Config
+	TLS     tlsx.Config
+	Log     logging.Config
+	Tracing tracing.Config
+}
+
+// Validate checks settings that depend on each other.
+func (c *serviceConfig) Validate() error {
+	if c.Store == storeMongo && c.DBHost == `` {
+		return errors.New(`DBHOST is required when HISTORY_STORE is mongo`)
+	}
+	if err := c.Queue.Validate(); err != nil {
+		return err
+	}
+	if err := c.Consumer.Validate(); err != nil {
+		return err
+	}
+	if c.Partitions.Partitions > 0 && c.Consumer.AckMode == service.AckBatch {
+		// Every partition is consumed on one channel.
+		return errors.New(`HISTORY_ACK_MODE batch can't be used with HISTORY_PARTITIONS`)
+	}
+	if err := c.Partitions.Validate(); err != nil {
+		return err
+	}
+	return c.TLS.Validate()
+}
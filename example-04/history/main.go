@@ -6,150 +6,171 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
-
+	"os/signal"
+	"syscall"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/history/service"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/errgroup"
 )
 
-type viewedMessageBody struct {
-	VideoPath string `json:"videoPath" bson:"videoPath"`
-}
-
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	var cfg serviceConfig
+	if err := config.Load(&cfg); err != nil {
+		slog.Error(`config.Load`, logging.KeyError, err)
+		os.Exit(1)
+	}
+
+	logger, err := logging.New(os.Stderr, `history`, cfg.Log)
+	if err != nil {
+		slog.Error(`logging.New`, logging.KeyError, err)
+		os.Exit(1)
+	}
 
-	if err := run(logger); err != nil {
-		logger.Error(`run failed`, `err`, err.Error())
+	if err := run(logger, cfg); err != nil {
+		logger.Error(`run failed`, logging.KeyError, err)
 		os.Exit(1)
 	}
 }
 
-func run(log *slog.Logger) error {
-	port := os.Getenv(`PORT`)
-	dbhost := os.Getenv(`DBHOST`)
-	dbname := os.Getenv(`DBNAME`)
-	rabbit := os.Getenv(`RABBIT`)
+func run(log *slog.Logger, cfg serviceConfig) error {
+	log.Info(`effective config`, `config`, config.Redacted(cfg))
 
-	// Connect to Mongo
-	// https://pkg.go.dev/go.mongodb.org/mongo-driver/mongo
-	clientOpts := options.Client().
-		ApplyURI(dbhost)
-	client, err := mongo.Connect(context.TODO(), clientOpts)
-	failWithError(log, err, `mongo.Connection`)
+	// Send spans to stdout or an OTLP collector, per OTEL_TRACES_EXPORTER.
+	cfg.Tracing.ServiceName = `history`
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
 
-	collection := client.Database(dbname).Collection(`history`)
-	defer client.Disconnect(context.TODO())
+	verifier, err := auth.NewVerifier(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	if verifier == nil {
+		log.Warn(`authentication disabled; set AUTH_HMAC_SECRET or AUTH_JWKS_FILE`)
+	}
+
+	store, closeStore, err := openStore(log, cfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
 
 	// Connect to RabbitMQ
-	conn, err := amqp.Dial(rabbit)
-	failWithError(log, err, `amqp.Dial`)
+	conn, err := amqp.Dial(cfg.Rabbit)
+	if err != nil {
+		return fmt.Errorf(`amqp.Dial: %w`, err)
+	}
 	defer conn.Close()
 
 	// Create a channel to communicate with RabbitMQ.
 	ch, err := conn.Channel()
-	failWithError(log, err, `conn.Channel`)
+	if err != nil {
+		return fmt.Errorf(`conn.Channel: %w`, err)
+	}
 	defer ch.Close()
-
-	// Declare an exchange of type "fanout" so we can bind to it.
-	err = ch.ExchangeDeclare(
-		`Viewed`, // Exchange name.
-		`fanout`, // Exchange type.
-		true,     // Durable?
-		false,    // Delete when unused.
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
-	)
-	failWithError(log, err, `ch.ExchangeDeclare`)
-
-	// Declare a queue named "historyQueue".
-	// This queue will be used to store messages routed from the "Viewed" exchange.
-	historyQueue, err := ch.QueueDeclare(
-		`historyQueue`, // name
-		false,          // durable
-		false,          // delete when unused
-		false,          // exclusive
-		false,          // no-wait
-		nil,            // arguments
-	)
-	failWithError(log, err, `ch.QueueDeclare`)
-
-	// Bind the historyQueue to the exchange.
-	err = ch.QueueBind(
-		historyQueue.Name, // Queue name to bind,
-		``,                // routing key; not applicable for fanout exchanges
-		`Viewed`,          // Exchange name.
-		false,             // no-wait
-		nil,               // arguments
-	)
-	failWithError(log, err, `ch.QueueBind`)
-
-	// Create a channel to receive messages sent to our historyQueue.
-	msgs, err := ch.Consume(
-		historyQueue.Name, // queue
-		``,                // consumer
-		false,             // auto-ack
-		false,             // exclusive
-		false,             // no-local
-		false,             // no-wait
-		nil,               // args
-	)
-	failWithError(log, err, `ch.Consume`)
-
-	go func() {
-		for msg := range msgs {
-			var msgBody viewedMessageBody
-			bson.Unmarshal(msg.Body, &msgBody)
-
-			// Add to Mongo
-			res, err := collection.InsertOne(context.TODO(), msgBody)
-			failWithError(log, err, `collection.InsertOne`)
-			log.Info(`collection.InsertOne`, `insertedId`, res.InsertedID)
-			msg.Ack(true)
+	// Progress gets a channel of its own, so a batch ack on ch can only
+	// settle views.
+	progressCh, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf(`conn.Channel: %w`, err)
+	}
+	defer progressCh.Close()
+
+	svc := service.New(log, store, verifier, cfg.Consumer)
+	views := messaging.Bindings{Exchange: cfg.Events.Exchange, Patterns: cfg.HistoryBindings, KeepLegacy: cfg.LegacyBinding}
+	progress := messaging.Bindings{Exchange: cfg.Events.Exchange, Patterns: cfg.PlaybackBindings, KeepLegacy: cfg.LegacyBinding}
+
+	// Stop on SIGINT/SIGTERM, or as soon as a consumer or the HTTP server
+	// fails; the deferred Closes above then run before main exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	g, ctx := errgroup.WithContext(ctx)
+
+	if cfg.Partitions.Partitions > 0 {
+		if cfg.Partitions.Replica == `` {
+			if cfg.Partitions.Replica, err = os.Hostname(); err != nil {
+				return fmt.Errorf(`os.Hostname: %w`, err)
+			}
+		}
+		log.Info(`consuming partitions`, `partitions`, cfg.Partitions.Partitions, `replica`, cfg.Partitions.Replica)
+		g.Go(func() error {
+			return svc.RunPartitions(ctx, ch, cfg.Partitions, views, progress, cfg.Queue)
+		})
+	} else {
+		msgs, err := svc.Subscribe(ch, views, cfg.Queue)
+		if err != nil {
+			return err
 		}
-	}()
-
-	// The viewed handler is no longer necessary since we're ulling from the
-	// queue.  But we do need an endpoint that will print our view history.
-	mux := http.NewServeMux()
-	mux.HandleFunc(`GET /history`, func(w http.ResponseWriter, r *http.Request) {
-		skip, limit := r.FormValue(`skip`), r.FormValue(`limit`)
-		skipInt, _ := strconv.Atoi(skip)
-		limitInt, _ := strconv.Atoi(limit)
-		w.Header().Add(`Content-Type`, `plain/text`)
-		findOptions := options.Find().
-			SetSkip(int64(skipInt)).
-			SetLimit(int64(limitInt))
-
-		cursor, err := collection.Find(context.TODO(), bson.D{}, findOptions)
-		warnOnNonFatalError(log, err, `/history.collection.Find`)
-
-		var results []viewedMessageBody
-
-		err = cursor.All(context.TODO(), &results)
-		warnOnNonFatalError(log, err, `/history.Cursor.All`)
-
-		for _, result := range results {
-			log.Info(`cursor.All`, `videoPath`, result.VideoPath)
+		progressMsgs, err := svc.SubscribeProgress(progressCh, progress, cfg.Queue)
+		if err != nil {
+			return err
 		}
+		g.Go(func() error {
+			return svc.Consume(ctx, msgs)
+		})
+		g.Go(func() error {
+			return svc.ConsumeProgress(ctx, progressMsgs)
+		})
+	}
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(`:%d`, cfg.Port),
+		Handler: svc.Handler(),
+	}
+	if err := tlsx.Secure(ctx, log, srv, cfg.TLS); err != nil {
+		return err
+	}
+	g.Go(func() error {
+		log.Info(`Microservice online!`, `tls`, cfg.TLS.Enabled(), `h2c`, cfg.TLS.H2C && !cfg.TLS.Enabled())
+		return httpx.Serve(ctx, srv)
 	})
 
-	log.Info(`Microservice online!`)
-	return http.ListenAndServe(fmt.Sprintf(`:%s`, port), mux)
+	return g.Wait()
 }
 
-func warnOnNonFatalError(log *slog.Logger, err error, msg string) {
-	if err != nil {
-		log.Error(msg, `error`, err)
-	}
-}
+// openStore connects to the HistoryStore selected by HISTORY_STORE. The
+// returned function releases it.
+func openStore(log *slog.Logger, cfg serviceConfig) (service.HistoryStore, func(), error) {
+	switch cfg.Store {
+	case storeMemory:
+		return service.NewMemoryStore(), func() {}, nil
+
+	case storeFile:
+		store, err := service.OpenFileStore(cfg.File)
+		if err != nil {
+			return nil, nil, fmt.Errorf(`service.OpenFileStore: %w`, err)
+		}
+		return store, func() { store.Close() }, nil
+
+	default:
+		// Connect to Mongo
+		// https://pkg.go.dev/go.mongodb.org/mongo-driver/mongo
+		clientOpts := options.Client().
+			ApplyURI(cfg.DBHost)
+		client, err := mongo.Connect(context.TODO(), clientOpts)
+		if err != nil {
+			return nil, nil, fmt.Errorf(`mongo.Connect: %w`, err)
+		}
 
-func failWithError(log *slog.Logger, err error, msg string) {
-	if err != nil {
-		log.Error(msg, `error`, err)
-		os.Exit(2)
+		collection := client.Database(cfg.DBName).Collection(`history`)
+		store := service.NewMongoStore(collection)
+		if err := store.CreateIndexes(context.TODO()); err != nil {
+			// Most likely views recorded twice before the index existed;
+			// carry on without it until they are cleaned up.
+			log.Warn(`views delivered twice will be recorded twice`, logging.KeyError, err)
+		}
+		return store, func() { client.Disconnect(context.TODO()) }, nil
 	}
 }
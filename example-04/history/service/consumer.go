@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errDeliveriesClosed is returned by consume when RabbitMQ closes the
+// delivery channel, which happens when the connection or channel dies or
+// the consumer is cancelled.
+var errDeliveriesClosed = errors.New(`delivery channel closed`)
+
+// Acknowledgement modes accepted by ConsumerConfig.AckMode.
+const (
+	AckDelivery = `delivery`
+	AckBatch    = `batch`
+)
+
+// ConsumerConfig tunes how deliveries are taken off the queues. The zero
+// value handles one delivery at a time with no prefetch limit.
+type ConsumerConfig struct {
+	// Prefetch caps the deliveries RabbitMQ sends each consumer before they
+	// are acknowledged; 0 means no cap.
+	Prefetch int `env:"HISTORY_PREFETCH" default:"64"`
+	// Workers handle deliveries, or batches of views, in parallel.
+	Workers int `env:"HISTORY_WORKERS" default:"4"`
+	// BatchSize views are written with a single insert; a smaller batch is
+	// written once its first view has waited BatchInterval.
+	BatchSize     int           `env:"HISTORY_BATCH_SIZE" default:"32"`
+	BatchInterval time.Duration `env:"HISTORY_BATCH_INTERVAL" default:"250ms"`
+	// AckMode is delivery, acking each view on its own, or batch, acking a
+	// written batch with one multiple ack. Batch needs a single worker and
+	// a channel no other consumer uses, or it would ack their deliveries
+	// too.
+	AckMode string `env:"HISTORY_ACK_MODE" default:"delivery" validate:"oneof=delivery batch"`
+}
+
+// Validate reports settings that can't work together.
+func (c ConsumerConfig) Validate() error {
+	switch {
+	case c.Prefetch < 0 || c.Workers < 0 || c.BatchSize < 0 || c.BatchInterval < 0:
+		return errors.New(`HISTORY_PREFETCH, HISTORY_WORKERS, HISTORY_BATCH_SIZE and HISTORY_BATCH_INTERVAL must not be negative`)
+	case c.Prefetch > 0 && c.Prefetch < c.BatchSize:
+		return fmt.Errorf(`HISTORY_PREFETCH %d is smaller than HISTORY_BATCH_SIZE %d, so batches never fill`, c.Prefetch, c.BatchSize)
+	case c.AckMode == AckBatch && c.Workers > 1:
+		return errors.New(`HISTORY_ACK_MODE batch needs HISTORY_WORKERS=1: a multiple ack would settle other workers' deliveries`)
+	}
+	return nil
+}
+
+func (c ConsumerConfig) workers() int { return max(c.Workers, 1) }
+
+// BatchError is returned by HistoryStore.RecordMany when only some views
+// were written.
+type BatchError struct {
+	// Failed holds the indexes of the views that weren't written.
+	Failed []int
+	Err    error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf(`%d views not written: %v`, len(e.Failed), e.Err)
+}
+
+func (e *BatchError) Unwrap() error { return e.Err }
+
+// message is a BSON message body we consume.
+type message interface {
+	View | Progress
+	// check reports what makes a decoded message unusable.
+	check() error
+	requestID() string
+}
+
+// pending is a decoded delivery waiting to be recorded.
+type pending[M message] struct {
+	ctx  context.Context
+	span trace.Span
+	msg  amqp.Delivery
+	m    M
+}
+
+// consume records every message until ctx is cancelled or the broker goes
+// away, handing them to cfg.Workers goroutines. A message that can't be
+// decoded or stored is nacked and logged; it never stops the loop.
+func consume[M message](ctx context.Context, log *slog.Logger, queue string, cfg ConsumerConfig, msgs <-chan amqp.Delivery, record func(context.Context, M) error) error {
+	work := make(chan amqp.Delivery)
+	stop := startWorkers(cfg.workers(), work, func(msg amqp.Delivery) {
+		handle(ctx, log, queue, msg, record)
+	})
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf(`%s: %w`, queue, errDeliveriesClosed)
+			}
+			select {
+			case work <- msg:
+			case <-ctx.Done():
+				// Unacked, so RabbitMQ redelivers it once we disconnect.
+				return nil
+			}
+		}
+	}
+}
+
+// consumeBatches is consume for stores that write many messages at once:
+// messages are grouped into batches of up to cfg.BatchSize, each passed to
+// recordMany when full or cfg.BatchInterval after its first message.
+func consumeBatches[M message](ctx context.Context, log *slog.Logger, queue string, cfg ConsumerConfig, msgs <-chan amqp.Delivery, recordMany func(context.Context, []M) error) error {
+	work := make(chan []pending[M])
+	stop := startWorkers(cfg.workers(), work, func(batch []pending[M]) {
+		handleBatch(ctx, log, queue, cfg.AckMode, batch, recordMany)
+	})
+	defer stop()
+
+	size := max(cfg.BatchSize, 1)
+	var (
+		batch []pending[M]
+		due   <-chan time.Time
+	)
+	flush := func() bool {
+		select {
+		case work <- batch:
+			batch, due = nil, nil
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-due:
+			if !flush() {
+				return nil
+			}
+		case msg, ok := <-msgs:
+			if !ok {
+				// After a Cancel the batch can still be acked; if the
+				// channel died, RabbitMQ redelivers it anyway.
+				if len(batch) > 0 {
+					flush()
+				}
+				return fmt.Errorf(`%s: %w`, queue, errDeliveriesClosed)
+			}
+			p, ok := decode[M](ctx, log, queue, msg)
+			if !ok {
+				continue
+			}
+			batch = append(batch, p)
+			if len(batch) == 1 {
+				due = time.After(cfg.BatchInterval)
+			}
+			if len(batch) >= size && !flush() {
+				return nil
+			}
+		}
+	}
+}
+
+// startWorkers runs fn on everything sent to work in n goroutines. The
+// returned function closes work and waits for them to finish.
+func startWorkers[T any](n int, work chan T, fn func(T)) func() {
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				fn(item)
+			}
+		}()
+	}
+	return func() {
+		close(work)
+		wg.Wait()
+	}
+}
+
+func handle[M message](ctx context.Context, log *slog.Logger, queue string, msg amqp.Delivery, record func(context.Context, M) error) {
+	p, ok := decode[M](ctx, log, queue, msg)
+	if !ok {
+		return
+	}
+	defer p.span.End()
+
+	if err := record(p.ctx, p.m); err != nil {
+		p.span.RecordError(err)
+		p.span.SetStatus(codes.Error, `record`)
+		retry(p.ctx, log, msg, err)
+		return
+	}
+	msg.Ack(false)
+}
+
+// decode starts the message's consumer span and unmarshals it. A message
+// that can't be used is nacked, ending the span, and ok is false.
+func decode[M message](ctx context.Context, log *slog.Logger, queue string, msg amqp.Delivery) (p pending[M], ok bool) {
+	// Continue the trace started by the publisher.
+	ctx, span := tracer.Start(tracing.ExtractAMQP(ctx, msg.Headers), queue+` process`,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String(`messaging.system`, `rabbitmq`),
+			attribute.String(`messaging.destination.name`, queue),
+		))
+
+	var m M
+	err := bson.Unmarshal(msg.Body, &m)
+	if err == nil {
+		err = m.check()
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, `bson.Unmarshal`)
+		log.ErrorContext(ctx, `discarding malformed message`, logging.KeyError, err)
+		// Requeueing a message we can't read would only loop forever.
+		msg.Nack(false, false)
+		span.End()
+		return p, false
+	}
+	if v, ok := any(&m).(*View); ok {
+		v.MessageID = msg.MessageId
+	}
+	ctx = logging.WithRequestID(ctx, m.requestID())
+	return pending[M]{ctx: ctx, span: span, msg: msg, m: m}, true
+}
+
+// handleBatch records batch and settles every message in it: one multiple
+// ack in batch mode if all were written, otherwise one by one.
+func handleBatch[M message](ctx context.Context, log *slog.Logger, queue, ackMode string, batch []pending[M], recordMany func(context.Context, []M) error) {
+	links := make([]trace.Link, len(batch))
+	items := make([]M, len(batch))
+	for i, p := range batch {
+		links[i] = trace.LinkFromContext(p.ctx)
+		items[i] = p.m
+	}
+	ctx, span := tracer.Start(ctx, queue+` record`,
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int(`messaging.batch.message_count`, len(batch))))
+	defer span.End()
+
+	err := recordMany(ctx, items)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, `recordMany`)
+	}
+	var failed []int
+	var batchErr *BatchError
+	switch {
+	case errors.As(err, &batchErr):
+		failed = batchErr.Failed
+	case err != nil:
+		failed = make([]int, len(batch))
+		for i := range failed {
+			failed[i] = i
+		}
+	case ackMode == AckBatch:
+		batch[len(batch)-1].msg.Ack(true)
+		for _, p := range batch {
+			p.span.End()
+		}
+		return
+	}
+
+	for i, p := range batch {
+		if slices.Contains(failed, i) {
+			p.span.RecordError(err)
+			p.span.SetStatus(codes.Error, `record`)
+			retry(p.ctx, log, p.msg, err)
+		} else {
+			p.msg.Ack(false)
+		}
+		p.span.End()
+	}
+}
+
+// retry nacks a message that couldn't be recorded, requeueing it unless it
+// has already had its second attempt.
+func retry(ctx context.Context, log *slog.Logger, msg amqp.Delivery, err error) {
+	log.ErrorContext(ctx, `record message`, logging.KeyError, err, `requeue`, !msg.Redelivered)
+	msg.Nack(false, !msg.Redelivered)
+}
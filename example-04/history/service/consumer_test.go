@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// acknowledger records what the consumer did with each delivery tag.
+type acknowledger struct {
+	mu       sync.Mutex
+	acked    []uint64
+	multiple []bool
+	nacked   []uint64
+	requeue  []bool
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked = append(a.acked, tag)
+	a.multiple = append(a.multiple, multiple)
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked = append(a.nacked, tag)
+	a.requeue = append(a.requeue, requeue)
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func delivery(t *testing.T, ack amqp.Acknowledger, tag uint64, body any) amqp.Delivery {
+	t.Helper()
+	var payload []byte
+	switch b := body.(type) {
+	case []byte:
+		payload = b
+	default:
+		var err error
+		if payload, err = bson.Marshal(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return amqp.Delivery{Acknowledger: ack, DeliveryTag: tag, Body: payload}
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestConsumeViewedSurvivesBadMessages(t *testing.T) {
+	ack := &acknowledger{}
+	msgs := make(chan amqp.Delivery, 4)
+	msgs <- delivery(t, ack, 1, []byte(`not bson`))
+	msgs <- delivery(t, ack, 2, View{VideoPath: `fail`})
+	msgs <- delivery(t, ack, 3, View{VideoPath: `ok.mp4`})
+	close(msgs)
+
+	var recorded []string
+	record := func(_ context.Context, v View) error {
+		if v.VideoPath == `fail` {
+			return errors.New(`insert failed`)
+		}
+		recorded = append(recorded, v.VideoPath)
+		return nil
+	}
+
+	err := consume(context.Background(), discardLogger(), `historyQueue`, ConsumerConfig{}, msgs, record)
+	if !errors.Is(err, errDeliveriesClosed) {
+		t.Fatalf(`consume returned %v, want errDeliveriesClosed`, err)
+	}
+
+	if len(recorded) != 1 || recorded[0] != `ok.mp4` {
+		t.Errorf(`recorded %v, want [ok.mp4]`, recorded)
+	}
+	if len(ack.acked) != 1 || ack.acked[0] != 3 {
+		t.Errorf(`acked %v, want [3]`, ack.acked)
+	}
+	if len(ack.nacked) != 2 || ack.nacked[0] != 1 || ack.nacked[1] != 2 {
+		t.Fatalf(`nacked %v, want [1 2]`, ack.nacked)
+	}
+	if ack.requeue[0] {
+		t.Error(`malformed message was requeued`)
+	}
+	if !ack.requeue[1] {
+		t.Error(`failed insert was not requeued for a retry`)
+	}
+}
+
+func TestConsumeViewedKeepsMessageID(t *testing.T) {
+	ack := &acknowledger{}
+	msg := delivery(t, ack, 1, View{VideoPath: `ok.mp4`})
+	msg.MessageId = `m1`
+
+	var got View
+	handle(context.Background(), discardLogger(), `historyQueue`, msg, func(_ context.Context, v View) error {
+		got = v
+		return nil
+	})
+
+	if got.MessageID != `m1` {
+		t.Errorf(`MessageID = %q, want m1`, got.MessageID)
+	}
+}
+
+func TestConsumeViewedDropsFailedRedelivery(t *testing.T) {
+	ack := &acknowledger{}
+	msg := delivery(t, ack, 1, View{VideoPath: `ok.mp4`})
+	msg.Redelivered = true
+
+	handle(context.Background(), discardLogger(), `historyQueue`, msg, func(context.Context, View) error {
+		return errors.New(`insert failed`)
+	})
+
+	if len(ack.requeue) != 1 || ack.requeue[0] {
+		t.Errorf(`requeue = %v, want [false]`, ack.requeue)
+	}
+}
+
+func TestConsumeViewedStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- consume[View](ctx, discardLogger(), `historyQueue`, ConsumerConfig{Workers: 4}, make(chan amqp.Delivery), nil)
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf(`consume returned %v after cancel, want nil`, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal(`consume did not return after cancel`)
+	}
+}
+
+func TestConsumeBatchesAcksWholeBatch(t *testing.T) {
+	ack := &acknowledger{}
+	msgs := make(chan amqp.Delivery, 5)
+	for tag := uint64(1); tag <= 4; tag++ {
+		msgs <- delivery(t, ack, tag, View{VideoPath: `ok.mp4`})
+	}
+	msgs <- delivery(t, ack, 5, []byte(`not bson`))
+
+	var mu sync.Mutex
+	var batches []int
+	recordMany := func(_ context.Context, vs []View) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, len(vs))
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := ConsumerConfig{Workers: 1, BatchSize: 4, BatchInterval: time.Hour, AckMode: AckBatch}
+	done := make(chan error, 1)
+	go func() { done <- consumeBatches(ctx, discardLogger(), `historyQueue`, cfg, msgs, recordMany) }()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		ack.mu.Lock()
+		n := len(ack.acked) + len(ack.nacked)
+		ack.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(`batch was not settled`)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if len(batches) != 1 || batches[0] != 4 {
+		t.Errorf(`batches %v, want [4]`, batches)
+	}
+	if len(ack.acked) != 1 || ack.acked[0] != 4 || !ack.multiple[0] {
+		t.Errorf(`acked %v multiple %v, want one multiple ack of 4`, ack.acked, ack.multiple)
+	}
+	if len(ack.nacked) != 1 || ack.nacked[0] != 5 {
+		t.Errorf(`nacked %v, want [5]`, ack.nacked)
+	}
+}
+
+func TestConsumeBatchesFlushesOnInterval(t *testing.T) {
+	ack := &acknowledger{}
+	msgs := make(chan amqp.Delivery, 1)
+	msgs <- delivery(t, ack, 1, View{VideoPath: `ok.mp4`})
+
+	written := make(chan int, 1)
+	recordMany := func(_ context.Context, vs []View) error {
+		written <- len(vs)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := ConsumerConfig{Workers: 2, BatchSize: 100, BatchInterval: 10 * time.Millisecond}
+	go consumeBatches(ctx, discardLogger(), `historyQueue`, cfg, msgs, recordMany)
+
+	select {
+	case n := <-written:
+		if n != 1 {
+			t.Errorf(`wrote %d views, want 1`, n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal(`partial batch was never written`)
+	}
+}
+
+func TestHandleBatchNacksOnlyFailed(t *testing.T) {
+	ack := &acknowledger{}
+	var batch []pending[View]
+	for tag := uint64(1); tag <= 3; tag++ {
+		p, ok := decode[View](context.Background(), discardLogger(), `historyQueue`, delivery(t, ack, tag, View{VideoPath: `ok.mp4`}))
+		if !ok {
+			t.Fatal(`decode failed`)
+		}
+		batch = append(batch, p)
+	}
+
+	// Batch mode still falls back to acking one by one.
+	handleBatch(context.Background(), discardLogger(), `historyQueue`, AckBatch, batch, func(context.Context, []View) error {
+		return &BatchError{Failed: []int{1}, Err: errors.New(`duplicate key`)}
+	})
+
+	if len(ack.acked) != 2 || ack.acked[0] != 1 || ack.acked[1] != 3 || ack.multiple[0] || ack.multiple[1] {
+		t.Errorf(`acked %v multiple %v, want single acks of [1 3]`, ack.acked, ack.multiple)
+	}
+	if len(ack.nacked) != 1 || ack.nacked[0] != 2 || !ack.requeue[0] {
+		t.Errorf(`nacked %v requeue %v, want [2] requeued`, ack.nacked, ack.requeue)
+	}
+}
+
+func TestConsumerConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		cfg ConsumerConfig
+		ok  bool
+	}{
+		{ConsumerConfig{}, true},
+		{ConsumerConfig{Prefetch: 64, Workers: 4, BatchSize: 32, AckMode: AckDelivery}, true},
+		{ConsumerConfig{Prefetch: 64, Workers: 1, BatchSize: 32, AckMode: AckBatch}, true},
+		{ConsumerConfig{Prefetch: 64, Workers: 4, BatchSize: 32, AckMode: AckBatch}, false},
+		{ConsumerConfig{Prefetch: 8, BatchSize: 32}, false},
+		{ConsumerConfig{Workers: -1}, false},
+	} {
+		if err := tc.cfg.Validate(); (err == nil) != tc.ok {
+			t.Errorf(`%+v.Validate() = %v`, tc.cfg, err)
+		}
+	}
+}
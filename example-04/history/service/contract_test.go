@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+// brokenStore fails every read.
+type brokenStore struct{ *MemoryStore }
+
+func (brokenStore) List(context.Context, Query) ([]View, error) { return nil, errors.New(`broken`) }
+func (brokenStore) Stats(context.Context) (Stats, error)        { return Stats{}, errors.New(`broken`) }
+func (brokenStore) Progress(context.Context, string, string) (Progress, error) {
+	return Progress{}, errors.New(`broken`)
+}
+
+// TestContract sends the routes requests good and bad, checking every
+// response against openapi.json and that every operation in it is tried.
+func TestContract(t *testing.T) {
+	const secret = `test-secret`
+	ctx := context.Background()
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := func(id auth.Identity) string {
+		token, err := auth.Sign(secret, id, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return `Bearer ` + token
+	}
+	alice, admin := bearer(auth.Identity{Subject: `alice`}), bearer(auth.Identity{Subject: `ops`, Admin: true})
+
+	store := NewMemoryStore()
+	store.Record(ctx, View{VideoPath: `a.mp4`, UserID: `alice`, RequestID: `r1`})
+	store.Record(ctx, View{VideoPath: `b.mp4`, UserID: `bob`, RequestID: `r2`})
+	store.SaveProgress(ctx, Progress{VideoID: `a`, UserID: `alice`, Position: 12.5, Duration: 60, At: time.Now()})
+	handler := New(discardLogger(), store, verifier, ConsumerConfig{}).Handler()
+	broken := New(discardLogger(), brokenStore{NewMemoryStore()}, verifier, ConsumerConfig{}).Handler()
+
+	tried := map[string]bool{}
+	for _, c := range []struct {
+		broken                bool
+		target, authorization string
+		want                  int
+		wantBody              string
+	}{
+		{false, `/history`, alice, 200, `[{"videoPath":"a.mp4","requestId":"r1","userId":"alice"}]`},
+		{false, `/history?skip=1&limit=1`, alice, 200, `[]`},
+		{false, `/history?skip=-1`, alice, 400, ``},
+		{false, `/history?limit=ten`, alice, 400, ``},
+		{false, `/history?userId=bob`, alice, 403, ``},
+		{false, `/history?userId=bob`, admin, 200, `[{"videoPath":"b.mp4","requestId":"r2","userId":"bob"}]`},
+		{false, `/history`, ``, 401, ``},
+		{true, `/history`, alice, 500, ``},
+		{false, `/history/stats`, alice, 200, `{"total":2,"videos":[{"videoPath":"a.mp4","views":1},{"videoPath":"b.mp4","views":1}]}`},
+		{false, `/history/stats`, `Bearer forged`, 401, ``},
+		{true, `/history/stats`, alice, 500, ``},
+		{false, `/history/alice/resume/a`, alice, 200, ``},
+		{false, `/history/alice/resume/b`, alice, 404, ``},
+		{false, `/history/bob/resume/a`, alice, 403, ``},
+		{false, `/history/alice/resume/a`, ``, 401, ``},
+		{true, `/history/alice/resume/a`, alice, 500, ``},
+		{false, `/openapi.json`, ``, 200, ``},
+	} {
+		req := httptest.NewRequest(`GET`, c.target, nil)
+		if c.authorization != `` {
+			req.Header.Set(`Authorization`, c.authorization)
+		}
+		w := httptest.NewRecorder()
+		if c.broken {
+			broken.ServeHTTP(w, req)
+		} else {
+			handler.ServeHTTP(w, req)
+		}
+
+		if w.Code != c.want {
+			t.Errorf(`GET %s: %d, want %d (%s)`, c.target, w.Code, c.want, w.Body)
+			continue
+		}
+		if err := spec.CheckResponse(req, w.Result()); err != nil {
+			t.Error(err)
+		}
+		if got := strings.TrimSpace(w.Body.String()); c.wantBody != `` && got != c.wantBody {
+			t.Errorf(`GET %s: body %s, want %s`, c.target, got, c.wantBody)
+		}
+		tried[spec.Pattern(req)] = true
+	}
+
+	for _, op := range spec.Operations() {
+		if !tried[op] {
+			t.Errorf(`%s: not tried`, op)
+		}
+	}
+}
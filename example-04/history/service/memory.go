@@ -0,0 +1,251 @@
+package service
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+)
+
+// MemoryStore keeps views in memory, optionally journalled to a file of
+// JSON lines so they survive a restart. It needs no database, which makes
+// it handy for local development and tests.
+type MemoryStore struct {
+	mu       sync.Mutex
+	views    []View
+	messages map[string]bool
+	progress map[progressKey]Progress
+	journal  *os.File
+}
+
+type progressKey struct{ userID, videoID string }
+
+// journalEntry is one line of the journal: a view with its message ID, or
+// a progress update wrapped as {"progress": ...}.
+type journalEntry struct {
+	View
+	MessageID string    `json:"messageId,omitempty"`
+	Progress  *Progress `json:"progress,omitempty"`
+}
+
+var _ HistoryStore = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty store that forgets everything on exit.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{messages: map[string]bool{}, progress: map[progressKey]Progress{}}
+}
+
+// OpenFileStore returns a store journalled to path, replaying any views
+// already in it. Close the store to release the file.
+func OpenFileStore(path string) (*MemoryStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMemoryStore()
+	m.journal = f
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			f.Close()
+			return nil, fmt.Errorf(`%s:%d: %w`, path, line, err)
+		}
+		if e.Progress != nil {
+			m.saveProgress(*e.Progress)
+		} else {
+			e.View.MessageID = e.MessageID
+			m.add(e.View)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf(`%s: %w`, path, err)
+	}
+	return m, nil
+}
+
+// Close releases the journal, if any.
+func (m *MemoryStore) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.journal == nil {
+		return nil
+	}
+	err := m.journal.Close()
+	m.journal = nil
+	return err
+}
+
+// Record appends v, writing it to the journal first, unless its message
+// was recorded already.
+func (m *MemoryStore) Record(_ context.Context, v View) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.recorded(v) {
+		return nil
+	}
+	if err := m.write(journalEntry{View: v, MessageID: v.MessageID}); err != nil {
+		return err
+	}
+	m.add(v)
+	return nil
+}
+
+// RecordMany appends the views in vs not recorded already, journalling
+// them with a single write.
+func (m *MemoryStore) RecordMany(_ context.Context, vs []View) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var fresh []View
+	batch := map[string]bool{}
+	for _, v := range vs {
+		if m.recorded(v) || batch[v.MessageID] {
+			continue
+		}
+		if v.MessageID != `` {
+			batch[v.MessageID] = true
+		}
+		fresh = append(fresh, v)
+	}
+	vs = fresh
+	if m.journal != nil {
+		var buf []byte
+		for _, v := range vs {
+			line, err := json.Marshal(journalEntry{View: v, MessageID: v.MessageID})
+			if err != nil {
+				return err
+			}
+			buf = append(append(buf, line...), '\n')
+		}
+		if _, err := m.journal.Write(buf); err != nil {
+			return err
+		}
+	}
+	for _, v := range vs {
+		m.add(v)
+	}
+	return nil
+}
+
+// recorded reports whether v's message has a view already.
+func (m *MemoryStore) recorded(v View) bool {
+	return v.MessageID != `` && m.messages[v.MessageID]
+}
+
+func (m *MemoryStore) add(v View) {
+	m.views = append(m.views, v)
+	if v.MessageID != `` {
+		m.messages[v.MessageID] = true
+	}
+}
+
+// write appends entry to the journal, if any.
+func (m *MemoryStore) write(entry any) error {
+	if m.journal == nil {
+		return nil
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = m.journal.Write(append(line, '\n'))
+	return err
+}
+
+// SaveProgress keeps p unless a later heartbeat for its user and video
+// was stored. Ties go to p: BSON keeps only milliseconds, so heartbeats
+// sent in quick succession can share a timestamp.
+func (m *MemoryStore) SaveProgress(_ context.Context, p Progress) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if old, ok := m.progress[progressKey{p.UserID, p.VideoID}]; ok && p.At.Before(old.At) {
+		return nil
+	}
+	if err := m.write(struct {
+		Progress Progress `json:"progress"`
+	}{p}); err != nil {
+		return err
+	}
+	m.saveProgress(p)
+	return nil
+}
+
+func (m *MemoryStore) saveProgress(p Progress) {
+	key := progressKey{p.UserID, p.VideoID}
+	if old, ok := m.progress[key]; !ok || !p.At.Before(old.At) {
+		m.progress[key] = p
+	}
+}
+
+// Progress returns the user's latest position in the video.
+func (m *MemoryStore) Progress(_ context.Context, userID, videoID string) (Progress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.progress[progressKey{userID, videoID}]
+	if !ok {
+		return Progress{}, ErrNoProgress
+	}
+	return p, nil
+}
+
+// List returns the views selected by q, oldest first.
+func (m *MemoryStore) List(_ context.Context, q Query) ([]View, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var views []View
+	skip := q.Skip
+	for _, v := range m.views {
+		if q.UserID != `` && v.UserID != q.UserID {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if q.Limit > 0 && len(views) == q.Limit {
+			break
+		}
+		views = append(views, v)
+	}
+	return views, nil
+}
+
+// Count returns the number of views recorded.
+func (m *MemoryStore) Count(_ context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.views)), nil
+}
+
+// Stats counts views per video, most viewed first.
+func (m *MemoryStore) Stats(_ context.Context) (Stats, error) {
+	m.mu.Lock()
+	counts := map[string]int64{}
+	for _, v := range m.views {
+		counts[v.VideoPath]++
+	}
+	total := int64(len(m.views))
+	m.mu.Unlock()
+
+	stats := Stats{Total: total, Videos: make([]VideoStat, 0, len(counts))}
+	for path, views := range counts {
+		stats.Videos = append(stats.Videos, VideoStat{VideoPath: path, Views: views})
+	}
+	slices.SortFunc(stats.Videos, func(a, b VideoStat) int {
+		if c := cmp.Compare(b.Views, a.Views); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.VideoPath, b.VideoPath)
+	})
+	return stats, nil
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileStoreReplaysJournal(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), `history.jsonl`)
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{`a.mp4`, `b.mp4`, `a.mp4`} {
+		if err := store.Record(ctx, View{VideoPath: p}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if n, _ := store.Count(ctx); n != 3 {
+		t.Errorf(`Count = %d, want 3`, n)
+	}
+
+	page, _ := store.List(ctx, Query{Skip: 1, Limit: 1})
+	if want := []View{{VideoPath: `b.mp4`}}; !reflect.DeepEqual(page, want) {
+		t.Errorf(`List(skip 1, limit 1) = %v, want %v`, page, want)
+	}
+
+	stats, _ := store.Stats(ctx)
+	want := Stats{Total: 3, Videos: []VideoStat{{`a.mp4`, 2}, {`b.mp4`, 1}}}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf(`Stats = %+v, want %+v`, stats, want)
+	}
+}
+
+func TestDuplicateMessagesRecordedOnce(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), `history.jsonl`)
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Record(ctx, View{VideoPath: `a.mp4`, RequestID: `r1`, MessageID: `m1`}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// A replay of m1, a batch holding m2 twice, two views of one request
+	// that are different events, and two views without message IDs,
+	// which can't be told apart and are both kept.
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Record(ctx, View{VideoPath: `a.mp4`, RequestID: `r1`, MessageID: `m1`}); err != nil {
+		t.Fatal(err)
+	}
+	err = store.RecordMany(ctx, []View{
+		{VideoPath: `a.mp4`, RequestID: `r1`, MessageID: `m1`},
+		{VideoPath: `b.mp4`, MessageID: `m2`},
+		{VideoPath: `b.mp4`, MessageID: `m2`},
+		{VideoPath: `c.mp4`, RequestID: `r3`, MessageID: `m3`},
+		{VideoPath: `c.mp4`, RequestID: `r3`, MessageID: `m4`},
+		{VideoPath: `d.mp4`},
+		{VideoPath: `d.mp4`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := store.Count(ctx); n != 6 {
+		t.Errorf(`Count = %d, want 6`, n)
+	}
+}
+
+func TestListScopedToUser(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	for _, v := range []View{
+		{VideoPath: `a.mp4`, UserID: `alice`},
+		{VideoPath: `b.mp4`, UserID: `bob`},
+		{VideoPath: `c.mp4`, UserID: `alice`},
+	} {
+		store.Record(ctx, v)
+	}
+
+	got, _ := store.List(ctx, Query{UserID: `alice`, Skip: 1})
+	if want := []View{{VideoPath: `c.mp4`, UserID: `alice`}}; !reflect.DeepEqual(got, want) {
+		t.Errorf(`List(alice, skip 1) = %v, want %v`, got, want)
+	}
+	if got, _ := store.List(ctx, Query{UserID: `carol`}); len(got) != 0 {
+		t.Errorf(`List(carol) = %v, want nothing`, got)
+	}
+}
+
+func TestProgressKeepsLatest(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), `history.jsonl`)
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, p := range []Progress{
+		{UserID: `alice`, VideoID: `a.mp4`, Position: 10, At: start},
+		{UserID: `alice`, VideoID: `a.mp4`, Position: 30, At: start.Add(20 * time.Second)},
+		// A heartbeat redelivered late must not move her back.
+		{UserID: `alice`, VideoID: `a.mp4`, Position: 20, At: start.Add(10 * time.Second)},
+		{UserID: `bob`, VideoID: `a.mp4`, Position: 5, At: start},
+	} {
+		if err := store.SaveProgress(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Record(ctx, View{VideoPath: `a.mp4`, UserID: `alice`}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// The progress survives a restart without being mistaken for views.
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if n, _ := store.Count(ctx); n != 1 {
+		t.Errorf(`Count = %d, want 1`, n)
+	}
+	if p, err := store.Progress(ctx, `alice`, `a.mp4`); err != nil || p.Position != 30 {
+		t.Errorf(`Progress(alice) = %+v, %v; want position 30`, p, err)
+	}
+	if p, err := store.Progress(ctx, `bob`, `a.mp4`); err != nil || p.Position != 5 {
+		t.Errorf(`Progress(bob) = %+v, %v; want position 5`, p, err)
+	}
+	if _, err := store.Progress(ctx, `alice`, `b.mp4`); !errors.Is(err, ErrNoProgress) {
+		t.Errorf(`Progress of an unwatched video: %v, want ErrNoProgress`, err)
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MongoStore keeps views in a MongoDB collection, and playback progress in
+// the progress collection next to it.
+type MongoStore struct {
+	collection *mongo.Collection
+	progress   *mongo.Collection
+}
+
+var _ HistoryStore = (*MongoStore)(nil)
+
+// NewMongoStore stores views in collection.
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{
+		collection: collection,
+		progress:   collection.Database().Collection(`progress`),
+	}
+}
+
+// CreateIndexes creates the unique index on messageId that makes an event
+// delivered twice, e.g. by an archive replay, be stored once. Views without
+// a message ID, from publishers that don't set one, aren't indexed.
+//
+// It drops the unique requestId index earlier versions created: request
+// IDs come from clients, so two views could share one.
+func (m *MongoStore) CreateIndexes(ctx context.Context) error {
+	_, err := m.collection.Indexes().DropOne(ctx, `requestId_1`)
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && (cmdErr.Code == codeNamespaceNotFound || cmdErr.Code == codeIndexNotFound)) {
+		return err
+	}
+
+	_, err = m.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: `messageId`, Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: `messageId`, Value: bson.D{{Key: `$type`, Value: `string`}}}}),
+	})
+	return err
+}
+
+// MongoDB error codes for dropping an index that isn't there.
+const (
+	codeNamespaceNotFound = 26
+	codeIndexNotFound     = 27
+)
+
+// Record inserts v, wrapped in a client span for the Mongo call. A view
+// whose message ID is stored already collides with it on the messageId
+// index and is skipped, leaving a span event.
+func (m *MongoStore) Record(ctx context.Context, v View) error {
+	ctx, span := tracer.Start(ctx, `history.insert`,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String(`db.system`, `mongodb`),
+			attribute.String(`db.collection.name`, m.collection.Name()),
+			attribute.String(`db.operation.name`, `insert`),
+		))
+	defer span.End()
+
+	_, err := m.collection.InsertOne(ctx, v)
+	if mongo.IsDuplicateKeyError(err) {
+		span.AddEvent(`duplicate message skipped`, trace.WithAttributes(attribute.String(`messaging.message.id`, v.MessageID)))
+		return nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, `collection.InsertOne`)
+	}
+	return err
+}
+
+// RecordMany inserts vs with a single unordered InsertMany, so one bad
+// document doesn't stop the rest. Duplicate messages are skipped as in
+// Record.
+func (m *MongoStore) RecordMany(ctx context.Context, vs []View) error {
+	ctx, span := tracer.Start(ctx, `history.insertMany`,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String(`db.system`, `mongodb`),
+			attribute.String(`db.collection.name`, m.collection.Name()),
+			attribute.String(`db.operation.name`, `insertMany`),
+			attribute.Int(`db.operation.batch.size`, len(vs)),
+		))
+	defer span.End()
+
+	docs := make([]any, len(vs))
+	for i, v := range vs {
+		docs[i] = v
+	}
+	_, err := m.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return nil
+	}
+
+	// Without a write concern error, the documents not listed were written,
+	// and those that collided on messageId were written before.
+	var bulk mongo.BulkWriteException
+	if errors.As(err, &bulk) && bulk.WriteConcernError == nil && len(bulk.WriteErrors) > 0 {
+		var failed []int
+		for _, we := range bulk.WriteErrors {
+			if mongo.IsDuplicateKeyError(we.WriteError) {
+				span.AddEvent(`duplicate message skipped`, trace.WithAttributes(attribute.String(`messaging.message.id`, vs[we.Index].MessageID)))
+			} else {
+				failed = append(failed, we.Index)
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, `collection.InsertMany`)
+		return &BatchError{Failed: failed, Err: err}
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, `collection.InsertMany`)
+	return err
+}
+
+// List finds the entries matching q, oldest first by their ObjectID,
+// ignoring the first q.Skip and retrieving up to q.Limit. Without the sort
+// MongoDB may return pages in any order.
+func (m *MongoStore) List(ctx context.Context, q Query) ([]View, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: `_id`, Value: 1}}).
+		SetSkip(int64(q.Skip)).
+		SetLimit(int64(q.Limit))
+
+	filter := bson.D{}
+	if q.UserID != `` {
+		filter = append(filter, bson.E{Key: `userId`, Value: q.UserID})
+	}
+	cursor, err := m.collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []View
+	err = cursor.All(ctx, &results)
+	return results, err
+}
+
+// Count counts every document in the collection.
+func (m *MongoStore) Count(ctx context.Context) (int64, error) {
+	return m.collection.CountDocuments(ctx, bson.D{})
+}
+
+// Stats groups the collection by video, most viewed first.
+func (m *MongoStore) Stats(ctx context.Context) (Stats, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: `$group`, Value: bson.D{
+			{Key: `_id`, Value: `$videoPath`},
+			{Key: `views`, Value: bson.D{{Key: `$sum`, Value: 1}}},
+		}}},
+		{{Key: `$sort`, Value: bson.D{{Key: `views`, Value: -1}, {Key: `_id`, Value: 1}}}},
+	}
+
+	cursor, err := m.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var stats Stats
+	if err := cursor.All(ctx, &stats.Videos); err != nil {
+		return Stats{}, err
+	}
+	for _, v := range stats.Videos {
+		stats.Total += v.Views
+	}
+	return stats, nil
+}
+
+// progressID keys the progress collection: one document per user and
+// video.
+type progressID struct {
+	UserID  string `bson:"userId"`
+	VideoID string `bson:"videoId"`
+}
+
+// SaveProgress upserts p unless the stored document is more recent. When
+// it is, the filter misses and the upsert collides with the
+// existing _id, which we take to mean there is nothing to do.
+func (m *MongoStore) SaveProgress(ctx context.Context, p Progress) error {
+	ctx, span := tracer.Start(ctx, `progress.upsert`,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String(`db.system`, `mongodb`),
+			attribute.String(`db.collection.name`, m.progress.Name()),
+			attribute.String(`db.operation.name`, `update`),
+		))
+	defer span.End()
+
+	filter := bson.D{
+		{Key: `_id`, Value: progressID{UserID: p.UserID, VideoID: p.VideoID}},
+		{Key: `at`, Value: bson.D{{Key: `$lte`, Value: p.At}}},
+	}
+	update := bson.D{{Key: `$set`, Value: p}}
+	_, err := m.progress.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, `collection.UpdateOne`)
+	}
+	return err
+}
+
+// Progress finds the user's document for the video.
+func (m *MongoStore) Progress(ctx context.Context, userID, videoID string) (Progress, error) {
+	var p Progress
+	err := m.progress.FindOne(ctx, bson.D{{Key: `_id`, Value: progressID{UserID: userID, VideoID: videoID}}}).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Progress{}, ErrNoProgress
+	}
+	return p, err
+}
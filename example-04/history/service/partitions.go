@@ -0,0 +1,381 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Partitioned topology. Views and progress each pass from the topic exchange
+// through a consistent hash exchange to PartitionConfig.Partitions queues,
+// historyQueue.0, historyQueue.1 and so on, hashed by user. Replicas share
+// out the partitions between them by announcing themselves on
+// MembersExchange.
+const (
+	ViewPartitions     = `history.views`
+	ProgressPartitions = `history.progress`
+	MembersExchange    = `history.members`
+)
+
+// PartitionConfig splits the history queues so that replicas can scale out
+// while each user's events are handled by one replica at a time.
+type PartitionConfig struct {
+	// Partitions is how many queues each of historyQueue and playbackQueue
+	// is split into; 0 keeps the single queues, shared by every replica.
+	// Changing it needs the old queues drained and deleted.
+	Partitions int `env:"HISTORY_PARTITIONS" default:"0"`
+	// Replica names this replica to the others. It must be unique; main
+	// defaults it to the host name.
+	Replica string `env:"HISTORY_REPLICA"`
+	// Heartbeat is how often each replica announces itself. One not heard
+	// from for three heartbeats is taken to have left.
+	Heartbeat time.Duration `env:"HISTORY_HEARTBEAT" default:"2s"`
+}
+
+// Validate reports settings that can't work together.
+func (c PartitionConfig) Validate() error {
+	switch {
+	case c.Partitions < 0:
+		return errors.New(`HISTORY_PARTITIONS must not be negative`)
+	case c.Partitions > 0 && c.Heartbeat <= 0:
+		return errors.New(`HISTORY_HEARTBEAT must be positive`)
+	}
+	return nil
+}
+
+// partitionQueue names partition i of queue.
+func partitionQueue(queue string, i int) string {
+	return queue + `.` + strconv.Itoa(i)
+}
+
+// member is a MembersExchange message.
+type member struct {
+	Replica string `bson:"replica"`
+	// Leaving is set by a replica shutting down, so the others can take
+	// its partitions over without waiting for it to time out.
+	Leaving bool `bson:"leaving,omitempty"`
+}
+
+// RunPartitions declares the partitioned topology on ch, binding views by
+// the views patterns and progress by the progress patterns, and consumes
+// the partitions this replica owns until ctx is cancelled or the broker
+// goes away. Each partition queue is declared as q describes, with a single
+// active consumer so only one replica ever consumes it.
+//
+// Ownership follows messaging.Owner over the replicas currently heard
+// from, so partitions move as replicas join and leave. The consumer for a
+// partition given up is cancelled and drained before the next owner gets
+// its messages.
+func (s *Service) RunPartitions(ctx context.Context, ch messaging.Channel, cfg PartitionConfig, views, progress messaging.Bindings, q messaging.QueueConfig) error {
+	p := s.partitioner(ch, cfg)
+	if err := p.declare(views, progress, q); err != nil {
+		return err
+	}
+	return p.run(ctx)
+}
+
+// partitioner consumes the partitions one replica owns.
+type partitioner struct {
+	svc  *Service
+	ch   messaging.Channel
+	cfg  PartitionConfig
+	errs chan error
+	// queue is how the partition queues were declared.
+	queue messaging.QueueConfig
+
+	// seen holds when each other replica was last heard from.
+	seen map[string]time.Time
+
+	mu    sync.Mutex
+	owned map[int]*partition
+}
+
+// partition is a running pair of consumers, for views and progress.
+type partition struct {
+	tags    []string
+	stopped atomic.Bool
+	wg      sync.WaitGroup
+}
+
+func (s *Service) partitioner(ch messaging.Channel, cfg PartitionConfig) *partitioner {
+	return &partitioner{
+		svc:   s,
+		ch:    ch,
+		cfg:   cfg,
+		errs:  make(chan error, 1),
+		seen:  map[string]time.Time{},
+		owned: map[int]*partition{},
+	}
+}
+
+// declare creates the partition exchanges and queues.
+func (p *partitioner) declare(views, progress messaging.Bindings, q messaging.QueueConfig) error {
+	q.SingleActive = true
+	p.queue = q
+	for _, t := range []struct {
+		exchange, queue, legacy string
+		b                       messaging.Bindings
+	}{
+		{ViewPartitions, HistoryQueue, ViewedExchange, views},
+		{ProgressPartitions, PlaybackQueue, PlaybackExchange, progress},
+	} {
+		if err := messaging.DeclareTopic(p.ch, t.b.Exchange); err != nil {
+			return err
+		}
+		if err := messaging.DeclarePartitions(p.ch, t.exchange); err != nil {
+			return err
+		}
+		for _, pattern := range t.b.Patterns {
+			if err := p.ch.ExchangeBind(t.exchange, pattern, t.b.Exchange, false, nil); err != nil {
+				return fmt.Errorf(`ch.ExchangeBind %s %s: %w`, t.exchange, pattern, err)
+			}
+		}
+		if t.b.KeepLegacy {
+			// Events from publishers that only know the fanout lack the
+			// partition header, so they all hash to the same partition.
+			if err := messaging.DeclareFanout(p.ch, t.legacy); err != nil {
+				return err
+			}
+			if err := p.ch.ExchangeBind(t.exchange, ``, t.legacy, false, nil); err != nil {
+				return fmt.Errorf(`ch.ExchangeBind %s %s: %w`, t.exchange, t.legacy, err)
+			}
+		}
+		for i := range p.cfg.Partitions {
+			queue, err := messaging.DeclareQueue(p.ch, partitionQueue(t.queue, i), q)
+			if err != nil {
+				return err
+			}
+			// Every partition weighs the same.
+			if err := p.ch.QueueBind(queue.Name, `1`, t.exchange, false, nil); err != nil {
+				return fmt.Errorf(`ch.QueueBind %s: %w`, queue.Name, err)
+			}
+		}
+	}
+	if err := p.ch.Qos(p.svc.consumer.Prefetch, 0, false); err != nil {
+		return fmt.Errorf(`ch.Qos: %w`, err)
+	}
+	return nil
+}
+
+func (p *partitioner) run(ctx context.Context) error {
+	log := p.svc.log
+	if err := p.ch.ExchangeDeclare(MembersExchange, amqp.ExchangeFanout, false, false, false, false, nil); err != nil {
+		return fmt.Errorf(`ch.ExchangeDeclare %s: %w`, MembersExchange, err)
+	}
+	inbox, err := p.ch.QueueDeclare(``, false, true, true, false, nil)
+	if err != nil {
+		return fmt.Errorf(`ch.QueueDeclare: %w`, err)
+	}
+	if err := p.ch.QueueBind(inbox.Name, ``, MembersExchange, false, nil); err != nil {
+		return fmt.Errorf(`ch.QueueBind: %w`, err)
+	}
+	announcements, err := p.ch.Consume(inbox.Name, ``, true, true, false, false, nil)
+	if err != nil {
+		return fmt.Errorf(`ch.Consume: %w`, err)
+	}
+
+	defer func() {
+		// Let the others take over straight away, then finish what we have.
+		p.announce(context.WithoutCancel(ctx), true)
+		p.stopAll()
+	}()
+
+	ticker := time.NewTicker(p.cfg.Heartbeat)
+	defer ticker.Stop()
+	if err := p.announce(ctx, false); err != nil {
+		return err
+	}
+	p.rebalance(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-p.errs:
+			return err
+		case <-ticker.C:
+			if err := p.announce(ctx, false); err != nil {
+				return err
+			}
+			p.rebalance(ctx)
+		case d, ok := <-announcements:
+			if !ok {
+				return fmt.Errorf(`%s: %w`, MembersExchange, errDeliveriesClosed)
+			}
+			var m member
+			if err := bson.Unmarshal(d.Body, &m); err != nil || m.Replica == `` {
+				log.WarnContext(ctx, `ignoring malformed announcement`, logging.KeyError, err)
+				continue
+			}
+			if m.Replica == p.cfg.Replica {
+				continue
+			}
+			_, known := p.seen[m.Replica]
+			joined, left := !known && !m.Leaving, known && m.Leaving
+			if m.Leaving {
+				delete(p.seen, m.Replica)
+			} else {
+				p.seen[m.Replica] = time.Now()
+			}
+			if joined {
+				// Tell the newcomer about us rather than leave it thinking
+				// it is alone until our next heartbeat.
+				if err := p.announce(ctx, false); err != nil {
+					return err
+				}
+			}
+			if joined || left {
+				p.rebalance(ctx)
+			}
+		}
+	}
+}
+
+func (p *partitioner) announce(ctx context.Context, leaving bool) error {
+	body, err := bson.Marshal(member{Replica: p.cfg.Replica, Leaving: leaving})
+	if err != nil {
+		return fmt.Errorf(`bson.Marshal: %w`, err)
+	}
+	err = p.ch.PublishWithContext(ctx, MembersExchange, ``, false, false, amqp.Publishing{
+		ContentType: `application/bson`,
+		Body:        body,
+	})
+	if err != nil {
+		return fmt.Errorf(`channel.Publish %s: %w`, MembersExchange, err)
+	}
+	return nil
+}
+
+// members returns this replica and every other one heard from recently,
+// forgetting those that have gone quiet.
+func (p *partitioner) members() []string {
+	members := []string{p.cfg.Replica}
+	for replica, at := range p.seen {
+		if time.Since(at) > 3*p.cfg.Heartbeat {
+			delete(p.seen, replica)
+			continue
+		}
+		members = append(members, replica)
+	}
+	return members
+}
+
+// rebalance starts consuming the partitions this replica now owns and
+// stops consuming the ones it no longer does.
+func (p *partitioner) rebalance(ctx context.Context) {
+	members := p.members()
+	changed := false
+	for i := range p.cfg.Partitions {
+		mine := messaging.Owner(i, members) == p.cfg.Replica
+		p.mu.Lock()
+		running, ok := p.owned[i]
+		p.mu.Unlock()
+		switch {
+		case mine && !ok:
+			if err := p.start(ctx, i); err != nil {
+				p.fail(err)
+				return
+			}
+			changed = true
+		case !mine && ok:
+			p.stop(i, running)
+			changed = true
+		}
+	}
+	if changed {
+		p.svc.log.InfoContext(ctx, `rebalanced partitions`, `replicas`, len(members), `owned`, p.Owned())
+	}
+}
+
+// start consumes partition i of both queues.
+func (p *partitioner) start(ctx context.Context, i int) error {
+	s := p.svc
+	part := &partition{}
+	for _, c := range []struct {
+		queue string
+		run   func(context.Context, <-chan amqp.Delivery) error
+	}{
+		{HistoryQueue, func(ctx context.Context, msgs <-chan amqp.Delivery) error {
+			return consumeBatches(ctx, s.log, HistoryQueue, s.consumer, s.seen.Filter(ctx, msgs), s.recordMany)
+		}},
+		{PlaybackQueue, func(ctx context.Context, msgs <-chan amqp.Delivery) error {
+			return consume(ctx, s.log, PlaybackQueue, s.consumer, s.seen.Filter(ctx, msgs), s.saveProgress)
+		}},
+	} {
+		name := p.queue.Name(partitionQueue(c.queue, i))
+		tag := p.cfg.Replica + `/` + name
+		msgs, err := p.ch.Consume(name, tag, false, false, false, false, nil)
+		if err != nil {
+			p.stop(i, part)
+			return fmt.Errorf(`ch.Consume %s: %w`, name, err)
+		}
+		part.tags = append(part.tags, tag)
+		part.wg.Add(1)
+		go func() {
+			defer part.wg.Done()
+			err := c.run(ctx, msgs)
+			if errors.Is(err, errDeliveriesClosed) && part.stopped.Load() {
+				return
+			}
+			if err != nil {
+				p.fail(err)
+			}
+		}()
+	}
+
+	p.mu.Lock()
+	p.owned[i] = part
+	p.mu.Unlock()
+	return nil
+}
+
+// stop cancels partition i's consumers and waits for them to finish with
+// the deliveries they already had.
+func (p *partitioner) stop(i int, part *partition) {
+	part.stopped.Store(true)
+	for _, tag := range part.tags {
+		if err := p.ch.Cancel(tag, false); err != nil {
+			p.svc.log.Warn(`ch.Cancel`, `consumer`, tag, logging.KeyError, err)
+		}
+	}
+	part.wg.Wait()
+
+	p.mu.Lock()
+	delete(p.owned, i)
+	p.mu.Unlock()
+}
+
+func (p *partitioner) stopAll() {
+	p.mu.Lock()
+	owned := maps.Clone(p.owned)
+	p.mu.Unlock()
+	for i, part := range owned {
+		p.stop(i, part)
+	}
+}
+
+// fail hands err to run, unless it already has one to return.
+func (p *partitioner) fail(err error) {
+	select {
+	case p.errs <- err:
+	default:
+	}
+}
+
+// Owned returns the partitions this replica is consuming, in order.
+func (p *partitioner) Owned() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Sorted(maps.Keys(p.owned))
+}
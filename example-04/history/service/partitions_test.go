@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging/memory"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// replica is one history consumer in TestPartitionsRebalance, with a store
+// of its own so the test can see which replica recorded what.
+type replica struct {
+	store *MemoryStore
+	p     *partitioner
+	stop  context.CancelFunc
+	done  chan error
+}
+
+func startReplica(t *testing.T, broker *memory.Broker, name string, partitions int) *replica {
+	t.Helper()
+	store := NewMemoryStore()
+	svc := New(discardLogger(), store, nil, ConsumerConfig{Prefetch: 8, Workers: 2, BatchSize: 4, BatchInterval: time.Millisecond})
+	ch := broker.Channel()
+	p := svc.partitioner(ch, PartitionConfig{Partitions: partitions, Replica: name, Heartbeat: 20 * time.Millisecond})
+	bindings := func(key string) messaging.Bindings {
+		return messaging.Bindings{Exchange: messaging.DefaultExchange, Patterns: []string{key}}
+	}
+	if err := p.declare(bindings(messaging.VideoViewed), bindings(messaging.PlaybackProgress), messaging.QueueConfig{Type: messaging.Quorum}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &replica{store: store, p: p, stop: cancel, done: make(chan error, 1)}
+	go func() {
+		err := p.run(ctx)
+		ch.Close()
+		r.done <- err
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-r.done; err != nil {
+			t.Errorf(`%s: %v`, name, err)
+		}
+	})
+	return r
+}
+
+// eventually polls cond until it holds or a few seconds have passed.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf(`timed out waiting until %s`, what)
+		}
+	}
+}
+
+func TestPartitionsRebalance(t *testing.T) {
+	const partitions = 8
+	broker := memory.New()
+	t.Cleanup(func() { broker.Close() })
+	pub := broker.Channel()
+
+	view := func(user string, n int) {
+		t.Helper()
+		body, err := bson.Marshal(View{VideoPath: fmt.Sprintf(`%d.mp4`, n), UserID: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = pub.PublishWithContext(context.Background(), messaging.DefaultExchange, messaging.VideoViewed, false, false, amqp.Publishing{
+			Headers: amqp.Table{messaging.PartitionHeader: messaging.PartitionKey(user, ``)},
+			Body:    body,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	users := make([]string, 20)
+	for i := range users {
+		users[i] = fmt.Sprintf(`user-%d`, i)
+	}
+	publishRound := func(round int) {
+		for _, user := range users {
+			view(user, round)
+		}
+	}
+	recorded := func(rs ...*replica) int {
+		total := 0
+		for _, r := range rs {
+			n, _ := r.store.Count(context.Background())
+			total += int(n)
+		}
+		return total
+	}
+	owned := func(r *replica) int { return len(r.p.Owned()) }
+
+	// Three replicas settle on sharing out every partition exactly once.
+	a := startReplica(t, broker, `a`, partitions)
+	b := startReplica(t, broker, `b`, partitions)
+	c := startReplica(t, broker, `c`, partitions)
+	eventually(t, `the partitions are shared out`, func() bool {
+		all := slices.Concat(a.p.Owned(), b.p.Owned(), c.p.Owned())
+		slices.Sort(all)
+		return len(all) == partitions && len(slices.Compact(all)) == partitions &&
+			owned(a) > 0 && owned(b) > 0 && owned(c) > 0
+	})
+
+	publishRound(1)
+	eventually(t, `the first round is recorded`, func() bool { return recorded(a, b, c) == len(users) })
+
+	// Each user's views all went to one replica.
+	owner := map[string]*replica{}
+	for _, r := range []*replica{a, b, c} {
+		views, err := r.store.List(context.Background(), Query{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range views {
+			if prev, ok := owner[v.UserID]; ok && prev != r {
+				t.Errorf(`%s's views were split between replicas`, v.UserID)
+			}
+			owner[v.UserID] = r
+		}
+	}
+	if len(owner) != len(users) {
+		t.Errorf(`views for %d users recorded, want %d`, len(owner), len(users))
+	}
+
+	// When c leaves, a and b pick up its partitions.
+	c.stop()
+	if err := <-c.done; err != nil {
+		t.Fatal(err)
+	}
+	c.done <- nil
+	eventually(t, `c's partitions are taken over`, func() bool { return owned(a)+owned(b) == partitions })
+
+	left := recorded(c)
+	publishRound(2)
+	eventually(t, `the second round is recorded`, func() bool { return recorded(a, b, c) == 2*len(users) })
+	if got := recorded(c); got != left {
+		t.Errorf(`c recorded %d views after leaving`, got-left)
+	}
+
+	// A replica joining takes its share back.
+	d := startReplica(t, broker, `d`, partitions)
+	eventually(t, `d takes some partitions`, func() bool {
+		return owned(d) > 0 && owned(a)+owned(b)+owned(d) == partitions
+	})
+	publishRound(3)
+	eventually(t, `the third round is recorded`, func() bool { return recorded(a, b, c, d) == 3*len(users) })
+
+	for i := range partitions {
+		if ready, unacked := broker.QueueDepth(partitionQueue(HistoryQueue, i)); ready+unacked != 0 {
+			t.Errorf(`partition %d left %d ready, %d unacked`, i, ready, unacked)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Playback topology names. PlaybackExchange is the fanout progress was
+// published to before the topic exchange.
+const (
+	PlaybackExchange = `PlaybackProgress`
+	PlaybackQueue    = `playbackQueue`
+)
+
+// ErrNoProgress is returned by ProgressStore.Progress when the user has
+// never played the video.
+var ErrNoProgress = errors.New(`no playback progress`)
+
+// Progress is a playback.progress event as published by video-streaming,
+// and the latest one is stored per user and video.
+type Progress struct {
+	VideoID string `json:"videoId" bson:"videoId"`
+	UserID  string `json:"userId" bson:"userId"`
+	// Position and Duration are in seconds; Duration is 0 if unknown.
+	Position float64 `json:"position" bson:"position"`
+	Duration float64 `json:"duration,omitempty" bson:"duration,omitempty"`
+	// At is when video-streaming received the heartbeat.
+	At        time.Time `json:"at" bson:"at"`
+	RequestID string    `json:"-" bson:"requestId,omitempty"`
+}
+
+func (p Progress) check() error {
+	switch {
+	case p.VideoID == ``:
+		return errors.New(`missing videoId`)
+	case p.UserID == ``:
+		// Anonymous viewers have nowhere to resume from.
+		return errors.New(`missing userId`)
+	case p.Position < 0:
+		return errors.New(`negative position`)
+	}
+	return nil
+}
+
+func (p Progress) requestID() string { return p.RequestID }
+
+// ProgressStore keeps each user's latest position in each video.
+type ProgressStore interface {
+	// SaveProgress stores p unless a later heartbeat for the same user and
+	// video is already stored, so redeliveries can't move it backwards.
+	SaveProgress(ctx context.Context, p Progress) error
+	// Progress returns the latest position stored, or ErrNoProgress.
+	Progress(ctx context.Context, userID, videoID string) (Progress, error)
+}
+
+// SubscribeProgress declares playbackQueue as q describes, binds it to the
+// topic exchange with b's patterns, usually playback.progress, and starts
+// consuming. Pass the returned channel to ConsumeProgress.
+func (s *Service) SubscribeProgress(ch messaging.Channel, b messaging.Bindings, q messaging.QueueConfig) (<-chan amqp.Delivery, error) {
+	queue, err := messaging.DeclareQueue(ch, PlaybackQueue, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := messaging.Bind(ch, queue.Name, b, PlaybackExchange); err != nil {
+		return nil, err
+	}
+	if err := ch.Qos(s.consumer.Prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf(`ch.Qos: %w`, err)
+	}
+	msgs, err := ch.Consume(queue.Name, ``, false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf(`ch.Consume: %w`, err)
+	}
+	return msgs, nil
+}
+
+// ConsumeProgress stores playback positions from msgs until ctx is
+// cancelled or the broker closes msgs. Workers may store them out of order;
+// SaveProgress keeps the latest regardless.
+func (s *Service) ConsumeProgress(ctx context.Context, msgs <-chan amqp.Delivery) error {
+	return consume(ctx, s.log, PlaybackQueue, s.consumer, s.seen.Filter(ctx, msgs), s.saveProgress)
+}
+
+func (s *Service) saveProgress(ctx context.Context, p Progress) error {
+	if err := s.store.SaveProgress(ctx, p); err != nil {
+		return err
+	}
+	s.log.DebugContext(ctx, `saved progress`, `videoId`, p.VideoID, `userId`, p.UserID, `position`, p.Position)
+	return nil
+}
+
+// handleResume serves GET /history/{userId}/resume/{videoId}: where the
+// user left off in the video. Callers may only ask about themselves.
+func (s *Service) handleResume(w http.ResponseWriter, r *http.Request) {
+	userID, videoID := r.PathValue(`userId`), r.PathValue(`videoId`)
+	if s.verifier != nil && userID != auth.Subject(r.Context()) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	p, err := s.store.Progress(r.Context(), userID, videoID)
+	if errors.Is(err, ErrNoProgress) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `/history/resume.store.Progress`, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(`Content-Type`, `application/json`)
+	json.NewEncoder(w).Encode(p)
+}
@@ -0,0 +1,253 @@
+// Package service is the history microservice: it records every
+// video.viewed event and serves them on GET /history, and keeps each
+// user's latest position in each video from playback.progress events.
+// main wires it to MongoDB and RabbitMQ; tests can wire it to anything that
+// satisfies HistoryStore and messaging.Channel.
+package service
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/openapi"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Topology names. ViewedExchange is the fanout views were published to
+// before the topic exchange.
+const (
+	ViewedExchange = `Viewed`
+	HistoryQueue   = `historyQueue`
+)
+
+var tracer = tracing.Tracer(`history`)
+
+// View is a 'viewed' message as published by video-streaming and as
+// stored.
+type View struct {
+	VideoPath string `json:"videoPath" bson:"videoPath"`
+	// RequestID correlates the view with the GET /video request that
+	// caused it.
+	RequestID string `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	// UserID is the authenticated subject who watched the video, if any.
+	UserID string `json:"userId,omitempty" bson:"userId,omitempty"`
+	// MessageID is the ID video-streaming gave the event, which every
+	// copy and replay of it keeps. It isn't part of the body; the
+	// consumer copies it from the delivery.
+	MessageID string `json:"-" bson:"messageId,omitempty"`
+}
+
+func (v View) check() error {
+	if v.VideoPath == `` {
+		return errors.New(`missing videoPath`)
+	}
+	return nil
+}
+
+func (v View) requestID() string { return v.RequestID }
+
+// Query selects a page of views.
+type Query struct {
+	// UserID restricts the views to one user; empty means everyone's.
+	UserID string
+	// Skip ignores the first views; Limit caps how many are returned, 0
+	// meaning no cap.
+	Skip, Limit int
+}
+
+// HistoryStore persists views. MongoStore is used in production;
+// MemoryStore runs without any database for local development and tests.
+type HistoryStore interface {
+	// Record stores a single view. A view with the MessageID of one
+	// already stored is the same event delivered again, e.g. by an archive
+	// replay, and is skipped.
+	Record(ctx context.Context, v View) error
+	// RecordMany stores vs in one write, skipping views already stored as
+	// Record does. If only some are stored it returns a *BatchError
+	// listing the others.
+	RecordMany(ctx context.Context, vs []View) error
+	// List returns the views selected by q, oldest first.
+	List(ctx context.Context, q Query) ([]View, error)
+	// Count returns the number of views stored.
+	Count(ctx context.Context) (int64, error)
+	// Stats summarises views per video.
+	Stats(ctx context.Context) (Stats, error)
+
+	ProgressStore
+}
+
+// Stats is the response of GET /history/stats.
+type Stats struct {
+	Total  int64       `json:"total"`
+	Videos []VideoStat `json:"videos"`
+}
+
+// VideoStat counts the views of one video.
+type VideoStat struct {
+	VideoPath string `json:"videoPath" bson:"_id"`
+	Views     int64  `json:"views" bson:"views"`
+}
+
+// Service ties a HistoryStore to the Viewed exchange and the HTTP API.
+type Service struct {
+	log      *slog.Logger
+	store    HistoryStore
+	verifier *auth.Verifier
+	consumer ConsumerConfig
+	// seen drops the second copy of an event that reaches a queue through
+	// both the topic exchange and a legacy fanout.
+	seen *messaging.Dedup
+}
+
+// New returns a history service backed by store. Requests must carry a
+// token accepted by verifier, and only see their own history; a nil
+// verifier leaves the API open and unscoped. consumer sets how deliveries
+// are fetched and processed.
+func New(log *slog.Logger, store HistoryStore, verifier *auth.Verifier, consumer ConsumerConfig) *Service {
+	return &Service{log: log, store: store, verifier: verifier, consumer: consumer, seen: messaging.NewDedup(messaging.DedupWindow)}
+}
+
+// Subscribe declares historyQueue as q describes, binds it to the topic
+// exchange with b's patterns, usually video.viewed, and starts consuming.
+// Pass the returned channel to Consume.
+func (s *Service) Subscribe(ch messaging.Channel, b messaging.Bindings, q messaging.QueueConfig) (<-chan amqp.Delivery, error) {
+	// Declare a queue named "historyQueue".
+	// This queue will be used to store the views routed to it.
+	historyQueue, err := messaging.DeclareQueue(ch, HistoryQueue, q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bind the historyQueue to the topic exchange, and to the Viewed fanout
+	// it was bound to before while b.KeepLegacy is set.
+	if err := messaging.Bind(ch, historyQueue.Name, b, ViewedExchange); err != nil {
+		return nil, err
+	}
+
+	// Don't let RabbitMQ send more than we'll process before acking.
+	if err := ch.Qos(s.consumer.Prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf(`ch.Qos: %w`, err)
+	}
+
+	// Create a channel to receive messages sent to our historyQueue.
+	msgs, err := ch.Consume(
+		historyQueue.Name, // queue
+		``,                // consumer
+		false,             // auto-ack
+		false,             // exclusive
+		false,             // no-local
+		false,             // no-wait
+		nil,               // args
+	)
+	if err != nil {
+		return nil, fmt.Errorf(`ch.Consume: %w`, err)
+	}
+	return msgs, nil
+}
+
+// Consume records deliveries from msgs, in batches, until ctx is cancelled
+// or the broker closes msgs. A view already taken under the same message ID
+// is acked and dropped.
+func (s *Service) Consume(ctx context.Context, msgs <-chan amqp.Delivery) error {
+	return consumeBatches(ctx, s.log, HistoryQueue, s.consumer, s.seen.Filter(ctx, msgs), s.recordMany)
+}
+
+func (s *Service) recordMany(ctx context.Context, vs []View) error {
+	err := s.store.RecordMany(ctx, vs)
+	recorded := len(vs)
+	var batchErr *BatchError
+	if errors.As(err, &batchErr) {
+		recorded -= len(batchErr.Failed)
+	} else if err != nil {
+		return err
+	}
+	s.log.InfoContext(ctx, `recorded views`, `count`, recorded)
+	return err
+}
+
+// openAPI describes the routes Handler serves.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+var spec = openapi.MustLoad(openAPI)
+
+// Handler serves the HTTP API, wrapped in the shared tracing, logging and
+// auth middleware, checking requests against openapi.json, which anyone may
+// read at GET /openapi.json.
+func (s *Service) Handler() http.Handler {
+	// The viewed handler is no longer necessary since we're pulling from the
+	// queue.  But we do need an endpoint that will print our view history.
+	mux := http.NewServeMux()
+	mux.Handle(`GET /history`, s.authorized(s.handleHistory))
+	mux.Handle(`GET /history/stats`, s.authorized(s.handleStats))
+	mux.Handle(`GET /history/{userId}/resume/{videoId}`, s.authorized(s.handleResume))
+	mux.Handle(`GET /openapi.json`, spec)
+	return tracing.Middleware(logging.Middleware(s.log, mux))
+}
+
+// authorized checks the caller's token and then the request against spec
+// before h sees it.
+func (s *Service) authorized(h http.HandlerFunc) http.Handler {
+	return auth.Middleware(s.log, s.verifier, spec.Validate(h))
+}
+
+func (s *Service) handleHistory(w http.ResponseWriter, r *http.Request) {
+	// spec.Validate has already answered 400 to a skip or limit that isn't
+	// a non-negative integer; both default to 0.
+	skipInt, _ := strconv.Atoi(r.FormValue(`skip`))
+	limitInt, _ := strconv.Atoi(r.FormValue(`limit`))
+
+	// Callers only ever see their own views, but admins may ask for
+	// anyone's with userId.
+	user := auth.Subject(r.Context())
+	if u := r.FormValue(`userId`); u != `` {
+		if s.verifier != nil && !auth.Admin(r.Context()) {
+			http.Error(w, `userId needs an admin token`, http.StatusForbidden)
+			return
+		}
+		user = u
+	}
+	results, err := s.store.List(r.Context(), Query{
+		UserID: user,
+		Skip:   skipInt,
+		Limit:  limitInt,
+	})
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `/history.store.List`, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []View{}
+	}
+
+	// Return the results as a JSON body.
+	w.Header().Set(`Content-Type`, `application/json`)
+	json.NewEncoder(w).Encode(results)
+}
+
+func (s *Service) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.store.Stats(r.Context())
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `/history/stats.store.Stats`, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if stats.Videos == nil {
+		stats.Videos = []VideoStat{}
+	}
+
+	w.Header().Set(`Content-Type`, `application/json`)
+	json.NewEncoder(w).Encode(stats)
+}
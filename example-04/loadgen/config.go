@@ -0,0 +1,23 @@
+package main
+
+import (
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+)
+
+// loadConfig is loaded by config.Load from the environment and the optional
+// CONFIG_FILE. The defaults reach the services docker-compose.yml runs; the
+// shape of the load comes from flags.
+type loadConfig struct {
+	VideoStreaming string `env:"LOADGEN_VIDEO_STREAMING_URL" default:"http://localhost:4001" validate:"url"`
+	History        string `env:"LOADGEN_HISTORY_URL" default:"http://localhost:4002" validate:"url"`
+
+	// HMACSecret is the services' AUTH_HMAC_SECRET, which each simulated
+	// user's token is signed with. Empty sends no tokens, and views aren't
+	// looked for in history.
+	HMACSecret string        `env:"AUTH_HMAC_SECRET" secret:"true"`
+	Timeout    time.Duration `env:"LOADGEN_TIMEOUT" default:"1m"`
+
+	Log logging.Config
+}
@@ -0,0 +1,16 @@
+module bootstrapping-microservices-in-go/chapter-05/example-4/loadgen
+
+go 1.23.1
+
+require (
+	bootstrapping-microservices-in-go/chapter-05/shared v0.0.0
+	golang.org/x/sync v0.15.0
+)
+
+require (
+	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
+	go.opentelemetry.io/otel v1.37.0 // indirect
+	go.opentelemetry.io/otel/trace v1.37.0 // indirect
+)
+
+replace bootstrapping-microservices-in-go/chapter-05/shared => ../../shared
@@ -0,0 +1,155 @@
+// Command loadgen simulates viewers watching videos, to measure how the
+// streaming service and the history pipeline behind it hold up. Run it
+// with -h for the shape of the load it can make.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/loadgen/sim"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run parses args, runs the scenario they describe and returns the exit
+// status.
+func run(args []string) int {
+	flags := flag.NewFlagSet(`loadgen`, flag.ContinueOnError)
+	var (
+		sc     sim.Scenario
+		videos string
+		asJSON bool
+	)
+	flags.IntVar(&sc.Users, `users`, 10, `simulated users watching at once`)
+	flags.DurationVar(&sc.Duration, `duration`, time.Minute, `how long users keep watching`)
+	flags.DurationVar(&sc.Ramp, `ramp`, 10*time.Second, `time over which users start`)
+	flags.DurationVar(&sc.Think, `think`, 2*time.Second, `mean pause between views`)
+	flags.StringVar(&videos, `videos`, ``, "comma-separated videos, most popular first; default those in GET /videos")
+	flags.Float64Var(&sc.Zipf, `zipf`, 1.2, `Zipf exponent of video popularity; 0 picks uniformly`)
+	flags.Float64Var(&sc.Seeks, `seeks`, 1, `mean seeks per view`)
+	flags.StringVar(&sc.SeekPattern, `seek-pattern`, sim.SeekRandom, `where seeks go: random or forward`)
+	flags.Float64Var(&sc.Abandon, `abandon`, 0.3, `fraction of views given up partway`)
+	flags.StringVar(&sc.Plan, `plan`, ``, `plan claim for the users' tokens`)
+	flags.DurationVar(&sc.PollInterval, `poll`, 500*time.Millisecond, `how often history is polled for views`)
+	flags.DurationVar(&sc.ViewTimeout, `view-timeout`, 30*time.Second, `how long a view may take to reach history`)
+	flags.Uint64Var(&sc.Seed, `seed`, uint64(time.Now().UnixNano()), `seed for the users' choices`)
+	flags.StringVar(&sc.RunID, `run-id`, ``, `prefix for user and request IDs; default one made from the time`)
+	flags.DurationVar(&sc.ProgressInterval, `progress`, 10*time.Second, `how often to log progress; 0 for never`)
+	flags.BoolVar(&asJSON, `json`, false, `print the report as JSON`)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var cfg loadConfig
+	if err := config.Load(&cfg); err != nil {
+		slog.Error(`config.Load`, logging.KeyError, err)
+		return 1
+	}
+	log, err := logging.New(os.Stderr, `loadgen`, cfg.Log)
+	if err != nil {
+		slog.Error(`logging.New`, logging.KeyError, err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sc.HTTP = &http.Client{Timeout: cfg.Timeout}
+	sc.VideoStreamingURL, sc.HistoryURL = cfg.VideoStreaming, cfg.History
+	sc.Secret = cfg.HMACSecret
+	if sc.RunID == `` {
+		sc.RunID = `loadgen-` + strconv.FormatInt(time.Now().Unix(), 36)
+	}
+	if videos != `` {
+		sc.Videos = strings.Split(videos, `,`)
+	} else if sc.Videos, err = listVideos(ctx, sc.HTTP, cfg); err != nil {
+		log.Error(`listing videos; pass -videos instead`, logging.KeyError, err)
+		return 1
+	}
+	if sc.Secret == `` {
+		log.Warn(`AUTH_HMAC_SECRET not set: sending no tokens and not timing views to history`)
+	}
+	sc.Progress = func(r sim.Report) {
+		log.Info(`progress`, `elapsed`, r.Elapsed.Round(time.Second), `sessions`, r.Sessions,
+			`failed`, r.Failed, `requestsPerSec`, fmt.Sprintf(`%.1f`, r.RequestsPerSec), `ttfbP95`, r.TTFB.P95)
+	}
+
+	log.Info(`starting`, `run`, sc.RunID, `users`, sc.Users, `duration`, sc.Duration, `videos`, len(sc.Videos), `seed`, sc.Seed)
+	report, err := sim.Run(ctx, sc)
+	if err != nil {
+		log.Error(`sim.Run`, logging.KeyError, err)
+		return 1
+	}
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent(``, `  `)
+		err = enc.Encode(report)
+	} else {
+		err = printReport(os.Stdout, report)
+	}
+	if err != nil {
+		log.Error(`writing report`, logging.KeyError, err)
+		return 1
+	}
+	return 0
+}
+
+// listVideos asks video-streaming which videos it has.
+func listVideos(ctx context.Context, c *http.Client, cfg loadConfig) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.VideoStreaming+`/videos`, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.HMACSecret != `` {
+		token, err := auth.Sign(cfg.HMACSecret, auth.Identity{Subject: `loadgen`}, time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(`Authorization`, `Bearer `+token)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf(`GET /videos: %d %s: %s`, resp.StatusCode, http.StatusText(resp.StatusCode), strings.TrimSpace(string(text)))
+	}
+	var list []struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf(`GET /videos: %w`, err)
+	}
+	names := make([]string, len(list))
+	for i, v := range list {
+		names[i] = v.Name
+	}
+	return names, nil
+}
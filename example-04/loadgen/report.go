@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/loadgen/sim"
+)
+
+// printReport writes r for people to read.
+func printReport(out io.Writer, r sim.Report) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "elapsed\t%s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "users\t%d\n", r.Users)
+	fmt.Fprintf(w, "views\t%d: %d completed, %d abandoned, %d failed\n", r.Sessions, r.Completed, r.Abandoned, r.Failed)
+	fmt.Fprintf(w, "requests\t%d, %.1f/s\n", r.Requests, r.RequestsPerSec)
+	fmt.Fprintf(w, "throughput\t%.1f MB/s, %d bytes\n", r.BytesPerSec/1e6, r.Bytes)
+	for _, status := range slices.Sorted(maps.Keys(r.Statuses)) {
+		fmt.Fprintf(w, "status %d\t%d\n", status, r.Statuses[status])
+	}
+	if r.RangeIgnored > 0 {
+		fmt.Fprintf(w, "range ignored\t%d seeks got the whole video\n", r.RangeIgnored)
+	}
+	for _, err := range slices.Sorted(maps.Keys(r.Errors)) {
+		fmt.Fprintf(w, "error\t%d× %s\n", r.Errors[err], err)
+	}
+
+	fmt.Fprintln(w, "\nLATENCY\tCOUNT\tP50\tP90\tP95\tP99\tMAX")
+	for _, row := range []struct {
+		name string
+		p    sim.Percentiles
+	}{
+		{`time to first byte`, r.TTFB},
+		{`seek first byte`, r.SeekTTFB},
+		{`history poll`, r.History},
+		{`view to history`, r.ViewToHistory},
+	} {
+		p := row.p
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", row.name, p.Count,
+			ms(p.P50), ms(p.P90), ms(p.P95), ms(p.P99), ms(p.Max))
+	}
+	if r.ViewsEarly > 0 {
+		fmt.Fprintf(w, "\nviews early\t%d in history before they were read to the end\n", r.ViewsEarly)
+	}
+	if r.ViewsLost > 0 || r.ViewsPending > 0 {
+		fmt.Fprintf(w, "\nviews lost\t%d never reached history\n", r.ViewsLost)
+		fmt.Fprintf(w, "views pending\t%d still awaited at the end\n", r.ViewsPending)
+	}
+	return w.Flush()
+}
+
+func ms(d time.Duration) string {
+	return d.Round(100 * time.Microsecond).String()
+}
@@ -0,0 +1,153 @@
+package sim
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// latencies collects durations to report their percentiles. Load runs are
+// short enough that keeping every sample is cheaper than being clever.
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.mu.Lock()
+	l.samples = append(l.samples, d)
+	l.mu.Unlock()
+}
+
+// Percentiles summarises a set of durations.
+type Percentiles struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P95   time.Duration `json:"p95"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+func (l *latencies) percentiles() Percentiles {
+	l.mu.Lock()
+	sorted := slices.Clone(l.samples)
+	l.mu.Unlock()
+	slices.Sort(sorted)
+	return Percentiles{
+		Count: len(sorted),
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P95:   percentile(sorted, 95),
+		P99:   percentile(sorted, 99),
+		Max:   percentile(sorted, 100),
+	}
+}
+
+// percentile returns the nearest-rank pth percentile of sorted.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// counters are the totals a run keeps, guarded by metrics.mu.
+type counters struct {
+	sessions, completed, abandoned, failed int
+	requests, bytes                        int64
+	rangeIgnored, viewsEarly               int
+	statuses                               map[int]int
+	errors                                 map[string]int
+}
+
+// metrics is everything a run measures.
+type metrics struct {
+	// ttfb is the time to the first byte of the body, for the request
+	// that starts a view and for those made to seek.
+	ttfb, seekTTFB latencies
+	// history is how long GET /history polls take; viewToHistory is how
+	// long from a view finishing until it shows up there.
+	history, viewToHistory latencies
+
+	mu sync.Mutex
+	counters
+}
+
+func newMetrics() *metrics {
+	return &metrics{counters: counters{statuses: map[int]int{}, errors: map[string]int{}}}
+}
+
+func (m *metrics) update(fn func(c *counters)) {
+	m.mu.Lock()
+	fn(&m.counters)
+	m.mu.Unlock()
+}
+
+// Report is the outcome of a run.
+type Report struct {
+	Elapsed time.Duration `json:"elapsed"`
+	Users   int           `json:"users"`
+
+	Sessions  int `json:"sessions"`
+	Completed int `json:"completed"`
+	Abandoned int `json:"abandoned"`
+	Failed    int `json:"failed"`
+
+	Requests int64 `json:"requests"`
+	Bytes    int64 `json:"bytes"`
+	// RequestsPerSec and BytesPerSec are video requests and body bytes
+	// read, over Elapsed.
+	RequestsPerSec float64 `json:"requestsPerSec"`
+	BytesPerSec    float64 `json:"bytesPerSec"`
+	// RangeIgnored counts seeks answered with the whole video rather than
+	// 206 Partial Content.
+	RangeIgnored int `json:"rangeIgnored"`
+	// Statuses counts video responses by status code, and Errors requests
+	// that got none, by error.
+	Statuses map[int]int    `json:"statuses"`
+	Errors   map[string]int `json:"errors,omitempty"`
+
+	TTFB     Percentiles `json:"ttfb"`
+	SeekTTFB Percentiles `json:"seekTtfb"`
+	History  Percentiles `json:"history"`
+	// ViewToHistory is measured to the poll that found the view, so it is
+	// only as precise as Scenario.PollInterval. ViewsEarly were found
+	// before the user had finished reading them, as video-streaming is done
+	// once the last bytes are buffered, and aren't in ViewToHistory.
+	// ViewsLost never showed up within Scenario.ViewTimeout; ViewsPending
+	// were still being waited for when the run ended.
+	ViewToHistory Percentiles `json:"viewToHistory"`
+	ViewsEarly    int         `json:"viewsEarly"`
+	ViewsLost     int         `json:"viewsLost"`
+	ViewsPending  int         `json:"viewsPending"`
+}
+
+func (m *metrics) report(users int, elapsed time.Duration) Report {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := Report{
+		Elapsed:       elapsed,
+		Users:         users,
+		Sessions:      m.sessions,
+		Completed:     m.completed,
+		Abandoned:     m.abandoned,
+		Failed:        m.failed,
+		Requests:      m.requests,
+		Bytes:         m.bytes,
+		RangeIgnored:  m.rangeIgnored,
+		Statuses:      m.statuses,
+		Errors:        m.errors,
+		TTFB:          m.ttfb.percentiles(),
+		SeekTTFB:      m.seekTTFB.percentiles(),
+		History:       m.history.percentiles(),
+		ViewToHistory: m.viewToHistory.percentiles(),
+		ViewsEarly:    m.viewsEarly,
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		r.RequestsPerSec = float64(m.requests) / seconds
+		r.BytesPerSec = float64(m.bytes) / seconds
+	}
+	return r
+}
@@ -0,0 +1,40 @@
+package sim
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentiles(t *testing.T) {
+	var l latencies
+	if got := l.percentiles(); got != (Percentiles{}) {
+		t.Errorf(`no samples = %+v, want zero`, got)
+	}
+	for i := 100; i >= 1; i-- {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	want := Percentiles{
+		Count: 100,
+		P50:   50 * time.Millisecond,
+		P90:   90 * time.Millisecond,
+		P95:   95 * time.Millisecond,
+		P99:   99 * time.Millisecond,
+		Max:   100 * time.Millisecond,
+	}
+	if got := l.percentiles(); got != want {
+		t.Errorf(`percentiles = %+v, want %+v`, got, want)
+	}
+}
+
+func TestRangeSize(t *testing.T) {
+	for header, want := range map[string]int64{
+		`bytes 0-499/1000`: 1000,
+		`bytes 10-10/11`:   11,
+		`bytes 0-499/*`:    -1,
+		``:                 -1,
+	} {
+		if got := rangeSize(header); got != want {
+			t.Errorf(`rangeSize(%q) = %d, want %d`, header, got, want)
+		}
+	}
+}
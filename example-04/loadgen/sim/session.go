@@ -0,0 +1,212 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readSize is how much of a video is read at a time, and so how finely
+// seeks and abandonment are placed.
+const readSize = 32 << 10
+
+// view watches one video: a request from the start, a new Range request
+// for each seek, and perhaps giving up partway.
+func (u *user) view(ctx context.Context) {
+	u.views++
+	video := u.pick()
+	requestID := fmt.Sprintf(`%s-%d`, u.id, u.views)
+	u.m.update(func(c *counters) { c.sessions++ })
+
+	body, size, err := u.get(ctx, video, 0, requestID, &u.m.ttfb)
+	if err != nil {
+		u.fail(ctx, err)
+		return
+	}
+	// video-streaming records the view once the response is over, however
+	// it ends, which is timed from when the user stops reading it.
+	u.t.start(u.id, u.token, requestID)
+	var ended time.Time
+	defer func() {
+		if ended.IsZero() {
+			ended = time.Now()
+		}
+		u.t.end(u.id, requestID, ended)
+	}()
+
+	// Plan where to seek and where to give up, by byte position.
+	seeks := make([]int64, u.seeks())
+	for i := range seeks {
+		seeks[i] = u.rand.Int64N(max(size, 1))
+	}
+	slices.Sort(seeks)
+	stop := size
+	if u.rand.Float64() < u.sc.Abandon {
+		stop = u.rand.Int64N(max(size, 1))
+	}
+
+	buf := make([]byte, readSize)
+	var pos int64
+	for seek := 0; ; {
+		if pos >= stop && stop < size {
+			ended = closeAt(body, ended)
+			u.m.update(func(c *counters) { c.abandoned++ })
+			return
+		}
+		if seek < len(seeks) && pos >= seeks[seek] {
+			ended = closeAt(body, ended)
+			to := u.seekTarget(pos, size)
+			seek++
+			b, _, err := u.get(ctx, video, to, fmt.Sprintf(`%s-seek-%d`, requestID, seek), &u.m.seekTTFB)
+			if err != nil {
+				u.fail(ctx, err)
+				return
+			}
+			body, pos = b, to
+			continue
+		}
+
+		n, err := body.Read(buf)
+		pos += int64(n)
+		u.m.update(func(c *counters) { c.bytes += int64(n) })
+		switch {
+		case errors.Is(err, io.EOF):
+			ended = closeAt(body, ended)
+			u.m.update(func(c *counters) { c.completed++ })
+			return
+		case err != nil:
+			ended = closeAt(body, ended)
+			u.fail(ctx, err)
+			return
+		}
+	}
+}
+
+// closeAt closes body and returns when the first view ended.
+func closeAt(body io.Closer, ended time.Time) time.Time {
+	body.Close()
+	if ended.IsZero() {
+		return time.Now()
+	}
+	return ended
+}
+
+// seekTarget picks where to seek to from pos.
+func (u *user) seekTarget(pos, size int64) int64 {
+	if size <= 0 {
+		return 0
+	}
+	if u.sc.SeekPattern == SeekForward {
+		left := size - pos
+		if left <= 1 {
+			return pos
+		}
+		return pos + u.rand.Int64N(left/2+1)
+	}
+	return u.rand.Int64N(size)
+}
+
+// fail counts a view that went wrong, unless it was cut short by the end of
+// the run.
+func (u *user) fail(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		u.m.update(func(c *counters) { c.sessions-- })
+		return
+	}
+	u.m.update(func(c *counters) {
+		c.failed++
+		var status *statusError
+		if !errors.As(err, &status) {
+			c.errors[errorKind(err)]++
+		}
+	})
+}
+
+// statusError is a video response other than 200 or 206.
+type statusError struct{ status int }
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf(`%d %s`, e.status, http.StatusText(e.status))
+}
+
+// errorKind shortens err to something worth counting: the innermost cause
+// of a failed request rather than its URL.
+func errorKind(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	return err.Error()
+}
+
+// get requests video from byte from, recording the time to the first byte
+// in ttfb. It returns the body and the video's full size, or -1 if that
+// isn't known.
+func (u *user) get(ctx context.Context, video string, from int64, requestID string, ttfb *latencies) (io.ReadCloser, int64, error) {
+	target := u.sc.VideoStreamingURL + `/video?` + url.Values{`v`: {video}}.Encode()
+	start := time.Now()
+	var first time.Duration
+	trace := &httptrace.ClientTrace{GotFirstResponseByte: func() { first = time.Since(start) }}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, target, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set(`Range`, fmt.Sprintf(`bytes=%d-`, from))
+	req.Header.Set(`X-Request-ID`, requestID)
+	if u.token != `` {
+		req.Header.Set(`Authorization`, `Bearer `+u.token)
+	}
+
+	u.m.update(func(c *counters) { c.requests++ })
+	resp, err := u.sc.HTTP.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	u.m.update(func(c *counters) {
+		c.statuses[resp.StatusCode]++
+		if from > 0 && resp.StatusCode == http.StatusOK {
+			c.rangeIgnored++
+		}
+	})
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if from > 0 {
+			// The whole video came back; skip to where the seek was to.
+			if _, err := io.CopyN(io.Discard, resp.Body, from); err != nil {
+				resp.Body.Close()
+				return nil, 0, err
+			}
+		}
+		ttfb.add(first)
+		return resp.Body, resp.ContentLength, nil
+	case http.StatusPartialContent:
+		ttfb.add(first)
+		return resp.Body, rangeSize(resp.Header.Get(`Content-Range`)), nil
+	default:
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, 0, &statusError{resp.StatusCode}
+	}
+}
+
+// rangeSize returns the full size from a Content-Range header, e.g. 1000
+// from "bytes 0-499/1000", or -1.
+func rangeSize(header string) int64 {
+	_, total, ok := strings.Cut(header, `/`)
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
@@ -0,0 +1,266 @@
+// Package sim simulates viewers of the video platform to find out how many
+// the streaming service and the history consumer can keep up with.
+//
+// Each simulated user watches videos picked by a Zipf popularity
+// distribution, seeking with Range requests and abandoning some views
+// partway, and thinks for a while between them. Every view is then looked
+// for in the user's GET /history to time the trip through the broker and
+// the history consumer.
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+// Seek patterns accepted by Scenario.SeekPattern.
+const (
+	// SeekRandom jumps anywhere in the video.
+	SeekRandom = `random`
+	// SeekForward skips ahead, up to half of what is left.
+	SeekForward = `forward`
+)
+
+// Scenario describes a run.
+type Scenario struct {
+	HTTP *http.Client
+	// Base URLs of video-streaming and history.
+	VideoStreamingURL, HistoryURL string
+	// Secret is the services' AUTH_HMAC_SECRET, which each user's token
+	// is signed with, on Plan if set. Without it no tokens are sent and
+	// views can't be looked for in history, which only shows users their
+	// own.
+	Secret, Plan string
+
+	// Users watch at once for Duration, starting evenly over Ramp.
+	Users          int
+	Duration, Ramp time.Duration
+	// Think is the mean pause between one view and the next; pauses are
+	// exponentially distributed.
+	Think time.Duration
+	// Videos are picked with Zipf exponent Zipf, the first most often; an
+	// exponent of 0 picks them uniformly.
+	Videos []string
+	Zipf   float64
+	// Seeks is the mean number of seeks per view, Poisson distributed,
+	// each a new Range request positioned by SeekPattern.
+	Seeks       float64
+	SeekPattern string
+	// Abandon is the fraction of views stopped at a random point.
+	Abandon float64
+	// PollInterval is how often GET /history is polled for views not yet
+	// recorded; those still missing after ViewTimeout are counted lost.
+	PollInterval, ViewTimeout time.Duration
+	// Seed makes the users' choices repeatable; RunID tells this run's
+	// users and requests apart from any other's.
+	Seed  uint64
+	RunID string
+
+	// Progress, if set, is called with the report so far every
+	// ProgressInterval.
+	Progress         func(Report)
+	ProgressInterval time.Duration
+}
+
+// Validate reports settings a run can't use.
+func (sc *Scenario) Validate() error {
+	switch {
+	case sc.Users <= 0:
+		return errors.New(`need at least one user`)
+	case sc.Duration <= 0:
+		return errors.New(`duration must be positive`)
+	case sc.Ramp < 0 || sc.Think < 0:
+		return errors.New(`ramp and think time must not be negative`)
+	case len(sc.Videos) == 0:
+		return errors.New(`no videos to watch`)
+	case sc.Zipf < 0 || math.IsNaN(sc.Zipf):
+		return errors.New(`zipf exponent must not be negative`)
+	case sc.Seeks < 0:
+		return errors.New(`seeks must not be negative`)
+	case sc.SeekPattern != SeekRandom && sc.SeekPattern != SeekForward:
+		return fmt.Errorf(`seek pattern %q: must be %s or %s`, sc.SeekPattern, SeekRandom, SeekForward)
+	case sc.Abandon < 0 || sc.Abandon > 1:
+		return errors.New(`abandon rate must be between 0 and 1`)
+	case sc.tracking() && (sc.PollInterval <= 0 || sc.ViewTimeout <= 0):
+		return errors.New(`poll interval and view timeout must be positive`)
+	}
+	return nil
+}
+
+// tracking reports whether views are looked for in history.
+func (sc *Scenario) tracking() bool {
+	return sc.Secret != `` && sc.HistoryURL != ``
+}
+
+// Run simulates sc.Users viewers for sc.Duration, then waits up to
+// sc.ViewTimeout for the last views to reach history, and reports what it
+// saw. Cancelling ctx ends the run early with the report so far.
+func Run(ctx context.Context, sc Scenario) (Report, error) {
+	if err := sc.Validate(); err != nil {
+		return Report{}, err
+	}
+	m := newMetrics()
+	t := newTracker(&sc, m)
+
+	trackCtx, stopTracking := context.WithCancel(ctx)
+	defer stopTracking()
+	tracked := make(chan struct{})
+	go func() {
+		defer close(tracked)
+		if sc.tracking() {
+			t.run(trackCtx)
+		}
+	}()
+
+	start := time.Now()
+	if sc.Progress != nil && sc.ProgressInterval > 0 {
+		ticker := time.NewTicker(sc.ProgressInterval)
+		defer ticker.Stop()
+		go func() {
+			for {
+				select {
+				case <-trackCtx.Done():
+					return
+				case <-ticker.C:
+					sc.Progress(m.report(sc.Users, time.Since(start)))
+				}
+			}
+		}()
+	}
+
+	watchCtx, stopWatching := context.WithTimeout(ctx, sc.Duration)
+	defer stopWatching()
+	var wg sync.WaitGroup
+	for i := range sc.Users {
+		u, err := newUser(&sc, m, t, i)
+		if err != nil {
+			return Report{}, err
+		}
+		delay := time.Duration(0)
+		if sc.Users > 1 {
+			delay = sc.Ramp * time.Duration(i) / time.Duration(sc.Users-1)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sleep(watchCtx, delay) {
+				u.watch(watchCtx)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Give the last views time to get through.
+	t.drain(ctx)
+	stopTracking()
+	<-tracked
+
+	r := m.report(sc.Users, elapsed)
+	r.ViewsLost, r.ViewsPending = t.outcome()
+	return r, nil
+}
+
+// user is one simulated viewer.
+type user struct {
+	sc    *Scenario
+	m     *metrics
+	t     *tracker
+	id    string
+	token string
+	rand  *rand.Rand
+	// popular is the cumulative probability of picking each video.
+	popular []float64
+	views   int
+}
+
+func newUser(sc *Scenario, m *metrics, t *tracker, i int) (*user, error) {
+	u := &user{
+		sc:      sc,
+		m:       m,
+		t:       t,
+		id:      fmt.Sprintf(`%s-user-%d`, sc.RunID, i),
+		rand:    rand.New(rand.NewPCG(sc.Seed, uint64(i))),
+		popular: zipfCDF(len(sc.Videos), sc.Zipf),
+	}
+	if sc.Secret != `` {
+		ttl := sc.Ramp + sc.Duration + sc.ViewTimeout + time.Hour
+		token, err := auth.Sign(sc.Secret, auth.Identity{Subject: u.id, Plan: sc.Plan}, ttl)
+		if err != nil {
+			return nil, err
+		}
+		u.token = token
+	}
+	return u, nil
+}
+
+// watch watches videos, thinking between them, until ctx is done.
+func (u *user) watch(ctx context.Context) {
+	for ctx.Err() == nil {
+		u.view(ctx)
+		if !sleep(ctx, u.think()) {
+			return
+		}
+	}
+}
+
+// pick chooses the next video by popularity.
+func (u *user) pick() string {
+	i := sort.SearchFloat64s(u.popular, u.rand.Float64()*u.popular[len(u.popular)-1])
+	return u.sc.Videos[min(i, len(u.sc.Videos)-1)]
+}
+
+// zipfCDF returns the running totals of the Zipf weights 1/k^s for ranks 1
+// to n. Unlike rand.Zipf it takes any s >= 0, so the mild skews of real
+// catalogues, below 1, can be simulated too.
+func zipfCDF(n int, s float64) []float64 {
+	cdf := make([]float64, n)
+	total := 0.0
+	for k := range n {
+		total += math.Pow(float64(k+1), -s)
+		cdf[k] = total
+	}
+	return cdf
+}
+
+func (u *user) think() time.Duration {
+	if u.sc.Think <= 0 {
+		return 0
+	}
+	return time.Duration(u.rand.ExpFloat64() * float64(u.sc.Think))
+}
+
+// seeks draws how many times the next view seeks.
+func (u *user) seeks() int {
+	// Knuth's method; the mean is small.
+	limit, k := math.Exp(-u.sc.Seeks), 0
+	for p := u.rand.Float64(); p > limit; p *= u.rand.Float64() {
+		k++
+	}
+	return k
+}
+
+// sleep waits for d or until ctx is done, reporting whether it waited the
+// whole time.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
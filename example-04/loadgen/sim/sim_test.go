@@ -0,0 +1,178 @@
+package sim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+const secret = `s3cret`
+
+// platform fakes video-streaming and history: views of /video show up in
+// the viewer's /history a little after the response ends, as they would
+// after a trip through the broker.
+type platform struct {
+	video        []byte
+	ignoreRanges bool
+
+	mu    sync.Mutex
+	views map[string][]view
+}
+
+func newPlatform(t *testing.T, ignoreRanges bool) *httptest.Server {
+	t.Helper()
+	p := &platform{video: bytes.Repeat([]byte(`0123456789abcdef`), 16<<10), ignoreRanges: ignoreRanges, views: map[string][]view{}}
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(`GET /video`, p.handleVideo)
+	mux.HandleFunc(`GET /history`, p.handleHistory)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := httptest.NewServer(auth.Middleware(log, verifier, mux))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func (p *platform) handleVideo(w http.ResponseWriter, r *http.Request) {
+	user, requestID := auth.Subject(r.Context()), r.Header.Get(`X-Request-ID`)
+	defer time.AfterFunc(20*time.Millisecond, func() {
+		p.mu.Lock()
+		p.views[user] = append(p.views[user], view{RequestID: requestID})
+		p.mu.Unlock()
+	})
+	if p.ignoreRanges {
+		w.Header().Set(`Content-Length`, strconv.Itoa(len(p.video)))
+		w.Write(p.video)
+		return
+	}
+	http.ServeContent(w, r, r.URL.Query().Get(`v`), time.Time{}, bytes.NewReader(p.video))
+}
+
+func (p *platform) handleHistory(w http.ResponseWriter, r *http.Request) {
+	skip, _ := strconv.Atoi(r.FormValue(`skip`))
+	p.mu.Lock()
+	views := p.views[auth.Subject(r.Context())]
+	views = views[min(skip, len(views)):]
+	p.mu.Unlock()
+	json.NewEncoder(w).Encode(views)
+}
+
+func scenario(srv *httptest.Server) Scenario {
+	return Scenario{
+		HTTP:              srv.Client(),
+		VideoStreamingURL: srv.URL,
+		HistoryURL:        srv.URL,
+		Secret:            secret,
+		Users:             4,
+		Duration:          300 * time.Millisecond,
+		Ramp:              50 * time.Millisecond,
+		Think:             5 * time.Millisecond,
+		Videos:            []string{`a.mp4`, `b.mp4`, `c.mp4`},
+		Zipf:              1.5,
+		Seeks:             1,
+		SeekPattern:       SeekForward,
+		Abandon:           0.3,
+		PollInterval:      10 * time.Millisecond,
+		ViewTimeout:       2 * time.Second,
+		Seed:              1,
+		RunID:             `test`,
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := newPlatform(t, false)
+	r, err := Run(context.Background(), scenario(srv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf(`%+v`, r)
+
+	if r.Sessions == 0 || r.Failed != 0 || len(r.Errors) != 0 {
+		t.Fatalf(`%d views, %d failed, errors %v; want some views and no failures`, r.Sessions, r.Failed, r.Errors)
+	}
+	if r.Completed == 0 || r.Completed+r.Abandoned > r.Sessions {
+		t.Errorf(`%d completed and %d abandoned of %d views`, r.Completed, r.Abandoned, r.Sessions)
+	}
+	if r.Statuses[http.StatusPartialContent] == 0 || r.RangeIgnored != 0 {
+		t.Errorf(`statuses %v, %d ranges ignored; want 206s only`, r.Statuses, r.RangeIgnored)
+	}
+	if r.TTFB.Count < r.Completed+r.Abandoned || r.Bytes == 0 || r.RequestsPerSec == 0 {
+		t.Errorf(`TTFB %+v, %d bytes, %.1f requests/s`, r.TTFB, r.Bytes, r.RequestsPerSec)
+	}
+	if r.ViewToHistory.Count+r.ViewsEarly < r.Completed+r.Abandoned || r.ViewsLost != 0 || r.ViewsPending != 0 {
+		t.Errorf(`view to history %+v, %d early, %d lost, %d pending; want every view found`, r.ViewToHistory, r.ViewsEarly, r.ViewsLost, r.ViewsPending)
+	}
+	if r.ViewToHistory.P50 < 20*time.Millisecond {
+		t.Errorf(`view to history p50 %s, faster than the fake's delay`, r.ViewToHistory.P50)
+	}
+}
+
+func TestRunRangeIgnored(t *testing.T) {
+	srv := newPlatform(t, true)
+	sc := scenario(srv)
+	sc.Seeks, sc.Abandon = 3, 0
+	r, err := Run(context.Background(), sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.RangeIgnored == 0 || r.Statuses[http.StatusPartialContent] != 0 {
+		t.Errorf(`statuses %v, %d ranges ignored; want seeks counted as ignored`, r.Statuses, r.RangeIgnored)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	sc := scenario(&httptest.Server{URL: `http://example.test`})
+	if err := sc.Validate(); err != nil {
+		t.Errorf(`valid scenario: %v`, err)
+	}
+	for name, breakIt := range map[string]func(*Scenario){
+		`no users`:     func(sc *Scenario) { sc.Users = 0 },
+		`no videos`:    func(sc *Scenario) { sc.Videos = nil },
+		`bad pattern`:  func(sc *Scenario) { sc.SeekPattern = `backwards` },
+		`abandon > 1`:  func(sc *Scenario) { sc.Abandon = 1.5 },
+		`no poll time`: func(sc *Scenario) { sc.PollInterval = 0 },
+		`zipf < 0`:     func(sc *Scenario) { sc.Zipf = -1 },
+	} {
+		sc := scenario(&httptest.Server{URL: `http://example.test`})
+		breakIt(&sc)
+		if sc.Validate() == nil {
+			t.Errorf(`%s: Validate passed`, name)
+		}
+	}
+}
+
+func TestPickSkew(t *testing.T) {
+	videos := []string{`a`, `b`, `c`, `d`}
+	for _, zipf := range []float64{0, 0.8, 1, 1.5} {
+		sc := Scenario{Videos: videos, Zipf: zipf}
+		u, err := newUser(&sc, nil, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks := map[string]int{}
+		for range 20000 {
+			picks[u.pick()]++
+		}
+		// Expected share of the first video: 1 over the sum of 1/k^s.
+		want := 1 / u.popular[len(u.popular)-1]
+		if got := float64(picks[`a`]) / 20000; math.Abs(got-want) > 0.02 {
+			t.Errorf(`zipf %v: first video picked %.3f of the time, want %.3f`, zipf, got, want)
+		}
+		if zipf > 0 && picks[`a`] <= picks[`d`] {
+			t.Errorf(`zipf %v: picks %v not skewed to the first video`, zipf, picks)
+		}
+	}
+}
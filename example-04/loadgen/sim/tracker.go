@@ -0,0 +1,221 @@
+package sim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// pollers is how many users' histories are polled at once.
+const pollers = 8
+
+// tracker looks for finished views in GET /history, which each user polls
+// for their own, to time how long the views take to get there.
+type tracker struct {
+	sc *Scenario
+	m  *metrics
+
+	mu    sync.Mutex
+	users map[string]*watched
+	lost  int
+}
+
+// watched is what's known of one user's history.
+type watched struct {
+	token string
+	// seen is how many views history has shown, so polls can skip them.
+	seen int
+	// pending are views not yet shown, by request ID, with when they
+	// ended, or the zero time while they're still playing.
+	pending map[string]time.Time
+	// early are views shown while still playing.
+	early map[string]bool
+}
+
+func newTracker(sc *Scenario, m *metrics) *tracker {
+	return &tracker{sc: sc, m: m, users: map[string]*watched{}}
+}
+
+// start notes that user's view requestID is playing. video-streaming may
+// record it before the user has read it all, so it's looked for from now.
+func (t *tracker) start(user, token, requestID string) {
+	if !t.sc.tracking() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w, ok := t.users[user]
+	if !ok {
+		w = &watched{token: token, pending: map[string]time.Time{}, early: map[string]bool{}}
+		t.users[user] = w
+	}
+	w.pending[requestID] = time.Time{}
+}
+
+// end notes that user's view requestID ended at.
+func (t *tracker) end(user, requestID string, at time.Time) {
+	if !t.sc.tracking() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.users[user]
+	if w.early[requestID] {
+		delete(w.early, requestID)
+		t.m.update(func(c *counters) { c.viewsEarly++ })
+		return
+	}
+	w.pending[requestID] = at
+}
+
+// run polls until ctx is done.
+func (t *tracker) run(ctx context.Context) {
+	ticker := time.NewTicker(t.sc.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.poll(ctx)
+		}
+	}
+}
+
+// drain waits until no views are pending, or the last of them has had
+// ViewTimeout to show up.
+func (t *tracker) drain(ctx context.Context) {
+	if !t.sc.tracking() {
+		return
+	}
+	deadline := time.Now().Add(t.sc.ViewTimeout)
+	for time.Now().Before(deadline) {
+		if _, pending := t.outcome(); pending == 0 {
+			return
+		}
+		if !sleep(ctx, t.sc.PollInterval) {
+			return
+		}
+	}
+}
+
+// outcome returns how many views were given up on and how many are still
+// awaited.
+func (t *tracker) outcome() (lost, pending int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, w := range t.users {
+		pending += len(w.pending)
+	}
+	return t.lost, pending
+}
+
+// poll fetches the new history of every user with views pending.
+func (t *tracker) poll(ctx context.Context) {
+	t.mu.Lock()
+	due := map[string]int{}
+	for user, w := range t.users {
+		if len(w.pending) > 0 {
+			due[user] = w.seen
+		}
+	}
+	t.mu.Unlock()
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(pollers)
+	for user, seen := range due {
+		g.Go(func() error {
+			t.pollUser(ctx, user, seen)
+			return nil
+		})
+	}
+	g.Wait()
+	t.expire()
+}
+
+// view is the part of an entry in GET /history the tracker needs.
+type view struct {
+	RequestID string `json:"requestId"`
+}
+
+func (t *tracker) pollUser(ctx context.Context, user string, seen int) {
+	t.mu.Lock()
+	token := t.users[user].token
+	t.mu.Unlock()
+
+	start := time.Now()
+	views, err := t.history(ctx, token, seen)
+	if err != nil {
+		if ctx.Err() == nil {
+			t.m.update(func(c *counters) { c.errors[`history: `+errorKind(err)]++ })
+		}
+		return
+	}
+	now := time.Now()
+	t.m.history.add(now.Sub(start))
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.users[user]
+	if w.seen != seen {
+		// Another poll got here first.
+		return
+	}
+	w.seen += len(views)
+	for _, v := range views {
+		at, ok := w.pending[v.RequestID]
+		switch {
+		case !ok:
+		case at.IsZero():
+			w.early[v.RequestID] = true
+			delete(w.pending, v.RequestID)
+		default:
+			t.m.viewToHistory.add(now.Sub(at))
+			delete(w.pending, v.RequestID)
+		}
+	}
+}
+
+func (t *tracker) history(ctx context.Context, token string, skip int) ([]view, error) {
+	target := t.sc.HistoryURL + `/history?` + url.Values{`skip`: {strconv.Itoa(skip)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(`Authorization`, `Bearer `+token)
+	resp, err := t.sc.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{resp.StatusCode}
+	}
+	var views []view
+	if err := json.NewDecoder(resp.Body).Decode(&views); err != nil {
+		return nil, fmt.Errorf(`decoding history: %w`, err)
+	}
+	return views, nil
+}
+
+// expire gives up on views that have been pending longer than ViewTimeout.
+func (t *tracker) expire() {
+	cutoff := time.Now().Add(-t.sc.ViewTimeout)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, w := range t.users {
+		for id, at := range w.pending {
+			if !at.IsZero() && at.Before(cutoff) {
+				delete(w.pending, id)
+				t.lost++
+			}
+		}
+	}
+}
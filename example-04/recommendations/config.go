@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+)
+
+// serviceConfig is loaded by config.Load from the environment and the
+// optional CONFIG_FILE.
+type serviceConfig struct {
+	Port   int    `env:"PORT" required:"true" validate:"port"`
+	Rabbit string `env:"RABBIT" required:"true" validate:"url" secret:"userinfo"`
+	// Views reach recommendationsQueue from the EVENTS_EXCHANGE topic
+	// exchange by Bindings, and from the Viewed fanout while LegacyBinding
+	// is set; turn it off only once every publisher sends to the topic
+	// exchange.
+	Events        messaging.Config
+	Bindings      []string `env:"RECOMMENDATIONS_BINDINGS" default:"video.viewed"`
+	LegacyBinding bool     `env:"EVENTS_LEGACY_BINDING" default:"true"`
+	// Queue is how recommendationsQueue is declared.
+	Queue messaging.QueueConfig
+
+	// Auth verifies the admin tokens PUT /recommendations/model takes.
+	Auth    auth.Config
+	TLS     tlsx.Config
+	Log     logging.Config
+	Tracing tracing.Config
+}
+
+// Validate checks settings that depend on each other.
+func (c *serviceConfig) Validate() error {
+	if err := c.Queue.Validate(); err != nil {
+		return err
+	}
+	return c.TLS.Validate()
+}
@@ -1,107 +1,109 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-
+	"os/signal"
+	"syscall"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/recommendations/service"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/sync/errgroup"
 )
 
-type viewedMessageBody struct {
-	VideoPath string `json:"videoPath" bson:"videoPath"`
-}
-
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	var cfg serviceConfig
+	if err := config.Load(&cfg); err != nil {
+		slog.Error(`config.Load`, logging.KeyError, err)
+		os.Exit(1)
+	}
+
+	logger, err := logging.New(os.Stderr, `recommendations`, cfg.Log)
+	if err != nil {
+		slog.Error(`logging.New`, logging.KeyError, err)
+		os.Exit(1)
+	}
 
-	if err := run(logger); err != nil {
-		logger.Error(`run failed`, `err`, err.Error())
+	if err := run(logger, cfg); err != nil {
+		logger.Error(`run failed`, logging.KeyError, err)
 		os.Exit(1)
 	}
 }
 
-func run(log *slog.Logger) error {
-	port := os.Getenv(`PORT`)
-	rabbit := os.Getenv(`RABBIT`)
+func run(log *slog.Logger, cfg serviceConfig) error {
+	log.Info(`effective config`, `config`, config.Redacted(cfg))
+
+	// Send spans to stdout or an OTLP collector, per OTEL_TRACES_EXPORTER.
+	cfg.Tracing.ServiceName = `recommendations`
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
 
 	// Connect to RabbitMQ
-	conn, err := amqp.Dial(rabbit)
-	failWithError(log, err, `amqp.Dial`)
+	conn, err := amqp.Dial(cfg.Rabbit)
+	if err != nil {
+		return fmt.Errorf(`amqp.Dial: %w`, err)
+	}
 	defer conn.Close()
 
 	// Create a channel to communicate with RabbitMQ.
 	ch, err := conn.Channel()
-	failWithError(log, err, `conn.Channel`)
+	if err != nil {
+		return fmt.Errorf(`conn.Channel: %w`, err)
+	}
 	defer ch.Close()
 
-	// Declare an exchange of type "fanout".
-	// This exchange will route messages to all queues bound to it,
-	// allowing for broadcast messaging to multiple consumers.
-	err = ch.ExchangeDeclare(
-		`Viewed`,
-		`fanout`,
-		true,
-		false,
-		false,
-		false,
-		nil)
-	failWithError(log, err, `ch.ExchangeDeclare`)
-
-	// Declare a queue named "recommendationsQueue".
-	// This queue will be used to store messages routed from the "Viewed" exchange.
-	recommendationsQueue, err := ch.QueueDeclare(
-		`recommendationsQueue`, // name
-		false,                  // durable
-		false,                  // delete when unused
-		false,                  // exclusive
-		false,                  // no-wait
-		nil,                    // arguments
-	)
-	failWithError(log, err, `ch.QueueDeclare`)
-
-	// Bind the recommendationsQueue to the exchange.
-	err = ch.QueueBind(
-		recommendationsQueue.Name, // Queue name to bind,
-		``,                        // routing key; not applicable for fanout exchanges
-		`Viewed`,                  // Exchange name.
-		false,                     // no-wait
-		nil,                       // arguments
-	)
-	failWithError(log, err, `ch.QueueBind`)
-
-	// Create a channel to receive messages sent to our recommendationsQueue.
-	msgs, err := ch.Consume(
-		recommendationsQueue.Name, // queue
-		``,                        // consumer
-		false,                     // auto-ack
-		false,                     // exclusive
-		false,                     // no-local
-		false,                     // no-wait
-		nil,                       // args
-	)
-	failWithError(log, err, `ch.Consume`)
+	verifier, err := auth.NewVerifier(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	if verifier == nil {
+		log.Warn(`authentication disabled; set AUTH_HMAC_SECRET or AUTH_JWKS_FILE`)
+	}
 
-	go func() {
-		for d := range msgs {
-			var msgBody viewedMessageBody
-			bson.Unmarshal(d.Body, &msgBody)
+	svc := service.New(log, verifier)
+	msgs, err := svc.Subscribe(ch, messaging.Bindings{
+		Exchange:   cfg.Events.Exchange,
+		Patterns:   cfg.Bindings,
+		KeepLegacy: cfg.LegacyBinding,
+	}, cfg.Queue)
+	if err != nil {
+		return err
+	}
 
-			log.Info(`'viewed' message ack.`)
-		}
-	}()
+	// Stop on SIGINT/SIGTERM, or as soon as either the consumer or the HTTP
+	// server fails; the deferred Closes above then run before main exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	g, ctx := errgroup.WithContext(ctx)
 
-	mux := http.NewServeMux()
-	log.Info(`Microservice online!`)
-	// We're simply starting this server as a demo.
-	return http.ListenAndServe(fmt.Sprintf(`:%s`, port), mux)
-}
+	g.Go(func() error {
+		return svc.Consume(ctx, msgs)
+	})
 
-func failWithError(log *slog.Logger, err error, msg string) {
-	if err != nil {
-		log.Error(msg, `error`, err)
-		os.Exit(2)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(`:%d`, cfg.Port),
+		Handler: svc.Handler(),
+	}
+	if err := tlsx.Secure(ctx, log, srv, cfg.TLS); err != nil {
+		return err
 	}
+	g.Go(func() error {
+		log.Info(`Microservice online!`, `tls`, cfg.TLS.Enabled(), `h2c`, cfg.TLS.H2C && !cfg.TLS.Enabled())
+		return httpx.Serve(ctx, srv)
+	})
+
+	return g.Wait()
 }
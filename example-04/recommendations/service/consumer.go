@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errDeliveriesClosed is returned by consumeViewed when RabbitMQ closes the
+// delivery channel, which happens when the connection or channel dies.
+var errDeliveriesClosed = errors.New(`delivery channel closed`)
+
+// consumeViewed feeds every 'viewed' message to model until ctx is
+// cancelled or the broker goes away. A message that can't be decoded is
+// nacked and logged; it never stops the loop.
+func consumeViewed(ctx context.Context, log *slog.Logger, queue string, msgs <-chan amqp.Delivery, model *Model) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf(`%s: %w`, queue, errDeliveriesClosed)
+			}
+			handleViewed(ctx, log, queue, d, model)
+		}
+	}
+}
+
+func handleViewed(ctx context.Context, log *slog.Logger, queue string, d amqp.Delivery, model *Model) {
+	// Continue the trace started by the publisher.
+	ctx, span := tracer.Start(tracing.ExtractAMQP(ctx, d.Headers), queue+` process`,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String(`messaging.system`, `rabbitmq`),
+			attribute.String(`messaging.destination.name`, queue),
+		))
+	defer span.End()
+
+	var msgBody viewedMessageBody
+	if err := bson.Unmarshal(d.Body, &msgBody); err != nil || msgBody.VideoPath == `` {
+		if err == nil {
+			err = errors.New(`missing videoPath`)
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, `bson.Unmarshal`)
+		log.ErrorContext(ctx, `discarding malformed message`, logging.KeyError, err)
+		d.Nack(false, false)
+		return
+	}
+	ctx = logging.WithRequestID(ctx, msgBody.RequestID)
+
+	views := model.Observe(msgBody.VideoPath)
+	log.InfoContext(ctx, `'viewed' message ack.`, `videoPath`, msgBody.VideoPath, `views`, views)
+	d.Ack(false)
+}
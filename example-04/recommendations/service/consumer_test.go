@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type acknowledger struct {
+	acked, nacked []uint64
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.nacked = append(a.nacked, tag)
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestConsumeViewedSurvivesBadMessages(t *testing.T) {
+	good, err := bson.Marshal(viewedMessageBody{VideoPath: `ok.mp4`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ack := &acknowledger{}
+	msgs := make(chan amqp.Delivery, 2)
+	msgs <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 1, Body: []byte(`not bson`)}
+	msgs <- amqp.Delivery{Acknowledger: ack, DeliveryTag: 2, Body: good}
+	close(msgs)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	model := NewModel()
+	err = consumeViewed(context.Background(), log, `recommendationsQueue`, msgs, model)
+	if !errors.Is(err, errDeliveriesClosed) {
+		t.Fatalf(`consumeViewed returned %v, want errDeliveriesClosed`, err)
+	}
+
+	if len(ack.nacked) != 1 || ack.nacked[0] != 1 {
+		t.Errorf(`nacked %v, want [1]`, ack.nacked)
+	}
+	if len(ack.acked) != 1 || ack.acked[0] != 2 {
+		t.Errorf(`acked %v, want [2]`, ack.acked)
+	}
+	if top := model.Top(0); len(top) != 1 || top[0] != (Recommendation{VideoPath: `ok.mp4`, Views: 1}) {
+		t.Errorf(`model.Top = %v, want [{ok.mp4 1}]`, top)
+	}
+}
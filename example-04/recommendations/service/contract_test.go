@@ -0,0 +1,63 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+// TestContract sends the routes requests good and bad, checking every
+// response against openapi.json and that every operation in it is tried.
+func TestContract(t *testing.T) {
+	svc := newService(t)
+	svc.Model().Observe(`a.mp4`)
+	h := svc.Handler()
+	admin := `Bearer ` + token(t, auth.Identity{Subject: `ops`, Admin: true})
+	alice := `Bearer ` + token(t, auth.Identity{Subject: `alice`})
+
+	tried := map[string]bool{}
+	for _, c := range []struct {
+		method, target, authorization, contentType, body string
+		want                                             int
+	}{
+		{`GET`, `/recommendations`, ``, ``, ``, 200},
+		{`GET`, `/recommendations?limit=1`, ``, ``, ``, 200},
+		{`GET`, `/recommendations?limit=-1`, ``, ``, ``, 400},
+		{`GET`, `/recommendations?limit=ten`, ``, ``, ``, 400},
+		{`PUT`, `/recommendations/model`, admin, `application/json`, `[{"videoPath":"b.mp4","views":2}]`, 204},
+		{`PUT`, `/recommendations/model`, admin, `application/json`, `[{"videoPath":"b.mp4"}]`, 400},
+		{`PUT`, `/recommendations/model`, admin, `application/json`, `{"videoPath":"b.mp4","views":2}`, 400},
+		{`PUT`, `/recommendations/model`, admin, `text/plain`, `b.mp4 2`, 415},
+		{`PUT`, `/recommendations/model`, ``, `application/json`, `[]`, 401},
+		{`PUT`, `/recommendations/model`, alice, `application/json`, `[]`, 403},
+		{`GET`, `/openapi.json`, ``, ``, ``, 200},
+	} {
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		if c.contentType != `` {
+			req.Header.Set(`Content-Type`, c.contentType)
+		}
+		if c.authorization != `` {
+			req.Header.Set(`Authorization`, c.authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		name := c.method + ` ` + c.target
+		if w.Code != c.want {
+			t.Errorf(`%s: %d, want %d (%s)`, name, w.Code, c.want, w.Body)
+			continue
+		}
+		if err := spec.CheckResponse(req, w.Result()); err != nil {
+			t.Error(err)
+		}
+		tried[spec.Pattern(req)] = true
+	}
+
+	for _, op := range spec.Operations() {
+		if !tried[op] {
+			t.Errorf(`%s: not tried`, op)
+		}
+	}
+}
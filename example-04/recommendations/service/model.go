@@ -0,0 +1,66 @@
+package service
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// Recommendation is a video and how many times it has been viewed.
+type Recommendation struct {
+	VideoPath string `json:"videoPath"`
+	Views     int    `json:"views"`
+}
+
+// Model is a deliberately simple recommendation model: it recommends the
+// most viewed videos.
+type Model struct {
+	mu    sync.Mutex
+	views map[string]int
+}
+
+// NewModel returns an empty model.
+func NewModel() *Model {
+	return &Model{views: map[string]int{}}
+}
+
+// Observe counts a view of videoPath and returns its new total.
+func (m *Model) Observe(videoPath string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.views[videoPath]++
+	return m.views[videoPath]
+}
+
+// Top returns up to n videos, most viewed first. n <= 0 returns them all.
+func (m *Model) Top(n int) []Recommendation {
+	m.mu.Lock()
+	recs := make([]Recommendation, 0, len(m.views))
+	for path, views := range m.views {
+		recs = append(recs, Recommendation{VideoPath: path, Views: views})
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(recs, func(a, b Recommendation) int {
+		if c := cmp.Compare(b.Views, a.Views); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.VideoPath, b.VideoPath)
+	})
+	if n > 0 && len(recs) > n {
+		recs = recs[:n]
+	}
+	return recs
+}
+
+// Replace throws the model's counts away for recs, e.g. totals rebuilt
+// from the history service.
+func (m *Model) Replace(recs []Recommendation) {
+	views := make(map[string]int, len(recs))
+	for _, r := range recs {
+		views[r.VideoPath] += r.Views
+	}
+	m.mu.Lock()
+	m.views = views
+	m.mu.Unlock()
+}
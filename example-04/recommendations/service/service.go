@@ -0,0 +1,143 @@
+// Package service is the recommendations microservice: it builds a
+// recommendation model from every video.viewed event and serves it on
+// GET /recommendations. PUT /recommendations/model lets an admin replace
+// the model, to rebuild it from the history service after it has drifted.
+package service
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/openapi"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Topology names. ViewedExchange is the fanout views were published to
+// before the topic exchange.
+const (
+	ViewedExchange       = `Viewed`
+	RecommendationsQueue = `recommendationsQueue`
+)
+
+var tracer = tracing.Tracer(`recommendations`)
+
+type viewedMessageBody struct {
+	VideoPath string `json:"videoPath" bson:"videoPath"`
+	// RequestID correlates the view with the GET /video request that
+	// caused it.
+	RequestID string `json:"requestId,omitempty" bson:"requestId,omitempty"`
+}
+
+// Service keeps a Model up to date from video.viewed events.
+type Service struct {
+	log      *slog.Logger
+	model    *Model
+	verifier *auth.Verifier
+	// seen drops the second copy of a view that reaches the queue through
+	// both the topic exchange and the Viewed fanout.
+	seen *messaging.Dedup
+}
+
+// New returns a recommendations service with an empty model. Replacing
+// the model takes an admin token accepted by verifier; with a nil
+// verifier nobody can.
+func New(log *slog.Logger, verifier *auth.Verifier) *Service {
+	return &Service{log: log, model: NewModel(), verifier: verifier, seen: messaging.NewDedup(messaging.DedupWindow)}
+}
+
+// Model returns the live recommendation model.
+func (s *Service) Model() *Model {
+	return s.model
+}
+
+// Subscribe declares recommendationsQueue as q describes, binds it to the
+// topic exchange with b's patterns, usually video.viewed, and starts
+// consuming. Pass the returned channel to Consume.
+func (s *Service) Subscribe(ch messaging.Channel, b messaging.Bindings, q messaging.QueueConfig) (<-chan amqp.Delivery, error) {
+	// Declare a queue named "recommendationsQueue".
+	// This queue will be used to store the views routed to it.
+	recommendationsQueue, err := messaging.DeclareQueue(ch, RecommendationsQueue, q)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bind the recommendationsQueue to the topic exchange, and to the
+	// Viewed fanout it was bound to before while b.KeepLegacy is set.
+	if err := messaging.Bind(ch, recommendationsQueue.Name, b, ViewedExchange); err != nil {
+		return nil, err
+	}
+
+	// Create a channel to receive messages sent to our recommendationsQueue.
+	msgs, err := ch.Consume(
+		recommendationsQueue.Name, // queue
+		``,                        // consumer
+		false,                     // auto-ack
+		false,                     // exclusive
+		false,                     // no-local
+		false,                     // no-wait
+		nil,                       // args
+	)
+	if err != nil {
+		return nil, fmt.Errorf(`ch.Consume: %w`, err)
+	}
+	return msgs, nil
+}
+
+// Consume updates the model from msgs until ctx is cancelled or the broker
+// closes msgs. A view already counted under the same message ID is acked
+// and dropped.
+func (s *Service) Consume(ctx context.Context, msgs <-chan amqp.Delivery) error {
+	return consumeViewed(ctx, s.log, RecommendationsQueue, s.seen.Filter(ctx, msgs), s.model)
+}
+
+// openAPI describes the routes Handler serves.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+var spec = openapi.MustLoad(openAPI)
+
+// Handler serves the HTTP API, wrapped in the shared tracing and logging
+// middleware, checking requests against openapi.json, which it serves at
+// GET /openapi.json. Only PUT /recommendations/model needs a token.
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(`GET /openapi.json`, spec)
+	mux.HandleFunc(`GET /recommendations`, func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.FormValue(`limit`))
+		w.Header().Set(`Content-Type`, `application/json`)
+		json.NewEncoder(w).Encode(s.model.Top(limit))
+	})
+	mux.Handle(`PUT /recommendations/model`, auth.RequireAdmin(s.log, s.verifier, http.HandlerFunc(s.handleReplaceModel)))
+	return tracing.Middleware(logging.Middleware(s.log, spec.Validate(mux)))
+}
+
+// handleReplaceModel replaces the model with the view counts in the body,
+// a JSON array like the one GET /recommendations returns. Views consumed
+// while the caller was totting up the counts may be counted twice or not at
+// all.
+func (s *Service) handleReplaceModel(w http.ResponseWriter, r *http.Request) {
+	var recs []Recommendation
+	if err := json.NewDecoder(r.Body).Decode(&recs); err != nil {
+		http.Error(w, `invalid JSON body`, http.StatusBadRequest)
+		return
+	}
+	for _, rec := range recs {
+		if rec.VideoPath == `` || rec.Views < 0 {
+			http.Error(w, `every entry needs a videoPath and a count of views`, http.StatusBadRequest)
+			return
+		}
+	}
+	s.model.Replace(recs)
+	s.log.InfoContext(r.Context(), `replaced model`, `videos`, len(recs))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+const secret = `test-secret`
+
+func token(t *testing.T, id auth.Identity) string {
+	t.Helper()
+	tok, err := auth.Sign(secret, id, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func newService(t *testing.T) *Service {
+	t.Helper()
+	v, err := auth.NewVerifier(auth.Config{HMACSecret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(slog.New(slog.NewTextHandler(io.Discard, nil)), v)
+}
+
+func TestReplaceModel(t *testing.T) {
+	svc := newService(t)
+	svc.Model().Observe(`stale.mp4`)
+	h := svc.Handler()
+	admin := `Bearer ` + token(t, auth.Identity{Subject: `ops`, Admin: true})
+
+	for body, want := range map[string]int{
+		`[{"videoPath":"a.mp4","views":3},{"videoPath":"b.mp4","views":5}]`: http.StatusNoContent,
+		`[{"videoPath":"","views":3}]`:                                      http.StatusBadRequest,
+		`[{"videoPath":"a.mp4","views":-1}]`:                                http.StatusBadRequest,
+		`{`:                                                                 http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, `/recommendations/model`, strings.NewReader(body))
+		req.Header.Set(`Authorization`, admin)
+		req.Header.Set(`Content-Type`, `application/json`)
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf(`PUT %s: status %d, want %d`, body, rec.Code, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/recommendations`, nil))
+	var got []Recommendation
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []Recommendation{{`b.mp4`, 5}, {`a.mp4`, 3}}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf(`GET /recommendations = %+v, want %+v`, got, want)
+	}
+}
+
+func TestReplaceModelNeedsAdmin(t *testing.T) {
+	svc := newService(t)
+	svc.Model().Observe(`kept.mp4`)
+	h := svc.Handler()
+
+	for authz, want := range map[string]int{
+		``: http.StatusUnauthorized,
+		`Bearer ` + token(t, auth.Identity{Subject: `alice`}): http.StatusForbidden,
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, `/recommendations/model`, strings.NewReader(`[]`))
+		req.Header.Set(`Content-Type`, `application/json`)
+		if authz != `` {
+			req.Header.Set(`Authorization`, authz)
+		}
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf(`PUT with %q: status %d, want %d`, authz, rec.Code, want)
+		}
+	}
+
+	// GET stays open, and the model is untouched.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/recommendations`, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `kept.mp4`) {
+		t.Errorf(`GET /recommendations = %d %s`, rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,89 @@
+package captions
+
+import (
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+)
+
+func TestToWebVTTConvertsSRT(t *testing.T) {
+	srt := "\ufeff1\r\n00:00:01,000 --> 00:00:02,500\r\n<i>Hello</i> & <font color=\"red\">welcome</font>\r\n\r\n" +
+		"2\r\n00:00:03,000 --> 00:00:04,000 X1:10 X2:20 Y1:10 Y2:20\r\nTwo\r\nlines\r\n"
+	want := "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.500\n<i>Hello</i> &amp; welcome\n\n" +
+		"2\n00:00:03.000 --> 00:00:04.000\nTwo\nlines\n"
+
+	got, err := ToWebVTT([]byte(srt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("ToWebVTT:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestToWebVTT(t *testing.T) {
+	for _, tc := range []struct {
+		name, in string
+		ok       bool
+	}{
+		{`webvtt`, "WEBVTT\n\n00:01.000 --> 00:02.000 align:start\nHi\n", true},
+		{`webvtt with header text`, "WEBVTT - English\r\n\r\n00:00:01.000 --> 00:00:02.000\r\nHi\r\n", true},
+		{`srt timings under a webvtt header`, "WEBVTT\n\n00:00:01,000 --> 00:00:02,000\nHi\n", false},
+		{`srt without indices`, "00:00:01,000 --> 00:00:02,000\nHi\n", true},
+		{`bad timing`, "1\n00:01 --> 00:02\nHi\n", false},
+		{`empty`, ``, false},
+		{`not captions`, "hello world\n", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ToWebVTT([]byte(tc.in))
+			if tc.ok && err != nil {
+				t.Errorf(`ToWebVTT: %v`, err)
+			}
+			if !tc.ok && !errors.Is(err, ErrInvalid) {
+				t.Errorf(`ToWebVTT: got %v, want ErrInvalid`, err)
+			}
+		})
+	}
+}
+
+func TestParseLang(t *testing.T) {
+	if got, err := ParseLang(`en-us`); err != nil || got != `en-US` {
+		t.Errorf(`ParseLang(en-us) = %q, %v`, got, err)
+	}
+	if _, err := ParseLang(`../etc`); err == nil {
+		t.Error(`ParseLang(../etc) succeeded`)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const video = `a.mp4`
+
+	if langs, err := s.Languages(video); err != nil || len(langs) != 0 {
+		t.Errorf(`Languages before Put = %v, %v`, langs, err)
+	}
+	if _, err := s.Get(video, `en`); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`Get before Put: %v`, err)
+	}
+
+	for _, lang := range []string{`fr`, `en`} {
+		if err := s.Put(video, lang, []byte("1\n00:00:01,000 --> 00:00:02,000\n"+lang+"\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Put(video, `de`, []byte(`nonsense`)); !errors.Is(err, ErrInvalid) {
+		t.Errorf(`Put of nonsense: %v`, err)
+	}
+
+	if langs, err := s.Languages(video); err != nil || !slices.Equal(langs, []string{`en`, `fr`}) {
+		t.Errorf(`Languages = %v, %v; want [en fr]`, langs, err)
+	}
+	got, err := s.Get(video, `fr`)
+	if err != nil || string(got) != "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nfr\n" {
+		t.Errorf(`Get = %q, %v`, got, err)
+	}
+}
@@ -0,0 +1,103 @@
+package captions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// ParseLang canonicalizes a BCP 47 language tag such as "en-us" to
+// "en-US", rejecting anything that isn't one.
+func ParseLang(s string) (string, error) {
+	tag, err := language.Parse(s)
+	if err != nil {
+		return ``, fmt.Errorf(`captions: language %q: %w`, s, err)
+	}
+	return tag.String(), nil
+}
+
+// Store keeps one WebVTT file per video and language under a directory.
+// Languages passed to it must have come from ParseLang.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a store in dir, which is created if needed.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf(`captions: %w`, err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Put converts data to WebVTT and stores it as video's captions in lang,
+// replacing any already there.
+func (s *Store) Put(video, lang string, data []byte) error {
+	vtt, err := ToWebVTT(data)
+	if err != nil {
+		return err
+	}
+	dir := s.videoDir(video)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, `*.tmp`)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(vtt)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, lang+`.vtt`))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Get returns video's WebVTT captions in lang, or an error wrapping
+// fs.ErrNotExist.
+func (s *Store) Get(video, lang string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.videoDir(video), lang+`.vtt`))
+}
+
+// Delete removes all of video's captions. A video without any is not an
+// error.
+func (s *Store) Delete(video string) error {
+	return os.RemoveAll(s.videoDir(video))
+}
+
+// Languages lists the languages video has captions in, sorted.
+func (s *Store) Languages(video string) ([]string, error) {
+	entries, err := os.ReadDir(s.videoDir(video))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var langs []string
+	for _, e := range entries {
+		if lang, ok := strings.CutSuffix(e.Name(), `.vtt`); ok && e.Type().IsRegular() {
+			langs = append(langs, lang)
+		}
+	}
+	slices.Sort(langs)
+	return langs, nil
+}
+
+// videoDir holds video's captions; the name is hashed so ids never need
+// escaping.
+func (s *Store) videoDir(video string) string {
+	sum := sha256.Sum256([]byte(video))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
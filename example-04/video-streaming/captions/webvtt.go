@@ -0,0 +1,128 @@
+// Package captions stores caption tracks for videos as WebVTT, converting
+// SRT uploads on the way in.
+package captions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalid is returned for uploads that are neither WebVTT nor SRT.
+var ErrInvalid = errors.New(`captions: not WebVTT or SRT`)
+
+// timing matches a cue timing line. SRT uses a comma before the
+// milliseconds and always has hours; WebVTT uses a dot and may omit them.
+// Anything after the end time is SRT coordinates or WebVTT cue settings.
+var timing = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}[,.]\d{3})[ \t]+-->[ \t]+((?:\d+:)?\d{2}:\d{2}[,.]\d{3})(.*)$`)
+
+// tag matches the markup SRT files use in cue text.
+var tag = regexp.MustCompile(`</?([a-zA-Z]+)[^<>]*>`)
+
+// ToWebVTT returns data as WebVTT. WebVTT is checked and passed through;
+// anything else is parsed as SRT and converted.
+func ToWebVTT(data []byte) ([]byte, error) {
+	text := normalize(data)
+	if isWebVTT(text) {
+		if err := checkWebVTT(text); err != nil {
+			return nil, err
+		}
+		return []byte(text), nil
+	}
+	return fromSRT(text)
+}
+
+// normalize drops a byte order mark and turns every line ending into \n.
+func normalize(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	s := strings.ReplaceAll(string(data), "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
+
+func isWebVTT(s string) bool {
+	rest, ok := strings.CutPrefix(s, `WEBVTT`)
+	return ok && (rest == `` || rest[0] == ' ' || rest[0] == '\t' || rest[0] == '\n')
+}
+
+// checkWebVTT makes sure every cue timing line parses, which catches SRT
+// files that were merely given a WEBVTT header.
+func checkWebVTT(s string) error {
+	for i, line := range strings.Split(s, "\n") {
+		if !strings.Contains(line, `-->`) {
+			continue
+		}
+		m := timing.FindStringSubmatch(line)
+		if m == nil || strings.Contains(m[1]+m[2], `,`) {
+			return fmt.Errorf(`%w: line %d: bad cue timing`, ErrInvalid, i+1)
+		}
+	}
+	return nil
+}
+
+// fromSRT converts SubRip cues to WebVTT. Each block is an optional
+// index, a timing line and the cue text; the index becomes the cue's
+// identifier.
+func fromSRT(s string) ([]byte, error) {
+	var out strings.Builder
+	out.WriteString("WEBVTT\n")
+	cues := 0
+	for _, block := range strings.Split(s, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) == 1 && strings.TrimSpace(lines[0]) == `` {
+			continue
+		}
+		id := ``
+		if !strings.Contains(lines[0], `-->`) {
+			id, lines = strings.TrimSpace(lines[0]), lines[1:]
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf(`%w: cue %d has no timing`, ErrInvalid, cues+1)
+		}
+		m := timing.FindStringSubmatch(strings.TrimSpace(lines[0]))
+		if m == nil {
+			return nil, fmt.Errorf(`%w: cue %d: bad timing %q`, ErrInvalid, cues+1, lines[0])
+		}
+		cues++
+
+		out.WriteString("\n")
+		if id != `` {
+			out.WriteString(id + "\n")
+		}
+		out.WriteString(strings.Replace(m[1], `,`, `.`, 1) + ` --> ` + strings.Replace(m[2], `,`, `.`, 1) + "\n")
+		for _, line := range lines[1:] {
+			out.WriteString(cueText(line) + "\n")
+		}
+	}
+	if cues == 0 {
+		return nil, fmt.Errorf(`%w: no cues`, ErrInvalid)
+	}
+	return []byte(out.String()), nil
+}
+
+// cueText keeps the italic, bold and underline tags WebVTT shares with
+// SRT, drops the rest (such as <font>) and escapes everything else.
+func cueText(line string) string {
+	line = strings.ReplaceAll(line, `-->`, `->`)
+	var out strings.Builder
+	last := 0
+	for _, m := range tag.FindAllStringSubmatchIndex(line, -1) {
+		out.WriteString(escape(line[last:m[0]]))
+		last = m[1]
+		switch name := strings.ToLower(line[m[2]:m[3]]); name {
+		case `i`, `b`, `u`:
+			if line[m[0]+1] == '/' {
+				out.WriteString(`</` + name + `>`)
+			} else {
+				out.WriteString(`<` + name + `>`)
+			}
+		}
+	}
+	out.WriteString(escape(line[last:]))
+	return out.String()
+}
+
+var escaper = strings.NewReplacer(`&`, `&amp;`, `<`, `&lt;`, `>`, `&gt;`)
+
+func escape(s string) string { return escaper.Replace(s) }
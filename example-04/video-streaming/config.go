@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+)
+
+// serviceConfig is loaded by config.Load from the environment and the
+// optional CONFIG_FILE.
+type serviceConfig struct {
+	Port   int    `env:"PORT" required:"true" validate:"port"`
+	Rabbit string `env:"RABBIT" required:"true" validate:"url" secret:"userinfo"`
+	// VideoStore is dir to serve the files in VideosDir, or http to fetch
+	// them from VideoStoreURL with Range requests.
+	VideoStore    string `env:"VIDEO_STORE" default:"dir" validate:"oneof=dir http"`
+	VideosDir     string `env:"VIDEOS_DIR" default:"./videos"`
+	VideoStoreURL string `env:"VIDEO_STORE_URL" validate:"url" secret:"userinfo"`
+	// UploadMaxBytes caps a video uploaded to PUT /videos/{id}; 0 means
+	// no cap. Only the dir store takes uploads.
+	UploadMaxBytes int `env:"UPLOAD_MAX_BYTES" default:"4294967296"`
+	// CacheDir, when set, keeps chunks of videos on local disk, evicting
+	// the least recently used beyond CacheMaxBytes. Uploaded and Deleted
+	// messages invalidate them.
+	CacheDir        string `env:"CACHE_DIR"`
+	CacheMaxBytes   int    `env:"CACHE_MAX_BYTES" default:"1073741824"`
+	CacheChunkBytes int    `env:"CACHE_CHUNK_BYTES" default:"1048576"`
+	// ThumbnailDir caches the images served on GET /thumbnail/{id}; empty
+	// disables the endpoint. ThumbnailDecoder is raw to return the encoded
+	// H.264 keyframe, or ffmpeg to decode it to JPEG with FFmpegPath.
+	ThumbnailDir     string `env:"THUMBNAIL_DIR" default:"./thumbnails"`
+	ThumbnailDecoder string `env:"THUMBNAIL_DECODER" default:"raw" validate:"oneof=raw ffmpeg"`
+	FFmpegPath       string `env:"FFMPEG_PATH" default:"ffmpeg"`
+	// CaptionsDir holds the WebVTT caption tracks uploaded to
+	// PUT /videos/{id}/captions/{lang}; empty disables captions.
+	CaptionsDir string `env:"CAPTIONS_DIR" default:"./data/captions"`
+	// SigningKeys are "id:secret" pairs for signed links; the first signs
+	// and all of them verify. Empty disables signed links.
+	SigningKeys []string      `env:"URL_SIGNING_KEYS" secret:"true"`
+	LinkTTL     time.Duration `env:"URL_LINK_TTL" default:"1h"`
+	MaxLinkTTL  time.Duration `env:"URL_LINK_MAX_TTL" default:"24h"`
+
+	// Per-client limits; 0 turns a limit off. Requests are limited per IP
+	// address, before authentication, and again per token subject; streams
+	// by subject, or by IP address without one.
+	RequestsPerSec float64 `env:"RATE_LIMIT_RPS" default:"5"`
+	Burst          int     `env:"RATE_LIMIT_BURST" default:"10"`
+	MaxStreams     int     `env:"MAX_STREAMS_PER_CLIENT" default:"3"`
+	BytesPerSec    int     `env:"STREAM_BYTES_PER_SEC" default:"0"`
+
+	// Catalog holds each video's bitrate. Streams are paced to it by the
+	// viewer's tier, a "name:headroom:burst:maxBitrate" entry picked by the
+	// token's plan claim, or DefaultTier. No tiers means no pacing.
+	Catalog     string   `env:"VIDEOS_CATALOG" default:"./catalog.json"`
+	Tiers       []string `env:"STREAM_TIERS"`
+	DefaultTier string   `env:"STREAM_DEFAULT_TIER" default:"free"`
+
+	// Events are published to the EVENTS_EXCHANGE topic exchange. While
+	// consumers move to it, LegacyEvents publishes them to the old fanout
+	// exchanges too and LegacyViewedQueue feeds example-03's viewed queue.
+	// Cache invalidations come from InvalidationBindings, and from the
+	// old Uploaded and Deleted fanouts under LegacyEvents.
+	Events               messaging.Config
+	LegacyEvents         bool     `env:"EVENTS_LEGACY_FANOUT" default:"true"`
+	LegacyViewedQueue    bool     `env:"EVENTS_LEGACY_VIEWED_QUEUE" default:"false"`
+	InvalidationBindings []string `env:"INVALIDATION_BINDINGS" default:"video.uploaded,video.deleted"`
+
+	Auth auth.Config
+
+	// TLS serves HTTPS, and HTTP/2 with it. sendfile can't be used once
+	// the stream is encrypted, so TLS costs more CPU per stream than
+	// plain HTTP behind a terminating proxy.
+	TLS tlsx.Config
+
+	Log     logging.Config
+	Tracing tracing.Config
+}
+
+// Validate checks settings that depend on each other.
+func (c *serviceConfig) Validate() error {
+	if c.VideoStore == `http` && c.VideoStoreURL == `` {
+		return errors.New(`VIDEO_STORE_URL is required when VIDEO_STORE is http`)
+	}
+	if c.LinkTTL > c.MaxLinkTTL {
+		return errors.New(`URL_LINK_TTL may not exceed URL_LINK_MAX_TTL`)
+	}
+	return c.TLS.Validate()
+}
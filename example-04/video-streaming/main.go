@@ -1,115 +1,215 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
-
+	"os/signal"
+	"syscall"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/captions"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/service"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/thumbnail"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/config"
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"go.mongodb.org/mongo-driver/bson"
-)
-
-const (
-	contentLength = `Content-Length`
-	contentType   = `Content-Type`
+	"golang.org/x/sync/errgroup"
 )
 
-type viewedMessageBody struct {
-	VideoPath string `json:"videoPath" bson:"videoPath"`
-}
-
 func main() {
-	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	var cfg serviceConfig
+	if err := config.Load(&cfg); err != nil {
+		slog.Error(`config.Load`, logging.KeyError, err)
+		os.Exit(1)
+	}
 
-	if err := run(logger); err != nil {
-		logger.Error(`run failed`, `err`, err.Error())
+	logger, err := logging.New(os.Stderr, `video-streaming`, cfg.Log)
+	if err != nil {
+		slog.Error(`logging.New`, logging.KeyError, err)
+		os.Exit(1)
+	}
+
+	if err := run(logger, cfg); err != nil {
+		logger.Error(`run failed`, logging.KeyError, err)
 		os.Exit(1)
 	}
 }
 
-func run(log *slog.Logger) error {
-	port, found := os.LookupEnv(`PORT`)
-	if !found {
-		fmt.Errorf(`Please specify the port number for the HTTP server with the environment variable PORT.`)
+func run(log *slog.Logger, cfg serviceConfig) error {
+	log.Info(`effective config`, `config`, config.Redacted(cfg))
+
+	// Send spans to stdout or an OTLP collector, per OTEL_TRACES_EXPORTER.
+	cfg.Tracing.ServiceName = `video-streaming`
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return err
 	}
+	defer shutdownTracing(context.Background())
 
-	rabbit := os.Getenv(`RABBIT`)
+	verifier, err := auth.NewVerifier(cfg.Auth)
+	if err != nil {
+		return err
+	}
+	if verifier == nil {
+		log.Warn(`authentication disabled; set AUTH_HMAC_SECRET or AUTH_JWKS_FILE`)
+	}
 
 	// Connect to RabbitMQ
-	conn, err := amqp.Dial(rabbit)
-	failWithError(log, err, `amqp.Dial`)
+	conn, err := amqp.Dial(cfg.Rabbit)
+	if err != nil {
+		return fmt.Errorf(`amqp.Dial: %w`, err)
+	}
 	defer conn.Close()
 
 	// Create a channel to communicate with RabbitMQ.
 	ch, err := conn.Channel()
-	failWithError(log, err, `conn.Channel`)
+	if err != nil {
+		return fmt.Errorf(`conn.Channel: %w`, err)
+	}
 	defer ch.Close()
 
-	// Declare an exchange of type "fanout".
-	// This exchange will route messages to all queues bound to it,
-	// allowing for broadcast messaging to multiple consumers.
-	err = ch.ExchangeDeclare(
-		`Viewed`, // Exchange name.
-		`fanout`, // Exchange type.
-		true,     // Durable?
-		false,    // Delete when unused.
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
-	)
-	failWithError(log, err, `ch.ExchangeDeclare`)
-
-	mux := http.NewServeMux()
-	mux.HandleFunc(`GET /video`, func(w http.ResponseWriter, r *http.Request) {
-		videoPath := `./videos/SampleVideo_1280x720_1mb.mp4`
-		videoReader, err := os.Open(videoPath)
-		if err != nil {
-			w.WriteHeader(http.StatusNotFound)
-			return
+	signingKeys, err := service.ParseSigningKeys(cfg.SigningKeys)
+	if err != nil {
+		return fmt.Errorf(`URL_SIGNING_KEYS: %w`, err)
+	}
+	catalog, err := service.LoadCatalog(cfg.Catalog)
+	if err != nil {
+		return fmt.Errorf(`service.LoadCatalog: %w`, err)
+	}
+	tiers, err := service.ParseTiers(cfg.Tiers)
+	if err != nil {
+		return fmt.Errorf(`STREAM_TIERS: %w`, err)
+	}
+	if _, found := tiers[cfg.DefaultTier]; len(tiers) > 0 && !found {
+		return fmt.Errorf(`STREAM_DEFAULT_TIER: no tier named %q`, cfg.DefaultTier)
+	}
+
+	videos, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Videos can be managed only where they are files of our own.
+	var library store.Library
+	if cfg.VideoStore == `dir` {
+		library = store.Dir(cfg.VideosDir)
+	}
+
+	var thumbnails *thumbnail.Extractor
+	if cfg.ThumbnailDir != `` {
+		var decoder thumbnail.Decoder = thumbnail.Raw{}
+		if cfg.ThumbnailDecoder == `ffmpeg` {
+			decoder = thumbnail.FFmpeg{Path: cfg.FFmpegPath}
 		}
-		defer videoReader.Close()
-		videoStats, err := videoReader.Stat()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		if thumbnails, err = thumbnail.New(videos, decoder, cfg.ThumbnailDir); err != nil {
+			return err
 		}
+	}
 
-		w.Header().Add(contentLength, strconv.FormatInt(videoStats.Size(), 10))
-		w.Header().Add(contentType, `video/mp4`)
-		// use io.Copy for streaming.
-		io.Copy(w, videoReader)
-		sendViewedMessage(log, videoPath, ch)
-	})
-
-	log.Info(`Microservice online!`)
-	return http.ListenAndServe(fmt.Sprint(`:`, port), mux)
-}
+	var captionStore *captions.Store
+	if cfg.CaptionsDir != `` {
+		if captionStore, err = captions.NewStore(cfg.CaptionsDir); err != nil {
+			return err
+		}
+	}
 
-func sendViewedMessage(log *slog.Logger, path string, channel *amqp.Channel) {
-	// Refactor to send to RabbitMQ.
-	body := viewedMessageBody{
-		VideoPath: path,
+	svc := service.New(log, ch, service.Options{
+		Events:            cfg.Events.Exchange,
+		LegacyEvents:      cfg.LegacyEvents,
+		LegacyViewedQueue: cfg.LegacyViewedQueue,
+		Store:             videos,
+		Library:           library,
+		MaxUploadBytes:    int64(cfg.UploadMaxBytes),
+		Verifier:          verifier,
+		Signer:            service.NewURLSigner(signingKeys),
+		LinkTTL:           cfg.LinkTTL,
+		MaxLinkTTL:        cfg.MaxLinkTTL,
+		Limits: service.Limits{
+			RequestsPerSec: cfg.RequestsPerSec,
+			Burst:          cfg.Burst,
+			MaxStreams:     cfg.MaxStreams,
+			BytesPerSec:    cfg.BytesPerSec,
+		},
+		Catalog:     catalog,
+		Tiers:       tiers,
+		DefaultTier: cfg.DefaultTier,
+		Thumbnails:  thumbnails,
+		Captions:    captionStore,
+	})
+	if err := svc.Declare(ch); err != nil {
+		return err
 	}
 
-	payload, err := bson.Marshal(body)
+	// Stop on SIGINT/SIGTERM, if the HTTP server fails or if RabbitMQ drops
+	// the connection; the deferred Closes above then run before main exits.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	g, ctx := errgroup.WithContext(ctx)
+
+	// Each replica drops its own cached copies of changed videos, and
+	// deleted videos' captions, so it subscribes whatever is cached.
+	invalidations, err := svc.SubscribeInvalidations(ch, messaging.Bindings{
+		Exchange: cfg.Events.Exchange,
+		Patterns: cfg.InvalidationBindings,
+	})
 	if err != nil {
-		return
+		return err
 	}
+	g.Go(func() error {
+		return svc.ConsumeInvalidations(ctx, invalidations)
+	})
 
-	err = channel.Publish(`Viewed`, ``, false, false, amqp.Publishing{
-		ContentType: `application/bson`,
-		Body:        payload,
+	connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+	g.Go(func() error {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-connClosed:
+			return fmt.Errorf(`rabbitmq connection closed: %w`, err)
+		}
+	})
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(`:%d`, cfg.Port),
+		Handler: svc.Handler(),
+	}
+	if err := tlsx.Secure(ctx, log, srv, cfg.TLS); err != nil {
+		return err
+	}
+	g.Go(func() error {
+		log.Info(`Microservice online!`, `tls`, cfg.TLS.Enabled(), `h2c`, cfg.TLS.H2C && !cfg.TLS.Enabled())
+		return httpx.Serve(ctx, srv)
 	})
 
-	failWithError(log, err, `Unable to publish to RabbitMQ channel`)
+	return g.Wait()
 }
 
-func failWithError(log *slog.Logger, err error, msg string) {
+// openStore returns the VideoStore selected by VIDEO_STORE, behind a chunk
+// cache if CACHE_DIR is set.
+func openStore(cfg serviceConfig) (store.VideoStore, error) {
+	var videos store.VideoStore = store.Dir(cfg.VideosDir)
+	if cfg.VideoStore == `http` {
+		remote, err := store.NewHTTP(cfg.VideoStoreURL, &http.Client{Timeout: time.Minute})
+		if err != nil {
+			return nil, fmt.Errorf(`store.NewHTTP: %w`, err)
+		}
+		videos = remote
+	}
+	if cfg.CacheDir == `` {
+		return videos, nil
+	}
+
+	cache, err := store.NewCache(videos, cfg.CacheDir, int64(cfg.CacheChunkBytes), int64(cfg.CacheMaxBytes))
 	if err != nil {
-		log.Error(msg, `error`, err)
-		os.Exit(2)
+		return nil, err
 	}
+	return cache, nil
 }
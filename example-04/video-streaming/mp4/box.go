@@ -0,0 +1,250 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// box is a box header: its type and where its payload lies.
+type box struct {
+	typ     string
+	payload int64
+	end     int64
+}
+
+// maxBoxes bounds how many sibling boxes we will list.
+const maxBoxes = 1 << 16
+
+// children lists the boxes between off and end.
+func children(r io.ReaderAt, off, end int64) ([]box, error) {
+	var boxes []box
+	var hdr [16]byte
+	for off+8 <= end {
+		if len(boxes) == maxBoxes {
+			return nil, errors.New(`mp4: too many boxes`)
+		}
+		if _, err := r.ReadAt(hdr[:8], off); err != nil {
+			return nil, fmt.Errorf(`mp4: box header at %d: %w`, off, err)
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		b := box{typ: string(hdr[4:8]), payload: off + 8}
+		switch size {
+		case 0: // extends to the end
+			size = end - off
+		case 1: // 64-bit size follows
+			if _, err := r.ReadAt(hdr[8:16], off+8); err != nil {
+				return nil, fmt.Errorf(`mp4: box header at %d: %w`, off, err)
+			}
+			size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			b.payload += 8
+		}
+		b.end = off + size
+		if size < b.payload-off || b.end > end {
+			return nil, fmt.Errorf(`mp4: bad %q box size at %d`, b.typ, off)
+		}
+		boxes = append(boxes, b)
+		off = b.end
+	}
+	return boxes, nil
+}
+
+// find returns the first box of type typ between off and end.
+func find(r io.ReaderAt, off, end int64, typ string) (box, error) {
+	boxes, err := children(r, off, end)
+	if err != nil {
+		return box{}, err
+	}
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, nil
+		}
+	}
+	return box{}, fmt.Errorf(`mp4: no %q box`, typ)
+}
+
+// read loads b's payload.
+func (b box) read(r io.ReaderAt) ([]byte, error) {
+	n := b.end - b.payload
+	if n > maxTableSize {
+		return nil, fmt.Errorf(`mp4: %q box is %d bytes`, b.typ, n)
+	}
+	data := make([]byte, n)
+	if _, err := r.ReadAt(data, b.payload); err != nil {
+		return nil, fmt.Errorf(`mp4: read %q box: %w`, b.typ, err)
+	}
+	return data, nil
+}
+
+// readTrack reads trak if it is a video track, and returns nil otherwise.
+func readTrack(r io.ReaderAt, trak box) (*Track, error) {
+	mdia, err := find(r, trak.payload, trak.end, `mdia`)
+	if err != nil {
+		return nil, err
+	}
+	hdlr, err := find(r, mdia.payload, mdia.end, `hdlr`)
+	if err != nil {
+		return nil, err
+	}
+	data, err := hdlr.read(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[8:12]) != `vide` {
+		return nil, nil
+	}
+
+	t := &Track{}
+	mdhd, err := find(r, mdia.payload, mdia.end, `mdhd`)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = mdhd.read(r); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(data) >= 16 && data[0] == 0:
+		t.Timescale = binary.BigEndian.Uint32(data[12:16])
+	case len(data) >= 24 && data[0] == 1:
+		t.Timescale = binary.BigEndian.Uint32(data[20:24])
+	default:
+		return nil, errors.New(`mp4: bad mdhd`)
+	}
+
+	minf, err := find(r, mdia.payload, mdia.end, `minf`)
+	if err != nil {
+		return nil, err
+	}
+	stbl, err := find(r, minf.payload, minf.end, `stbl`)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := children(r, stbl.payload, stbl.end)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range tables {
+		switch b.typ {
+		case `stsd`:
+			err = t.readStsd(r, b)
+		case `stts`, `stss`, `stsz`, `stsc`, `stco`, `co64`:
+			if data, err = b.read(r); err == nil {
+				err = t.readTable(b.typ, data)
+			}
+		case `stz2`:
+			err = errors.New(`mp4: compact sample sizes (stz2) are not supported`)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t.Codec == `` || t.timeToSample == nil || t.sampleToChunk == nil || t.chunkOffsets == nil {
+		return nil, errors.New(`mp4: video track is missing sample tables`)
+	}
+	if t.sampleSize == 0 && len(t.sampleSizes) != t.sampleCount {
+		return nil, errors.New(`mp4: video track has no sample sizes`)
+	}
+	return t, nil
+}
+
+// readStsd reads the first sample entry's type, dimensions and
+// configuration box.
+func (t *Track) readStsd(r io.ReaderAt, stsd box) error {
+	// Skip version, flags and entry count.
+	entries, err := children(r, stsd.payload+8, stsd.end)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return errors.New(`mp4: empty stsd`)
+	}
+	entry := entries[0]
+	t.Codec = entry.typ
+
+	// A VisualSampleEntry has 78 bytes of fixed fields before its child
+	// boxes; width and height are at 24 and 26.
+	const visualEntrySize = 78
+	if entry.end-entry.payload < visualEntrySize {
+		return errors.New(`mp4: short visual sample entry`)
+	}
+	var dims [4]byte
+	if _, err := r.ReadAt(dims[:], entry.payload+24); err != nil {
+		return err
+	}
+	t.Width = binary.BigEndian.Uint16(dims[:2])
+	t.Height = binary.BigEndian.Uint16(dims[2:])
+
+	configs, err := children(r, entry.payload+visualEntrySize, entry.end)
+	if err != nil {
+		return err
+	}
+	for _, b := range configs {
+		switch b.typ {
+		case `avcC`, `hvcC`, `av1C`, `vpcC`:
+			t.Config, err = b.read(r)
+			return err
+		}
+	}
+	return nil
+}
+
+// readTable parses one sample table's payload.
+func (t *Track) readTable(typ string, data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf(`mp4: short %s`, typ)
+	}
+	count := int(binary.BigEndian.Uint32(data[4:8]))
+	body := data[8:]
+
+	entrySize := map[string]int{`stts`: 8, `stss`: 4, `stsz`: 4, `stsc`: 12, `stco`: 4, `co64`: 8}[typ]
+	if typ == `stsz` {
+		if len(data) < 12 {
+			return errors.New(`mp4: short stsz`)
+		}
+		t.sampleSize = binary.BigEndian.Uint32(data[4:8])
+		t.sampleCount = int(binary.BigEndian.Uint32(data[8:12]))
+		if t.sampleSize != 0 {
+			return nil
+		}
+		count, body = t.sampleCount, data[12:]
+	}
+	if count < 0 || count > len(body)/entrySize {
+		return fmt.Errorf(`mp4: %s claims %d entries`, typ, count)
+	}
+
+	be := binary.BigEndian
+	switch typ {
+	case `stts`:
+		t.timeToSample = make([]sttsEntry, count)
+		for i := range t.timeToSample {
+			t.timeToSample[i] = sttsEntry{be.Uint32(body[i*8:]), be.Uint32(body[i*8+4:])}
+		}
+	case `stss`:
+		t.syncSamples = make([]uint32, count)
+		for i := range t.syncSamples {
+			t.syncSamples[i] = be.Uint32(body[i*4:])
+		}
+	case `stsz`:
+		t.sampleSizes = make([]uint32, count)
+		for i := range t.sampleSizes {
+			t.sampleSizes[i] = be.Uint32(body[i*4:])
+		}
+	case `stsc`:
+		t.sampleToChunk = make([]stscEntry, count)
+		for i := range t.sampleToChunk {
+			t.sampleToChunk[i] = stscEntry{be.Uint32(body[i*12:]), be.Uint32(body[i*12+4:])}
+		}
+	case `stco`:
+		t.chunkOffsets = make([]uint64, count)
+		for i := range t.chunkOffsets {
+			t.chunkOffsets[i] = uint64(be.Uint32(body[i*4:]))
+		}
+	case `co64`:
+		t.chunkOffsets = make([]uint64, count)
+		for i := range t.chunkOffsets {
+			t.chunkOffsets[i] = be.Uint64(body[i*8:])
+		}
+	}
+	return nil
+}
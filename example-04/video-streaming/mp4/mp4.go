@@ -0,0 +1,271 @@
+// Package mp4 reads just enough of an ISO base media (MP4) file to find
+// and extract individual video samples: the video track's timescale, codec
+// configuration and sample tables.
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNoVideo is returned for files without a video track.
+var ErrNoVideo = errors.New(`mp4: no video track`)
+
+// maxTableSize bounds the sample tables we are willing to load, so a
+// corrupt or hostile file can't make us allocate without limit.
+const maxTableSize = 64 << 20
+
+// Track is a video track's sample tables.
+type Track struct {
+	// Codec is the sample entry type, e.g. avc1.
+	Codec     string
+	Width     uint16
+	Height    uint16
+	Timescale uint32
+	// Config is the codec configuration record, e.g. the avcC box's
+	// payload for avc1.
+	Config []byte
+
+	timeToSample  []sttsEntry
+	syncSamples   []uint32 // 1-based; nil means every sample is a sync sample
+	sampleCount   int
+	sampleSize    uint32 // if non-zero, the size of every sample
+	sampleSizes   []uint32
+	sampleToChunk []stscEntry
+	chunkOffsets  []uint64
+}
+
+type sttsEntry struct{ count, delta uint32 }
+
+type stscEntry struct{ firstChunk, samplesPerChunk uint32 }
+
+// Sample locates one sample in the file.
+type Sample struct {
+	// Index counts from 0.
+	Index  int
+	Time   time.Duration
+	Offset int64
+	Size   uint32
+}
+
+// ReadVideoTrack finds the first video track in the size-byte file r.
+func ReadVideoTrack(r io.ReaderAt, size int64) (*Track, error) {
+	moov, err := find(r, 0, size, `moov`)
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := children(r, moov.payload, moov.end)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range boxes {
+		if b.typ != `trak` {
+			continue
+		}
+		t, err := readTrack(r, b)
+		if err != nil {
+			return nil, err
+		}
+		if t != nil {
+			return t, nil
+		}
+	}
+	return nil, ErrNoVideo
+}
+
+// KeyframeNear returns the sync sample whose decode time is closest to at.
+func (t *Track) KeyframeNear(at time.Duration) (Sample, error) {
+	n := t.sampleCount
+	if n == 0 {
+		return Sample{}, errors.New(`mp4: track has no samples`)
+	}
+
+	// Candidates come in increasing order, so one pass over the
+	// time-to-sample table times them all.
+	var c sttsCursor
+	best, bestDist := -1, time.Duration(0)
+	consider := func(i int) {
+		d := t.ticksToDuration(c.ticks(t.timeToSample, i)) - at
+		if d < 0 {
+			d = -d
+		}
+		if best < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	if t.syncSamples == nil {
+		for i := range n {
+			consider(i)
+		}
+	} else {
+		for _, s := range t.syncSamples {
+			if s >= 1 && int(s) <= n {
+				consider(int(s) - 1)
+			}
+		}
+	}
+	if best < 0 {
+		return Sample{}, errors.New(`mp4: track has no sync samples`)
+	}
+	return t.sample(best)
+}
+
+// Duration is the track's length: the decode time just past its last
+// sample.
+func (t *Track) Duration() time.Duration {
+	return t.sampleTime(t.sampleCount)
+}
+
+// sampleTime is sample i's decode time.
+func (t *Track) sampleTime(i int) time.Duration {
+	var c sttsCursor
+	return t.ticksToDuration(c.ticks(t.timeToSample, i))
+}
+
+func (t *Track) ticksToDuration(ticks uint64) time.Duration {
+	if t.Timescale == 0 {
+		return 0
+	}
+	return time.Duration(ticks * uint64(time.Second) / uint64(t.Timescale))
+}
+
+// sttsCursor finds decode times in a time-to-sample table, carrying on
+// from the last sample asked about so increasing samples cost one pass.
+type sttsCursor struct {
+	entry int    // index of the entry holding sample first
+	first int    // first sample of entry
+	start uint64 // decode time of first, in ticks
+}
+
+// ticks returns sample i's decode time in ticks.
+func (c *sttsCursor) ticks(stts []sttsEntry, i int) uint64 {
+	if i < c.first {
+		*c = sttsCursor{}
+	}
+	for c.entry < len(stts) && i >= c.first+int(stts[c.entry].count) {
+		e := stts[c.entry]
+		c.start += uint64(e.count) * uint64(e.delta)
+		c.first += int(e.count)
+		c.entry++
+	}
+	if c.entry == len(stts) {
+		return c.start
+	}
+	return c.start + uint64(i-c.first)*uint64(stts[c.entry].delta)
+}
+
+func (t *Track) size(i int) uint32 {
+	if t.sampleSize != 0 {
+		return t.sampleSize
+	}
+	return t.sampleSizes[i]
+}
+
+// sample locates sample i using the sample-to-chunk and chunk offset
+// tables.
+func (t *Track) sample(i int) (Sample, error) {
+	first := 0 // index of the first sample in the current chunk run
+	for k, e := range t.sampleToChunk {
+		lastChunk := uint32(len(t.chunkOffsets))
+		if k+1 < len(t.sampleToChunk) {
+			lastChunk = t.sampleToChunk[k+1].firstChunk - 1
+		}
+		if e.firstChunk == 0 || lastChunk < e.firstChunk-1 || e.samplesPerChunk == 0 {
+			return Sample{}, errors.New(`mp4: bad sample-to-chunk table`)
+		}
+		runSamples := int(lastChunk-e.firstChunk+1) * int(e.samplesPerChunk)
+		if i >= first+runSamples {
+			first += runSamples
+			continue
+		}
+
+		chunk := int(e.firstChunk-1) + (i-first)/int(e.samplesPerChunk)
+		if chunk >= len(t.chunkOffsets) {
+			break
+		}
+		offset := t.chunkOffsets[chunk]
+		for j := i - (i-first)%int(e.samplesPerChunk); j < i; j++ {
+			offset += uint64(t.size(j))
+		}
+		return Sample{Index: i, Time: t.sampleTime(i), Offset: int64(offset), Size: t.size(i)}, nil
+	}
+	return Sample{}, fmt.Errorf(`mp4: sample %d is not in any chunk`, i)
+}
+
+// ReadSample reads s's bytes from r.
+func (t *Track) ReadSample(r io.ReaderAt, s Sample) ([]byte, error) {
+	if s.Size > maxTableSize {
+		return nil, fmt.Errorf(`mp4: sample %d is %d bytes`, s.Index, s.Size)
+	}
+	data := make([]byte, s.Size)
+	if _, err := r.ReadAt(data, s.Offset); err != nil {
+		return nil, fmt.Errorf(`mp4: read sample %d: %w`, s.Index, err)
+	}
+	return data, nil
+}
+
+// AnnexB converts an avc1/avc3 sample from length-prefixed NAL units to an
+// Annex B byte stream, preceded by the SPS and PPS from Config, which most
+// decoders accept on its own.
+func (t *Track) AnnexB(sample []byte) ([]byte, error) {
+	if t.Codec != `avc1` && t.Codec != `avc3` {
+		return nil, fmt.Errorf(`mp4: can't convert %s to Annex B`, t.Codec)
+	}
+	errShort := errors.New(`mp4: short avcC`)
+	c := t.Config
+	if len(c) < 6 {
+		return nil, errShort
+	}
+	lengthSize := int(c[4]&3) + 1
+	startCode := []byte{0, 0, 0, 1}
+
+	// The SPS count is in the low bits of c[5]; the PPS count follows the
+	// SPSs.
+	var out []byte
+	rest := c[6:]
+	parameterSets := func(count int) error {
+		for range count {
+			if len(rest) < 2 {
+				return errShort
+			}
+			n := int(binary.BigEndian.Uint16(rest))
+			if len(rest) < 2+n {
+				return errShort
+			}
+			out = append(append(out, startCode...), rest[2:2+n]...)
+			rest = rest[2+n:]
+		}
+		return nil
+	}
+	if err := parameterSets(int(c[5] & 0x1f)); err != nil {
+		return nil, err
+	}
+	if len(rest) < 1 {
+		return nil, errShort
+	}
+	ppsCount := int(rest[0])
+	rest = rest[1:]
+	if err := parameterSets(ppsCount); err != nil {
+		return nil, err
+	}
+
+	for len(sample) > 0 {
+		if len(sample) < lengthSize {
+			return nil, errors.New(`mp4: truncated NAL unit length`)
+		}
+		var n int
+		for _, b := range sample[:lengthSize] {
+			n = n<<8 | int(b)
+		}
+		sample = sample[lengthSize:]
+		if n > len(sample) {
+			return nil, errors.New(`mp4: truncated NAL unit`)
+		}
+		out = append(append(out, startCode...), sample[:n]...)
+		sample = sample[n:]
+	}
+	return out, nil
+}
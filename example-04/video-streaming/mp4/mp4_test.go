@@ -0,0 +1,95 @@
+package mp4
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSampleVideo(t *testing.T) {
+	data, err := os.ReadFile(`../videos/SampleVideo_1280x720_1mb.mp4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+
+	track, err := ReadVideoTrack(r, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Codec != `avc1` || track.Width != 1280 || track.Height != 720 {
+		t.Errorf(`track = %s %dx%d, want avc1 1280x720`, track.Codec, track.Width, track.Height)
+	}
+	if d := track.Duration(); d < 5*time.Second || d > 6*time.Second {
+		t.Errorf(`Duration = %s, want about 5s`, d)
+	}
+
+	// The sample video has a single keyframe, at the start.
+	for _, at := range []time.Duration{0, 3 * time.Second, time.Hour} {
+		s, err := track.KeyframeNear(at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Index != 0 || s.Time != 0 {
+			t.Errorf(`KeyframeNear(%s) = %+v, want the first sample`, at, s)
+		}
+	}
+
+	s, _ := track.KeyframeNear(0)
+	sample, err := track.ReadSample(r, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := track.AnnexB(sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SPS, PPS, then the frame, which must contain an IDR slice.
+	var types []byte
+	for _, nal := range bytes.Split(stream, []byte{0, 0, 0, 1})[1:] {
+		types = append(types, nal[0]&0x1f)
+	}
+	if len(types) < 3 || types[0] != 7 || types[1] != 8 || !bytes.Contains(types[2:], []byte{5}) {
+		t.Errorf(`NAL unit types = %v, want SPS, PPS and an IDR slice`, types)
+	}
+}
+
+func TestKeyframeNear(t *testing.T) {
+	// Samples at 0, 100, 200, 300, 550, 800, 850, 900 and 950ms; sync
+	// samples at 0, 300, 800 and 950ms.
+	track := &Track{
+		Timescale:     1000,
+		timeToSample:  []sttsEntry{{3, 100}, {2, 250}, {4, 50}},
+		sampleCount:   9,
+		sampleSize:    10,
+		sampleToChunk: []stscEntry{{1, 9}},
+		chunkOffsets:  []uint64{0},
+	}
+	for _, sync := range [][]uint32{{1, 4, 6, 9}, {9, 6, 1, 4}} {
+		track.syncSamples = sync
+		for at, want := range map[time.Duration]int{0: 0, 400 * time.Millisecond: 3, 700 * time.Millisecond: 5, time.Hour: 8} {
+			s, err := track.KeyframeNear(at)
+			if err != nil || s.Index != want || s.Time != track.sampleTime(want) {
+				t.Errorf(`sync %v: KeyframeNear(%s) = %+v, %v; want sample %d`, sync, at, s, err, want)
+			}
+		}
+	}
+	if d := track.Duration(); d != time.Second {
+		t.Errorf(`Duration = %s, want 1s`, d)
+	}
+}
+
+func TestRejectsGarbage(t *testing.T) {
+	for name, data := range map[string][]byte{
+		`empty`:     nil,
+		`no moov`:   []byte("\x00\x00\x00\x08free"),
+		`bad size`:  []byte("\x00\x00\x00\x04moov"),
+		`overflows`: []byte("\x00\x00\x10\x00moov"),
+	} {
+		if _, err := ReadVideoTrack(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf(`%s: no error`, name)
+		}
+	}
+}
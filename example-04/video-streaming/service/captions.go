@@ -0,0 +1,135 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/captions"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// maxCaptionBytes caps uploads to PUT /videos/{id}/captions/{lang}; an
+// hour of dense subtitles is well under this.
+const maxCaptionBytes = 1 << 20
+
+// captionTrack is one entry in GET /videos/{id}/captions.
+type captionTrack struct {
+	Lang  string `json:"lang"`
+	Label string `json:"label"`
+	URL   string `json:"url"`
+}
+
+// captionTracks lists the caption tracks stored for video, labelled from
+// the catalog.
+func (s *Service) captionTracks(video string) ([]captionTrack, error) {
+	if s.opts.Captions == nil {
+		return nil, nil
+	}
+	langs, err := s.opts.Captions.Languages(video)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]captionTrack, 0, len(langs))
+	for _, lang := range langs {
+		label := s.opts.Catalog[video].Captions[lang]
+		if label == `` {
+			label = display.Self.Name(language.Make(lang))
+		}
+		if label == `` {
+			label = lang
+		}
+		tracks = append(tracks, captionTrack{
+			Lang:  lang,
+			Label: label,
+			URL:   videoPath(video, `captions`, lang),
+		})
+	}
+	return tracks, nil
+}
+
+func (s *Service) handleListCaptions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	if !s.checkVideo(w, r, id) {
+		return
+	}
+	tracks, err := s.captionTracks(id)
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `list captions`, `video`, id, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(contentType, `application/json`)
+	json.NewEncoder(w).Encode(tracks)
+}
+
+func (s *Service) handleGetCaptions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	lang, err := captions.ParseLang(r.PathValue(`lang`))
+	if !validName(id) || err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	vtt, err := s.opts.Captions.Get(id, lang)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `read captions`, `video`, id, `lang`, lang, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(contentType, `text/vtt; charset=utf-8`)
+	w.Write(vtt)
+}
+
+// handlePutCaptions stores a WebVTT or SRT file as the video's captions in
+// the language named by the path, replacing any already there.
+func (s *Service) handlePutCaptions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	lang, err := captions.ParseLang(r.PathValue(`lang`))
+	if err != nil {
+		http.Error(w, `not a BCP 47 language tag`, http.StatusBadRequest)
+		return
+	}
+	if !s.checkVideo(w, r, id) {
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCaptionBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	err = s.opts.Captions.Put(id, lang, data)
+	if errors.Is(err, captions.ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `store captions`, `video`, id, `lang`, lang, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	s.log.InfoContext(r.Context(), `stored captions`, `video`, id, `lang`, lang, `user`, auth.Subject(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// videoPath is the path of a resource under /videos/{id}.
+func videoPath(video string, elem ...string) string {
+	return `/videos/` + url.PathEscape(video) + `/` + strings.Join(elem, `/`)
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/captions"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCaptions(t *testing.T) {
+	captionStore, err := captions.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: `test-secret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.Sign(`test-secret`, auth.Identity{Subject: `ops`, Admin: true}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, Options{
+		Store:    store.Dir(`../videos`),
+		Catalog:  Catalog{sampleVideo: {Bitrate: 1590000, Captions: map[string]string{`en`: `English (CC)`}}},
+		Captions: captionStore,
+		Verifier: verifier,
+	})
+	h := svc.Handler()
+	base := `/videos/` + sampleVideo
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(`Authorization`, `Bearer `+token)
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nHello\n"
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{base + `/captions/en`, srt, http.StatusNoContent},
+		{base + `/captions/fr-ca`, "WEBVTT\n\n00:01.000 --> 00:02.000\nBonjour\n", http.StatusNoContent},
+		{base + `/captions/de`, `not captions`, http.StatusBadRequest},
+		{base + `/captions/english!`, srt, http.StatusBadRequest},
+		{`/videos/missing.mp4/captions/en`, srt, http.StatusNotFound},
+	} {
+		if rec := serve(http.MethodPut, tc.path, tc.body); rec.Code != tc.want {
+			t.Errorf(`PUT %s: status %d, want %d: %s`, tc.path, rec.Code, tc.want, rec.Body)
+		}
+	}
+
+	rec := serve(http.MethodGet, base+`/captions/en`, ``)
+	if rec.Code != http.StatusOK || rec.Header().Get(contentType) != `text/vtt; charset=utf-8` ||
+		rec.Body.String() != "WEBVTT\n\n1\n00:00:01.000 --> 00:00:02.000\nHello\n" {
+		t.Errorf(`GET captions/en: %d %s %q`, rec.Code, rec.Header().Get(contentType), rec.Body)
+	}
+
+	var tracks []captionTrack
+	rec = serve(http.MethodGet, base+`/captions`, ``)
+	if err := json.Unmarshal(rec.Body.Bytes(), &tracks); err != nil {
+		t.Fatalf(`GET captions: %d %s`, rec.Code, rec.Body)
+	}
+	want := []captionTrack{
+		{Lang: `en`, Label: `English (CC)`, URL: base + `/captions/en`},
+		{Lang: `fr-CA`, Label: `français canadien`, URL: base + `/captions/fr-CA`},
+	}
+	if len(tracks) != len(want) || tracks[0] != want[0] || tracks[1] != want[1] {
+		t.Errorf(`GET captions = %+v, want %+v`, tracks, want)
+	}
+
+	rec = serve(http.MethodGet, base+`/playlist.m3u8`, ``)
+	for _, line := range []string{
+		`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="English (CC)",LANGUAGE="en",AUTOSELECT=YES,DEFAULT=NO,URI="` + base + `/captions/en/playlist.m3u8"`,
+		`#EXT-X-STREAM-INF:BANDWIDTH=1590000,RESOLUTION=1280x720,SUBTITLES="subs"`,
+		base + `/stream.m3u8`,
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("playlist.m3u8 lacks %s:\n%s", line, rec.Body)
+		}
+	}
+
+	rec = serve(http.MethodGet, base+`/captions/fr-CA/playlist.m3u8`, ``)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "\n"+base+"/captions/fr-CA\n#EXT-X-ENDLIST\n") {
+		t.Errorf("captions playlist: %d\n%s", rec.Code, rec.Body)
+	}
+	if rec := serve(http.MethodGet, base+`/captions/de/playlist.m3u8`, ``); rec.Code != http.StatusNotFound {
+		t.Errorf(`playlist for missing captions: status %d, want 404`, rec.Code)
+	}
+}
+
+// acks counts acknowledgements.
+type acks struct{ acked, nacked int }
+
+func (a *acks) Ack(uint64, bool) error        { a.acked++; return nil }
+func (a *acks) Nack(uint64, bool, bool) error { a.nacked++; return nil }
+func (a *acks) Reject(uint64, bool) error     { a.nacked++; return nil }
+
+func TestCaptionsAdminOnlyAndDeleted(t *testing.T) {
+	captionStore, err := captions.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: `test-secret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, Options{
+		Store:    store.Dir(`../videos`),
+		Verifier: verifier,
+		Captions: captionStore,
+	})
+	h := svc.Handler()
+	path := `/videos/` + sampleVideo + `/captions/en`
+
+	put := func(id auth.Identity) int {
+		req := httptest.NewRequest(http.MethodPut, path, strings.NewReader("WEBVTT\n\n00:01.000 --> 00:02.000\nHello\n"))
+		if id.Subject != `` {
+			token, err := auth.Sign(`test-secret`, id, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set(`Authorization`, `Bearer `+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := put(auth.Identity{}); code != http.StatusUnauthorized {
+		t.Errorf(`PUT without a token: %d, want 401`, code)
+	}
+	if code := put(auth.Identity{Subject: `alice`}); code != http.StatusForbidden {
+		t.Errorf(`PUT as a viewer: %d, want 403`, code)
+	}
+	if code := put(auth.Identity{Subject: `ops`, Admin: true}); code != http.StatusNoContent {
+		t.Fatalf(`PUT as an admin: %d, want 204`, code)
+	}
+
+	changed := func(exchange, key string) {
+		body, err := bson.Marshal(VideoChanged{VideoID: sampleVideo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ack := &acks{}
+		svc.handleVideoChanged(context.Background(), nil, amqp.Delivery{Acknowledger: ack, Exchange: exchange, RoutingKey: key, Body: body})
+		if ack.acked != 1 {
+			t.Errorf(`%s %s: acked %d times, want 1`, exchange, key, ack.acked)
+		}
+	}
+	langs := func() []string {
+		langs, err := captionStore.Languages(sampleVideo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return langs
+	}
+
+	// A re-upload keeps the captions; a deletion, announced either way,
+	// takes them.
+	changed(`events`, messaging.VideoUploaded)
+	if got := langs(); len(got) != 1 {
+		t.Fatalf(`after video.uploaded: captions in %v, want en`, got)
+	}
+	changed(`events`, messaging.VideoDeleted)
+	if got := langs(); len(got) != 0 {
+		t.Errorf(`after video.deleted: captions in %v, want none`, got)
+	}
+	if code := put(auth.Identity{Subject: `ops`, Admin: true}); code != http.StatusNoContent {
+		t.Fatalf(`PUT as an admin: %d, want 204`, code)
+	}
+	changed(DeletedExchange, ``)
+	if got := langs(); len(got) != 0 {
+		t.Errorf(`after a legacy Deleted message: captions in %v, want none`, got)
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/captions"
+)
+
+// VideoInfo is what the catalog knows about one video.
+type VideoInfo struct {
+	// Bitrate is the video's average bitrate in bits per second.
+	Bitrate int `json:"bitrate"`
+	// Captions labels the video's caption tracks by BCP 47 language tag,
+	// e.g. {"en": "English"}. Tracks without a label are named in their
+	// own language.
+	Captions map[string]string `json:"captions,omitempty"`
+}
+
+// Catalog maps video names, as passed in ?v=, to their metadata.
+type Catalog map[string]VideoInfo
+
+// LoadCatalog reads a catalog from a JSON object keyed by video name. A
+// missing file gives an empty catalog; videos without an entry are simply
+// not paced by bitrate.
+func LoadCatalog(path string) (Catalog, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Catalog{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf(`%s: %w`, path, err)
+	}
+	for name, info := range c {
+		if info.Bitrate < 0 {
+			return nil, fmt.Errorf(`%s: %s: negative bitrate`, path, name)
+		}
+		labels := make(map[string]string, len(info.Captions))
+		for lang, label := range info.Captions {
+			canonical, err := captions.ParseLang(lang)
+			if err != nil {
+				return nil, fmt.Errorf(`%s: %s: %w`, path, name, err)
+			}
+			labels[canonical] = label
+		}
+		info.Captions = labels
+		c[name] = info
+	}
+	return c, nil
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/captions"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/thumbnail"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// brokenStore fails to open any video.
+type brokenStore struct{}
+
+func (brokenStore) Open(context.Context, string) (store.Video, error) {
+	return nil, errors.New(`store unreachable`)
+}
+
+// TestContract sends the routes requests good and bad, checking every
+// response against openapi.json and that every operation in it is tried.
+func TestContract(t *testing.T) {
+	const secret = `test-secret`
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer := func(id auth.Identity) string {
+		token, err := auth.Sign(secret, id, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return `Bearer ` + token
+	}
+	alice, admin := bearer(auth.Identity{Subject: `alice`}), bearer(auth.Identity{Subject: `ops`, Admin: true})
+
+	dir := t.TempDir()
+	sample, err := os.ReadFile(`../videos/` + sampleVideo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, sampleVideo), sample, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, `junk.mp4`), []byte(`not a movie`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := store.NewCache(store.Dir(dir), t.TempDir(), 64<<10, 16<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnails, err := thumbnail.New(cache, thumbnail.Raw{}, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	captionStore, err := captions.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := NewURLSigner([]SigningKey{{ID: `k1`, Secret: []byte(`link-secret`)}})
+	published := publisherFunc(func(context.Context, string, string, bool, bool, amqp.Publishing) error { return nil })
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	opts := Options{
+		Store:          cache,
+		Library:        store.Dir(dir),
+		MaxUploadBytes: 16,
+		Verifier:       verifier,
+		Signer:         signer,
+		LinkTTL:        time.Hour,
+		MaxLinkTTL:     24 * time.Hour,
+		Thumbnails:     thumbnails,
+		Captions:       captionStore,
+	}
+	h := New(log, published, opts).Handler()
+
+	// broken can't reach its videos or publish events.
+	brokenOpts := opts
+	brokenOpts.Store = brokenStore{}
+	brokenOpts.Library = store.Dir(filepath.Join(dir, `missing`))
+	if brokenOpts.Thumbnails, err = thumbnail.New(brokenStore{}, thumbnail.Raw{}, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	unpublished := publisherFunc(func(context.Context, string, string, bool, bool, amqp.Publishing) error {
+		return errors.New(`channel closed`)
+	})
+	broken := New(log, unpublished, brokenOpts).Handler()
+
+	// limited has used up its one request.
+	limitedOpts := opts
+	limitedOpts.Limits = Limits{RequestsPerSec: 0.001, Burst: 1}
+	limited := New(log, published, limitedOpts).Handler()
+	limited.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(`GET`, `/openapi.json`, nil))
+
+	link := `/video?` + signer.Sign(Link{Video: sampleVideo, Subject: `alice`, Expires: time.Now().Add(time.Hour)}).Encode()
+	base := `/videos/` + sampleVideo
+	srt := "1\n00:00:01,000 --> 00:00:02,000\nHello\n"
+	large := strings.Repeat(`x`, 2<<20)
+
+	tried := map[string]bool{}
+	for _, c := range []struct {
+		h                                                       http.Handler
+		method, target, authorization, rangeHeader, contentType string
+		body                                                    string
+		want                                                    int
+	}{
+		{h, `GET`, `/video`, alice, ``, ``, ``, 200},
+		{h, `GET`, `/video?v=` + sampleVideo, alice, `bytes=0-99`, ``, ``, 206},
+		{h, `GET`, `/video`, alice, `bytes=99999999-`, ``, ``, 416},
+		{h, `GET`, link, ``, ``, ``, ``, 200},
+		{h, `GET`, link + `0`, ``, ``, ``, ``, 403},
+		{h, `GET`, `/video?exp=soon`, alice, ``, ``, ``, 400},
+		{h, `GET`, `/video?v=missing.mp4`, alice, ``, ``, ``, 404},
+		{h, `GET`, `/video`, ``, ``, ``, ``, 401},
+		{broken, `GET`, `/video`, alice, ``, ``, ``, 502},
+		{limited, `GET`, `/video`, alice, ``, ``, ``, 429},
+
+		{h, `POST`, `/video/links`, alice, ``, `application/json`, `{"video":"` + sampleVideo + `","ttl":"15m","bindIp":true}`, 200},
+		{h, `POST`, `/video/links`, alice, ``, `application/json`, `{"ttl":"forever"}`, 400},
+		{h, `POST`, `/video/links`, alice, ``, `application/json`, `{"bindIp":"yes"}`, 400},
+		{h, `POST`, `/video/links`, alice, ``, `application/json`, `{"video":"missing.mp4"}`, 404},
+		{h, `POST`, `/video/links`, alice, ``, `application/json`, `"` + large + `"`, 413},
+		{h, `POST`, `/video/links`, alice, ``, `text/plain`, sampleVideo, 415},
+		{h, `POST`, `/video/links`, ``, ``, `application/json`, `{}`, 401},
+		{broken, `POST`, `/video/links`, alice, ``, `application/json`, `{}`, 502},
+
+		{h, `POST`, `/playback/heartbeat`, alice, ``, `application/json`, `{"video":"` + sampleVideo + `","position":12.5,"duration":60}`, 202},
+		{h, `POST`, `/playback/heartbeat`, alice, ``, `application/json`, `{"position":12.5}`, 400},
+		{h, `POST`, `/playback/heartbeat`, alice, ``, `application/json`, `{"video":"a.mp4","position":-1}`, 400},
+		{h, `POST`, `/playback/heartbeat`, alice, ``, `application/json`, `{"video":"../a.mp4"}`, 400},
+		{h, `POST`, `/playback/heartbeat`, alice, ``, `application/json`, ``, 400},
+		{h, `POST`, `/playback/heartbeat`, alice, ``, `application/x-www-form-urlencoded`, `video=a.mp4`, 415},
+		{h, `POST`, `/playback/heartbeat`, ``, ``, `application/json`, `{"video":"a.mp4"}`, 401},
+		{broken, `POST`, `/playback/heartbeat`, alice, ``, `application/json`, `{"video":"a.mp4"}`, 503},
+
+		{h, `GET`, `/thumbnail/` + sampleVideo + `?t=1.5`, alice, ``, ``, ``, 200},
+		{h, `GET`, `/thumbnail/` + sampleVideo + `?t=-1`, alice, ``, ``, ``, 400},
+		{h, `GET`, `/thumbnail/missing.mp4`, alice, ``, ``, ``, 404},
+		{h, `GET`, `/thumbnail/junk.mp4`, alice, ``, ``, ``, 422},
+		{h, `GET`, `/thumbnail/` + sampleVideo, ``, ``, ``, ``, 401},
+		{broken, `GET`, `/thumbnail/` + sampleVideo, alice, ``, ``, ``, 500},
+
+		{h, `PUT`, `/videos/new.mp4`, admin, ``, ``, `fake video`, 201},
+		{h, `PUT`, `/videos/new.mp4`, admin, ``, `video/mp4`, `fake video`, 201},
+		{h, `PUT`, `/videos/new.mp4`, admin, ``, ``, ``, 400},
+		{h, `PUT`, `/videos/.hidden`, admin, ``, ``, `fake video`, 400},
+		{h, `PUT`, `/videos/big.mp4`, admin, ``, ``, strings.Repeat(`x`, 17), 413},
+		{h, `PUT`, `/videos/new.mp4`, alice, ``, ``, `fake video`, 403},
+		{h, `PUT`, `/videos/new.mp4`, ``, ``, ``, `fake video`, 401},
+		{broken, `PUT`, `/videos/new.mp4`, admin, ``, ``, `fake video`, 500},
+		{h, `GET`, `/videos`, alice, ``, ``, ``, 200},
+		{h, `GET`, `/videos`, ``, ``, ``, ``, 401},
+		{broken, `GET`, `/videos`, alice, ``, ``, ``, 500},
+		{limited, `GET`, `/videos`, alice, ``, ``, ``, 429},
+		{h, `DELETE`, `/videos/new.mp4`, admin, ``, ``, ``, 204},
+		{h, `DELETE`, `/videos/new.mp4`, admin, ``, ``, ``, 404},
+		{h, `DELETE`, `/videos/` + sampleVideo, alice, ``, ``, ``, 403},
+		{h, `DELETE`, `/videos/` + sampleVideo, ``, ``, ``, ``, 401},
+
+		{h, `PUT`, base + `/captions/en`, admin, ``, ``, srt, 204},
+		{h, `PUT`, base + `/captions/fr`, admin, ``, `text/vtt`, "WEBVTT\n\n00:01.000 --> 00:02.000\nBonjour\n", 204},
+		{h, `PUT`, base + `/captions/de`, admin, ``, ``, `not captions`, 400},
+		{h, `PUT`, base + `/captions/de`, admin, ``, ``, ``, 400},
+		{h, `PUT`, base + `/captions/english!`, admin, ``, ``, srt, 400},
+		{h, `PUT`, `/videos/missing.mp4/captions/en`, admin, ``, ``, srt, 404},
+		{h, `PUT`, base + `/captions/de`, admin, ``, ``, large, 413},
+		{h, `PUT`, base + `/captions/de`, alice, ``, ``, srt, 403},
+		{h, `PUT`, base + `/captions/de`, ``, ``, ``, srt, 401},
+		{broken, `PUT`, base + `/captions/de`, admin, ``, ``, srt, 502},
+		{h, `GET`, base + `/captions`, alice, ``, ``, ``, 200},
+		{h, `GET`, `/videos/missing.mp4/captions`, alice, ``, ``, ``, 404},
+		{h, `GET`, base + `/captions`, ``, ``, ``, ``, 401},
+		{broken, `GET`, base + `/captions`, alice, ``, ``, ``, 502},
+		{h, `GET`, base + `/captions/en`, alice, ``, ``, ``, 200},
+		{h, `GET`, base + `/captions/de`, alice, ``, ``, ``, 404},
+		{h, `GET`, base + `/captions/en`, ``, ``, ``, ``, 401},
+		{h, `GET`, base + `/captions/en/playlist.m3u8`, alice, ``, ``, ``, 200},
+		{h, `GET`, base + `/captions/de/playlist.m3u8`, alice, ``, ``, ``, 404},
+		{h, `GET`, `/videos/junk.mp4/captions/en/playlist.m3u8`, alice, ``, ``, ``, 422},
+		{h, `GET`, base + `/captions/en/playlist.m3u8`, ``, ``, ``, ``, 401},
+		{broken, `GET`, base + `/captions/en/playlist.m3u8`, alice, ``, ``, ``, 502},
+
+		{h, `GET`, base + `/playlist.m3u8`, alice, ``, ``, ``, 200},
+		{h, `GET`, `/videos/missing.mp4/playlist.m3u8`, alice, ``, ``, ``, 404},
+		{h, `GET`, `/videos/junk.mp4/playlist.m3u8`, alice, ``, ``, ``, 422},
+		{h, `GET`, base + `/playlist.m3u8`, ``, ``, ``, ``, 401},
+		{broken, `GET`, base + `/playlist.m3u8`, alice, ``, ``, ``, 502},
+		{h, `GET`, base + `/stream.m3u8`, alice, ``, ``, ``, 200},
+		{h, `GET`, `/videos/junk.mp4/stream.m3u8`, alice, ``, ``, ``, 422},
+		{h, `GET`, base + `/stream.m3u8`, ``, ``, ``, ``, 401},
+		{broken, `GET`, base + `/stream.m3u8`, alice, ``, ``, ``, 502},
+
+		{h, `GET`, `/cache/stats`, admin, ``, ``, ``, 200},
+		{h, `GET`, `/cache/stats`, ``, ``, ``, ``, 401},
+		{h, `GET`, `/cache/stats`, alice, ``, ``, ``, 403},
+		{limited, `GET`, `/cache/stats`, admin, ``, ``, ``, 429},
+		{h, `GET`, `/openapi.json`, ``, ``, ``, ``, 200},
+		{limited, `GET`, `/openapi.json`, ``, ``, ``, ``, 429},
+	} {
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		if c.authorization != `` {
+			req.Header.Set(`Authorization`, c.authorization)
+		}
+		if c.rangeHeader != `` {
+			req.Header.Set(`Range`, c.rangeHeader)
+		}
+		if c.contentType != `` {
+			req.Header.Set(`Content-Type`, c.contentType)
+		}
+		w := httptest.NewRecorder()
+		c.h.ServeHTTP(w, req)
+
+		name := c.method + ` ` + c.target
+		if w.Code != c.want {
+			t.Errorf(`%s: %d, want %d (%.200s)`, name, w.Code, c.want, w.Body)
+			continue
+		}
+		if err := spec.CheckResponse(req, w.Result()); err != nil {
+			t.Error(err)
+		}
+		tried[spec.Pattern(req)] = true
+	}
+
+	for _, op := range spec.Operations() {
+		if !tried[op] {
+			t.Errorf(`%s: not tried`, op)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Pre-topic destinations, still published to while LegacyEvents is set.
+const (
+	// ViewedExchange received a message for every video streamed.
+	ViewedExchange = `Viewed`
+	// PlaybackExchange received a message for every playback heartbeat.
+	PlaybackExchange = `PlaybackProgress`
+	// LegacyViewedQueue is the durable queue example-03 sent views to
+	// directly.
+	LegacyViewedQueue = `viewed`
+)
+
+// legacyFanouts maps routing keys to the fanout exchange each event had
+// before the topic exchange.
+var legacyFanouts = map[string]string{
+	messaging.VideoViewed:      ViewedExchange,
+	messaging.PlaybackProgress: PlaybackExchange,
+}
+
+// emitter publishes events to the topic exchange and, while consumers
+// migrate to it, the old way as well.
+type emitter struct {
+	pub          publisher
+	exchange     string
+	legacyFanout bool
+	legacyQueue  bool
+}
+
+// Declare creates the topic exchange and whichever legacy destinations are
+// still published to.
+func (s *Service) Declare(ch messaging.Channel) error {
+	if err := messaging.DeclareTopic(ch, s.events.exchange); err != nil {
+		return err
+	}
+	if s.events.legacyFanout {
+		for _, exchange := range []string{ViewedExchange, PlaybackExchange} {
+			if err := messaging.DeclareFanout(ch, exchange); err != nil {
+				return err
+			}
+		}
+	}
+	if s.events.legacyQueue {
+		// Declared exactly as example-03 does, or RabbitMQ refuses it.
+		if _, err := ch.QueueDeclare(LegacyViewedQueue, true, false, false, false, nil); err != nil {
+			return fmt.Errorf(`ch.QueueDeclare %s: %w`, LegacyViewedQueue, err)
+		}
+	}
+	return nil
+}
+
+// emit sends body, BSON encoded, as the event key. partition goes in the
+// PartitionHeader for consumers that split events by it. Every copy of the
+// event carries the same message ID, so a queue bound to both the topic
+// exchange and a legacy fanout can drop the second.
+func (e *emitter) emit(ctx context.Context, key, requestID, partition string, body any) error {
+	payload, err := bson.Marshal(body)
+	if err != nil {
+		return fmt.Errorf(`bson.Marshal: %w`, err)
+	}
+	msg := message{id: newMessageID(), requestID: requestID, partition: partition, payload: payload}
+
+	err = e.publish(ctx, e.exchange, key, msg)
+	if exchange, ok := legacyFanouts[key]; ok && e.legacyFanout {
+		err = errors.Join(err, e.publish(ctx, exchange, ``, msg))
+	}
+	if key == messaging.VideoViewed && e.legacyQueue {
+		err = errors.Join(err, e.publish(ctx, ``, LegacyViewedQueue, msg))
+	}
+	return err
+}
+
+// message is one event, as published to each of its destinations.
+type message struct {
+	id, requestID, partition string
+	payload                  []byte
+}
+
+func newMessageID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func (e *emitter) publish(ctx context.Context, exchange, key string, msg message) error {
+	destination := exchange
+	if destination == `` {
+		destination = key
+	}
+	ctx, span := tracer.Start(ctx, destination+` publish`,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String(`messaging.system`, `rabbitmq`),
+			attribute.String(`messaging.destination.name`, destination),
+			attribute.String(`messaging.rabbitmq.destination.routing_key`, key),
+		))
+	defer span.End()
+
+	// Carry the trace across the broker in the message headers. Persistent
+	// messages survive a broker restart in durable queues.
+	headers := tracing.InjectAMQP(ctx, amqp.Table{messaging.PartitionHeader: msg.partition})
+	err := e.pub.PublishWithContext(ctx, exchange, key, false, false, amqp.Publishing{
+		ContentType:   `application/bson`,
+		DeliveryMode:  amqp.Persistent,
+		MessageId:     msg.id,
+		CorrelationId: msg.requestID,
+		Headers:       headers,
+		Body:          msg.payload,
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, `channel.Publish`)
+		return fmt.Errorf(`channel.Publish %s: %w`, destination, err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/captions"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/mp4"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+)
+
+// The HLS playlists describe each video as a single variant whose only
+// segment is the whole MP4 on GET /video, plus a subtitles rendition per
+// caption track. That is enough for players to offer the captions; cutting
+// videos into real segments is a job for the transcoder.
+
+const (
+	mpegURL       = `application/vnd.apple.mpegurl`
+	subtitleGroup = `subs`
+)
+
+// errNotPlayable means a video has no MP4 video track we can read.
+var errNotPlayable = errors.New(`not a playable video`)
+
+// videoMedia is what the playlists need to know about a video.
+type videoMedia struct {
+	duration      time.Duration
+	width, height uint16
+	bandwidth     int
+}
+
+// media reads the video's length and size from its MP4 sample tables.
+func (s *Service) media(ctx context.Context, video string) (videoMedia, error) {
+	v, err := s.opts.Store.Open(ctx, video)
+	if err != nil {
+		return videoMedia{}, err
+	}
+	defer v.Close()
+	track, err := mp4.ReadVideoTrack(v, v.Size())
+	if err != nil {
+		return videoMedia{}, fmt.Errorf(`%w: %w`, errNotPlayable, err)
+	}
+
+	m := videoMedia{
+		duration:  track.Duration(),
+		width:     track.Width,
+		height:    track.Height,
+		bandwidth: s.opts.Catalog[video].Bitrate,
+	}
+	if m.bandwidth == 0 && m.duration > 0 {
+		m.bandwidth = int(float64(v.Size()*8) / m.duration.Seconds())
+	}
+	return m, nil
+}
+
+// mediaOrError is media for handlers, answering the request itself when
+// it fails.
+func (s *Service) mediaOrError(w http.ResponseWriter, r *http.Request, video string) (videoMedia, bool) {
+	if !validName(video) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return videoMedia{}, false
+	}
+	m, err := s.media(r.Context(), video)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return videoMedia{}, false
+	case errors.Is(err, errNotPlayable):
+		s.log.WarnContext(r.Context(), `read video`, `video`, video, logging.KeyError, err)
+		http.Error(w, errNotPlayable.Error(), http.StatusUnprocessableEntity)
+		return videoMedia{}, false
+	case err != nil:
+		s.log.ErrorContext(r.Context(), `read video`, `video`, video, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return videoMedia{}, false
+	}
+	return m, true
+}
+
+// handleMasterPlaylist serves GET /videos/{id}/playlist.m3u8, listing the
+// video's caption tracks as subtitles renditions.
+func (s *Service) handleMasterPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	m, ok := s.mediaOrError(w, r, id)
+	if !ok {
+		return
+	}
+	tracks, err := s.captionTracks(id)
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `list captions`, `video`, id, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, t := range tracks {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=%q,NAME=%s,LANGUAGE=%q,AUTOSELECT=YES,DEFAULT=NO,URI=%q\n",
+			subtitleGroup, quoted(t.Label), t.Lang, t.URL+`/playlist.m3u8`)
+	}
+	fmt.Fprintf(&b, `#EXT-X-STREAM-INF:BANDWIDTH=%d`, m.bandwidth)
+	if m.width > 0 && m.height > 0 {
+		fmt.Fprintf(&b, `,RESOLUTION=%dx%d`, m.width, m.height)
+	}
+	if len(tracks) > 0 {
+		fmt.Fprintf(&b, `,SUBTITLES=%q`, subtitleGroup)
+	}
+	b.WriteString("\n" + videoPath(id, `stream.m3u8`) + "\n")
+
+	writePlaylist(w, b.String())
+}
+
+// handleStreamPlaylist serves GET /videos/{id}/stream.m3u8, the video's
+// one-segment media playlist.
+func (s *Service) handleStreamPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	m, ok := s.mediaOrError(w, r, id)
+	if !ok {
+		return
+	}
+	writePlaylist(w, mediaPlaylist(m.duration, `/video?v=`+url.QueryEscape(id)))
+}
+
+// handleCaptionsPlaylist serves GET /videos/{id}/captions/{lang}/playlist.m3u8,
+// a media playlist whose one segment is the whole WebVTT file.
+func (s *Service) handleCaptionsPlaylist(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	m, ok := s.mediaOrError(w, r, id)
+	if !ok {
+		return
+	}
+	lang, err := captions.ParseLang(r.PathValue(`lang`))
+	if err == nil {
+		_, err = s.opts.Captions.Get(id, lang)
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	writePlaylist(w, mediaPlaylist(m.duration, videoPath(id, `captions`, lang)))
+}
+
+// mediaPlaylist is a VOD playlist with one segment lasting d.
+func mediaPlaylist(d time.Duration, uri string) string {
+	return fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-TARGETDURATION:%d\n#EXTINF:%.3f,\n%s\n#EXT-X-ENDLIST\n",
+		int(math.Ceil(d.Seconds())), d.Seconds(), uri)
+}
+
+func writePlaylist(w http.ResponseWriter, playlist string) {
+	w.Header().Set(contentType, mpegURL)
+	w.Write([]byte(playlist))
+}
+
+// quoted makes s an HLS quoted-string, which may not contain double quotes
+// or line breaks.
+func quoted(s string) string {
+	return `"` + strings.NewReplacer(`"`, `'`, "\n", ` `, "\r", ` `).Replace(s) + `"`
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Fanout exchanges that announced changes to the videos in the store
+// before the topic exchange.
+const (
+	UploadedExchange = `Uploaded`
+	DeletedExchange  = `Deleted`
+)
+
+// errDeliveriesClosed is returned when RabbitMQ closes the delivery
+// channel, which happens when the connection or channel dies.
+var errDeliveriesClosed = errors.New(`delivery channel closed`)
+
+// VideoChanged is the body of video.uploaded and video.deleted events.
+type VideoChanged struct {
+	// VideoID is the video's name, as passed to GET /video?v=.
+	VideoID string `json:"videoId" bson:"videoId"`
+}
+
+// SubscribeInvalidations binds a queue of this replica's own to the topic
+// exchange with b's patterns, usually video.uploaded and video.deleted.
+// Every replica has its own cache so each needs every message; the queue
+// is exclusive and goes away with the connection.
+//
+// With LegacyEvents set the queue is bound to the Uploaded and Deleted
+// fanouts as well, for uploaders that still publish there. Dropping a
+// video from the cache twice does no harm.
+func (s *Service) SubscribeInvalidations(ch messaging.Channel, b messaging.Bindings) (<-chan amqp.Delivery, error) {
+	queue, err := ch.QueueDeclare(
+		``,    // name; let the broker pick one
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return nil, fmt.Errorf(`ch.QueueDeclare: %w`, err)
+	}
+
+	if err := messaging.Bind(ch, queue.Name, b, ``); err != nil {
+		return nil, err
+	}
+	if s.opts.LegacyEvents {
+		for _, exchange := range []string{UploadedExchange, DeletedExchange} {
+			if err := messaging.DeclareFanout(ch, exchange); err != nil {
+				return nil, err
+			}
+			if err := ch.QueueBind(queue.Name, ``, exchange, false, nil); err != nil {
+				return nil, fmt.Errorf(`ch.QueueBind %s: %w`, exchange, err)
+			}
+		}
+	}
+
+	msgs, err := ch.Consume(queue.Name, ``, false, true, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf(`ch.Consume: %w`, err)
+	}
+	return msgs, nil
+}
+
+// ConsumeInvalidations drops changed videos from the store's cache and
+// the thumbnail cache, and deleted videos' captions, until ctx is
+// cancelled or the broker closes msgs.
+func (s *Service) ConsumeInvalidations(ctx context.Context, msgs <-chan amqp.Delivery) error {
+	var caches []store.Invalidator
+	if cache, ok := s.opts.Store.(store.Invalidator); ok {
+		caches = append(caches, cache)
+	}
+	if s.opts.Thumbnails != nil {
+		caches = append(caches, s.opts.Thumbnails)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf(`invalidations: %w`, errDeliveriesClosed)
+			}
+			s.handleVideoChanged(ctx, caches, msg)
+		}
+	}
+}
+
+func (s *Service) handleVideoChanged(ctx context.Context, caches []store.Invalidator, msg amqp.Delivery) {
+	ctx, span := tracer.Start(tracing.ExtractAMQP(ctx, msg.Headers), msg.Exchange+` process`,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String(`messaging.system`, `rabbitmq`),
+			attribute.String(`messaging.source.name`, msg.Exchange),
+			attribute.String(`messaging.rabbitmq.destination.routing_key`, msg.RoutingKey),
+		))
+	defer span.End()
+
+	var body VideoChanged
+	if err := bson.Unmarshal(msg.Body, &body); err != nil || body.VideoID == `` {
+		if err == nil {
+			err = errors.New(`missing videoId`)
+		}
+		span.RecordError(err)
+		s.log.ErrorContext(ctx, `discarding malformed message`, `exchange`, msg.Exchange, logging.KeyError, err)
+		msg.Nack(false, false)
+		return
+	}
+
+	for _, cache := range caches {
+		cache.Invalidate(body.VideoID)
+	}
+	s.log.InfoContext(ctx, `invalidated cached video`, `video`, body.VideoID, `exchange`, msg.Exchange, `routingKey`, msg.RoutingKey)
+
+	// A deleted video's captions go with it, so a new upload under the
+	// same name doesn't inherit them.
+	deleted := msg.RoutingKey == messaging.VideoDeleted || msg.Exchange == DeletedExchange
+	if deleted && s.opts.Captions != nil {
+		if err := s.opts.Captions.Delete(body.VideoID); err != nil {
+			span.RecordError(err)
+			s.log.ErrorContext(ctx, `delete captions`, `video`, body.VideoID, logging.KeyError, err)
+		} else {
+			s.log.InfoContext(ctx, `deleted captions`, `video`, body.VideoID)
+		}
+	}
+	msg.Ack(false)
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+)
+
+// handleListVideos serves GET /videos, the videos in the library.
+func (s *Service) handleListVideos(w http.ResponseWriter, r *http.Request) {
+	videos, err := s.opts.Library.List(r.Context())
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `/videos.Library.List`, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if videos == nil {
+		videos = []store.Info{}
+	}
+	w.Header().Set(contentType, `application/json`)
+	json.NewEncoder(w).Encode(videos)
+}
+
+// handleUploadVideo serves PUT /videos/{id}: the body is the video, stored
+// under id and announced as video.uploaded so replicas drop cached copies
+// of any video it replaces.
+func (s *Service) handleUploadVideo(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	if !validName(id) {
+		http.Error(w, `invalid video name`, http.StatusBadRequest)
+		return
+	}
+	body := r.Body
+	if s.opts.MaxUploadBytes > 0 {
+		body = http.MaxBytesReader(w, body, s.opts.MaxUploadBytes)
+	}
+
+	info, err := s.opts.Library.Put(r.Context(), id, body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `/videos.Library.Put`, `video`, id, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	s.log.InfoContext(r.Context(), `uploaded video`, `video`, id, `bytes`, info.Size)
+	s.announceChange(r, messaging.VideoUploaded, id)
+
+	w.Header().Set(contentType, `application/json`)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(info)
+}
+
+// handleDeleteVideo serves DELETE /videos/{id} and announces it as
+// video.deleted.
+func (s *Service) handleDeleteVideo(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	if !validName(id) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	err := s.opts.Library.Delete(r.Context(), id)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `/videos.Library.Delete`, `video`, id, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	s.log.InfoContext(r.Context(), `deleted video`, `video`, id)
+	s.announceChange(r, messaging.VideoDeleted, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// announceChange publishes a VideoChanged event. A failure is only logged:
+// the change has been made, and caches expire eventually anyway.
+func (s *Service) announceChange(r *http.Request, key, id string) {
+	ctx := r.Context()
+	requestID := logging.RequestID(ctx)
+	if err := s.events.emit(ctx, key, requestID, messaging.PartitionKey(``, id), VideoChanged{VideoID: id}); err != nil {
+		s.log.ErrorContext(ctx, `Unable to publish to RabbitMQ channel`, `event`, key, logging.KeyError, err)
+	}
+}
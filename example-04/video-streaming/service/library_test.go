@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLibrary(t *testing.T) {
+	dir := t.TempDir()
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: `s3cret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	pub := publisherFunc(func(_ context.Context, _, key string, _, _ bool, msg amqp.Publishing) error {
+		var body VideoChanged
+		if err := bson.Unmarshal(msg.Body, &body); err != nil {
+			t.Error(err)
+		}
+		events = append(events, key+` `+body.VideoID)
+		return nil
+	})
+	svc := New(slog.New(slog.NewTextHandler(io.Discard, nil)), pub, Options{
+		Store:          store.Dir(dir),
+		Library:        store.Dir(dir),
+		MaxUploadBytes: 16,
+		Verifier:       verifier,
+	})
+	h := svc.Handler()
+
+	token := func(admin bool) string {
+		s, err := auth.Sign(`s3cret`, auth.Identity{Subject: `ops`, Admin: admin}, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	serve := func(method, path, body, token string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(`Authorization`, `Bearer `+token)
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, tc := range []struct {
+		method, path, body string
+		admin              bool
+		want               int
+	}{
+		{http.MethodPut, `/videos/a.mp4`, `not an admin`, false, http.StatusForbidden},
+		{http.MethodPut, `/videos/a.mp4`, `aaaa`, true, http.StatusCreated},
+		{http.MethodPut, `/videos/b.mp4`, `bb`, true, http.StatusCreated},
+		{http.MethodPut, `/videos/.hidden`, `x`, true, http.StatusBadRequest},
+		{http.MethodPut, `/videos/big.mp4`, strings.Repeat(`x`, 17), true, http.StatusRequestEntityTooLarge},
+		{http.MethodDelete, `/videos/b.mp4`, ``, false, http.StatusForbidden},
+		{http.MethodDelete, `/videos/b.mp4`, ``, true, http.StatusNoContent},
+		{http.MethodDelete, `/videos/b.mp4`, ``, true, http.StatusNotFound},
+	} {
+		if rec := serve(tc.method, tc.path, tc.body, token(tc.admin)); rec.Code != tc.want {
+			t.Errorf(`%s %s: status %d, want %d: %s`, tc.method, tc.path, rec.Code, tc.want, rec.Body)
+		}
+	}
+
+	rec := serve(http.MethodGet, `/videos`, ``, token(false))
+	var videos []store.Info
+	if err := json.Unmarshal(rec.Body.Bytes(), &videos); err != nil {
+		t.Fatalf(`GET /videos: %d %s`, rec.Code, rec.Body)
+	}
+	if len(videos) != 1 || videos[0].Name != `a.mp4` || videos[0].Size != 4 {
+		t.Errorf(`GET /videos = %+v, want just a.mp4`, videos)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, `.*`)); len(leftovers) != 0 {
+		t.Errorf(`uploads left %v behind`, leftovers)
+	}
+	if _, err := os.Stat(filepath.Join(dir, `big.mp4`)); err == nil {
+		t.Error(`oversized upload was stored`)
+	}
+
+	want := []string{`video.uploaded a.mp4`, `video.uploaded b.mp4`, `video.deleted b.mp4`}
+	if strings.Join(events, `, `) != strings.Join(want, `, `) {
+		t.Errorf(`published %q, want %q`, events, want)
+	}
+}
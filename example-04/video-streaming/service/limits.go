@@ -0,0 +1,175 @@
+package service
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+	"golang.org/x/time/rate"
+)
+
+// Limits caps how much of the service one client can take. Zero values
+// disable the corresponding limit.
+type Limits struct {
+	// RequestsPerSec and Burst size the token buckets requests draw from:
+	// one per peer IP address, checked before authentication so floods of
+	// bad tokens are throttled too, and one per authenticated user.
+	RequestsPerSec float64
+	Burst          int
+	// MaxStreams caps the videos one client may stream at once.
+	MaxStreams int
+	// BytesPerSec caps each stream, whatever the viewer's tier.
+	BytesPerSec int
+}
+
+// streamRetryAfter is what we suggest to a client that hit MaxStreams; we
+// can't know when one of its streams will finish.
+const streamRetryAfter = 5 * time.Second
+
+// idleClientAge is how long a client's state is kept after its last
+// request once it has no streams open and a full bucket.
+const idleClientAge = 10 * time.Minute
+
+// limiter tracks Limits per client: a peer IP address or an authenticated
+// subject.
+type limiter struct {
+	limits Limits
+
+	mu        sync.Mutex
+	clients   map[string]*clientState
+	lastSweep time.Time
+}
+
+type clientState struct {
+	bucket   *rate.Limiter
+	streams  int
+	lastSeen time.Time
+}
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{limits: limits, clients: map[string]*clientState{}}
+}
+
+// clientKey identifies whose streams r counts against.
+func clientKey(r *http.Request) string {
+	if subject := auth.Subject(r.Context()); subject != `` {
+		return `user:` + subject
+	}
+	return `ip:` + httpx.ClientIP(r)
+}
+
+// client returns the state for key, creating it if needed. l.mu must be
+// held.
+func (l *limiter) client(key string, now time.Time) *clientState {
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+	c, found := l.clients[key]
+	if !found {
+		limit := rate.Limit(l.limits.RequestsPerSec)
+		if l.limits.RequestsPerSec <= 0 {
+			limit = rate.Inf
+		}
+		c = &clientState{bucket: rate.NewLimiter(limit, max(l.limits.Burst, 1))}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+	return c
+}
+
+// sweep forgets clients that have nothing to remember. l.mu must be held.
+func (l *limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, c := range l.clients {
+		if c.streams == 0 && now.Sub(c.lastSeen) > idleClientAge &&
+			c.bucket.TokensAt(now) >= float64(c.bucket.Burst()) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// allow takes a request token for key. If none is available it returns how
+// long until one will be.
+func (l *limiter) allow(key string) (bool, time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	res := l.client(key, now).bucket.ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// openStream counts a stream against key, returning false if it already
+// has MaxStreams open. Call the returned function when the stream ends.
+func (l *limiter) openStream(key string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.client(key, time.Now())
+	if l.limits.MaxStreams > 0 && c.streams >= l.limits.MaxStreams {
+		return nil, false
+	}
+	c.streams++
+	return func() {
+		l.mu.Lock()
+		c.streams--
+		c.lastSeen = time.Now()
+		l.mu.Unlock()
+	}, true
+}
+
+// limitRequests answers 429 once the peer IP address's bucket is empty. It
+// goes in front of authentication, so callers are throttled whether or not
+// their tokens are any good.
+func (l *limiter) limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.allow(`ip:` + httpx.ClientIP(r)); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitUsers answers 429 once the authenticated user's bucket is empty, so
+// one user can't spread their requests over many addresses. Anonymous
+// requests pass; limitRequests has already counted them.
+func (l *limiter) limitUsers(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subject := auth.Subject(r.Context()); subject != `` {
+			if ok, wait := l.allow(`user:` + subject); !ok {
+				tooManyRequests(w, wait)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limitStreams answers 429 when the client is already streaming
+// MaxStreams videos.
+func (l *limiter) limitStreams(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		done, ok := l.openStream(clientKey(r))
+		if !ok {
+			tooManyRequests(w, streamRetryAfter)
+			return
+		}
+		defer done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set(`Retry-After`, strconv.Itoa(max(seconds, 1)))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+func TestLimitRequests(t *testing.T) {
+	l := newLimiter(Limits{RequestsPerSec: 1, Burst: 2})
+	h := l.limitRequests(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, `/video`, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := range 2 {
+		if rec := get(`10.0.0.1:1234`); rec.Code != http.StatusOK {
+			t.Fatalf(`request %d: status %d within burst`, i, rec.Code)
+		}
+	}
+	rec := get(`10.0.0.1:1235`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get(`Retry-After`) != `1` {
+		t.Errorf(`over burst: status %d, Retry-After %q; want 429, 1`, rec.Code, rec.Header().Get(`Retry-After`))
+	}
+	if rec := get(`10.0.0.2:1234`); rec.Code != http.StatusOK {
+		t.Errorf(`another client: status %d`, rec.Code)
+	}
+}
+
+func TestLimitUsers(t *testing.T) {
+	l := newLimiter(Limits{RequestsPerSec: 1, Burst: 1})
+	h := l.limitUsers(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	get := func(subject, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, `/video`, nil)
+		req.RemoteAddr = remoteAddr
+		if subject != `` {
+			req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Subject: subject}))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get(`alice`, `10.0.0.1:1234`); code != http.StatusOK {
+		t.Fatalf(`first request: status %d`, code)
+	}
+	// A new address doesn't buy alice another bucket.
+	if code := get(`alice`, `10.0.0.2:1234`); code != http.StatusTooManyRequests {
+		t.Errorf(`from another address: status %d, want 429`, code)
+	}
+	for range 2 {
+		if code := get(``, `10.0.0.1:1234`); code != http.StatusOK {
+			t.Errorf(`anonymous: status %d`, code)
+		}
+	}
+}
+
+// TestBadTokensThrottled checks that a caller retrying bad tokens is
+// limited by address before authentication turns it away.
+func TestBadTokensThrottled(t *testing.T) {
+	verifier, err := auth.NewVerifier(auth.Config{HMACSecret: `test-secret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := New(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, Options{
+		Store:    store.Dir(`../videos`),
+		Verifier: verifier,
+		Limits:   Limits{RequestsPerSec: 0.5, Burst: 3},
+	})
+	h := svc.Handler()
+
+	var codes []int
+	for range 5 {
+		req := httptest.NewRequest(http.MethodGet, `/video`, nil)
+		req.RemoteAddr = `10.0.0.1:1234`
+		req.Header.Set(`Authorization`, `Bearer forged`)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get(`Retry-After`) == `` {
+			t.Error(`429 without Retry-After`)
+		}
+	}
+	want := []int{401, 401, 401, 429, 429}
+	if !slices.Equal(codes, want) {
+		t.Errorf(`statuses %v, want %v`, codes, want)
+	}
+}
+
+func TestLimitStreams(t *testing.T) {
+	l := newLimiter(Limits{MaxStreams: 1})
+	var inner func()
+	h := l.limitStreams(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { inner() }))
+
+	get := func(subject string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, `/video`, nil)
+		req = req.WithContext(auth.WithIdentity(req.Context(), auth.Identity{Subject: subject}))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// While alice streams, her second stream is refused but bob's is not.
+	var second, other *httptest.ResponseRecorder
+	inner = func() {
+		inner = func() {}
+		second = get(`alice`)
+		other = get(`bob`)
+	}
+	get(`alice`)
+	if second.Code != http.StatusTooManyRequests || second.Header().Get(`Retry-After`) == `` {
+		t.Errorf(`second stream: status %d, Retry-After %q; want 429`, second.Code, second.Header().Get(`Retry-After`))
+	}
+	if other.Code != http.StatusOK {
+		t.Errorf(`other user: status %d`, other.Code)
+	}
+
+	if rec := get(`alice`); rec.Code != http.StatusOK {
+		t.Errorf(`after the first stream ended: status %d`, rec.Code)
+	}
+}
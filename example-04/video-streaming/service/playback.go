@@ -0,0 +1,73 @@
+package service
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+)
+
+// PlaybackProgress is the body of playback.progress events: how far a
+// user has got through a video.
+type PlaybackProgress struct {
+	VideoID string `json:"videoId" bson:"videoId"`
+	UserID  string `json:"userId,omitempty" bson:"userId,omitempty"`
+	// Position and Duration are in seconds; Duration is 0 if the player
+	// didn't say.
+	Position float64 `json:"position" bson:"position"`
+	Duration float64 `json:"duration,omitempty" bson:"duration,omitempty"`
+	// At is when we received the heartbeat, so consumers can drop ones
+	// that arrive out of order.
+	At        time.Time `json:"at" bson:"at"`
+	RequestID string    `json:"requestId,omitempty" bson:"requestId,omitempty"`
+}
+
+// heartbeatRequest is the body of POST /playback/heartbeat.
+type heartbeatRequest struct {
+	Video    string  `json:"video"`
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+}
+
+// handleHeartbeat publishes the caller's position in a video. Players send
+// one every few seconds while playing, so the video isn't opened to check
+// it exists.
+func (s *Service) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req heartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `invalid JSON body`, http.StatusBadRequest)
+		return
+	}
+	if req.Video == `` || !validName(req.Video) {
+		http.Error(w, `video must name a video`, http.StatusBadRequest)
+		return
+	}
+	if !validSeconds(req.Position) || !validSeconds(req.Duration) {
+		http.Error(w, `position and duration must be non-negative seconds`, http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	progress := PlaybackProgress{
+		VideoID:   req.Video,
+		UserID:    auth.Subject(ctx),
+		Position:  req.Position,
+		Duration:  req.Duration,
+		At:        time.Now().UTC(),
+		RequestID: logging.RequestID(ctx),
+	}
+	if err := s.events.emit(ctx, messaging.PlaybackProgress, progress.RequestID, messaging.PartitionKey(progress.UserID, progress.VideoID), progress); err != nil {
+		s.log.ErrorContext(ctx, `Unable to publish to RabbitMQ channel`, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func validSeconds(f float64) bool {
+	return f >= 0 && !math.IsInf(f, 0) && !math.IsNaN(f)
+}
@@ -0,0 +1,436 @@
+// Package service is the video-streaming microservice: it streams videos on
+// GET /video, hands out signed links to them on POST /video/links, serves
+// their captions and HLS playlists under /videos/{id}, lets admins upload
+// and delete them and announces every view, playback heartbeat and change
+// on the events topic exchange. main wires it to RabbitMQ; tests can wire
+// it to anything that publishes.
+package service
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/captions"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/thumbnail"
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/openapi"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	contentLength = `Content-Length`
+	contentType   = `Content-Type`
+)
+
+// sampleVideo is the only video we serve for now.
+const sampleVideo = `SampleVideo_1280x720_1mb.mp4`
+
+var tracer = tracing.Tracer(`video-streaming`)
+
+// openAPI describes the routes Handler serves.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+var spec = openapi.MustLoad(openAPI)
+
+type viewedMessageBody struct {
+	VideoPath string `json:"videoPath" bson:"videoPath"`
+	// RequestID correlates the view with the GET /video request that
+	// caused it.
+	RequestID string `json:"requestId,omitempty" bson:"requestId,omitempty"`
+	// UserID is the authenticated subject who watched the video.
+	UserID string `json:"userId,omitempty" bson:"userId,omitempty"`
+}
+
+// Options configures a Service.
+type Options struct {
+	// Events is the topic exchange events are published to; empty means
+	// messaging.DefaultExchange. LegacyEvents also publishes them the way
+	// consumers that haven't moved yet expect, to the Viewed and
+	// PlaybackProgress fanouts, and LegacyViewedQueue sends views to
+	// example-03's viewed queue too.
+	Events            string
+	LegacyEvents      bool
+	LegacyViewedQueue bool
+	// Store holds the videos served on GET /video.
+	Store store.VideoStore
+	// Library, usually the same videos as Store without its cache, is
+	// listed on GET /videos and managed by admins with PUT and DELETE
+	// /videos/{id}; nil disables those. MaxUploadBytes caps an upload; 0
+	// means no cap.
+	Library        store.Library
+	MaxUploadBytes int64
+	// Verifier checks bearer tokens; nil lets anyone watch, but closes
+	// the admin routes.
+	Verifier *auth.Verifier
+	// Signer mints and verifies signed links to single videos; nil
+	// disables POST /video/links.
+	Signer *URLSigner
+	// LinkTTL is how long a minted link lasts unless the caller asks for
+	// less; MaxLinkTTL caps what the caller may ask for.
+	LinkTTL, MaxLinkTTL time.Duration
+	// Limits caps each client's request rate, streams and bandwidth.
+	Limits Limits
+	// Catalog gives videos' bitrates, which Tiers pace streams to. Callers
+	// whose token names no known plan get DefaultTier, if it exists.
+	Catalog     Catalog
+	Tiers       map[string]Tier
+	DefaultTier string
+	// Thumbnails serves GET /thumbnail/{id}; nil disables it.
+	Thumbnails *thumbnail.Extractor
+	// Captions holds the caption tracks served under
+	// /videos/{id}/captions and listed in the HLS playlists; nil disables
+	// them.
+	Captions *captions.Store
+}
+
+// Service streams videos from a VideoStore.
+type Service struct {
+	log     *slog.Logger
+	events  *emitter
+	opts    Options
+	limiter *limiter
+}
+
+// New returns a service publishing events with pub, usually the
+// *amqp.Channel later passed to Declare.
+func New(log *slog.Logger, pub publisher, opts Options) *Service {
+	if opts.Events == `` {
+		opts.Events = messaging.DefaultExchange
+	}
+	events := &emitter{
+		pub:          pub,
+		exchange:     opts.Events,
+		legacyFanout: opts.LegacyEvents,
+		legacyQueue:  opts.LegacyViewedQueue,
+	}
+	return &Service{log: log, events: events, opts: opts, limiter: newLimiter(opts.Limits)}
+}
+
+// Handler serves the HTTP API, wrapped in the shared tracing and logging
+// middleware, and openapi.json on GET /openapi.json. GET /video takes
+// either a bearer token or a signed link. Requests are rate limited per IP
+// address before they are authenticated, and per user after; those let in
+// are checked against openapi.json.
+func (s *Service) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(`GET /video`, s.authorizeVideo(
+		s.limiter.limitUsers(s.limiter.limitStreams(spec.Validate(http.HandlerFunc(s.handleVideo))))))
+	if s.opts.Signer != nil {
+		mux.Handle(`POST /video/links`, s.authorized(s.handleMintLink))
+	}
+	mux.Handle(`POST /playback/heartbeat`, s.authorized(s.handleHeartbeat))
+	if s.opts.Thumbnails != nil {
+		mux.Handle(`GET /thumbnail/{id}`, s.authorized(s.handleThumbnail))
+	}
+	if s.opts.Captions != nil {
+		mux.Handle(`GET /videos/{id}/captions`, s.authorized(s.handleListCaptions))
+		mux.Handle(`GET /videos/{id}/captions/{lang}`, s.authorized(s.handleGetCaptions))
+		mux.Handle(`PUT /videos/{id}/captions/{lang}`, s.admin(s.handlePutCaptions))
+		mux.Handle(`GET /videos/{id}/captions/{lang}/playlist.m3u8`, s.authorized(s.handleCaptionsPlaylist))
+	}
+	if s.opts.Library != nil {
+		mux.Handle(`GET /videos`, s.authorized(s.handleListVideos))
+		mux.Handle(`PUT /videos/{id}`, s.admin(s.handleUploadVideo))
+		mux.Handle(`DELETE /videos/{id}`, s.admin(s.handleDeleteVideo))
+	}
+	mux.Handle(`GET /videos/{id}/playlist.m3u8`, s.authorized(s.handleMasterPlaylist))
+	mux.Handle(`GET /videos/{id}/stream.m3u8`, s.authorized(s.handleStreamPlaylist))
+	if cache, ok := s.opts.Store.(*store.Cache); ok {
+		mux.Handle(`GET /cache/stats`, s.admin(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(contentType, `application/json`)
+			json.NewEncoder(w).Encode(cache.Stats())
+		}))
+	}
+	mux.Handle(`GET /openapi.json`, spec)
+	return tracing.Middleware(logging.Middleware(s.log, s.limiter.limitRequests(mux)))
+}
+
+// authorized requires a bearer token, then rate limits the user and
+// validates the request.
+func (s *Service) authorized(h http.HandlerFunc) http.Handler {
+	return auth.Middleware(s.log, s.opts.Verifier, s.limiter.limitUsers(spec.Validate(h)))
+}
+
+// admin requires a bearer token with the admin claim, then validates the
+// request.
+func (s *Service) admin(h http.HandlerFunc) http.Handler {
+	return auth.RequireAdmin(s.log, s.opts.Verifier, spec.Validate(h))
+}
+
+// authorizeVideo lets a request through if it is a valid signed link, and
+// otherwise falls back to the bearer token check. A signed link's subject
+// and plan become the request's so the view is still attributed and
+// shaped.
+func (s *Service) authorizeVideo(next http.Handler) http.Handler {
+	withToken := auth.Middleware(s.log, s.opts.Verifier, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if s.opts.Signer == nil || !q.Has(`sig`) {
+			withToken.ServeHTTP(w, r)
+			return
+		}
+		link, err := s.opts.Signer.Verify(q, httpx.ClientIP(r))
+		if err != nil {
+			s.log.InfoContext(r.Context(), `rejected signed link`, logging.KeyError, err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		id := auth.Identity{Subject: link.Subject, Plan: link.Plan}
+		next.ServeHTTP(w, r.WithContext(auth.WithIdentity(r.Context(), id)))
+	})
+}
+
+func (s *Service) handleVideo(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get(`v`)
+	if name == `` {
+		name = sampleVideo
+	}
+	if !validName(name) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	if rate, burst := s.pace(r.Context(), name); rate > 0 {
+		w = newThrottledWriter(r.Context(), w, rate, burst)
+	}
+	videoHandler(s.log, s.events, s.opts.Store, name).ServeHTTP(w, r)
+}
+
+// validName refuses video names that could reach outside the store.
+func validName(name string) bool {
+	return name == filepath.Base(name) && !strings.HasPrefix(name, `.`)
+}
+
+// handleThumbnail serves the keyframe nearest ?t=, in seconds, of the
+// video named by the path.
+func (s *Service) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue(`id`)
+	if !validName(id) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	var at time.Duration
+	if t := r.URL.Query().Get(`t`); t != `` {
+		seconds, err := strconv.ParseFloat(t, 64)
+		if err != nil || seconds < 0 || seconds > math.MaxInt64/float64(time.Second) {
+			http.Error(w, `t must be a number of seconds`, http.StatusBadRequest)
+			return
+		}
+		at = time.Duration(seconds * float64(time.Second))
+	}
+
+	img, err := s.opts.Thumbnails.Thumbnail(r.Context(), id, at)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	case errors.Is(err, thumbnail.ErrUnsupported):
+		s.log.WarnContext(r.Context(), `thumbnail`, `video`, id, logging.KeyError, err)
+		http.Error(w, `no thumbnail for this video`, http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		s.log.ErrorContext(r.Context(), `thumbnail`, `video`, id, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(contentType, img.ContentType)
+	w.Header().Set(contentLength, strconv.Itoa(len(img.Data)))
+	w.Header().Set(`Cache-Control`, `private, max-age=3600`)
+	w.Write(img.Data)
+}
+
+// mintLinkRequest is the body of POST /video/links.
+type mintLinkRequest struct {
+	Video string `json:"video"`
+	// TTL is a Go duration such as "15m"; empty means the default.
+	TTL string `json:"ttl"`
+	// BindIP restricts the link to the caller's IP address.
+	BindIP bool `json:"bindIp"`
+}
+
+type mintLinkResponse struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// handleMintLink signs a link to one video on behalf of the caller.
+func (s *Service) handleMintLink(w http.ResponseWriter, r *http.Request) {
+	var req mintLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `invalid JSON body`, http.StatusBadRequest)
+		return
+	}
+	if req.Video == `` {
+		req.Video = sampleVideo
+	}
+	ttl := s.opts.LinkTTL
+	if req.TTL != `` {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			http.Error(w, `ttl must be a positive duration`, http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	if s.opts.MaxLinkTTL > 0 && ttl > s.opts.MaxLinkTTL {
+		http.Error(w, fmt.Sprintf(`ttl may not exceed %s`, s.opts.MaxLinkTTL), http.StatusBadRequest)
+		return
+	}
+
+	if !s.checkVideo(w, r, req.Video) {
+		return
+	}
+
+	link := Link{
+		Video:   req.Video,
+		Subject: auth.Subject(r.Context()),
+		Plan:    auth.Plan(r.Context()),
+		Expires: time.Now().Add(ttl).Truncate(time.Second),
+	}
+	if req.BindIP {
+		link.IP = httpx.ClientIP(r)
+	}
+	resp := mintLinkResponse{
+		URL:     `/video?` + s.opts.Signer.Sign(link).Encode(),
+		Expires: link.Expires,
+	}
+	w.Header().Set(contentType, `application/json`)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkVideo reports whether the named video exists, answering the
+// request itself when it doesn't.
+func (s *Service) checkVideo(w http.ResponseWriter, r *http.Request, name string) bool {
+	if !validName(name) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return false
+	}
+	video, err := s.opts.Store.Open(r.Context(), name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		s.log.ErrorContext(r.Context(), `open video`, `video`, name, logging.KeyError, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return false
+	}
+	video.Close()
+	return true
+}
+
+// publisher is the part of *amqp.Channel used to emit events.
+type publisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// videoHandler streams the named video from videos and then announces the
+// view, if the response started one. A failure to publish is logged; the
+// client already has its video.
+func videoHandler(log *slog.Logger, events *emitter, videos store.VideoStore, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		video, err := videos.Open(r.Context(), name)
+		if errors.Is(err, fs.ErrNotExist) {
+			log.WarnContext(r.Context(), `open video`, `video`, name, logging.KeyError, err)
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.ErrorContext(r.Context(), `open video`, `video`, name, logging.KeyError, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+		defer video.Close()
+		videoPath := video.Path()
+
+		// ServeContent answers Range requests, and copies a local file to the
+		// client with sendfile where every writer in between passes ReadFrom
+		// through.
+		w.Header().Set(contentType, `video/mp4`)
+		rec := httpx.NewRecorder(w)
+		_, span := tracer.Start(r.Context(), `video.stream`,
+			trace.WithAttributes(attribute.String(`video.path`, videoPath)))
+		http.ServeContent(rec, r, name, time.Time{}, store.Content(video))
+		span.SetAttributes(attribute.Int64(`video.bytes_written`, rec.Bytes), attribute.Int(`http.status_code`, rec.Status))
+		if rec.Err != nil {
+			// Most likely the client went away partway.
+			span.RecordError(rec.Err)
+			span.SetStatus(codes.Error, `stream video`)
+			log.WarnContext(r.Context(), `stream video`, `videoPath`, videoPath, logging.KeyError, rec.Err)
+		}
+		span.End()
+
+		// Seeks, HEADs and failed requests are part of a view counted
+		// already, or of none.
+		if !startsView(r, rec.Status) {
+			return
+		}
+		if err := sendViewedMessage(r.Context(), videoPath, events); err != nil {
+			log.ErrorContext(r.Context(), `Unable to publish to RabbitMQ channel`, logging.KeyError, err)
+			return
+		}
+		log.InfoContext(r.Context(), `published Viewed`, `videoPath`, videoPath)
+	}
+}
+
+// minViewRange is the fewest bytes a Range from byte 0 must ask for to
+// count as a view. Players probe with tiny ranges such as bytes=0-1 to
+// learn the size and whether ranges work before they play.
+const minViewRange = 64 << 10
+
+// startsView reports whether a response with status to r plays the video
+// from the start: a GET answered in full, or a Range from byte 0 that is
+// open-ended or at least minViewRange long.
+func startsView(r *http.Request, status int) bool {
+	if r.Method == http.MethodHead {
+		return false
+	}
+	switch status {
+	case http.StatusOK:
+		return true
+	case http.StatusPartialContent:
+		spec, ok := strings.CutPrefix(r.Header.Get(`Range`), `bytes=`)
+		start, end, _ := strings.Cut(spec, `-`)
+		if !ok || strings.TrimSpace(start) != `0` {
+			return false
+		}
+		if end = strings.TrimSpace(end); end == `` {
+			return true
+		}
+		last, err := strconv.ParseInt(end, 10, 64)
+		return err == nil && last+1 >= minViewRange
+	}
+	return false
+}
+
+func sendViewedMessage(ctx context.Context, path string, events *emitter) error {
+	// Refactor to send to RabbitMQ.
+	requestID := logging.RequestID(ctx)
+	body := viewedMessageBody{
+		VideoPath: path,
+		RequestID: requestID,
+		UserID:    auth.Subject(ctx),
+	}
+	return events.emit(ctx, messaging.VideoViewed, requestID, messaging.PartitionKey(body.UserID, path), body)
+}
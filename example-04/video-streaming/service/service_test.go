@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+type publisherFunc func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+
+func (f publisherFunc) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	return f(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func TestVideoHandlerSurvivesPublishFailure(t *testing.T) {
+	want, err := os.ReadFile(`../videos/SampleVideo_1280x720_1mb.mp4`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	pub := publisherFunc(func(context.Context, string, string, bool, bool, amqp.Publishing) error {
+		calls++
+		return errors.New(`channel closed`)
+	})
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		videoHandler(log, &emitter{pub: pub, exchange: `events`}, store.Dir(`../videos`), sampleVideo).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/video`, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf(`status = %d, want %d`, rec.Code, http.StatusOK)
+		}
+		if rec.Body.Len() != len(want) {
+			t.Fatalf(`streamed %d bytes, want %d`, rec.Body.Len(), len(want))
+		}
+	}
+	if calls != 2 {
+		t.Errorf(`publish called %d times, want 2`, calls)
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+	"golang.org/x/time/rate"
+)
+
+// Tier shapes the streams of users on one plan. A stream is paced to the
+// video's bitrate times Headroom, so the player stays ahead of the viewer
+// without the whole file leaving at once.
+type Tier struct {
+	Name string
+	// Headroom multiplies the video's bitrate; it is at least 1.
+	Headroom float64
+	// Burst is how much video, in playback time, is sent up front before
+	// pacing starts.
+	Burst time.Duration
+	// MaxBitrate caps the pace in bits per second, and paces videos the
+	// catalog has no bitrate for; 0 means no cap.
+	MaxBitrate int
+}
+
+// ParseTiers parses entries of the form "name:headroom:burst:maxBitrate",
+// e.g. "free:1.25:2s:2000000" or "premium:1.5:10s:0".
+func ParseTiers(entries []string) (map[string]Tier, error) {
+	tiers := map[string]Tier{}
+	for _, entry := range entries {
+		fields := strings.Split(entry, `:`)
+		if len(fields) != 4 || fields[0] == `` {
+			return nil, fmt.Errorf(`tier %q: want name:headroom:burst:maxBitrate`, entry)
+		}
+		t := Tier{Name: fields[0]}
+		var err error
+		if t.Headroom, err = strconv.ParseFloat(fields[1], 64); err != nil || t.Headroom < 1 {
+			return nil, fmt.Errorf(`tier %q: headroom must be a number of at least 1`, t.Name)
+		}
+		if t.Burst, err = time.ParseDuration(fields[2]); err != nil || t.Burst < 0 {
+			return nil, fmt.Errorf(`tier %q: burst must be a duration such as 2s`, t.Name)
+		}
+		if t.MaxBitrate, err = strconv.Atoi(fields[3]); err != nil || t.MaxBitrate < 0 {
+			return nil, fmt.Errorf(`tier %q: maxBitrate must be a whole number of bits per second`, t.Name)
+		}
+		if _, dup := tiers[t.Name]; dup {
+			return nil, fmt.Errorf(`tier %q: duplicate name`, t.Name)
+		}
+		tiers[t.Name] = t
+	}
+	return tiers, nil
+}
+
+// minBurst keeps paced writes from shrinking below io.Copy's buffer.
+const minBurst = 32 << 10
+
+// pace returns the bytes per second to stream video at for the caller in
+// ctx, and how many bytes may go out at once; 0 means unpaced. Callers
+// without a plan, or with an unknown one, get DefaultTier. Limits.BytesPerSec
+// caps the result whatever the tier.
+func (s *Service) pace(ctx context.Context, video string) (rate, burst int) {
+	tier, ok := s.opts.Tiers[auth.Plan(ctx)]
+	if !ok {
+		tier, ok = s.opts.Tiers[s.opts.DefaultTier]
+	}
+
+	var bitrate float64
+	if ok {
+		if info := s.opts.Catalog[video]; info.Bitrate > 0 {
+			bitrate = float64(info.Bitrate) * tier.Headroom
+		}
+		if limit := float64(tier.MaxBitrate); limit > 0 && (bitrate == 0 || bitrate > limit) {
+			bitrate = limit
+		}
+	}
+	rate = int(bitrate / 8)
+	if limit := s.opts.Limits.BytesPerSec; limit > 0 && (rate == 0 || rate > limit) {
+		rate = limit
+	}
+	if rate == 0 {
+		return 0, 0
+	}
+
+	burst = rate // a second's worth
+	if ok && tier.Burst > 0 {
+		burst = int(float64(rate) * tier.Burst.Seconds())
+	}
+	return rate, max(burst, minBurst)
+}
+
+// throttledWriter paces writes to a fixed number of bytes per second,
+// letting up to burst bytes through at once.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx    context.Context
+	bucket *rate.Limiter
+}
+
+func newThrottledWriter(ctx context.Context, w http.ResponseWriter, bytesPerSec, burst int) *throttledWriter {
+	return &throttledWriter{
+		ResponseWriter: w,
+		ctx:            ctx,
+		bucket:         rate.NewLimiter(rate.Limit(bytesPerSec), burst),
+	}
+}
+
+// Write waits for the bucket before each burst-sized piece of p, giving up
+// when the client goes away.
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), t.bucket.Burst())
+		if err := t.bucket.WaitN(t.ctx, n); err != nil {
+			return written, err
+		}
+		n, err := t.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// ReadFrom paces a copy from src in burst-sized pieces, each handed to the
+// underlying writer's ReadFrom, so a paced file is still sent with
+// sendfile, just a burst at a time.
+func (t *throttledWriter) ReadFrom(src io.Reader) (int64, error) {
+	rf, ok := t.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{t}, src)
+	}
+	// Limit the file itself rather than wrapping src again: sendfile is only
+	// used for a file under at most one io.LimitedReader.
+	file, left := src, int64(-1)
+	if lr, ok := src.(*io.LimitedReader); ok {
+		file, left = lr.R, lr.N
+		defer func() { lr.N = left }()
+	}
+	var written int64
+	for left != 0 {
+		n := int64(t.bucket.Burst())
+		if left > 0 {
+			n = min(n, left)
+		}
+		if err := t.bucket.WaitN(t.ctx, int(n)); err != nil {
+			return written, err
+		}
+		sent, err := rf.ReadFrom(&io.LimitedReader{R: file, N: n})
+		written += sent
+		if left > 0 {
+			left -= sent
+		}
+		if err != nil || sent < n {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// writerOnly hides a writer's ReadFrom from io.Copy.
+type writerOnly struct{ io.Writer }
+
+// Unwrap exposes the underlying writer to http.ResponseController.
+func (t *throttledWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
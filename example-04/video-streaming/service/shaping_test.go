@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/auth"
+)
+
+func TestPace(t *testing.T) {
+	tiers, err := ParseTiers([]string{`free:1.25:2s:2000000`, `premium:1.5:10s:0`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(nil, nil, Options{
+		Catalog:     Catalog{`hd.mp4`: {Bitrate: 4_000_000}, `sd.mp4`: {Bitrate: 800_000}},
+		Tiers:       tiers,
+		DefaultTier: `free`,
+	})
+
+	for _, tc := range []struct {
+		plan, video string
+		rate, burst int
+	}{
+		// 800kb/s * 1.25 = 125000 B/s, with 2s up front.
+		{`free`, `sd.mp4`, 125_000, 250_000},
+		// Capped at the tier's 2Mb/s.
+		{`free`, `hd.mp4`, 250_000, 500_000},
+		{`premium`, `hd.mp4`, 750_000, 7_500_000},
+		// No bitrate: the cap if there is one, else unpaced.
+		{`free`, `unknown.mp4`, 250_000, 500_000},
+		{`premium`, `unknown.mp4`, 0, 0},
+		// Unknown plans fall back to the default tier.
+		{`gold`, `sd.mp4`, 125_000, 250_000},
+		{``, `sd.mp4`, 125_000, 250_000},
+	} {
+		ctx := auth.WithIdentity(context.Background(), auth.Identity{Subject: `alice`, Plan: tc.plan})
+		if rate, burst := s.pace(ctx, tc.video); rate != tc.rate || burst != tc.burst {
+			t.Errorf(`pace(%q, %q) = %d, %d; want %d, %d`, tc.plan, tc.video, rate, burst, tc.rate, tc.burst)
+		}
+	}
+
+	// The global cap wins over every tier.
+	s.opts.Limits.BytesPerSec = 100_000
+	ctx := auth.WithIdentity(context.Background(), auth.Identity{Plan: `premium`})
+	if rate, _ := s.pace(ctx, `hd.mp4`); rate != 100_000 {
+		t.Errorf(`capped pace = %d, want 100000`, rate)
+	}
+}
+
+func TestParseTiersRejects(t *testing.T) {
+	for _, entry := range []string{`free`, `free:0.5:2s:0`, `free:1:soon:0`, `free:1:2s:-1`, `:1:2s:0`} {
+		if _, err := ParseTiers([]string{entry}); err == nil {
+			t.Errorf(`ParseTiers(%q) succeeded`, entry)
+		}
+	}
+	if _, err := ParseTiers([]string{`free:1:1s:0`, `free:2:1s:0`}); err == nil {
+		t.Error(`duplicate tier accepted`)
+	}
+}
+
+func TestThrottledWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := newThrottledWriter(context.Background(), rec, 1000, 1000)
+
+	start := time.Now()
+	// The burst goes out at once; the rest is paced.
+	n, err := w.Write(make([]byte, 1500))
+	if err != nil || n != 1500 {
+		t.Fatalf(`Write = %d, %v`, n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf(`wrote 1500 bytes at 1000 B/s in %s`, elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w = newThrottledWriter(ctx, rec, 1000, 1000)
+	if _, err := w.Write(make([]byte, 10)); err == nil {
+		t.Error(`Write succeeded after the client went away`)
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SigningKey is one HMAC key used for signed video URLs.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// ParseSigningKeys parses entries of the form "id:secret". The first key
+// signs new URLs; the rest only verify, so a key can be rotated out by
+// moving it down the list until the URLs it signed have expired.
+func ParseSigningKeys(entries []string) ([]SigningKey, error) {
+	var keys []SigningKey
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		id, secret, found := strings.Cut(entry, `:`)
+		if !found || id == `` || secret == `` {
+			// Don't echo the entry; it holds the secret.
+			return nil, fmt.Errorf(`signing key %d: want id:secret`, len(keys)+1)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf(`signing key %q: duplicate id`, id)
+		}
+		seen[id] = true
+		keys = append(keys, SigningKey{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// Reasons a signed URL is refused.
+var (
+	errUnsigned     = errors.New(`URL is not signed`)
+	errUnknownKey   = errors.New(`URL signed with an unknown key`)
+	errBadSignature = errors.New(`URL signature does not match`)
+	errExpired      = errors.New(`URL has expired`)
+	errWrongIP      = errors.New(`URL is bound to another IP address`)
+	errVersion      = errors.New(`URL signature version is not supported`)
+)
+
+// signatureVersion is the sv parameter of links Sign makes. Version 2 added
+// plan to the signed parameters. Links without sv were signed before that;
+// they are still accepted, without a plan, until they expire, which is at
+// most URL_LINK_MAX_TTL after the upgrade.
+const signatureVersion = `2`
+
+// signedParams lists the parameters each signature version covers, in the
+// order they are hashed.
+var signedParams = map[string][]string{
+	``:  {`v`, `sub`, `ip`, `exp`, `kid`},
+	`2`: {`v`, `sub`, `plan`, `ip`, `exp`, `kid`, `sv`},
+}
+
+// Link is what a signed URL grants: Subject, on Plan, may stream Video
+// until Expires, from IP if it is set.
+type Link struct {
+	Video   string
+	Subject string
+	Plan    string
+	IP      string
+	Expires time.Time
+}
+
+// URLSigner signs and verifies the query string of GET /video links.
+type URLSigner struct {
+	keys []SigningKey
+	now  func() time.Time
+}
+
+// NewURLSigner returns a signer using keys[0] for new links and any of keys
+// for verification. It returns nil when keys is empty.
+func NewURLSigner(keys []SigningKey) *URLSigner {
+	if len(keys) == 0 {
+		return nil
+	}
+	return &URLSigner{keys: keys, now: time.Now}
+}
+
+// Sign returns the query parameters granting l.
+func (s *URLSigner) Sign(l Link) url.Values {
+	key := s.keys[0]
+	q := url.Values{}
+	q.Set(`v`, l.Video)
+	if l.Subject != `` {
+		q.Set(`sub`, l.Subject)
+	}
+	if l.Plan != `` {
+		q.Set(`plan`, l.Plan)
+	}
+	if l.IP != `` {
+		q.Set(`ip`, l.IP)
+	}
+	q.Set(`exp`, strconv.FormatInt(l.Expires.Unix(), 10))
+	q.Set(`kid`, key.ID)
+	q.Set(`sv`, signatureVersion)
+	q.Set(`sig`, signature(key.Secret, q))
+	return q
+}
+
+// Verify checks q was produced by Sign with one of our keys, has not
+// expired and, if bound to an IP, is presented from clientIP.
+func (s *URLSigner) Verify(q url.Values, clientIP string) (Link, error) {
+	sig := q.Get(`sig`)
+	if sig == `` {
+		return Link{}, errUnsigned
+	}
+	var secret []byte
+	for _, k := range s.keys {
+		if k.ID == q.Get(`kid`) {
+			secret = k.Secret
+			break
+		}
+	}
+	if secret == nil {
+		return Link{}, errUnknownKey
+	}
+	if _, found := signedParams[q.Get(`sv`)]; !found {
+		return Link{}, errVersion
+	}
+	if !hmac.Equal([]byte(sig), []byte(signature(secret, q))) {
+		return Link{}, errBadSignature
+	}
+
+	exp, err := strconv.ParseInt(q.Get(`exp`), 10, 64)
+	if err != nil {
+		return Link{}, errBadSignature
+	}
+	l := Link{
+		Video:   q.Get(`v`),
+		Subject: q.Get(`sub`),
+		Plan:    q.Get(`plan`),
+		IP:      q.Get(`ip`),
+		Expires: time.Unix(exp, 0),
+	}
+	if q.Get(`sv`) == `` {
+		// The plan wasn't signed.
+		l.Plan = ``
+	}
+	if !s.now().Before(l.Expires) {
+		return Link{}, errExpired
+	}
+	if l.IP != `` && l.IP != clientIP {
+		return Link{}, errWrongIP
+	}
+	return l, nil
+}
+
+// signature is the HMAC-SHA256 of the parameters of q signed in its
+// version, in a fixed order so the query string can be rearranged without
+// breaking it.
+func signature(secret []byte, q url.Values) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, name := range signedParams[q.Get(`sv`)] {
+		fmt.Fprintf(mac, "%s=%s\n", name, url.QueryEscape(q.Get(name)))
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
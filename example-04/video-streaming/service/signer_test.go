@@ -0,0 +1,107 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	old := SigningKey{ID: `k1`, Secret: []byte(`old`)}
+	cur := SigningKey{ID: `k2`, Secret: []byte(`new`)}
+
+	before := NewURLSigner([]SigningKey{old})
+	after := NewURLSigner([]SigningKey{cur, old})
+	for _, s := range []*URLSigner{before, after} {
+		s.now = func() time.Time { return now }
+	}
+
+	link := Link{Video: `a.mp4`, Subject: `alice`, Plan: `free`, IP: `10.0.0.1`, Expires: now.Add(time.Minute)}
+	signedBefore := before.Sign(link)
+	signedAfter := after.Sign(link)
+	if signedAfter.Get(`kid`) != `k2` {
+		t.Errorf(`signed with %q, want the first key`, signedAfter.Get(`kid`))
+	}
+
+	// Links signed before the rotation keep working after it, but not the
+	// other way round.
+	for name, q := range map[string]url.Values{`old key`: signedBefore, `new key`: signedAfter} {
+		got, err := after.Verify(q, `10.0.0.1`)
+		if err != nil || got != link {
+			t.Errorf(`%s: Verify = %+v, %v; want %+v`, name, got, err, link)
+		}
+	}
+	if _, err := before.Verify(signedAfter, `10.0.0.1`); !errors.Is(err, errUnknownKey) {
+		t.Errorf(`retired signer accepted the new key: %v`, err)
+	}
+
+	tampered := func(name, value string) url.Values {
+		q := url.Values{}
+		for k, v := range signedAfter {
+			q[k] = v
+		}
+		q.Set(name, value)
+		return q
+	}
+	for _, tc := range []struct {
+		q    url.Values
+		ip   string
+		want error
+	}{
+		{tampered(`v`, `b.mp4`), `10.0.0.1`, errBadSignature},
+		{tampered(`sub`, `bob`), `10.0.0.1`, errBadSignature},
+		{tampered(`plan`, `premium`), `10.0.0.1`, errBadSignature},
+		{tampered(`exp`, `9999999999`), `10.0.0.1`, errBadSignature},
+		{tampered(`sv`, ``), `10.0.0.1`, errBadSignature},
+		{tampered(`sv`, `3`), `10.0.0.1`, errVersion},
+		{tampered(`sig`, `AAAA`), `10.0.0.1`, errBadSignature},
+		{signedAfter, `10.0.0.2`, errWrongIP},
+		{url.Values{`v`: {`a.mp4`}}, `10.0.0.1`, errUnsigned},
+	} {
+		if _, err := after.Verify(tc.q, tc.ip); !errors.Is(err, tc.want) {
+			t.Errorf(`Verify(%v, %s) = %v, want %v`, tc.q, tc.ip, err, tc.want)
+		}
+	}
+
+	// A link signed before plan was, with no sv, still verifies but can't
+	// claim a plan.
+	legacy := url.Values{}
+	legacy.Set(`v`, `a.mp4`)
+	legacy.Set(`sub`, `alice`)
+	legacy.Set(`plan`, `premium`)
+	legacy.Set(`exp`, strconv.FormatInt(now.Add(time.Minute).Unix(), 10))
+	legacy.Set(`kid`, `k1`)
+	legacy.Set(`sig`, signature(old.Secret, legacy))
+	got, err := after.Verify(legacy, `10.0.0.9`)
+	if err != nil || got.Subject != `alice` || got.Plan != `` {
+		t.Errorf(`unversioned link: Verify = %+v, %v; want alice with no plan`, got, err)
+	}
+
+	legacy.Set(`sv`, ``)
+	if got, err := after.Verify(legacy, `10.0.0.9`); err != nil || got.Plan != `` {
+		t.Errorf(`unversioned link with an empty sv: Verify = %+v, %v; want no plan`, got, err)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := after.Verify(legacy, `10.0.0.9`); !errors.Is(err, errExpired) {
+		t.Errorf(`expired unversioned link: %v`, err)
+	}
+	if _, err := after.Verify(signedAfter, `10.0.0.1`); !errors.Is(err, errExpired) {
+		t.Errorf(`expired link: %v`, err)
+	}
+}
+
+func TestParseSigningKeys(t *testing.T) {
+	keys, err := ParseSigningKeys([]string{`k2:new`, `k1:old:with:colons`})
+	if err != nil || len(keys) != 2 || keys[0].ID != `k2` || string(keys[1].Secret) != `old:with:colons` {
+		t.Errorf(`ParseSigningKeys = %+v, %v`, keys, err)
+	}
+	for _, bad := range [][]string{{`nokey`}, {`:secret`}, {`k1:`}, {`k1:a`, `k1:b`}} {
+		if _, err := ParseSigningKeys(bad); err == nil {
+			t.Errorf(`ParseSigningKeys(%q) succeeded`, bad)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func discardEvents() *emitter {
+	pub := publisherFunc(func(context.Context, string, string, bool, bool, amqp.Publishing) error { return nil })
+	return &emitter{pub: pub, exchange: `events`}
+}
+
+func TestVideoRange(t *testing.T) {
+	want, err := os.ReadFile(`../videos/` + sampleVideo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := videoHandler(log, discardEvents(), store.Dir(`../videos`), sampleVideo)
+
+	for _, tc := range []struct {
+		header string
+		status int
+		from   int
+		to     int
+	}{
+		{``, http.StatusOK, 0, len(want)},
+		{`bytes=0-`, http.StatusPartialContent, 0, len(want)},
+		{`bytes=100-199`, http.StatusPartialContent, 100, 200},
+		{fmt.Sprintf(`bytes=%d-`, len(want)-10), http.StatusPartialContent, len(want) - 10, len(want)},
+		{`bytes=-10`, http.StatusPartialContent, len(want) - 10, len(want)},
+	} {
+		req := httptest.NewRequest(http.MethodGet, `/video`, nil)
+		if tc.header != `` {
+			req.Header.Set(`Range`, tc.header)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.status || rec.Body.String() != string(want[tc.from:tc.to]) {
+			t.Errorf(`Range %q: %d with %d bytes, want %d with bytes %d-%d`, tc.header, rec.Code, rec.Body.Len(), tc.status, tc.from, tc.to)
+		}
+		if got := rec.Header().Get(`Content-Type`); got != `video/mp4` {
+			t.Errorf(`Range %q: Content-Type %q`, tc.header, got)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, `/video`, nil)
+	req.Header.Set(`Range`, fmt.Sprintf(`bytes=%d-`, len(want)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf(`range past the end: %d, want 416`, rec.Code)
+	}
+}
+
+func TestSeeksAreNotViews(t *testing.T) {
+	views := 0
+	pub := publisherFunc(func(_ context.Context, _, key string, _, _ bool, _ amqp.Publishing) error {
+		if key == messaging.VideoViewed {
+			views++
+		}
+		return nil
+	})
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	h := videoHandler(log, &emitter{pub: pub, exchange: `events`}, store.Dir(`../videos`), sampleVideo)
+
+	for _, tc := range []struct {
+		method, header string
+		views          int
+	}{
+		{http.MethodGet, ``, 1},
+		{http.MethodGet, `bytes=0-`, 1},
+		{http.MethodGet, `bytes=0-1`, 0},
+		{http.MethodGet, `bytes=0-99`, 0},
+		{http.MethodGet, `bytes=0-65535`, 1},
+		{http.MethodGet, `bytes=100-199`, 0},
+		{http.MethodGet, `bytes=-10`, 0},
+		{http.MethodGet, `bytes=99999999-`, 0},
+		{http.MethodHead, ``, 0},
+	} {
+		views = 0
+		req := httptest.NewRequest(tc.method, `/video`, nil)
+		if tc.header != `` {
+			req.Header.Set(`Range`, tc.header)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if views != tc.views {
+			t.Errorf(`%s Range %q: %d views published, want %d`, tc.method, tc.header, views, tc.views)
+		}
+	}
+}
+
+// sendfileSpy stands in for the server's ResponseWriter, whose ReadFrom
+// uses sendfile when handed a file, alone or under an io.LimitedReader.
+type sendfileSpy struct {
+	*httptest.ResponseRecorder
+	calls, files int
+	bytes        int64
+}
+
+func (w *sendfileSpy) ReadFrom(src io.Reader) (int64, error) {
+	w.calls++
+	r := src
+	if lr, ok := r.(*io.LimitedReader); ok {
+		r = lr.R
+	}
+	if _, ok := r.(syscall.Conn); ok {
+		w.files++
+	}
+	n, err := io.Copy(writerOnly{w.ResponseRecorder}, src)
+	w.bytes += n
+	return n, err
+}
+
+func TestVideoReachesSendfile(t *testing.T) {
+	info, err := os.Stat(`../videos/` + sampleVideo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	video := videoHandler(log, discardEvents(), store.Dir(`../videos`), sampleVideo)
+	paced := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		video.ServeHTTP(newThrottledWriter(r.Context(), w, 64<<20, 256<<10), r)
+	})
+
+	for name, h := range map[string]http.Handler{`unpaced`: video, `paced`: paced} {
+		for _, rng := range []string{``, `bytes=1000-`} {
+			// The same middleware as Handler wraps every route in.
+			spy := &sendfileSpy{ResponseRecorder: httptest.NewRecorder()}
+			req := httptest.NewRequest(http.MethodGet, `/video`, nil)
+			if rng != `` {
+				req.Header.Set(`Range`, rng)
+			}
+			tracing.Middleware(logging.Middleware(log, h)).ServeHTTP(spy, req)
+
+			want := info.Size()
+			if rng != `` {
+				want -= 1000
+			}
+			if spy.calls == 0 || spy.files != spy.calls {
+				t.Errorf(`%s %q: %d of %d ReadFrom calls were handed the file`, name, rng, spy.files, spy.calls)
+			}
+			if spy.bytes != want || int64(spy.Body.Len()) != want {
+				t.Errorf(`%s %q: sent %d bytes through ReadFrom, %d in all; want %d`, name, rng, spy.bytes, spy.Body.Len(), want)
+			}
+		}
+	}
+}
+
+func TestThrottledReadFrom(t *testing.T) {
+	f, err := os.Open(`../videos/` + sampleVideo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	spy := &sendfileSpy{ResponseRecorder: httptest.NewRecorder()}
+	w := newThrottledWriter(context.Background(), spy, 20000, 10000)
+	src := &io.LimitedReader{R: f, N: 25000}
+	start := time.Now()
+	n, err := w.ReadFrom(src)
+	if err != nil || n != 25000 || src.N != 0 {
+		t.Fatalf(`ReadFrom = %d, %v with %d left`, n, err, src.N)
+	}
+	// Three bursts, each straight from the file; the first goes at once.
+	if spy.calls != 3 || spy.files != 3 {
+		t.Errorf(`%d ReadFrom calls, %d with the file; want 3`, spy.calls, spy.files)
+	}
+	if elapsed := time.Since(start); elapsed < 600*time.Millisecond {
+		t.Errorf(`sent 25000 bytes at 20000 B/s with a 10000 burst in %s`, elapsed)
+	}
+}
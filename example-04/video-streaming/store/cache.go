@@ -0,0 +1,304 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// chunkExt marks the files Cache owns inside its directory.
+const chunkExt = `.chunk`
+
+// CacheStats counts what a Cache has been doing.
+type CacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`
+	Invalidations int64 `json:"invalidations"`
+	// Chunks and Bytes are what is on disk now.
+	Chunks int   `json:"chunks"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// Cache is a VideoStore that keeps fixed-size chunks of another store's
+// videos on local disk, evicting the least recently used chunks once it
+// holds more than maxBytes. Concurrent misses on the same chunk share one
+// fetch. Invalidate drops a video whose contents have changed.
+type Cache struct {
+	backend   VideoStore
+	dir       string
+	chunkSize int64
+	maxBytes  int64
+	fetches   singleflight.Group
+
+	mu      sync.Mutex
+	lru     *list.List // of *chunk, most recently used first
+	chunks  map[string]*list.Element
+	videos  map[string]videoMeta
+	gens    map[string]uint64
+	bytes   int64
+	counted CacheStats
+}
+
+type chunk struct {
+	key, name, path string
+	size            int64
+}
+
+type videoMeta struct {
+	size int64
+	path string
+}
+
+// NewCache caches backend's videos in dir, which is created if needed.
+// Chunks left over from a previous run are deleted: we can't know whether
+// they were invalidated while we were down.
+func NewCache(backend VideoStore, dir string, chunkSize, maxBytes int64) (*Cache, error) {
+	if chunkSize <= 0 || maxBytes <= 0 {
+		return nil, errors.New(`cache: chunk size and limit must be positive`)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf(`cache: %w`, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf(`cache: %w`, err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), chunkExt) || strings.HasSuffix(e.Name(), chunkExt+`.tmp`) {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+
+	return &Cache{
+		backend:   backend,
+		dir:       dir,
+		chunkSize: chunkSize,
+		maxBytes:  maxBytes,
+		lru:       list.New(),
+		chunks:    map[string]*list.Element{},
+		videos:    map[string]videoMeta{},
+		gens:      map[string]uint64{},
+	}, nil
+}
+
+// Stats returns the cache's counters.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.counted
+	s.Chunks = c.lru.Len()
+	s.Bytes = c.bytes
+	return s
+}
+
+// Invalidate forgets everything cached for name. Fetches already in
+// flight finish but their chunks are discarded.
+func (c *Cache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gens[name]++
+	delete(c.videos, name)
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if ch := el.Value.(*chunk); ch.name == name {
+			c.remove(el)
+		}
+		el = next
+	}
+	c.counted.Invalidations++
+}
+
+// Open returns the video's size from the cache, asking the backend only
+// the first time.
+func (c *Cache) Open(ctx context.Context, name string) (Video, error) {
+	c.mu.Lock()
+	meta, found := c.videos[name]
+	gen := c.gens[name]
+	c.mu.Unlock()
+
+	if !found {
+		v, err := c.share(ctx, fmt.Sprintf(`open %s@%d`, name, gen), func(ctx context.Context) (any, error) {
+			v, err := c.backend.Open(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			defer v.Close()
+			meta := videoMeta{size: v.Size(), path: v.Path()}
+
+			c.mu.Lock()
+			if c.gens[name] == gen {
+				c.videos[name] = meta
+			}
+			c.mu.Unlock()
+			return meta, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		meta = v.(videoMeta)
+	}
+	return &cachedVideo{ctx: ctx, cache: c, name: name, gen: gen, meta: meta}, nil
+}
+
+// share runs fetch once for all concurrent callers with the same key. The
+// fetch isn't cancelled when the caller that started it goes away, since
+// others may be waiting on it; each caller stops waiting when its own ctx
+// is done.
+func (c *Cache) share(ctx context.Context, key string, fetch func(context.Context) (any, error)) (any, error) {
+	ch := c.fetches.DoChan(key, func() (any, error) {
+		return fetch(context.WithoutCancel(ctx))
+	})
+	select {
+	case res := <-ch:
+		return res.Val, res.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// chunk returns chunk idx of name, reading it from disk or fetching it.
+func (c *Cache) chunk(ctx context.Context, name string, gen uint64, idx, videoSize int64) ([]byte, error) {
+	key := fmt.Sprintf(`%s@%d#%d`, name, gen, idx)
+
+	c.mu.Lock()
+	if el, found := c.chunks[key]; found {
+		c.lru.MoveToFront(el)
+		path := el.Value.(*chunk).path
+		c.mu.Unlock()
+		if data, err := os.ReadFile(path); err == nil {
+			c.count(func(s *CacheStats) { s.Hits++ })
+			return data, nil
+		}
+		// Evicted since we looked; fetch it again.
+	} else {
+		c.mu.Unlock()
+	}
+	c.count(func(s *CacheStats) { s.Misses++ })
+
+	v, err := c.share(ctx, key, func(ctx context.Context) (any, error) {
+		src, err := c.backend.Open(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer src.Close()
+
+		off := idx * c.chunkSize
+		data := make([]byte, min(c.chunkSize, videoSize-off))
+		if n, err := src.ReadAt(data, off); n < len(data) {
+			if err == nil || errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf(`fetch %s chunk %d: %w`, name, idx, err)
+		}
+		if err := c.store(key, name, gen, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// store writes a fetched chunk to disk and makes room for it.
+func (c *Cache) store(key, name string, gen uint64, data []byte) error {
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(c.dir, hex.EncodeToString(sum[:])+chunkExt)
+
+	tmp, err := os.CreateTemp(c.dir, `*`+chunkExt+`.tmp`)
+	if err != nil {
+		return fmt.Errorf(`cache: %w`, err)
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf(`cache: %w`, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[name] != gen {
+		// Invalidated while we fetched.
+		os.Remove(path)
+		return nil
+	}
+	if _, found := c.chunks[key]; found {
+		return nil
+	}
+	c.chunks[key] = c.lru.PushFront(&chunk{key: key, name: name, path: path, size: int64(len(data))})
+	c.bytes += int64(len(data))
+	for c.bytes > c.maxBytes && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+		c.counted.Evictions++
+	}
+	return nil
+}
+
+// remove drops el from the cache and the disk. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	ch := c.lru.Remove(el).(*chunk)
+	delete(c.chunks, ch.key)
+	c.bytes -= ch.size
+	// A file we fail to remove is untracked now; NewCache cleans it up on
+	// the next start.
+	os.Remove(ch.path)
+}
+
+func (c *Cache) count(f func(*CacheStats)) {
+	c.mu.Lock()
+	f(&c.counted)
+	c.mu.Unlock()
+}
+
+type cachedVideo struct {
+	ctx   context.Context
+	cache *Cache
+	name  string
+	gen   uint64
+	meta  videoMeta
+}
+
+func (v *cachedVideo) Size() int64  { return v.meta.size }
+func (v *cachedVideo) Path() string { return v.meta.path }
+func (v *cachedVideo) Close() error { return nil }
+
+// ReadAt assembles p from the chunks it spans.
+func (v *cachedVideo) ReadAt(p []byte, off int64) (int, error) {
+	if off >= v.meta.size {
+		return 0, io.EOF
+	}
+	read := 0
+	for read < len(p) && off < v.meta.size {
+		idx := off / v.cache.chunkSize
+		data, err := v.cache.chunk(v.ctx, v.name, v.gen, idx, v.meta.size)
+		if err != nil {
+			return read, err
+		}
+		n := copy(p[read:], data[off-idx*v.cache.chunkSize:])
+		read += n
+		off += int64(n)
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
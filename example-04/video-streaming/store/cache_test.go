@@ -0,0 +1,215 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeStore serves in-memory videos and counts chunk fetches.
+type fakeStore struct {
+	mu     sync.Mutex
+	videos map[string][]byte
+	reads  atomic.Int64
+	// gate, if set, holds every ReadAt until it is closed.
+	gate chan struct{}
+}
+
+func (f *fakeStore) Open(_ context.Context, name string) (Video, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, found := f.videos[name]
+	if !found {
+		return nil, fs.ErrNotExist
+	}
+	return &fakeVideo{Reader: bytes.NewReader(data), store: f, name: name}, nil
+}
+
+func (f *fakeStore) set(name, data string) {
+	f.mu.Lock()
+	f.videos[name] = []byte(data)
+	f.mu.Unlock()
+}
+
+type fakeVideo struct {
+	*bytes.Reader
+	store *fakeStore
+	name  string
+}
+
+func (v *fakeVideo) ReadAt(p []byte, off int64) (int, error) {
+	if v.store.gate != nil {
+		<-v.store.gate
+	}
+	v.store.reads.Add(1)
+	return v.Reader.ReadAt(p, off)
+}
+func (v *fakeVideo) Path() string { return `fake://` + v.name }
+func (v *fakeVideo) Close() error { return nil }
+
+func readAll(t *testing.T, s VideoStore, name string) string {
+	t.Helper()
+	v, err := s.Open(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer v.Close()
+	data, err := io.ReadAll(io.NewSectionReader(v, 0, v.Size()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCacheReadsThroughAndHits(t *testing.T) {
+	backend := &fakeStore{videos: map[string][]byte{`a.mp4`: []byte(`0123456789`)}}
+	c, err := NewCache(backend, t.TempDir(), 4, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		if got := readAll(t, c, `a.mp4`); got != `0123456789` {
+			t.Fatalf(`read %q`, got)
+		}
+	}
+	if n := backend.reads.Load(); n != 3 {
+		t.Errorf(`backend read %d chunks, want 3`, n)
+	}
+
+	// A read spanning chunks, starting mid-chunk.
+	v, _ := c.Open(context.Background(), `a.mp4`)
+	p := make([]byte, 5)
+	if n, err := v.ReadAt(p, 3); n != 5 || err != nil || string(p) != `34567` {
+		t.Errorf(`ReadAt(5, 3) = %d, %v, %q`, n, err, p)
+	}
+
+	s := c.Stats()
+	if s.Misses != 3 || s.Hits != 5 || s.Chunks != 3 || s.Bytes != 10 {
+		t.Errorf(`Stats = %+v`, s)
+	}
+
+	if _, err := c.Open(context.Background(), `missing.mp4`); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`Open(missing) = %v, want fs.ErrNotExist`, err)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	backend := &fakeStore{videos: map[string][]byte{
+		`a.mp4`: []byte(`aaaa`),
+		`b.mp4`: []byte(`bbbb`),
+		`c.mp4`: []byte(`cccc`),
+	}}
+	c, err := NewCache(backend, t.TempDir(), 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readAll(t, c, `a.mp4`)
+	readAll(t, c, `b.mp4`)
+	readAll(t, c, `a.mp4`) // b is now the least recently used
+	readAll(t, c, `c.mp4`)
+
+	s := c.Stats()
+	if s.Evictions != 1 || s.Bytes != 8 {
+		t.Errorf(`Stats = %+v, want one eviction and 8 bytes`, s)
+	}
+	before := backend.reads.Load()
+	readAll(t, c, `a.mp4`)
+	if backend.reads.Load() != before {
+		t.Error(`a.mp4 was evicted instead of b.mp4`)
+	}
+	readAll(t, c, `b.mp4`)
+	if backend.reads.Load() != before+1 {
+		t.Error(`b.mp4 was not evicted`)
+	}
+}
+
+func TestCacheCoalescesMisses(t *testing.T) {
+	backend := &fakeStore{
+		videos: map[string][]byte{`a.mp4`: []byte(`abcd`)},
+		gate:   make(chan struct{}),
+	}
+	c, err := NewCache(backend, t.TempDir(), 4, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := readAll(t, c, `a.mp4`); got != `abcd` {
+				t.Errorf(`read %q`, got)
+			}
+		}()
+	}
+	// Let the readers pile up on the one fetch before releasing it.
+	time.Sleep(50 * time.Millisecond)
+	close(backend.gate)
+	wg.Wait()
+
+	if n := backend.reads.Load(); n != 1 {
+		t.Errorf(`backend read %d times, want 1`, n)
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	backend := &fakeStore{videos: map[string][]byte{`a.mp4`: []byte(`old!`)}}
+	c, err := NewCache(backend, t.TempDir(), 4, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readAll(t, c, `a.mp4`)
+	backend.set(`a.mp4`, `brand new`)
+	if got := readAll(t, c, `a.mp4`); got != `old!` {
+		t.Fatalf(`before invalidation read %q`, got)
+	}
+
+	c.Invalidate(`a.mp4`)
+	if got := readAll(t, c, `a.mp4`); got != `brand new` {
+		t.Errorf(`after invalidation read %q`, got)
+	}
+	if s := c.Stats(); s.Invalidations != 1 || s.Bytes != 9 {
+		t.Errorf(`Stats = %+v`, s)
+	}
+}
+
+func TestHTTPStore(t *testing.T) {
+	content := strings.Repeat(`0123456789`, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != `/bucket/a.mp4` {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeContent(w, r, `a.mp4`, time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	s, err := NewHTTP(srv.URL+`/bucket`, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, s, `a.mp4`); got != content {
+		t.Errorf(`read %q`, got)
+	}
+	v, _ := s.Open(context.Background(), `a.mp4`)
+	p := make([]byte, 4)
+	if n, err := v.ReadAt(p, 98); n != 2 || err != io.EOF || string(p[:n]) != `89` {
+		t.Errorf(`ReadAt past the end = %d, %v, %q`, n, err, p[:n])
+	}
+	if _, err := s.Open(context.Background(), `b.mp4`); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`Open(missing) = %v, want fs.ErrNotExist`, err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package store reads videos from wherever they live: a local directory,
+// a remote object store over HTTP, or either of those behind an on-disk
+// chunk cache.
+package store
+
+import (
+	"cmp"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// VideoStore opens videos by name. A missing video is reported with an
+// error wrapping fs.ErrNotExist.
+type VideoStore interface {
+	Open(ctx context.Context, name string) (Video, error)
+}
+
+// Video is an open video.
+type Video interface {
+	io.ReaderAt
+	io.Closer
+	// Size is the video's length in bytes.
+	Size() int64
+	// Path locates the video in its store, e.g. a file path or URL.
+	Path() string
+}
+
+// Content returns v for http.ServeContent. A local file is returned as
+// itself, so ranges are sought in the file and the copy to the client can
+// use sendfile; other videos are read through an io.SectionReader.
+func Content(v Video) io.ReadSeeker {
+	if f, ok := v.(*file); ok {
+		return f.File
+	}
+	return io.NewSectionReader(v, 0, v.Size())
+}
+
+// Invalidator is implemented by stores that cache videos and must be told
+// when one changes.
+type Invalidator interface {
+	Invalidate(name string)
+}
+
+// Library is implemented by stores whose videos can be listed, added and
+// removed through the admin API.
+type Library interface {
+	List(ctx context.Context) ([]Info, error)
+	// Put stores the video read from r as name, replacing any video of
+	// that name only once all of it has been read.
+	Put(ctx context.Context, name string, r io.Reader) (Info, error)
+	// Delete removes the named video, returning an error wrapping
+	// fs.ErrNotExist if there is none.
+	Delete(ctx context.Context, name string) error
+}
+
+// Info describes a stored video.
+type Info struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Dir serves videos from a local directory.
+type Dir string
+
+// Open opens the named file in d.
+func (d Dir) Open(_ context.Context, name string) (Video, error) {
+	path := filepath.Join(string(d), name)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &file{File: f, size: info.Size(), path: path}, nil
+}
+
+type file struct {
+	*os.File
+	size int64
+	path string
+}
+
+func (f *file) Size() int64  { return f.size }
+func (f *file) Path() string { return f.path }
+
+// List returns the videos in d by name. Hidden files, such as uploads in
+// progress, are left out.
+func (d Dir) List(context.Context) ([]Info, error) {
+	entries, err := os.ReadDir(string(d))
+	if err != nil {
+		return nil, err
+	}
+	var videos []Info
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), `.`) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, Info{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	slices.SortFunc(videos, func(a, b Info) int { return cmp.Compare(a.Name, b.Name) })
+	return videos, nil
+}
+
+// Put writes the video to a hidden file in d and renames it into place, so
+// readers never see half a video.
+func (d Dir) Put(_ context.Context, name string, r io.Reader) (Info, error) {
+	tmp, err := os.CreateTemp(string(d), `.`+name+`.*.upload`)
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(string(d), name)); err != nil {
+		return Info{}, err
+	}
+	return Info{Name: name, Size: size, Modified: time.Now()}, nil
+}
+
+// Delete removes the named file from d.
+func (d Dir) Delete(_ context.Context, name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
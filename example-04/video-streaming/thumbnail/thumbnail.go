@@ -0,0 +1,241 @@
+// Package thumbnail extracts preview frames from the videos in a
+// VideoStore: it finds the keyframe nearest a timestamp in the MP4 sample
+// tables, hands it to a Decoder and keeps the result on disk.
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/mp4"
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+	"golang.org/x/sync/singleflight"
+)
+
+// Frame is one encoded keyframe as an Annex B elementary stream, with
+// the parameter sets needed to decode it on its own.
+type Frame struct {
+	Codec string
+	Data  []byte
+}
+
+// Image is a Decoder's output.
+type Image struct {
+	ContentType string
+	Data        []byte
+}
+
+// Decoder turns a Frame into an image. Name distinguishes the cached
+// output of different decoders.
+type Decoder interface {
+	Name() string
+	Decode(ctx context.Context, f Frame) (Image, error)
+}
+
+// Raw returns the encoded frame itself, for clients that can decode H.264.
+type Raw struct{}
+
+func (Raw) Name() string { return `raw` }
+
+func (Raw) Decode(_ context.Context, f Frame) (Image, error) {
+	return Image{ContentType: `video/h264`, Data: f.Data}, nil
+}
+
+// FFmpeg decodes frames to JPEG by running the ffmpeg binary at Path.
+type FFmpeg struct {
+	Path string
+}
+
+func (FFmpeg) Name() string { return `ffmpeg-jpeg` }
+
+func (d FFmpeg) Decode(ctx context.Context, f Frame) (Image, error) {
+	cmd := exec.CommandContext(ctx, d.Path,
+		`-hide_banner`, `-loglevel`, `error`,
+		`-f`, `h264`, `-i`, `pipe:0`,
+		`-frames:v`, `1`, `-f`, `image2pipe`, `-c:v`, `mjpeg`, `pipe:1`)
+	cmd.Stdin = bytes.NewReader(f.Data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return Image{}, fmt.Errorf(`ffmpeg: %w: %s`, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return Image{ContentType: `image/jpeg`, Data: stdout.Bytes()}, nil
+}
+
+// ErrUnsupported is returned for videos whose codec we can't extract
+// frames from.
+var ErrUnsupported = errors.New(`thumbnail: unsupported video`)
+
+// decodeTimeout bounds one extraction. It runs detached from the request
+// that started it, since other requests may be waiting on the result.
+const decodeTimeout = 30 * time.Second
+
+// maxTracks bounds how many videos' sample tables are kept in memory.
+const maxTracks = 256
+
+// Extractor produces thumbnails, caching them under a directory.
+type Extractor struct {
+	videos  store.VideoStore
+	decoder Decoder
+	dir     string
+	group   singleflight.Group
+
+	// tracks memoizes each video's sample tables until Invalidate. gen
+	// counts invalidations, so tables read during one aren't kept.
+	mu     sync.Mutex
+	tracks map[string]*mp4.Track
+	gen    uint64
+}
+
+// New returns an extractor reading from videos and caching in dir, which
+// is created if needed.
+func New(videos store.VideoStore, decoder Decoder, dir string) (*Extractor, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf(`thumbnail: %w`, err)
+	}
+	return &Extractor{videos: videos, decoder: decoder, dir: dir, tracks: map[string]*mp4.Track{}}, nil
+}
+
+// Thumbnail returns the image of the keyframe nearest at in the video
+// named id. Timestamps that land on the same keyframe share a cache entry.
+func (e *Extractor) Thumbnail(ctx context.Context, id string, at time.Duration) (Image, error) {
+	track, err := e.track(ctx, id)
+	if err != nil {
+		return Image{}, err
+	}
+	sample, err := track.KeyframeNear(at)
+	if err != nil {
+		return Image{}, fmt.Errorf(`%w: %w`, ErrUnsupported, err)
+	}
+
+	path := filepath.Join(e.videoDir(id), fmt.Sprintf(`%d.%s`, sample.Index, e.decoder.Name()))
+	if img, err := readCached(path); err == nil {
+		return img, nil
+	}
+
+	// Callers share the extraction, so it mustn't end with the first
+	// one's request.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), decodeTimeout)
+	defer cancel()
+	v, err, _ := e.group.Do(path, func() (any, error) {
+		video, err := e.videos.Open(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		defer video.Close()
+		data, err := track.ReadSample(video, sample)
+		if err != nil {
+			return nil, err
+		}
+		stream, err := track.AnnexB(data)
+		if err != nil {
+			return nil, fmt.Errorf(`%w: %w`, ErrUnsupported, err)
+		}
+		img, err := e.decoder.Decode(ctx, Frame{Codec: track.Codec, Data: stream})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeCached(path, img); err != nil {
+			return nil, err
+		}
+		return img, nil
+	})
+	if err != nil {
+		return Image{}, err
+	}
+	return v.(Image), nil
+}
+
+// track returns id's sample tables, reading them on first use.
+func (e *Extractor) track(ctx context.Context, id string) (*mp4.Track, error) {
+	e.mu.Lock()
+	track, found := e.tracks[id]
+	gen := e.gen
+	e.mu.Unlock()
+	if found {
+		return track, nil
+	}
+
+	video, err := e.videos.Open(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer video.Close()
+	track, err = mp4.ReadVideoTrack(video, video.Size())
+	if err != nil {
+		return nil, fmt.Errorf(`%w: %w`, ErrUnsupported, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.gen == gen {
+		if len(e.tracks) >= maxTracks {
+			for other := range e.tracks {
+				delete(e.tracks, other)
+				break
+			}
+		}
+		e.tracks[id] = track
+	}
+	return track, nil
+}
+
+// Invalidate forgets id's sample tables and deletes its cached thumbnails.
+func (e *Extractor) Invalidate(id string) {
+	e.mu.Lock()
+	delete(e.tracks, id)
+	e.gen++
+	e.mu.Unlock()
+	os.RemoveAll(e.videoDir(id))
+}
+
+// videoDir holds id's thumbnails; the name is hashed so ids never need
+// escaping.
+func (e *Extractor) videoDir(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(e.dir, hex.EncodeToString(sum[:]))
+}
+
+// A cached image is stored as its content type, a newline, then the data.
+func readCached(path string) (Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Image{}, err
+	}
+	contentType, body, found := bytes.Cut(data, []byte{'\n'})
+	if !found {
+		return Image{}, fs.ErrNotExist
+	}
+	return Image{ContentType: string(contentType), Data: body}, nil
+}
+
+func writeCached(path string, img Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), `*.tmp`)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append([]byte(img.ContentType+"\n"), img.Data...))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/example-4/video-streaming/store"
+)
+
+// countingDecoder wraps Raw and counts calls. Like a real decoder, it
+// gives up once ctx is done.
+type countingDecoder struct {
+	Raw
+	calls int
+}
+
+func (d *countingDecoder) Decode(ctx context.Context, f Frame) (Image, error) {
+	d.calls++
+	if err := ctx.Err(); err != nil {
+		return Image{}, err
+	}
+	return d.Raw.Decode(ctx, f)
+}
+
+// countingStore counts the videos opened.
+type countingStore struct {
+	store.VideoStore
+	opens int
+}
+
+func (s *countingStore) Open(ctx context.Context, name string) (store.Video, error) {
+	s.opens++
+	return s.VideoStore.Open(ctx, name)
+}
+
+func TestThumbnailIsCached(t *testing.T) {
+	decoder := &countingDecoder{}
+	videos := &countingStore{VideoStore: store.Dir(`../videos`)}
+	e, err := New(videos, decoder, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	img, err := e.Thumbnail(ctx, `SampleVideo_1280x720_1mb.mp4`, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.ContentType != `video/h264` || !bytes.HasPrefix(img.Data, []byte{0, 0, 0, 1}) {
+		t.Errorf(`got %s starting % x`, img.ContentType, img.Data[:min(len(img.Data), 8)])
+	}
+
+	// Another timestamp on the same keyframe comes from the cache.
+	again, err := e.Thumbnail(ctx, `SampleVideo_1280x720_1mb.mp4`, 4*time.Second)
+	if err != nil || !bytes.Equal(again.Data, img.Data) || again.ContentType != img.ContentType {
+		t.Errorf(`cached thumbnail differs: %v`, err)
+	}
+	if decoder.calls != 1 {
+		t.Errorf(`decoded %d times, want 1`, decoder.calls)
+	}
+	// The sample tables were read once, the frame once, and the cached
+	// thumbnail needed neither.
+	if videos.opens != 2 {
+		t.Errorf(`opened the video %d times, want 2`, videos.opens)
+	}
+
+	// A request that has gone away doesn't abandon an extraction others
+	// may be waiting on.
+	e.Invalidate(`SampleVideo_1280x720_1mb.mp4`)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := e.Thumbnail(canceled, `SampleVideo_1280x720_1mb.mp4`, 0); err != nil {
+		t.Errorf(`with a canceled context: %v`, err)
+	}
+
+	e.Invalidate(`SampleVideo_1280x720_1mb.mp4`)
+	if _, err := e.Thumbnail(ctx, `SampleVideo_1280x720_1mb.mp4`, 0); err != nil {
+		t.Fatal(err)
+	}
+	if decoder.calls != 3 {
+		t.Errorf(`decoded %d times after invalidation, want 3`, decoder.calls)
+	}
+
+	if _, err := e.Thumbnail(ctx, `missing.mp4`, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf(`missing video: %v, want fs.ErrNotExist`, err)
+	}
+}
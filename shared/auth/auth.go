@@ -0,0 +1,267 @@
+// Package auth verifies the bearer tokens callers present to our HTTP APIs.
+// Tokens are JWTs signed with a shared HMAC secret or with an RSA key
+// published in a JSON Web Key Set file; the verified identity is stored in
+// the request context for handlers to read with Subject, Plan and Admin.
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Config selects the keys tokens are verified with. Leaving both HMACSecret
+// and JWKSFile empty disables authentication.
+type Config struct {
+	// HMACSecret verifies HS256, HS384 and HS512 tokens without a kid.
+	HMACSecret string `env:"AUTH_HMAC_SECRET" secret:"true"`
+	// JWKSFile holds RSA and oct keys, picked by the token's kid.
+	JWKSFile string `env:"AUTH_JWKS_FILE"`
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string `env:"AUTH_ISSUER"`
+	Audience string `env:"AUTH_AUDIENCE"`
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration `env:"AUTH_LEEWAY" default:"30s"`
+}
+
+// Enabled reports whether any key is configured.
+func (c Config) Enabled() bool {
+	return c.HMACSecret != `` || c.JWKSFile != ``
+}
+
+var (
+	hmacMethods = []string{`HS256`, `HS384`, `HS512`}
+	rsaMethods  = []string{`RS256`, `RS384`, `RS512`, `PS256`, `PS384`, `PS512`}
+)
+
+// Verifier checks tokens against a fixed set of keys.
+type Verifier struct {
+	secret []byte
+	keys   map[string]any // kid -> []byte or *rsa.PublicKey
+	parser *jwt.Parser
+}
+
+// NewVerifier loads the keys named by cfg. It returns nil, and no error,
+// when cfg is not Enabled; Middleware then lets every request through.
+func NewVerifier(cfg Config) (*Verifier, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	v := &Verifier{keys: map[string]any{}}
+	if cfg.HMACSecret != `` {
+		v.secret = []byte(cfg.HMACSecret)
+	}
+	if cfg.JWKSFile != `` {
+		keys, err := readJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = keys
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(slices.Concat(hmacMethods, rsaMethods)),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(cfg.Leeway),
+	}
+	if cfg.Issuer != `` {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != `` {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+// Identity is who a verified token speaks for.
+type Identity struct {
+	Subject string
+	// Plan is the subscription plan from the token's plan claim, if any.
+	Plan string
+	// Admin is set by the token's admin claim and allows managing the
+	// platform, not just watching.
+	Admin bool
+}
+
+// claims are the JWT claims we read.
+type claims struct {
+	jwt.RegisteredClaims
+	Plan  string `json:"plan,omitempty"`
+	Admin bool   `json:"admin,omitempty"`
+}
+
+// Verify checks the token's signature and claims and returns who it
+// speaks for.
+func (v *Verifier) Verify(token string) (Identity, error) {
+	var c claims
+	if _, err := v.parser.ParseWithClaims(token, &c, v.key); err != nil {
+		return Identity{}, err
+	}
+	if c.Subject == `` {
+		return Identity{}, errors.New(`token has no subject`)
+	}
+	return Identity{Subject: c.Subject, Plan: c.Plan, Admin: c.Admin}, nil
+}
+
+// Sign returns an HS256 token for id, valid for ttl, that a Verifier
+// configured with secret accepts. It is for tools such as the admin CLI
+// that share the services' secret; users get their tokens elsewhere.
+func Sign(secret string, id Identity, ttl time.Duration) (string, error) {
+	if id.Subject == `` {
+		return ``, errors.New(`token needs a subject`)
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   id.Subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Plan:  id.Plan,
+		Admin: id.Admin,
+	})
+	return token.SignedString([]byte(secret))
+}
+
+// key picks the verification key for t. The key type must agree with the
+// signing method so an RSA public key can never be used as an HMAC secret.
+func (v *Verifier) key(t *jwt.Token) (any, error) {
+	var key any
+	if kid, _ := t.Header[`kid`].(string); kid != `` {
+		k, found := v.keys[kid]
+		if !found {
+			return nil, fmt.Errorf(`unknown kid %q`, kid)
+		}
+		key = k
+	} else if v.secret != nil {
+		key = v.secret
+	} else if len(v.keys) == 1 {
+		for _, k := range v.keys {
+			key = k
+		}
+	} else {
+		return nil, errors.New(`token has no kid`)
+	}
+
+	switch key.(type) {
+	case []byte:
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			return key, nil
+		}
+	case *rsa.PublicKey:
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf(`key does not accept %s`, t.Method.Alg())
+}
+
+// jwk is the subset of RFC 7517 we understand.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	K   string `json:"k"`
+}
+
+func readJWKS(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(`auth: %w`, err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf(`auth: %s: %w`, path, err)
+	}
+
+	keys := map[string]any{}
+	for i, k := range set.Keys {
+		if k.Use != `` && k.Use != `sig` {
+			continue
+		}
+		key, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf(`auth: %s: key %d: %w`, path, i, err)
+		}
+		if _, dup := keys[k.Kid]; dup {
+			return nil, fmt.Errorf(`auth: %s: duplicate kid %q`, path, k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf(`auth: %s: no signing keys`, path)
+	}
+	return keys, nil
+}
+
+func (k jwk) parse() (any, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case `RSA`:
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf(`n: %w`, err)
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf(`e: %w`, err)
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New(`e: bad exponent`)
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case `oct`:
+		secret, err := b64.DecodeString(k.K)
+		if err != nil {
+			return nil, fmt.Errorf(`k: %w`, err)
+		}
+		return secret, nil
+	default:
+		return nil, fmt.Errorf(`unsupported kty %q`, k.Kty)
+	}
+}
+
+type identityKey struct{}
+
+// WithIdentity returns ctx carrying the authenticated identity.
+func WithIdentity(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// Subject returns the authenticated subject in ctx, or "" if the request
+// was not authenticated.
+func Subject(ctx context.Context) string {
+	id, _ := ctx.Value(identityKey{}).(Identity)
+	return id.Subject
+}
+
+// Plan returns the authenticated caller's plan, or "" if it has none.
+func Plan(ctx context.Context) string {
+	id, _ := ctx.Value(identityKey{}).(Identity)
+	return id.Plan
+}
+
+// Admin reports whether the authenticated caller in ctx is an admin.
+func Admin(ctx context.Context) bool {
+	id, _ := ctx.Value(identityKey{}).(Identity)
+	return id.Admin
+}
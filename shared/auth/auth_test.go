@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != `` {
+		token.Header[`kid`] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func registered(sub string) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   sub,
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	v, err := NewVerifier(Config{HMACSecret: `s3cret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := v.Verify(sign(t, jwt.SigningMethodHS256, ``, []byte(`s3cret`), registered(`alice`)))
+	if err != nil || id.Subject != `alice` {
+		t.Errorf(`Verify = %+v, %v; want alice`, id, err)
+	}
+
+	for name, token := range map[string]string{
+		`wrong secret`: sign(t, jwt.SigningMethodHS256, ``, []byte(`guess`), registered(`alice`)),
+		`no subject`:   sign(t, jwt.SigningMethodHS256, ``, []byte(`s3cret`), registered(``)),
+		`expired`: sign(t, jwt.SigningMethodHS256, ``, []byte(`s3cret`), jwt.RegisteredClaims{
+			Subject:   `alice`,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		}),
+		`no expiry`: sign(t, jwt.SigningMethodHS256, ``, []byte(`s3cret`), jwt.RegisteredClaims{Subject: `alice`}),
+		`alg none`:  sign(t, jwt.SigningMethodNone, ``, jwt.UnsafeAllowNoneSignatureType, registered(`alice`)),
+	} {
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf(`%s: verified`, name)
+		}
+	}
+}
+
+func TestVerifyJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding
+	set := map[string]any{`keys`: []map[string]string{{
+		`kty`: `RSA`,
+		`kid`: `rsa-1`,
+		`n`:   b64.EncodeToString(key.N.Bytes()),
+		`e`:   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}, {
+		`kty`: `oct`,
+		`kid`: `oct-1`,
+		`k`:   b64.EncodeToString([]byte(`shared`)),
+	}}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), `jwks.json`)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier(Config{JWKSFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := v.Verify(sign(t, jwt.SigningMethodRS256, `rsa-1`, key, registered(`bob`))); err != nil || id.Subject != `bob` {
+		t.Errorf(`RS256: Verify = %+v, %v; want bob`, id, err)
+	}
+	if id, err := v.Verify(sign(t, jwt.SigningMethodHS256, `oct-1`, []byte(`shared`), registered(`carol`))); err != nil || id.Subject != `carol` {
+		t.Errorf(`HS256: Verify = %+v, %v; want carol`, id, err)
+	}
+	if _, err := v.Verify(sign(t, jwt.SigningMethodRS256, `rsa-2`, key, registered(`bob`))); err == nil {
+		t.Error(`unknown kid verified`)
+	}
+	// An HMAC token must not verify against the RSA key, even if the
+	// attacker signs with the public key's bytes.
+	if _, err := v.Verify(sign(t, jwt.SigningMethodHS256, `rsa-1`, key.N.Bytes(), registered(`mallory`))); err == nil {
+		t.Error(`HS256 verified against an RSA key`)
+	}
+}
+
+func TestVerifyPlan(t *testing.T) {
+	v, err := NewVerifier(Config{HMACSecret: `s3cret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		`sub`:  `alice`,
+		`plan`: `premium`,
+		`exp`:  time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(`s3cret`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := v.Verify(token); err != nil || id != (Identity{Subject: `alice`, Plan: `premium`}) {
+		t.Errorf(`Verify = %+v, %v; want alice on premium`, id, err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	v, err := NewVerifier(Config{HMACSecret: `s3cret`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Subject: `ops`, Admin: true}
+	token, err := Sign(`s3cret`, want, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := v.Verify(token); err != nil || id != want {
+		t.Errorf(`Verify = %+v, %v; want %+v`, id, err, want)
+	}
+
+	expired, err := Sign(`s3cret`, want, -time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(expired); err == nil {
+		t.Error(`expired token verified`)
+	}
+	if _, err := Sign(`s3cret`, Identity{}, time.Minute); err == nil {
+		t.Error(`signed a token without a subject`)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	v, err := NewVerifier(Config{})
+	if v != nil || err != nil {
+		t.Errorf(`NewVerifier(Config{}) = %v, %v; want nil, nil`, v, err)
+	}
+}
+
+func TestRequireAdminWithoutVerifier(t *testing.T) {
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// Without a verifier anyone may use the API, but nobody is an admin.
+	rec := httptest.NewRecorder()
+	Middleware(log, nil, ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, `/`, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf(`Middleware(nil): status %d, want 200`, rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	RequireAdmin(log, nil, ok).ServeHTTP(rec, httptest.NewRequest(http.MethodPut, `/`, nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf(`RequireAdmin(nil): status %d, want 403`, rec.Code)
+	}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+)
+
+// Middleware rejects requests without a valid "Authorization: Bearer"
+// token with 401 and stores the token's identity in the request context.
+// A nil v lets every request through unauthenticated.
+func Middleware(log *slog.Logger, v *Verifier, next http.Handler) http.Handler {
+	if v == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get(`Authorization`), `Bearer `)
+		if !found || token == `` {
+			w.Header().Set(`WWW-Authenticate`, `Bearer`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		id, err := v.Verify(token)
+		if err != nil {
+			log.InfoContext(r.Context(), `rejected token`, logging.KeyError, err)
+			w.Header().Set(`WWW-Authenticate`, `Bearer error="invalid_token"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// RequireAdmin is Middleware that also answers 403 to callers whose token
+// lacks the admin claim. With a nil v nobody can prove they are an admin,
+// so every request gets 403: unlike Middleware, it fails closed.
+func RequireAdmin(log *slog.Logger, v *Verifier, next http.Handler) http.Handler {
+	if v == nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		})
+	}
+	return Middleware(log, v, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Admin(r.Context()) {
+			log.InfoContext(r.Context(), `refused non-admin`, `subject`, Subject(r.Context()))
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,250 @@
+// Package config loads a service's typed configuration from the environment
+// and an optional JSON file, applies defaults and validates the result.
+//
+// Configuration structs describe themselves with field tags:
+//
+//	type config struct {
+//		Port   int    `env:"PORT" required:"true" validate:"port"`
+//		Rabbit string `env:"RABBIT" required:"true" validate:"url" secret:"userinfo"`
+//		Log    logging.Config
+//	}
+//
+//   - env names the variable, and the key in the JSON file.
+//   - default is used when neither the file nor the environment sets it.
+//   - required rejects an empty value.
+//   - validate is one of port, url or oneof=a b c.
+//   - secret is true to hide the whole value when printing, or userinfo to
+//     hide only the password inside a URL.
+//
+// Nested structs without an env tag are walked recursively. Rules that span
+// several fields go in a Validate method on the struct; its error is
+// reported alongside the others.
+//
+// Every example-04 service, and example-02's, loads its settings this way.
+// Examples 01 and 03 are self-contained snapshots of earlier chapters,
+// built without this module, and keep reading the few variables they need
+// directly.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileEnv names the variable pointing at an optional JSON file whose keys
+// are the same names as the environment variables, e.g. {"PORT": "80"}.
+// Values in the environment win over values in the file.
+const FileEnv = `CONFIG_FILE`
+
+// validator is implemented by configuration structs with cross-field
+// rules.
+type validator interface {
+	Validate() error
+}
+
+// Load fills dst, which must be a pointer to a struct, and returns every
+// problem it finds joined into a single error.
+func Load(dst any) error {
+	return LoadFrom(dst, os.LookupEnv)
+}
+
+// LoadFrom is Load with a custom variable lookup, for tests.
+func LoadFrom(dst any, lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf(`config: expected pointer to struct, got %T`, dst)
+	}
+
+	file := map[string]string{}
+	if path, found := lookup(FileEnv); found && path != `` {
+		var err error
+		if file, err = readFile(path); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	walk(v.Elem(), func(f reflect.StructField, fv reflect.Value) {
+		name := f.Tag.Get(`env`)
+		raw, found := lookup(name)
+		if !found {
+			raw, found = file[name]
+		}
+		if !found || raw == `` {
+			raw = f.Tag.Get(`default`)
+		}
+
+		if raw == `` {
+			if f.Tag.Get(`required`) == `true` {
+				errs = append(errs, fmt.Errorf(`%s is required`, name))
+			}
+			return
+		}
+
+		if err := set(fv, raw); err != nil {
+			errs = append(errs, fmt.Errorf(`%s: %w`, name, err))
+			return
+		}
+		canonical, err := validate(f.Tag.Get(`validate`), raw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(`%s: %w`, name, err))
+			return
+		}
+		if canonical != raw {
+			set(fv, canonical)
+		}
+	})
+
+	if cv, ok := dst.(validator); ok {
+		if err := cv.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func readFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(`%s: %w`, FileEnv, err)
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf(`%s %s: %w`, FileEnv, path, err)
+	}
+
+	// Accept numbers and booleans as well as strings so the file can be
+	// written naturally.
+	file := make(map[string]string, len(raw))
+	for k, v := range raw {
+		switch v := v.(type) {
+		case string:
+			file[k] = v
+		case []any:
+			parts := make([]string, len(v))
+			for i, p := range v {
+				parts[i] = fmt.Sprint(p)
+			}
+			file[k] = strings.Join(parts, `,`)
+		default:
+			file[k] = fmt.Sprint(v)
+		}
+	}
+	return file, nil
+}
+
+// walk calls fn for every field carrying an env tag, descending into nested
+// structs.
+func walk(v reflect.Value, fn func(reflect.StructField, reflect.Value)) {
+	t := v.Type()
+	for i := range t.NumField() {
+		f, fv := t.Field(i), v.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if _, ok := f.Tag.Lookup(`env`); ok {
+			fn(f, fv)
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct {
+			walk(fv, fn)
+		}
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func set(fv reflect.Value, raw string) error {
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf(`%q is not a number`, raw)
+		}
+		fv.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf(`%q is not a number`, raw)
+		}
+		fv.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf(`%q is not a boolean`, raw)
+		}
+		fv.SetBool(b)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf(`unsupported field type %s`, fv.Type())
+		}
+		var parts []string
+		for _, p := range strings.Split(raw, `,`) {
+			if p = strings.TrimSpace(p); p != `` {
+				parts = append(parts, p)
+			}
+		}
+		fv.Set(reflect.ValueOf(parts))
+	default:
+		return fmt.Errorf(`unsupported field type %s`, fv.Type())
+	}
+	return nil
+}
+
+// validate checks raw against rule and returns it in canonical form: a
+// oneof option matches whatever its case, and is returned as written in
+// the rule so the field can be compared with it exactly.
+func validate(rule, raw string) (string, error) {
+	switch {
+	case rule == ``:
+		return raw, nil
+	case rule == `port`:
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > 65535 {
+			return ``, fmt.Errorf(`%q is not a valid port number`, raw)
+		}
+	case rule == `url`:
+		// url.Parse errors quote the input, which may hold a password.
+		u, err := url.Parse(raw)
+		if err != nil {
+			return ``, errors.New(`not a valid URL`)
+		}
+		if u.Scheme == `` || u.Host == `` {
+			return ``, fmt.Errorf(`%q is not an absolute URL`, redactURL(raw))
+		}
+	case strings.HasPrefix(rule, `oneof=`):
+		options := strings.Fields(strings.TrimPrefix(rule, `oneof=`))
+		for _, o := range options {
+			if strings.EqualFold(o, raw) {
+				return o, nil
+			}
+		}
+		return ``, fmt.Errorf(`%q must be one of %s`, raw, strings.Join(options, `, `))
+	default:
+		return ``, fmt.Errorf(`unknown validation rule %q`, rule)
+	}
+	return raw, nil
+}
@@ -0,0 +1,17 @@
+package httpx
+
+import (
+	"net"
+	"net/http"
+)
+
+// ClientIP returns the address of the peer that sent r. Forwarded headers
+// are ignored since any client can set them; put a proxy that rewrites
+// RemoteAddr in front if the services sit behind a load balancer.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
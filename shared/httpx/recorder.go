@@ -0,0 +1,88 @@
+// Package httpx holds small net/http helpers shared by the example-04
+// microservices' middleware.
+package httpx
+
+import (
+	"io"
+	"net/http"
+)
+
+// Recorder wraps an http.ResponseWriter and remembers the status code and
+// number of body bytes written, for middleware that reports on a request
+// after the handler has returned.
+type Recorder struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+	// Err is the first error writing the body, usually the client going
+	// away; http.ServeContent doesn't return it.
+	Err error
+
+	route       string
+	wroteHeader bool
+}
+
+// NewRecorder wraps w. If w is already a *Recorder it is returned as is so
+// stacked middleware share one set of counters.
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	if rec, ok := w.(*Recorder); ok {
+		return rec
+	}
+	return &Recorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+// WriteHeader records status and forwards it.
+func (r *Recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.Status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write counts the bytes written and remembers any error.
+func (r *Recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	r.fail(err)
+	return n, err
+}
+
+// ReadFrom counts the bytes copied from src and hands the copy to the
+// underlying writer's ReadFrom where it has one, so io.Copy from a file
+// through middleware still reaches sendfile on the connection.
+func (r *Recorder) ReadFrom(src io.Reader) (int64, error) {
+	r.wroteHeader = true
+	var n int64
+	var err error
+	if rf, ok := r.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = io.Copy(r.ResponseWriter, src)
+	}
+	r.Bytes += n
+	r.fail(err)
+	return n, err
+}
+
+func (r *Recorder) fail(err error) {
+	if r.Err == nil {
+		r.Err = err
+	}
+}
+
+// Route returns the ServeMux pattern that matched req. The mux sets
+// req.Pattern on the copy of the request it was handed, which outer
+// middleware never sees, so the first non-empty pattern is remembered here.
+func (r *Recorder) Route(req *http.Request) string {
+	if req.Pattern != `` {
+		r.route = req.Pattern
+	}
+	return r.route
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *Recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,59 @@
+package httpx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readerFromWriter is a ResponseWriter with its own ReadFrom, like the
+// server's, recording what it was handed.
+type readerFromWriter struct {
+	*httptest.ResponseRecorder
+	src io.Reader
+}
+
+func (w *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.src = src
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+func TestRecorderReadFrom(t *testing.T) {
+	inner := &readerFromWriter{ResponseRecorder: httptest.NewRecorder()}
+	// Stacked recorders share one, as logging and tracing middleware do.
+	rec := NewRecorder(NewRecorder(inner))
+
+	src := io.LimitReader(strings.NewReader(`hello, world`), 5)
+	n, err := io.Copy(rec, src)
+	if err != nil || n != 5 {
+		t.Fatalf(`io.Copy = %d, %v; want 5`, n, err)
+	}
+	if inner.src != src {
+		t.Errorf(`underlying ReadFrom got %T, want the source itself`, inner.src)
+	}
+	if rec.Bytes != 5 || rec.Status != http.StatusOK || inner.Body.String() != `hello` {
+		t.Errorf(`recorded %d bytes, status %d, body %q`, rec.Bytes, rec.Status, inner.Body)
+	}
+
+	// Without a ReadFrom underneath, bytes are still counted.
+	plain := NewRecorder(httptest.NewRecorder())
+	if n, err := plain.ReadFrom(strings.NewReader(`abc`)); n != 3 || err != nil || plain.Bytes != 3 {
+		t.Errorf(`ReadFrom = %d, %v with %d bytes recorded`, n, err, plain.Bytes)
+	}
+}
+
+// failingWriter is a ResponseWriter whose client has gone away.
+type failingWriter struct{ *httptest.ResponseRecorder }
+
+func (failingWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestRecorderErr(t *testing.T) {
+	rec := NewRecorder(failingWriter{httptest.NewRecorder()})
+	rec.Write([]byte(`a`))
+	rec.ReadFrom(strings.NewReader(`bc`))
+	if rec.Err != io.ErrClosedPipe {
+		t.Errorf(`Err = %v, want %v`, rec.Err, io.ErrClosedPipe)
+	}
+}
@@ -0,0 +1,42 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ShutdownTimeout bounds how long in-flight requests get to finish once
+// Serve's context is cancelled.
+const ShutdownTimeout = 10 * time.Second
+
+// Serve runs srv until ctx is cancelled and then shuts it down gracefully,
+// over TLS if tlsx.Secure gave it a TLS config. It returns nil after a clean
+// shutdown and the listener's error otherwise.
+func Serve(ctx context.Context, srv *http.Server) error {
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errs <- srv.ListenAndServeTLS(``, ``)
+			return
+		}
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package logging builds the slog loggers used by the example-04
+// microservices and the HTTP middleware that logs each request under a
+// correlation id.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys shared by every service so log lines can be joined up.
+const (
+	KeyService   = `service`
+	KeyRequestID = `request_id`
+	KeyTraceID   = `trace_id`
+	KeyMethod    = `method`
+	KeyRoute     = `route`
+	KeyPath      = `path`
+	KeyStatus    = `status`
+	KeyBytes     = `bytes`
+	KeyDuration  = `duration`
+	KeyError     = `err`
+)
+
+// Formats accepted by LOG_FORMAT.
+const (
+	FormatText = `text`
+	FormatJSON = `json`
+)
+
+// Config selects the handler and minimum level; see package config.
+type Config struct {
+	Format string `env:"LOG_FORMAT" default:"text" validate:"oneof=text json"`
+	Level  string `env:"LOG_LEVEL" default:"info" validate:"oneof=debug info warn error"`
+}
+
+// New returns a logger for service writing to w. Records logged with a
+// context carrying a request id (see WithRequestID) or a sampled span get
+// request_id and trace_id attributes automatically.
+func New(w io.Writer, service string, cfg Config) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != `` {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf(`LOG_LEVEL: %w`, err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case ``, FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf(`LOG_FORMAT: unknown format %q`, cfg.Format)
+	}
+
+	return slog.New(contextHandler{h}).With(KeyService, service), nil
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id carried by ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request and trace ids found in the record's
+// context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != `` {
+		r.AddAttrs(slog.String(KeyRequestID, id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String(KeyTraceID, sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+)
+
+// RequestIDHeader carries the correlation id between services and back to
+// the client.
+const RequestIDHeader = `X-Request-ID`
+
+// maxRequestIDLen bounds ids we accept from callers so they can't bloat our
+// logs.
+const maxRequestIDLen = 128
+
+// Middleware assigns every request a correlation id, reusing the caller's
+// X-Request-ID when it looks sane, stores it in the request context and
+// echoes it in the response. Once the handler returns it logs one line with
+// the method, route, status, bytes written and duration.
+func Middleware(log *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		rec := httpx.NewRecorder(w)
+		r = r.WithContext(WithRequestID(r.Context(), id))
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		if rec.Status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		log.LogAttrs(r.Context(), level, `http request`,
+			slog.String(KeyMethod, r.Method),
+			slog.String(KeyRoute, rec.Route(r)),
+			slog.String(KeyPath, r.URL.Path),
+			slog.Int(KeyStatus, rec.Status),
+			slog.Int64(KeyBytes, rec.Bytes),
+			slog.Duration(KeyDuration, time.Since(start)),
+		)
+	})
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+func validRequestID(id string) bool {
+	if id == `` || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
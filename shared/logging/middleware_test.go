@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// serve runs one request through Middleware around handler, returning the
+// response and the access log line as decoded JSON.
+func serve(t *testing.T, handler http.HandlerFunc, requestID string) (*httptest.ResponseRecorder, map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	log, err := New(&buf, `test`, Config{Format: FormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(`GET /videos/{id}`, handler)
+
+	req := httptest.NewRequest(http.MethodGet, `/videos/1`, nil)
+	if requestID != `` {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	w := httptest.NewRecorder()
+	Middleware(log, mux).ServeHTTP(w, req)
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf(`access log %q: %v`, buf.String(), err)
+	}
+	return w, line
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var seen string
+	handler := func(w http.ResponseWriter, r *http.Request) { seen = RequestID(r.Context()) }
+
+	// The caller's id is used throughout.
+	w, line := serve(t, handler, `abc-123`)
+	if seen != `abc-123` || w.Header().Get(RequestIDHeader) != `abc-123` || line[KeyRequestID] != `abc-123` {
+		t.Errorf(`handler saw %q, response has %q, log has %v; want abc-123`, seen, w.Header().Get(RequestIDHeader), line[KeyRequestID])
+	}
+
+	// Missing or unreasonable ids are replaced with a fresh one.
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	for _, sent := range []string{``, `has spaces`, `<script>`, strings.Repeat(`a`, maxRequestIDLen+1)} {
+		w, line := serve(t, handler, sent)
+		id := w.Header().Get(RequestIDHeader)
+		if !generated.MatchString(id) || seen != id || line[KeyRequestID] != id {
+			t.Errorf(`sent %q: response has %q, handler saw %q, log has %v; want one generated id`, sent, id, seen, line[KeyRequestID])
+		}
+	}
+	first, _ := serve(t, handler, ``)
+	second, _ := serve(t, handler, ``)
+	if first.Header().Get(RequestIDHeader) == second.Header().Get(RequestIDHeader) {
+		t.Error(`two requests were given the same id`)
+	}
+}
+
+func TestMiddlewareRecordsResponse(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		handler http.HandlerFunc
+		status  int
+		bytes   int
+		level   string
+	}{
+		{`write without WriteHeader`, func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, `hello`) }, 200, 5, `INFO`},
+		{`nothing written`, func(w http.ResponseWriter, r *http.Request) {}, 200, 0, `INFO`},
+		{`explicit status`, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusPartialContent)
+			io.WriteString(w, `part`)
+			// A second WriteHeader doesn't change what was sent.
+			w.WriteHeader(http.StatusTeapot)
+		}, 206, 4, `INFO`},
+		{`server error`, func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `down`, http.StatusServiceUnavailable)
+		}, 503, len("down\n"), `ERROR`},
+	} {
+		w, line := serve(t, c.handler, ``)
+		if w.Code != c.status {
+			t.Errorf(`%s: response status %d, want %d`, c.name, w.Code, c.status)
+		}
+		if line[KeyStatus] != float64(c.status) || line[KeyBytes] != float64(c.bytes) || line[`level`] != c.level {
+			t.Errorf(`%s: logged status %v bytes %v level %v; want %d, %d, %s`, c.name, line[KeyStatus], line[KeyBytes], line[`level`], c.status, c.bytes, c.level)
+		}
+		if line[KeyRoute] != `GET /videos/{id}` || line[KeyPath] != `/videos/1` || line[KeyMethod] != `GET` || line[KeyService] != `test` {
+			t.Errorf(`%s: logged %v`, c.name, line)
+		}
+		if _, ok := line[KeyDuration]; !ok {
+			t.Errorf(`%s: no duration logged`, c.name)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DedupWindow is how many message IDs a consumer remembers by default.
+const DedupWindow = 10000
+
+// Dedup remembers the IDs of the last messages a consumer took, so an
+// event that reaches a queue twice, once through the topic exchange and
+// once through a legacy fanout still bound to it, is only handled once.
+// It sees one process's deliveries: two replicas that each take a copy
+// both handle it.
+type Dedup struct {
+	mu    sync.Mutex
+	seen  map[string]bool
+	order []string
+	next  int
+}
+
+// NewDedup returns a Dedup remembering the last n message IDs.
+func NewDedup(n int) *Dedup {
+	return &Dedup{seen: make(map[string]bool, n), order: make([]string, max(n, 1))}
+}
+
+// Claim reports whether id hasn't been seen yet, and remembers it.
+// Messages without an ID, from publishers that don't set one, are always
+// new.
+func (d *Dedup) Claim(id string) bool {
+	if id == `` {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen[id] {
+		return false
+	}
+	if old := d.order[d.next]; old != `` {
+		delete(d.seen, old)
+	}
+	d.order[d.next] = id
+	d.next = (d.next + 1) % len(d.order)
+	d.seen[id] = true
+	return true
+}
+
+// Filter passes on the deliveries from msgs whose IDs d hasn't seen, and
+// acks the rest. A redelivery is always passed on, as it is the retry of
+// a message that wasn't handled. The returned channel is closed when msgs
+// is; once ctx is done, d stops passing deliveries on, leaving them
+// unacked for RabbitMQ to redeliver.
+func (d *Dedup) Filter(ctx context.Context, msgs <-chan amqp.Delivery) <-chan amqp.Delivery {
+	out := make(chan amqp.Delivery)
+	go func() {
+		for msg := range msgs {
+			if !msg.Redelivered && !d.Claim(msg.MessageId) {
+				msg.Ack(false)
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,61 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestDedupClaim(t *testing.T) {
+	d := NewDedup(2)
+	for _, tc := range []struct {
+		id   string
+		want bool
+	}{
+		{`a`, true},
+		{`a`, false},
+		{``, true},
+		{``, true},
+		{`b`, true},
+		{`c`, true}, // forgets a
+		{`b`, false},
+		{`a`, true},
+	} {
+		if got := d.Claim(tc.id); got != tc.want {
+			t.Errorf(`Claim(%q) = %v, want %v`, tc.id, got, tc.want)
+		}
+	}
+}
+
+type acks struct{ acked []uint64 }
+
+func (a *acks) Ack(tag uint64, _ bool) error { a.acked = append(a.acked, tag); return nil }
+func (*acks) Nack(uint64, bool, bool) error  { return nil }
+func (*acks) Reject(uint64, bool) error      { return nil }
+
+func TestDedupFilter(t *testing.T) {
+	ack := &acks{}
+	msgs := make(chan amqp.Delivery, 4)
+	for i, d := range []amqp.Delivery{
+		{MessageId: `a`},
+		{MessageId: `a`},                    // the legacy fanout's copy
+		{MessageId: `a`, Redelivered: true}, // a retry
+		{},
+	} {
+		d.Acknowledger, d.DeliveryTag = ack, uint64(i+1)
+		msgs <- d
+	}
+	close(msgs)
+
+	var passed []uint64
+	for d := range NewDedup(DedupWindow).Filter(context.Background(), msgs) {
+		passed = append(passed, d.DeliveryTag)
+	}
+	if len(passed) != 3 || passed[0] != 1 || passed[1] != 3 || passed[2] != 4 {
+		t.Errorf(`passed %v, want [1 3 4]`, passed)
+	}
+	if len(ack.acked) != 1 || ack.acked[0] != 2 {
+		t.Errorf(`acked %v, want [2]`, ack.acked)
+	}
+}
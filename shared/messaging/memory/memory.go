@@ -0,0 +1,848 @@
+// Package memory is an in-process stand-in for RabbitMQ, good enough to run
+// the example-04 microservices together in a test without Docker or a
+// network. It supports the default, fanout, direct, topic and
+// x-consistent-hash exchanges, exchange-to-exchange bindings, competing and
+// single active consumers, prefetch limits, acknowledgements, publisher
+// confirms, requeueing and dead-lettering of rejected messages.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ErrClosed is returned by every operation once the broker or channel has
+// been closed.
+var ErrClosed = errors.New(`memory broker: closed`)
+
+// Broker holds exchanges and queues shared by every Channel it hands out.
+type Broker struct {
+	mu        sync.Mutex
+	ready     *sync.Cond
+	exchanges map[string]*exchange
+	queues    map[string]*queue
+	closed    bool
+	nextQueue int
+	nextTag   int
+}
+
+type exchange struct {
+	kind     string
+	durable  bool
+	args     amqp.Table
+	bindings []binding
+}
+
+// routes reports whether a message published with key reaches a queue
+// bound with bindingKey.
+func (ex *exchange) routes(bindingKey, key string) bool {
+	switch ex.kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return messaging.Match(bindingKey, key)
+	default:
+		return bindingKey == key
+	}
+}
+
+// binding routes to a queue, or to another exchange if toExchange is set.
+type binding struct {
+	queue, key string
+	toExchange bool
+}
+
+// pick chooses the one binding a consistent hash exchange routes a message
+// to. Each binding's key is its weight: the number of points it gets on a
+// ring that the message's hash key is then placed on.
+func (ex *exchange) pick(key string, headers amqp.Table) (binding, bool) {
+	if header, ok := ex.args[`hash-header`].(string); ok {
+		key = fmt.Sprint(headers[header])
+	}
+	type point struct {
+		hash uint32
+		bd   binding
+	}
+	var ring []point
+	for _, bd := range ex.bindings {
+		weight, err := strconv.Atoi(bd.key)
+		if err != nil || weight < 1 {
+			weight = 1
+		}
+		for i := range weight {
+			ring = append(ring, point{hash32(fmt.Sprintf(`%s#%d`, bd.queue, i)), bd})
+		}
+	}
+	if len(ring) == 0 {
+		return binding{}, false
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	h := hash32(key)
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	return ring[i%len(ring)].bd, true
+}
+
+// hash32 is FNV-1a, mixed so that similar keys such as q#0 and q#1 land
+// far apart on the ring.
+func hash32(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+type queue struct {
+	name     string
+	durable  bool
+	args     amqp.Table
+	messages []amqp.Delivery
+	// consumers are in the order they subscribed; with a single active
+	// consumer only the first is delivered to.
+	consumers []*acknowledger
+	unacked   int
+}
+
+func (q *queue) singleActive() bool {
+	return q.args[`x-single-active-consumer`] == true
+}
+
+// New returns an empty broker.
+func New() *Broker {
+	b := &Broker{
+		exchanges: map[string]*exchange{},
+		queues:    map[string]*queue{},
+	}
+	b.ready = sync.NewCond(&b.mu)
+	return b
+}
+
+// Channel opens a channel on the broker. Closing it stops its consumers.
+func (b *Broker) Channel() *Channel {
+	return &Channel{
+		broker:    b,
+		done:      make(chan struct{}),
+		consumers: map[string]*acknowledger{},
+		getters:   map[string]*acknowledger{},
+	}
+}
+
+// Close shuts the broker down; every consumer's delivery channel is closed.
+func (b *Broker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.ready.Broadcast()
+	return nil
+}
+
+// QueueDepth reports how many messages in the named queue are waiting for
+// a consumer and how many have been delivered but not yet acknowledged.
+func (b *Broker) QueueDepth(name string) (ready, unacked int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		return 0, 0
+	}
+	return len(q.messages), q.unacked
+}
+
+// Channel implements messaging.Channel against a Broker.
+type Channel struct {
+	broker *Broker
+	// prefetch caps each new consumer's unacknowledged deliveries; 0 is
+	// unlimited. It and consumers, by tag, are guarded by broker.mu.
+	prefetch  int
+	consumers map[string]*acknowledger
+	// getters settle the messages fetched with Get, one per queue.
+	getters map[string]*acknowledger
+	// In confirm mode every publish is numbered and acked to confirms,
+	// which are guarded by broker.mu.
+	confirming bool
+	published  uint64
+	confirms   []chan amqp.Confirmation
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var _ messaging.Channel = (*Channel)(nil)
+
+// Close stops every consumer started on this channel and waits for their
+// delivery channels to close.
+func (c *Channel) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.broker.mu.Lock()
+		c.broker.ready.Broadcast()
+		getters := slices.Collect(maps.Values(c.getters))
+		c.broker.mu.Unlock()
+		for _, ack := range getters {
+			ack.requeueAll()
+		}
+	})
+	c.wg.Wait()
+	return nil
+}
+
+func (c *Channel) isClosed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return c.broker.closed
+	}
+}
+
+// ExchangeDeclare creates the exchange, or checks that an existing one has
+// the same kind, as RabbitMQ would.
+func (c *Channel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	switch kind {
+	case amqp.ExchangeFanout, amqp.ExchangeDirect, amqp.ExchangeTopic, messaging.ExchangeConsistentHash:
+	default:
+		return fmt.Errorf(`memory broker: unsupported exchange kind %q`, kind)
+	}
+
+	if ex, ok := b.exchanges[name]; ok {
+		if ex.kind != kind || ex.durable != durable || !equivalent(ex.args, args) {
+			return preconditionFailed(`exchange`, name)
+		}
+		return nil
+	}
+	b.exchanges[name] = &exchange{kind: kind, durable: durable, args: args}
+	return nil
+}
+
+// Qos limits how many deliveries each consumer started afterwards may hold
+// unacknowledged, as RabbitMQ does when global is false. prefetchSize is
+// ignored.
+func (c *Channel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if prefetchCount < 0 {
+		return fmt.Errorf(`memory broker: negative prefetch count %d`, prefetchCount)
+	}
+	c.prefetch = prefetchCount
+	return nil
+}
+
+// QueueDeclare creates the queue, naming it if name is empty, or checks
+// that an existing one was declared the same way. Queue arguments are
+// compared but not acted on.
+func (c *Channel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.isClosed() {
+		return amqp.Queue{}, ErrClosed
+	}
+
+	if name == `` {
+		b.nextQueue++
+		name = fmt.Sprintf(`amq.gen-%d`, b.nextQueue)
+	}
+
+	if args[`x-queue-type`] == `quorum` && (!durable || autoDelete || exclusive) {
+		return amqp.Queue{}, preconditionFailed(`queue`, name)
+	}
+	q, ok := b.queues[name]
+	if !ok {
+		q = &queue{name: name, durable: durable, args: args}
+		b.queues[name] = q
+	} else if q.durable != durable || !equivalent(q.args, args) {
+		return amqp.Queue{}, preconditionFailed(`queue`, name)
+	}
+	return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: len(q.consumers)}, nil
+}
+
+// QueueBind routes messages from exchange to the named queue.
+func (c *Channel) QueueBind(name, key, exchangeName string, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	ex, ok := b.exchanges[exchangeName]
+	if !ok {
+		return notFound(`exchange`, exchangeName)
+	}
+	if _, ok := b.queues[name]; !ok {
+		return notFound(`queue`, name)
+	}
+	for _, bd := range ex.bindings {
+		if !bd.toExchange && bd.queue == name && bd.key == key {
+			return nil
+		}
+	}
+	ex.bindings = append(ex.bindings, binding{queue: name, key: key})
+	return nil
+}
+
+// ExchangeBind routes messages from source to the destination exchange.
+func (c *Channel) ExchangeBind(destination, key, source string, noWait bool, args amqp.Table) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	ex, ok := b.exchanges[source]
+	if !ok {
+		return notFound(`exchange`, source)
+	}
+	if _, ok := b.exchanges[destination]; !ok {
+		return notFound(`exchange`, destination)
+	}
+	bd := binding{queue: destination, key: key, toExchange: true}
+	if !slices.Contains(ex.bindings, bd) {
+		ex.bindings = append(ex.bindings, bd)
+	}
+	return nil
+}
+
+// QueueUnbind stops routing messages from exchange to the named queue.
+// Like RabbitMQ, removing a binding that doesn't exist is not an error.
+func (c *Channel) QueueUnbind(name, key, exchangeName string, args amqp.Table) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	ex, ok := b.exchanges[exchangeName]
+	if !ok {
+		return notFound(`exchange`, exchangeName)
+	}
+	ex.bindings = slices.DeleteFunc(ex.bindings, func(bd binding) bool {
+		return !bd.toExchange && bd.queue == name && bd.key == key
+	})
+	return nil
+}
+
+// Confirm puts the channel in confirm mode: every publish from now on is
+// acked to the channels passed to NotifyPublish.
+func (c *Channel) Confirm(noWait bool) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+	c.confirming = true
+	return nil
+}
+
+// NotifyPublish registers confirm for the confirmations of publishes in
+// confirm mode and returns it. As with RabbitMQ, publishing blocks until
+// the confirmation is received, so buffer confirm or read it elsewhere.
+func (c *Channel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	c.confirms = append(c.confirms, confirm)
+	return confirm
+}
+
+// PublishWithContext routes msg to every matching queue. Like RabbitMQ, a
+// message that matches no queue is dropped, and acked in confirm mode.
+func (c *Channel) PublishWithContext(ctx context.Context, exchangeName, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	confirmation, err := c.publish(exchangeName, key, msg)
+	if err != nil {
+		return err
+	}
+	for _, confirm := range confirmation.to {
+		confirm <- confirmation.Confirmation
+	}
+	return nil
+}
+
+// pendingConfirm is a publish's confirmation and where it goes.
+type pendingConfirm struct {
+	amqp.Confirmation
+	to []chan amqp.Confirmation
+}
+
+// publish enqueues msg and, in confirm mode, returns its confirmation.
+func (c *Channel) publish(exchangeName, key string, msg amqp.Publishing) (pendingConfirm, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.isClosed() {
+		return pendingConfirm{}, ErrClosed
+	}
+
+	var targets []string
+	if exchangeName == `` {
+		targets = []string{key}
+	} else {
+		if _, ok := b.exchanges[exchangeName]; !ok {
+			return pendingConfirm{}, notFound(`exchange`, exchangeName)
+		}
+		targets = b.route(exchangeName, key, msg.Headers, nil, nil)
+	}
+
+	b.enqueue(targets, amqp.Delivery{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+		Exchange:        exchangeName,
+		RoutingKey:      key,
+		Body:            msg.Body,
+	})
+	b.ready.Broadcast()
+	if !c.confirming {
+		return pendingConfirm{}, nil
+	}
+	c.published++
+	return pendingConfirm{amqp.Confirmation{DeliveryTag: c.published, Ack: true}, slices.Clone(c.confirms)}, nil
+}
+
+// enqueue adds d to each of the named queues that exists. The caller holds
+// b.mu and broadcasts b.ready.
+func (b *Broker) enqueue(targets []string, d amqp.Delivery) {
+	for _, name := range targets {
+		if q, ok := b.queues[name]; ok {
+			q.messages = append(q.messages, d)
+		}
+	}
+}
+
+// route appends to targets the queues exchangeName sends a message to,
+// following exchange-to-exchange bindings but never the same exchange
+// twice.
+func (b *Broker) route(exchangeName, key string, headers amqp.Table, targets, seen []string) []string {
+	ex, ok := b.exchanges[exchangeName]
+	if !ok || slices.Contains(seen, exchangeName) {
+		return targets
+	}
+	seen = append(seen, exchangeName)
+
+	var matched []binding
+	if ex.kind == messaging.ExchangeConsistentHash {
+		if bd, ok := ex.pick(key, headers); ok {
+			matched = append(matched, bd)
+		}
+	} else {
+		for _, bd := range ex.bindings {
+			if ex.routes(bd.key, key) {
+				matched = append(matched, bd)
+			}
+		}
+	}
+	for _, bd := range matched {
+		switch {
+		case bd.toExchange:
+			targets = b.route(bd.queue, key, headers, targets, seen)
+		case !slices.Contains(targets, bd.queue):
+			targets = append(targets, bd.queue)
+		}
+	}
+	return targets
+}
+
+// Consume starts delivering messages from the named queue. Consumers on the
+// same queue compete for messages, unless the queue was declared with
+// x-single-active-consumer: then only the oldest consumer gets any until
+// it goes away.
+func (c *Channel) Consume(queueName, consumerTag string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	b := c.broker
+	b.mu.Lock()
+	if c.isClosed() {
+		b.mu.Unlock()
+		return nil, ErrClosed
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		b.mu.Unlock()
+		return nil, notFound(`queue`, queueName)
+	}
+	if consumerTag == `` {
+		b.nextTag++
+		consumerTag = fmt.Sprintf(`ctag-%d`, b.nextTag)
+	}
+	if _, ok := c.consumers[consumerTag]; ok {
+		b.mu.Unlock()
+		return nil, &amqp.Error{Code: amqp.NotAllowed, Reason: fmt.Sprintf(`NOT_ALLOWED - attempt to reuse consumer tag '%s'`, consumerTag)}
+	}
+	ack := &acknowledger{
+		broker:   b,
+		queue:    q,
+		prefetch: c.prefetch,
+		cancel:   make(chan struct{}),
+		unacked:  map[uint64]amqp.Delivery{},
+	}
+	q.consumers = append(q.consumers, ack)
+	c.consumers[consumerTag] = ack
+	b.mu.Unlock()
+
+	deliveries := make(chan amqp.Delivery)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer close(deliveries)
+		defer func() {
+			b.mu.Lock()
+			q.consumers = slices.DeleteFunc(q.consumers, func(a *acknowledger) bool { return a == ack })
+			delete(c.consumers, consumerTag)
+			closed := c.isClosed()
+			// Another consumer may now be the active one.
+			b.ready.Broadcast()
+			b.mu.Unlock()
+			// As RabbitMQ does, return whatever a closed channel left
+			// unacked. A cancelled consumer can still ack on its channel.
+			if closed {
+				ack.requeueAll()
+			}
+		}()
+
+		var tag uint64
+		for {
+			d, ok := c.next(q, ack, autoAck)
+			if !ok {
+				return
+			}
+			tag++
+			d.ConsumerTag = consumerTag
+			d.DeliveryTag = tag
+			if autoAck {
+				d.Acknowledger = nopAcknowledger{}
+			} else {
+				d.Acknowledger = ack
+				ack.track(d)
+			}
+
+			select {
+			case deliveries <- d:
+			case <-c.done:
+				// Hand the message back so another consumer can have it.
+				if !autoAck {
+					ack.Nack(d.DeliveryTag, false, true)
+				}
+				return
+			case <-ack.cancel:
+				if !autoAck {
+					ack.Nack(d.DeliveryTag, false, true)
+				}
+				return
+			}
+		}
+	}()
+
+	return deliveries, nil
+}
+
+// Cancel stops the consumer's deliveries and closes its delivery channel.
+// Deliveries already received can still be acknowledged.
+func (c *Channel) Cancel(consumer string, noWait bool) error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.isClosed() {
+		return ErrClosed
+	}
+	ack, ok := c.consumers[consumer]
+	if !ok {
+		return nil
+	}
+	if !ack.cancelled {
+		ack.cancelled = true
+		close(ack.cancel)
+	}
+	b.ready.Broadcast()
+	return nil
+}
+
+// Get takes the next message from the named queue without waiting,
+// reporting false if there is none. As with RabbitMQ, its MessageCount is
+// how many messages are left ready.
+func (c *Channel) Get(queueName string, autoAck bool) (amqp.Delivery, bool, error) {
+	b := c.broker
+	b.mu.Lock()
+	if c.isClosed() {
+		b.mu.Unlock()
+		return amqp.Delivery{}, false, ErrClosed
+	}
+	q, ok := b.queues[queueName]
+	if !ok {
+		b.mu.Unlock()
+		return amqp.Delivery{}, false, notFound(`queue`, queueName)
+	}
+	if len(q.messages) == 0 {
+		b.mu.Unlock()
+		return amqp.Delivery{}, false, nil
+	}
+	d := q.messages[0]
+	q.messages = q.messages[1:]
+	d.MessageCount = uint32(len(q.messages))
+	ack, ok := c.getters[queueName]
+	if !ok {
+		ack = &acknowledger{broker: b, queue: q, cancel: make(chan struct{}), unacked: map[uint64]amqp.Delivery{}}
+		c.getters[queueName] = ack
+	}
+	ack.gets++
+	d.DeliveryTag = ack.gets
+	b.mu.Unlock()
+
+	if autoAck {
+		d.Acknowledger = nopAcknowledger{}
+	} else {
+		d.Acknowledger = ack
+		ack.track(d)
+	}
+	return d, true, nil
+}
+
+// next blocks until q has a message for this consumer and it is under its
+// prefetch limit, or the consumer is cancelled or the channel closes.
+func (c *Channel) next(q *queue, ack *acknowledger, autoAck bool) (amqp.Delivery, bool) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(q.messages) == 0 ||
+		(!autoAck && ack.prefetch > 0 && ack.pending >= ack.prefetch) ||
+		(q.singleActive() && q.consumers[0] != ack) {
+		if c.isClosed() || ack.cancelled {
+			return amqp.Delivery{}, false
+		}
+		b.ready.Wait()
+	}
+	if c.isClosed() || ack.cancelled {
+		return amqp.Delivery{}, false
+	}
+	d := q.messages[0]
+	q.messages = q.messages[1:]
+	return d, true
+}
+
+// acknowledger settles deliveries made to one consumer.
+type acknowledger struct {
+	broker *Broker
+	queue  *queue
+	// prefetch, pending (the deliveries not yet settled) and cancelled are
+	// guarded by broker.mu.
+	prefetch, pending int
+	cancelled         bool
+	cancel            chan struct{}
+	// gets numbers the deliveries of a Get acknowledger.
+	gets uint64
+
+	mu      sync.Mutex
+	unacked map[uint64]amqp.Delivery
+}
+
+func (a *acknowledger) track(d amqp.Delivery) {
+	a.mu.Lock()
+	a.unacked[d.DeliveryTag] = d
+	a.mu.Unlock()
+
+	a.broker.mu.Lock()
+	a.queue.unacked++
+	a.pending++
+	a.broker.mu.Unlock()
+}
+
+// settle removes tag, or every tag up to it when multiple is set.
+func (a *acknowledger) settle(tag uint64, multiple bool) ([]amqp.Delivery, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var settled []amqp.Delivery
+	if multiple {
+		for t, d := range a.unacked {
+			if t <= tag {
+				settled = append(settled, d)
+				delete(a.unacked, t)
+			}
+		}
+	} else if d, ok := a.unacked[tag]; ok {
+		settled = append(settled, d)
+		delete(a.unacked, tag)
+	}
+	if len(settled) == 0 {
+		return nil, fmt.Errorf(`memory broker: unknown delivery tag %d`, tag)
+	}
+	return settled, nil
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	settled, err := a.settle(tag, multiple)
+	if err != nil {
+		return err
+	}
+	a.broker.mu.Lock()
+	a.queue.unacked -= len(settled)
+	a.pending -= len(settled)
+	a.broker.ready.Broadcast()
+	a.broker.mu.Unlock()
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	settled, err := a.settle(tag, multiple)
+	if err != nil {
+		return err
+	}
+
+	b := a.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a.queue.unacked -= len(settled)
+	a.pending -= len(settled)
+	b.ready.Broadcast()
+	if requeue {
+		for i := range settled {
+			settled[i].Redelivered = true
+			settled[i].Acknowledger = nil
+		}
+		a.queue.messages = append(settled, a.queue.messages...)
+	} else {
+		b.deadLetter(a.queue, settled)
+	}
+	return nil
+}
+
+// requeueAll hands back every delivery not yet settled.
+func (a *acknowledger) requeueAll() {
+	a.mu.Lock()
+	var last uint64
+	for tag := range a.unacked {
+		last = max(last, tag)
+	}
+	a.mu.Unlock()
+	if last > 0 {
+		a.Nack(last, true, true)
+	}
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// deadLetter sends messages rejected from q to its x-dead-letter-exchange,
+// if it has one, recording why in their x-death header as RabbitMQ does.
+// The caller holds b.mu.
+func (b *Broker) deadLetter(q *queue, rejected []amqp.Delivery) {
+	dlx, ok := q.args[`x-dead-letter-exchange`].(string)
+	if !ok {
+		return
+	}
+	for _, d := range rejected {
+		key := d.RoutingKey
+		if k, ok := q.args[`x-dead-letter-routing-key`].(string); ok {
+			key = k
+		}
+		targets := []string{key}
+		if dlx != `` {
+			targets = b.route(dlx, key, d.Headers, nil, nil)
+		}
+
+		headers := maps.Clone(d.Headers)
+		if headers == nil {
+			headers = amqp.Table{}
+		}
+		deaths, _ := headers[`x-death`].([]interface{})
+		death := amqp.Table{
+			`queue`:        q.name,
+			`reason`:       `rejected`,
+			`count`:        int64(1),
+			`exchange`:     d.Exchange,
+			`routing-keys`: []interface{}{d.RoutingKey},
+			`time`:         time.Now(),
+		}
+		deaths = slices.Clone(deaths)
+		for i, prev := range deaths {
+			if t, ok := prev.(amqp.Table); ok && t[`queue`] == q.name && t[`reason`] == `rejected` {
+				count, _ := t[`count`].(int64)
+				death[`count`] = count + 1
+				deaths = slices.Delete(deaths, i, i+1)
+				break
+			}
+		}
+		headers[`x-death`] = append([]interface{}{death}, deaths...)
+		if _, ok := headers[`x-first-death-queue`]; !ok {
+			headers[`x-first-death-queue`] = q.name
+			headers[`x-first-death-reason`] = `rejected`
+			headers[`x-first-death-exchange`] = d.Exchange
+		}
+
+		d.Headers = headers
+		d.Exchange, d.RoutingKey = dlx, key
+		d.Redelivered, d.Acknowledger, d.ConsumerTag, d.DeliveryTag = false, nil, ``, 0
+		b.enqueue(targets, d)
+	}
+}
+
+type nopAcknowledger struct{}
+
+func (nopAcknowledger) Ack(uint64, bool) error        { return nil }
+func (nopAcknowledger) Nack(uint64, bool, bool) error { return nil }
+func (nopAcknowledger) Reject(uint64, bool) error     { return nil }
+
+// equivalent reports whether two declarations' x- arguments match, as
+// RabbitMQ checks them; integers of any width compare by value.
+func equivalent(a, b amqp.Table) bool {
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	for k, v := range b {
+		w, ok := a[k]
+		if !ok || fmt.Sprint(w) != fmt.Sprint(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func notFound(kind, name string) error {
+	return &amqp.Error{Code: amqp.NotFound, Reason: fmt.Sprintf(`NOT_FOUND - no %s '%s'`, kind, name)}
+}
+
+func preconditionFailed(kind, name string) error {
+	return &amqp.Error{Code: amqp.PreconditionFailed, Reason: fmt.Sprintf(`PRECONDITION_FAILED - inequivalent arg for %s '%s'`, kind, name)}
+}
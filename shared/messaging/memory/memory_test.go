@@ -0,0 +1,277 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/messaging"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestTopicRouting(t *testing.T) {
+	b := New()
+	defer b.Close()
+	ch := b.Channel()
+	defer ch.Close()
+
+	for _, q := range []string{`history`, `everything`} {
+		if _, err := ch.QueueDeclare(q, false, false, false, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// history used to take views from a fanout; Bind moves it over.
+	if err := messaging.DeclareFanout(ch, `Viewed`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.QueueBind(`history`, ``, `Viewed`, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := messaging.Bind(ch, `history`, messaging.Bindings{Exchange: `events`, Patterns: []string{`video.viewed`, `video.*`}}, `Viewed`); err != nil {
+		t.Fatal(err)
+	}
+	if err := messaging.Bind(ch, `everything`, messaging.Bindings{Exchange: `events`, Patterns: []string{`#`}}, ``); err != nil {
+		t.Fatal(err)
+	}
+
+	publish := func(exchange, key string) {
+		t.Helper()
+		if err := ch.PublishWithContext(context.Background(), exchange, key, false, false, amqp.Publishing{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publish(`events`, messaging.VideoViewed)
+	publish(`events`, messaging.PlaybackProgress)
+	publish(`Viewed`, ``)
+
+	// The view matches both of history's patterns but arrives once, and
+	// nothing arrives through the old fanout binding.
+	if ready, _ := b.QueueDepth(`history`); ready != 1 {
+		t.Errorf(`history has %d messages, want 1`, ready)
+	}
+	if ready, _ := b.QueueDepth(`everything`); ready != 2 {
+		t.Errorf(`everything has %d messages, want 2`, ready)
+	}
+}
+
+func TestDeclareQueueIncompatible(t *testing.T) {
+	b := New()
+	defer b.Close()
+	ch := b.Channel()
+	defer ch.Close()
+
+	// history used to declare a plain, non-durable queue.
+	if _, err := ch.QueueDeclare(`history`, false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := messaging.QueueConfig{Type: messaging.Quorum, MaxLength: 10, Overflow: `reject-publish`}
+	_, err := messaging.DeclareQueue(ch, `history`, cfg)
+	var incompatible *messaging.IncompatibleQueueError
+	if !errors.As(err, &incompatible) || incompatible.Queue != `history` {
+		t.Fatalf(`DeclareQueue over a classic queue = %v, want *IncompatibleQueueError`, err)
+	}
+
+	if _, err := messaging.DeclareQueue(ch, `fresh`, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := messaging.DeclareQueue(ch, `fresh`, cfg); err != nil {
+		t.Fatalf(`redeclaring the same way: %v`, err)
+	}
+	cfg.MaxLength = 20
+	if _, err := messaging.DeclareQueue(ch, `fresh`, cfg); !errors.As(err, &incompatible) {
+		t.Fatalf(`DeclareQueue with another x-max-length = %v, want *IncompatibleQueueError`, err)
+	}
+}
+
+func TestQueueConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		cfg messaging.QueueConfig
+		ok  bool
+	}{
+		{messaging.QueueConfig{Type: messaging.Quorum}, true},
+		{messaging.QueueConfig{Type: messaging.Classic, Overflow: `reject-publish-dlx`}, true},
+		{messaging.QueueConfig{Type: messaging.Quorum, Overflow: `reject-publish-dlx`}, false},
+		{messaging.QueueConfig{Type: `stream`}, false},
+		{messaging.QueueConfig{Type: messaging.Classic, MaxLength: -1}, false},
+		{messaging.QueueConfig{Type: messaging.Classic, MessageTTL: time.Microsecond}, false},
+	} {
+		if err := tc.cfg.Validate(); (err == nil) != tc.ok {
+			t.Errorf(`%+v.Validate() = %v`, tc.cfg, err)
+		}
+	}
+}
+
+func TestQosLimitsUnacked(t *testing.T) {
+	b := New()
+	defer b.Close()
+	ch := b.Channel()
+	defer ch.Close()
+
+	if _, err := ch.QueueDeclare(`history`, false, false, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		if err := ch.PublishWithContext(context.Background(), ``, `history`, false, false, amqp.Publishing{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ch.Qos(2, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := ch.Consume(`history`, ``, false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-msgs
+	second := <-msgs
+	select {
+	case <-msgs:
+		t.Fatal(`third delivery arrived with two unacked and prefetch 2`)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if ready, unacked := b.QueueDepth(`history`); ready != 3 || unacked != 2 {
+		t.Errorf(`QueueDepth = %d ready, %d unacked; want 3, 2`, ready, unacked)
+	}
+
+	// A multiple ack settles both and makes room for two more.
+	if err := second.Ack(true); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		select {
+		case <-msgs:
+		case <-time.After(time.Second):
+			t.Fatal(`no delivery after ack`)
+		}
+	}
+}
+
+func TestConsistentHashSingleActive(t *testing.T) {
+	b := New()
+	defer b.Close()
+	ch := b.Channel()
+	defer ch.Close()
+
+	if err := messaging.DeclareTopic(ch, `events`); err != nil {
+		t.Fatal(err)
+	}
+	if err := messaging.DeclarePartitions(ch, `parts`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ch.ExchangeBind(`parts`, `video.viewed`, `events`, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	sac := messaging.QueueConfig{Type: messaging.Quorum, SingleActive: true}
+	for _, q := range []string{`p0`, `p1`} {
+		if _, err := messaging.DeclareQueue(ch, q, sac); err != nil {
+			t.Fatal(err)
+		}
+		if err := ch.QueueBind(q, `1`, `parts`, false, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Every message with the same key lands in the same queue.
+	for i := range 40 {
+		key := messaging.PartitionKey(fmt.Sprintf(`user-%d`, i%4), ``)
+		err := ch.PublishWithContext(context.Background(), `events`, `video.viewed`, false, false, amqp.Publishing{
+			Headers: amqp.Table{messaging.PartitionHeader: key},
+			Body:    []byte(key),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	p0, _ := b.QueueDepth(`p0`)
+	p1, _ := b.QueueDepth(`p1`)
+	if p0+p1 != 40 || p0%10 != 0 {
+		t.Fatalf(`p0 has %d, p1 %d; want 40 in multiples of 10`, p0, p1)
+	}
+	queue := `p0`
+	if p0 == 0 {
+		queue = `p1`
+	}
+
+	// Only the first consumer gets anything until it is cancelled.
+	first, err := ch.Consume(queue, `first`, false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ch.Consume(queue, `second`, false, false, false, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := <-first
+	select {
+	case <-second:
+		t.Fatal(`inactive consumer got a delivery`)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := ch.Cancel(`first`, false); err != nil {
+		t.Fatal(err)
+	}
+	for range first {
+	}
+	// A cancelled consumer can still settle what it has.
+	if err := d.Ack(false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-second:
+	case <-time.After(time.Second):
+		t.Fatal(`second consumer did not take over`)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	b := New()
+	defer b.Close()
+	ch := b.Channel()
+	defer ch.Close()
+
+	if _, err := messaging.DeclareQueue(ch, `work`, messaging.QueueConfig{Type: messaging.Quorum, DeadLetter: true}); err != nil {
+		t.Fatal(err)
+	}
+	dead := messaging.DeadLetterQueue(`work`)
+	err := ch.PublishWithContext(context.Background(), ``, `work`, false, false, amqp.Publishing{
+		Headers: amqp.Table{`traceparent`: `00-1`},
+		Body:    []byte(`poison`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requeued messages stay; rejected ones move to the dead letter queue.
+	for _, requeue := range []bool{true, false} {
+		d, ok, err := ch.Get(`work`, false)
+		if err != nil || !ok {
+			t.Fatalf(`Get = %v, %v`, ok, err)
+		}
+		d.Nack(false, requeue)
+	}
+	if ready, unacked := b.QueueDepth(`work`); ready+unacked != 0 {
+		t.Errorf(`work has %d ready, %d unacked`, ready, unacked)
+	}
+
+	d, ok, err := ch.Get(dead, false)
+	if err != nil || !ok {
+		t.Fatalf(`Get %s = %v, %v`, dead, ok, err)
+	}
+	deaths, _ := d.Headers[`x-death`].([]interface{})
+	if len(deaths) != 1 || string(d.Body) != `poison` || d.Headers[`traceparent`] != `00-1` {
+		t.Fatalf(`dead letter %q with headers %v`, d.Body, d.Headers)
+	}
+	if death := deaths[0].(amqp.Table); death[`queue`] != `work` || death[`reason`] != `rejected` {
+		t.Errorf(`x-death = %v`, death)
+	}
+
+	// The dead letter queue has none of its own, so this drops it.
+	d.Nack(false, false)
+	if _, ok, _ := ch.Get(dead, false); ok {
+		t.Error(`dead letter queue not empty`)
+	}
+}
@@ -0,0 +1,70 @@
+package messaging
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ExchangeConsistentHash is the kind of exchange added by RabbitMQ's
+// consistent hash plugin. It routes each message to one of its queues,
+// weighted by the binding key, by hashing the message.
+const ExchangeConsistentHash = `x-consistent-hash`
+
+// PartitionHeader carries the key consistent hash exchanges route on, so
+// that every event about one user reaches the same partition.
+const PartitionHeader = `partition-key`
+
+// PartitionKey returns the PartitionHeader value for an event: the user it
+// is about or, for anonymous viewers, the video.
+func PartitionKey(userID, videoID string) string {
+	if userID != `` {
+		return `user:` + userID
+	}
+	return `video:` + videoID
+}
+
+// DeclarePartitions creates the durable x-consistent-hash exchange name,
+// which hashes PartitionHeader.
+func DeclarePartitions(ch Channel, name string) error {
+	err := ch.ExchangeDeclare(name, ExchangeConsistentHash, true, false, false, false, amqp.Table{
+		`hash-header`: PartitionHeader,
+	})
+	if err != nil {
+		return fmt.Errorf(`ch.ExchangeDeclare %s: %w`, name, err)
+	}
+	return nil
+}
+
+// Owner picks which of members owns partition by rendezvous hashing: each
+// member scores every partition and the highest score wins. Members that
+// join or leave only move the partitions they win or lose. It returns
+// empty if there are no members.
+func Owner(partition int, members []string) string {
+	var (
+		owner string
+		best  uint64
+	)
+	for _, m := range slices.Sorted(slices.Values(members)) {
+		h := fnv.New64a()
+		fmt.Fprintf(h, `%s/%d`, m, partition)
+		if score := mix(h.Sum64()); owner == `` || score > best {
+			owner, best = m, score
+		}
+	}
+	return owner
+}
+
+// mix spreads FNV's output, which is poorly spread for short keys that
+// differ in a byte or two: without it, names like a/1 and b/1 score in the
+// same order for almost every partition.
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,26 @@
+package messaging
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestOwnerMovesFewPartitions(t *testing.T) {
+	const partitions = 64
+	before := []string{`a`, `b`, `c`}
+	after := append(slices.Clone(before), `d`)
+	moved := 0
+	for i := range partitions {
+		was, is := Owner(i, before), Owner(i, after)
+		if was != is {
+			if is != `d` {
+				t.Errorf(`partition %d moved from %s to %s, not to the newcomer`, i, was, is)
+			}
+			moved++
+		}
+	}
+	// About a quarter should move to d; none should shuffle elsewhere.
+	if moved == 0 || moved > partitions/2 {
+		t.Errorf(`%d of %d partitions moved`, moved, partitions)
+	}
+}
@@ -0,0 +1,164 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Queue types accepted by QueueConfig.Type.
+const (
+	Classic = `classic`
+	Quorum  = `quorum`
+)
+
+// QueueConfig describes how a service's own queues are declared. The
+// defaults declare a durable classic queue; the zero value a non-durable
+// one.
+//
+// RabbitMQ refuses to redeclare an existing queue any other way, so a
+// service meeting a queue an older version declared non-durable fails
+// with an *IncompatibleQueueError. Set QUEUE_DURABLE=false to keep using
+// it, or a Suffix to declare a durable queue beside it and move what is
+// left in the old one across with admin drain-queue.
+//
+// To move to quorum queues, which RabbitMQ can't convert a queue to, set
+// Type with a Suffix in the same way.
+type QueueConfig struct {
+	// Type is classic or quorum. Quorum queues are replicated across the
+	// cluster and always durable.
+	Type string `env:"QUEUE_TYPE" default:"classic" validate:"oneof=classic quorum"`
+	// Durable keeps a classic queue, but not the messages published to it
+	// as transient, across broker restarts.
+	Durable bool `env:"QUEUE_DURABLE" default:"true"`
+	// Suffix is appended to every queue name, e.g. .quorum, so queues
+	// declared another way don't collide with the existing ones.
+	Suffix string `env:"QUEUE_SUFFIX"`
+	// MaxLength caps the messages ready in the queue; 0 means no limit.
+	MaxLength int `env:"QUEUE_MAX_LENGTH" default:"0"`
+	// Overflow is what happens at MaxLength: drop-head discards the oldest
+	// message, reject-publish refuses the new one. Empty is drop-head.
+	Overflow string `env:"QUEUE_OVERFLOW" validate:"oneof=drop-head reject-publish reject-publish-dlx"`
+	// MessageTTL discards messages left in the queue longer; 0 keeps them.
+	MessageTTL time.Duration `env:"QUEUE_MESSAGE_TTL" default:"0"`
+	// DeadLetter moves messages rejected without requeueing, expired by
+	// MessageTTL or dropped by Overflow to a queue of their own, named by
+	// DeadLetterQueue, instead of discarding them.
+	DeadLetter bool `env:"QUEUE_DEAD_LETTER" default:"false"`
+	// SingleActive delivers to one consumer at a time, failing over to the
+	// next when it goes away. Set by services, not configuration.
+	SingleActive bool
+}
+
+// Validate reports settings RabbitMQ would refuse.
+func (c QueueConfig) Validate() error {
+	switch {
+	case c.Type != `` && c.Type != Classic && c.Type != Quorum:
+		return fmt.Errorf(`QUEUE_TYPE %q: must be classic or quorum`, c.Type)
+	case c.MaxLength < 0:
+		return errors.New(`QUEUE_MAX_LENGTH must not be negative`)
+	case c.MessageTTL < 0:
+		return errors.New(`QUEUE_MESSAGE_TTL must not be negative`)
+	case c.MessageTTL > 0 && c.MessageTTL < time.Millisecond:
+		return errors.New(`QUEUE_MESSAGE_TTL must be at least 1ms`)
+	case c.Type == Quorum && c.Overflow == `reject-publish-dlx`:
+		return errors.New(`QUEUE_OVERFLOW reject-publish-dlx is not supported by quorum queues`)
+	}
+	return nil
+}
+
+// durable reports whether the queue outlives a broker restart.
+func (c QueueConfig) durable() bool {
+	return c.Type == Quorum || c.Durable
+}
+
+// Name returns the name queue is declared under.
+func (c QueueConfig) Name(queue string) string {
+	return queue + c.Suffix
+}
+
+// DeadLetterQueue names the queue the dead letters of queue go to.
+func DeadLetterQueue(queue string) string {
+	return queue + `.dead`
+}
+
+// Args returns the x- arguments the named queue is declared with.
+func (c QueueConfig) Args(queue string) amqp.Table {
+	args := amqp.Table{}
+	if c.Type == Quorum {
+		args[`x-queue-type`] = Quorum
+	}
+	if c.MaxLength > 0 {
+		args[`x-max-length`] = int64(c.MaxLength)
+	}
+	if c.Overflow != `` {
+		args[`x-overflow`] = c.Overflow
+	}
+	if c.MessageTTL > 0 {
+		args[`x-message-ttl`] = c.MessageTTL.Milliseconds()
+	}
+	if c.SingleActive {
+		args[`x-single-active-consumer`] = true
+	}
+	if c.DeadLetter {
+		// Through the default exchange, straight to the dead letter queue.
+		args[`x-dead-letter-exchange`] = ``
+		args[`x-dead-letter-routing-key`] = DeadLetterQueue(queue)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
+
+// IncompatibleQueueError is returned by DeclareQueue when the queue already
+// exists with a different declaration, which RabbitMQ never changes in
+// place.
+type IncompatibleQueueError struct {
+	Queue  string
+	Config QueueConfig
+	// Reason is RabbitMQ's explanation, naming the first argument that
+	// differs.
+	Reason string
+}
+
+func (e *IncompatibleQueueError) Error() string {
+	return fmt.Sprintf(`queue %s already exists with a different declaration (%s); `+
+		`wanted type=%s durable=%t args=%v. Set QUEUE_* to match how it was declared, `+
+		`or set QUEUE_SUFFIX to declare a new queue and move the messages over with admin drain-queue`,
+		e.Queue, e.Reason, e.Config.Type, e.Config.durable(), e.Config.Args(e.Queue))
+}
+
+// DeclareQueue declares the named queue as cfg describes, under
+// cfg.Name(name), and its dead letter queue first if cfg.DeadLetter is set.
+//
+// RabbitMQ answers a declaration that doesn't match the existing queue by
+// closing the channel with PRECONDITION_FAILED; that is returned as an
+// *IncompatibleQueueError saying what to do about it. The channel is
+// unusable afterwards either way, so callers should give up.
+func DeclareQueue(ch Channel, name string, cfg QueueConfig) (amqp.Queue, error) {
+	name = cfg.Name(name)
+	if cfg.DeadLetter {
+		// Dead letters are kept until someone looks at them: no limits.
+		dead := QueueConfig{Type: cfg.Type, Durable: cfg.Durable}
+		if _, err := DeclareQueue(ch, DeadLetterQueue(name), dead); err != nil {
+			return amqp.Queue{}, err
+		}
+	}
+	q, err := ch.QueueDeclare(name, cfg.durable(), false, false, false, cfg.Args(name))
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Code == amqp.PreconditionFailed {
+		return q, &IncompatibleQueueError{
+			Queue:  name,
+			Config: cfg,
+			Reason: strings.TrimPrefix(amqpErr.Reason, `PRECONDITION_FAILED - `),
+		}
+	}
+	if err != nil {
+		return q, fmt.Errorf(`ch.QueueDeclare %s: %w`, name, err)
+	}
+	return q, nil
+}
@@ -0,0 +1,122 @@
+package messaging
+
+import (
+	"fmt"
+	"strings"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Routing keys of the events published to the topic exchange.
+const (
+	VideoViewed      = `video.viewed`
+	VideoUploaded    = `video.uploaded`
+	VideoDeleted     = `video.deleted`
+	PlaybackProgress = `playback.progress`
+)
+
+// DefaultExchange is the topic exchange used unless configured otherwise.
+const DefaultExchange = `events`
+
+// Config names the topic exchange every event is published to.
+type Config struct {
+	// Exchange defaults to DefaultExchange.
+	Exchange string `env:"EVENTS_EXCHANGE" default:"events"`
+}
+
+// Bindings routes events from a topic exchange to one of a service's
+// queues.
+type Bindings struct {
+	Exchange string
+	// Patterns are routing key patterns: * matches exactly one word and #
+	// zero or more, e.g. video.* or #.
+	Patterns []string
+	// KeepLegacy leaves the queue bound to the fanout exchange passed to
+	// Bind, for events from publishers that don't send them to Exchange
+	// yet. Consumers should dedupe by message ID while it is set.
+	KeepLegacy bool
+}
+
+// DeclareTopic creates the durable topic exchange name.
+func DeclareTopic(ch Channel, name string) error {
+	if err := ch.ExchangeDeclare(name, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return fmt.Errorf(`ch.ExchangeDeclare %s: %w`, name, err)
+	}
+	return nil
+}
+
+// DeclareFanout creates the durable fanout exchange name, as the services
+// did before the topic exchange.
+func DeclareFanout(ch Channel, name string) error {
+	if err := ch.ExchangeDeclare(name, amqp.ExchangeFanout, true, false, false, false, nil); err != nil {
+		return fmt.Errorf(`ch.ExchangeDeclare %s: %w`, name, err)
+	}
+	return nil
+}
+
+// Bind declares b's exchange and binds queue to it with each pattern.
+//
+// If legacy names the fanout exchange the queue used to be fed from, the
+// queue stays bound to it while b.KeepLegacy is set, and is unbound from it
+// otherwise. Only turn KeepLegacy off once every publisher sends to the
+// topic exchange: until then, events from older publishers only reach the
+// fanout.
+func Bind(ch Channel, queue string, b Bindings, legacy string) error {
+	if err := DeclareTopic(ch, b.Exchange); err != nil {
+		return err
+	}
+	for _, pattern := range b.Patterns {
+		if err := ch.QueueBind(queue, pattern, b.Exchange, false, nil); err != nil {
+			return fmt.Errorf(`ch.QueueBind %s %s: %w`, b.Exchange, pattern, err)
+		}
+	}
+	if legacy == `` {
+		return nil
+	}
+	// Binding to or unbinding from an exchange that doesn't exist closes
+	// the channel.
+	if err := DeclareFanout(ch, legacy); err != nil {
+		return err
+	}
+	if b.KeepLegacy {
+		if err := ch.QueueBind(queue, ``, legacy, false, nil); err != nil {
+			return fmt.Errorf(`ch.QueueBind %s: %w`, legacy, err)
+		}
+		return nil
+	}
+	if err := ch.QueueUnbind(queue, ``, legacy, nil); err != nil {
+		return fmt.Errorf(`ch.QueueUnbind %s: %w`, legacy, err)
+	}
+	return nil
+}
+
+// Match reports whether a topic exchange would route key to a queue bound
+// with pattern.
+func Match(pattern, key string) bool {
+	return match(strings.Split(pattern, `.`), strings.Split(key, `.`))
+}
+
+func match(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case `#`:
+			// Try every way of letting # swallow words.
+			for i := 0; i <= len(key); i++ {
+				if match(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case `*`:
+			if len(key) == 0 {
+				return false
+			}
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
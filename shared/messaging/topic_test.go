@@ -0,0 +1,29 @@
+package messaging
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		want         bool
+	}{
+		{`video.viewed`, `video.viewed`, true},
+		{`video.viewed`, `video.uploaded`, false},
+		{`video.*`, `video.viewed`, true},
+		{`video.*`, `video`, false},
+		{`video.*`, `video.viewed.hd`, false},
+		{`*.viewed`, `video.viewed`, true},
+		{`video.#`, `video`, true},
+		{`video.#`, `video.viewed.hd`, true},
+		{`#`, `playback.progress`, true},
+		{`#.progress`, `playback.progress`, true},
+		{`#.progress`, `video.viewed`, false},
+		{`video.#.hd`, `video.viewed.hd`, true},
+		{`video.#.hd`, `video.hd`, true},
+		{`video.#.hd`, `video.viewed.sd`, false},
+	} {
+		if got := Match(tc.pattern, tc.key); got != tc.want {
+			t.Errorf(`Match(%q, %q) = %v, want %v`, tc.pattern, tc.key, got, tc.want)
+		}
+	}
+}
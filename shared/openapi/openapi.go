@@ -0,0 +1,244 @@
+// Package openapi loads the OpenAPI 3.0 documents that describe the
+// services' HTTP routes, serves them, validates requests against them and
+// checks responses in contract tests.
+//
+// Only the parts of OpenAPI the services use are supported: query, header
+// and path parameters of scalar types, JSON request and response bodies,
+// other bodies matched by media type or range but not checked, and the
+// schema keywords listed on Schema. Load rejects a document using
+// anything else, so a spec can't promise a check that is never made.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Document is a loaded OpenAPI document.
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       Info                             `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+	Security   []map[string][]string            `json:"security,omitempty"`
+
+	raw []byte
+	// routes matches requests to operations as the services' muxes do;
+	// OpenAPI path templates are ServeMux wildcards.
+	routes *http.ServeMux
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+}
+
+// Operation is one method on one path.
+type Operation struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema  *Schema `json:"schema,omitempty"`
+	Example any     `json:"example,omitempty"`
+}
+
+var methods = map[string]bool{
+	`get`: true, `put`: true, `post`: true, `delete`: true, `patch`: true, `head`: true, `options`: true,
+}
+
+// Load parses and checks an OpenAPI document.
+func Load(data []byte) (*Document, error) {
+	d := &Document{raw: data, routes: http.NewServeMux()}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf(`openapi: %w`, err)
+	}
+	if !strings.HasPrefix(d.OpenAPI, `3.0.`) {
+		return nil, fmt.Errorf(`openapi: version %q: want 3.0.x`, d.OpenAPI)
+	}
+
+	for name, s := range d.Components.Schemas {
+		if err := d.resolve(s); err != nil {
+			return nil, fmt.Errorf(`openapi: components.schemas.%s: %w`, name, err)
+		}
+	}
+	for path, ops := range d.Paths {
+		for method, op := range ops {
+			pattern := strings.ToUpper(method) + ` ` + path
+			if !methods[method] {
+				return nil, fmt.Errorf(`openapi: %s: unsupported method`, pattern)
+			}
+			if err := d.check(op); err != nil {
+				return nil, fmt.Errorf(`openapi: %s: %w`, pattern, err)
+			}
+			if err := d.route(pattern, op); err != nil {
+				return nil, fmt.Errorf(`openapi: %s: %w`, pattern, err)
+			}
+		}
+	}
+	return d, nil
+}
+
+// MustLoad is Load for a document embedded in the binary, where an error
+// is a bug: it panics.
+func MustLoad(data []byte) *Document {
+	d, err := Load(data)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// check resolves an operation's schemas and rejects what isn't supported.
+func (d *Document) check(op *Operation) error {
+	if len(op.Responses) == 0 {
+		return fmt.Errorf(`no responses`)
+	}
+	for _, p := range op.Parameters {
+		switch p.In {
+		case `query`, `header`, `path`:
+		default:
+			return fmt.Errorf(`parameter %s: unsupported location %q`, p.Name, p.In)
+		}
+		if p.Schema == nil {
+			return fmt.Errorf(`parameter %s: no schema`, p.Name)
+		}
+		if err := d.resolve(p.Schema); err != nil {
+			return fmt.Errorf(`parameter %s: %w`, p.Name, err)
+		}
+		if !p.Schema.scalar() {
+			return fmt.Errorf(`parameter %s: only scalar types are supported`, p.Name)
+		}
+	}
+	var contents []map[string]*MediaType
+	if op.RequestBody != nil {
+		contents = append(contents, op.RequestBody.Content)
+	}
+	for _, resp := range op.Responses {
+		contents = append(contents, resp.Content)
+	}
+	for _, content := range contents {
+		for _, media := range content {
+			if err := d.resolve(media.Schema); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// route adds an operation to d.routes, turning the panic ServeMux gives for
+// clashing paths into an error.
+func (d *Document) route(pattern string, op *Operation) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(`%v`, r)
+		}
+	}()
+	d.routes.Handle(pattern, http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if m, ok := r.Context().Value(matchKey{}).(*match); ok {
+			m.op, m.r = op, r
+		}
+	}))
+	return nil
+}
+
+type matchKey struct{}
+
+// match is what find learns about a request.
+type match struct {
+	op *Operation
+	// r is the request as routed, for its path values.
+	r *http.Request
+}
+
+// find returns the operation for r, or nil if d doesn't describe it.
+func (d *Document) find(r *http.Request) *match {
+	m := &match{}
+	d.routes.ServeHTTP(discard{}, r.WithContext(context.WithValue(r.Context(), matchKey{}, m)))
+	if m.op == nil {
+		return nil
+	}
+	return m
+}
+
+// discard swallows what d.routes writes for requests it has no route for.
+type discard struct{}
+
+func (discard) Header() http.Header         { return http.Header{} }
+func (discard) Write(b []byte) (int, error) { return len(b), nil }
+func (discard) WriteHeader(int)             {}
+
+// Operations returns the document's operations as ServeMux patterns, such
+// as "GET /history", so tests can check they cover them all.
+func (d *Document) Operations() []string {
+	var patterns []string
+	for path, ops := range d.Paths {
+		for method := range ops {
+			patterns = append(patterns, strings.ToUpper(method)+` `+path)
+		}
+	}
+	slices.Sort(patterns)
+	return patterns
+}
+
+// Pattern returns the operation r is for as a ServeMux pattern, such as
+// "GET /videos/{id}", or "" if d doesn't describe it.
+func (d *Document) Pattern(r *http.Request) string {
+	if m := d.find(r); m != nil {
+		return m.r.Pattern
+	}
+	return ``
+}
+
+// ServeHTTP serves the document as it was loaded.
+func (d *Document) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.Write(d.raw)
+}
@@ -0,0 +1,206 @@
+package openapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const doc = `{
+  "openapi": "3.0.3",
+  "info": {"title": "test", "version": "1"},
+  "paths": {
+    "/items": {
+      "get": {
+        "parameters": [
+          {"name": "skip", "in": "query", "required": true, "schema": {"type": "integer", "minimum": 0}},
+          {"name": "order", "in": "query", "schema": {"type": "string", "enum": ["asc", "desc"]}}
+        ],
+        "responses": {
+          "200": {"description": "items", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Item"}}}}},
+          "4XX": {"description": "error", "content": {"text/plain": {"schema": {"type": "string"}}}}
+        }
+      },
+      "post": {
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Item"}}}},
+        "responses": {"204": {"description": "added"}}
+      }
+    },
+    "/files": {
+      "put": {
+        "requestBody": {"required": true, "content": {"image/*": {}, "application/json": {"schema": {"type": "object"}}}},
+        "responses": {"204": {"description": "stored"}}
+      }
+    },
+    "/blobs": {
+      "put": {
+        "requestBody": {"content": {"*/*": {}}},
+        "responses": {"204": {"description": "stored"}}
+      }
+    },
+    "/items/{id}": {
+      "get": {
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer"}}],
+        "responses": {"default": {"description": "anything"}}
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Item": {
+        "type": "object",
+        "required": ["name"],
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "minLength": 1},
+          "count": {"type": "integer", "nullable": true}
+        }
+      }
+    }
+  }
+}`
+
+func load(t *testing.T) *Document {
+	t.Helper()
+	d, err := Load([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestLoadRejects(t *testing.T) {
+	for name, bad := range map[string]string{
+		`version`:       `{"openapi": "3.1.0", "info": {}, "paths": {}}`,
+		`unknown field`: `{"openapi": "3.0.3", "info": {}, "paths": {}, "webhooks": {}}`,
+		`keyword`:       `{"openapi": "3.0.3", "info": {}, "paths": {"/a": {"get": {"responses": {"200": {"description": "", "content": {"application/json": {"schema": {"pattern": "x"}}}}}}}}}`,
+		`ref`:           `{"openapi": "3.0.3", "info": {}, "paths": {"/a": {"get": {"responses": {"200": {"description": "", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Nope"}}}}}}}}}`,
+		`no responses`:  `{"openapi": "3.0.3", "info": {}, "paths": {"/a": {"get": {}}}}`,
+		`object param`:  `{"openapi": "3.0.3", "info": {}, "paths": {"/a": {"get": {"parameters": [{"name": "p", "in": "query", "schema": {"type": "object"}}], "responses": {"200": {"description": ""}}}}}}`,
+		`cookie param`:  `{"openapi": "3.0.3", "info": {}, "paths": {"/a": {"get": {"parameters": [{"name": "p", "in": "cookie", "schema": {"type": "string"}}], "responses": {"200": {"description": ""}}}}}}`,
+		`method`:        `{"openapi": "3.0.3", "info": {}, "paths": {"/a": {"fetch": {"responses": {"200": {"description": ""}}}}}}`,
+	} {
+		if _, err := Load([]byte(bad)); err == nil {
+			t.Errorf(`%s: Load succeeded`, name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	d := load(t)
+	reached := 0
+	h := d.Validate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached++
+		// The body can still be read after validation.
+		if r.Method == http.MethodPost || r.URL.Path == `/files` {
+			if b, _ := io.ReadAll(r.Body); len(b) == 0 {
+				t.Error(`handler got an empty body`)
+			}
+		}
+	}))
+
+	for _, c := range []struct {
+		method, target, contentType, body string
+		want                              int
+	}{
+		{`GET`, `/items?skip=0`, ``, ``, 200},
+		{`GET`, `/items?skip=2&order=desc`, ``, ``, 200},
+		{`GET`, `/items`, ``, ``, 400},
+		{`GET`, `/items?skip=x`, ``, ``, 400},
+		{`GET`, `/items?skip=-1`, ``, ``, 400},
+		{`GET`, `/items?skip=1.5`, ``, ``, 400},
+		{`GET`, `/items?skip=0&order=up`, ``, ``, 400},
+		{`GET`, `/items/7`, ``, ``, 200},
+		{`GET`, `/items/seven`, ``, ``, 400},
+		{`POST`, `/items`, `application/json`, `{"name": "a", "count": null}`, 200},
+		{`POST`, `/items`, `application/json; charset=utf-8`, `{"name": "a", "count": 2}`, 200},
+		{`POST`, `/items`, `application/json`, ``, 400},
+		{`POST`, `/items`, `application/json`, `{"name": ""}`, 400},
+		{`POST`, `/items`, `application/json`, `{"count": 1}`, 400},
+		{`POST`, `/items`, `application/json`, `{"name": "a", "extra": 1}`, 400},
+		{`POST`, `/items`, `application/json`, `{"name": "a", "count": 1.5}`, 400},
+		{`POST`, `/items`, `application/json`, `{"name": "a"} {}`, 400},
+		{`POST`, `/items`, `application/json`, `{"name": `, 400},
+		{`POST`, `/items`, `text/plain`, `{"name": "a"}`, 415},
+		{`POST`, `/items`, ``, `{"name": "a"}`, 415},
+		{`POST`, `/items`, `application/json`, `{"name": "` + strings.Repeat(`a`, maxBody) + `"}`, 413},
+		// Other bodies are matched by media range and not read.
+		{`PUT`, `/files`, `image/png`, strings.Repeat(`x`, 2*maxBody), 200},
+		{`PUT`, `/files`, `video/mp4`, `x`, 415},
+		{`PUT`, `/files`, `image/png`, ``, 400},
+		{`PUT`, `/blobs`, ``, `x`, 200},
+		{`PUT`, `/blobs`, ``, ``, 200},
+		// Routes the document doesn't describe are left to the handler.
+		{`GET`, `/other`, ``, ``, 200},
+		{`DELETE`, `/items`, ``, ``, 200},
+	} {
+		reached = 0
+		req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+		if c.contentType != `` {
+			req.Header.Set(`Content-Type`, c.contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != c.want || (reached == 1) != (c.want == 200) {
+			t.Errorf(`%s %s %s: %d, reached %d times; want %d (%s)`, c.method, c.target, c.body, w.Code, reached, c.want, w.Body)
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	d := load(t)
+	respond := func(status int, contentType, body string) *http.Response {
+		w := httptest.NewRecorder()
+		if contentType != `` {
+			w.Header().Set(`Content-Type`, contentType)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+		return w.Result()
+	}
+	get := httptest.NewRequest(`GET`, `/items?skip=0`, nil)
+	post := httptest.NewRequest(`POST`, `/items`, nil)
+
+	for _, c := range []struct {
+		req  *http.Request
+		resp *http.Response
+		ok   bool
+	}{
+		{get, respond(200, `application/json`, `[{"name": "a"}, {"name": "b", "count": 2}]`), true},
+		{get, respond(200, `application/json`, `[]`), true},
+		{get, respond(200, `application/json`, `null`), false},
+		{get, respond(200, `application/json`, `[{"count": 2}]`), false},
+		{get, respond(200, `text/plain; charset=utf-8`, `[]`), false},
+		{get, respond(404, `text/plain; charset=utf-8`, `not found`), true},
+		{get, respond(500, `text/plain`, `oops`), false},
+		{post, respond(204, ``, ``), true},
+		{post, respond(204, `application/json`, `{}`), false},
+		{httptest.NewRequest(`GET`, `/items/1`, nil), respond(418, ``, ``), true},
+		{httptest.NewRequest(`GET`, `/other`, nil), respond(200, ``, ``), false},
+	} {
+		err := d.CheckResponse(c.req, c.resp)
+		if (err == nil) != c.ok {
+			t.Errorf(`%s %s %d: CheckResponse = %v, want ok %t`, c.req.Method, c.req.URL, c.resp.StatusCode, err, c.ok)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	d := load(t)
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest(`GET`, `/openapi.json`, nil))
+	if w.Body.String() != doc || w.Header().Get(`Content-Type`) != `application/json` {
+		t.Errorf(`served %s %q`, w.Header().Get(`Content-Type`), w.Body)
+	}
+	if got, want := d.Operations(), []string{`GET /items`, `GET /items/{id}`, `POST /items`, `PUT /blobs`, `PUT /files`}; !slices.Equal(got, want) {
+		t.Errorf(`Operations = %v, want %v`, got, want)
+	}
+	for target, want := range map[string]string{`/items/7`: `GET /items/{id}`, `/nope`: ``} {
+		if got := d.Pattern(httptest.NewRequest(`GET`, target, nil)); got != want {
+			t.Errorf(`Pattern(GET %s) = %q, want %q`, target, got, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is the subset of an OpenAPI 3.0 schema object that is checked.
+// Description, Format and Example are documentation only.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Example              any                `json:"example,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+
+	// ref is the component Ref points to.
+	ref *Schema
+}
+
+var types = map[string]bool{
+	``: true, `object`: true, `array`: true, `string`: true, `integer`: true, `number`: true, `boolean`: true,
+}
+
+// resolve points s's references, and its children's, at the document's
+// components, and rejects types it can't check.
+func (d *Document) resolve(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != `` {
+		name, ok := strings.CutPrefix(s.Ref, `#/components/schemas/`)
+		if !ok || d.Components.Schemas[name] == nil {
+			return fmt.Errorf(`unresolved $ref %q`, s.Ref)
+		}
+		if d.Components.Schemas[name].Ref != `` {
+			return fmt.Errorf(`$ref %q: refers to another $ref`, s.Ref)
+		}
+		s.ref = d.Components.Schemas[name]
+		return nil
+	}
+	if !types[s.Type] {
+		return fmt.Errorf(`unsupported type %q`, s.Type)
+	}
+	if len(s.Enum) > 0 && s.Type != `string` {
+		return fmt.Errorf(`enum is only supported on strings`)
+	}
+	if err := d.resolve(s.Items); err != nil {
+		return err
+	}
+	for _, p := range s.Properties {
+		if err := d.resolve(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) target() *Schema {
+	if s.ref != nil {
+		return s.ref
+	}
+	return s
+}
+
+func (s *Schema) scalar() bool {
+	switch s.target().Type {
+	case `string`, `integer`, `number`, `boolean`:
+		return true
+	}
+	return false
+}
+
+// parse converts a parameter's text to the value its schema describes, and
+// validates it.
+func (s *Schema) parse(text, at string) error {
+	s = s.target()
+	var v any = text
+	switch s.Type {
+	case `integer`, `number`:
+		if _, err := strconv.ParseFloat(text, 64); err != nil {
+			return fmt.Errorf(`%s: %q is not a number`, at, text)
+		}
+		v = json.Number(text)
+	case `boolean`:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf(`%s: %q is not a boolean`, at, text)
+		}
+		v = b
+	}
+	return s.validate(v, at)
+}
+
+// validate checks v, decoded from JSON with numbers kept as json.Number,
+// against s. at says where v came from, for errors.
+func (s *Schema) validate(v any, at string) error {
+	s = s.target()
+	if v == nil {
+		if s.Nullable || s.Type == `` {
+			return nil
+		}
+		return fmt.Errorf(`%s: must not be null`, at)
+	}
+
+	switch s.Type {
+	case `object`:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf(`%s: must be an object`, at)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf(`%s.%s: is required`, at, name)
+			}
+		}
+		for name, value := range obj {
+			p, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf(`%s.%s: is not allowed`, at, name)
+				}
+				continue
+			}
+			if err := p.validate(value, at+`.`+name); err != nil {
+				return err
+			}
+		}
+
+	case `array`:
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf(`%s: must be an array`, at)
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				if err := s.Items.validate(item, fmt.Sprintf(`%s[%d]`, at, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case `string`:
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf(`%s: must be a string`, at)
+		}
+		n := utf8.RuneCountInString(str)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf(`%s: must be at least %d characters`, at, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf(`%s: must be at most %d characters`, at, *s.MaxLength)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, str) {
+			return fmt.Errorf(`%s: must be one of %s`, at, strings.Join(s.Enum, `, `))
+		}
+
+	case `integer`, `number`:
+		num, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf(`%s: must be a number`, at)
+		}
+		f, err := num.Float64()
+		if err != nil {
+			return fmt.Errorf(`%s: %w`, at, err)
+		}
+		if s.Type == `integer` {
+			if _, err := num.Int64(); err != nil {
+				return fmt.Errorf(`%s: must be an integer`, at)
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			return fmt.Errorf(`%s: must be at least %v`, at, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			return fmt.Errorf(`%s: must be at most %v`, at, *s.Maximum)
+		}
+
+	case `boolean`:
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf(`%s: must be a boolean`, at)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBody is the largest request body Validate reads.
+const maxBody = 1 << 20
+
+// Validate checks requests against the document before passing them to
+// next. A request breaking its operation's contract gets 400 Bad Request,
+// or 415 Unsupported Media Type for a body of an undocumented type, with
+// what was wrong. Requests the document doesn't describe pass through, for
+// next to answer.
+func (d *Document) Validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := d.find(r)
+		if m == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := checkParameters(m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body := m.op.RequestBody; body != nil {
+			if status, err := checkBody(r, body); err != nil {
+				http.Error(w, err.Error(), status)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func checkParameters(m *match) error {
+	query := m.r.URL.Query()
+	for _, p := range m.op.Parameters {
+		var text string
+		var found bool
+		switch p.In {
+		case `query`:
+			found = query.Has(p.Name)
+			text = query.Get(p.Name)
+		case `header`:
+			text = m.r.Header.Get(p.Name)
+			found = text != ``
+		case `path`:
+			text = m.r.PathValue(p.Name)
+			found = true
+		}
+		at := p.In + `.` + p.Name
+		if !found {
+			if p.Required {
+				return fmt.Errorf(`%s: is required`, at)
+			}
+			continue
+		}
+		if err := p.Schema.parse(text, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBody validates r's body. A JSON body is read, leaving it to be read
+// again; any other passes to the handler unread, so large uploads such as
+// videos are streamed rather than buffered.
+func checkBody(r *http.Request, spec *RequestBody) (int, error) {
+	if r.ContentLength == 0 {
+		if spec.Required {
+			return http.StatusBadRequest, errors.New(`body: is required`)
+		}
+		return 0, nil
+	}
+	media, isJSON, err := mediaType(r.Header.Get(`Content-Type`), spec.Content)
+	if err != nil {
+		return http.StatusUnsupportedMediaType, fmt.Errorf(`body: %w`, err)
+	}
+	if !isJSON {
+		return 0, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf(`body: %w`, err)
+	}
+	if len(data) > maxBody {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf(`body: larger than %d bytes`, maxBody)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	if len(data) == 0 && spec.Required {
+		return http.StatusBadRequest, errors.New(`body: is required`)
+	}
+	if err := checkContent(data, media, isJSON); err != nil {
+		return http.StatusBadRequest, err
+	}
+	return 0, nil
+}
+
+// mediaType returns what content says of contentType, matching ranges such
+// as video/* and */* too, and whether it is JSON. Only */* takes a body
+// without a Content-Type.
+func mediaType(contentType string, content map[string]*MediaType) (*MediaType, bool, error) {
+	if contentType == `` {
+		if media, ok := content[`*/*`]; ok {
+			return media, false, nil
+		}
+		return nil, false, errors.New(`no Content-Type`)
+	}
+	name, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false, fmt.Errorf(`Content-Type %q: %w`, contentType, err)
+	}
+	kind, _, _ := strings.Cut(name, `/`)
+	for _, key := range []string{name, kind + `/*`, `*/*`} {
+		if media, ok := content[key]; ok {
+			return media, name == `application/json` || strings.HasSuffix(name, `+json`), nil
+		}
+	}
+	return nil, false, fmt.Errorf(`Content-Type %s: not one of the documented types`, name)
+}
+
+// checkContent validates data against media's schema if it is JSON; other
+// bodies are taken as they come.
+func checkContent(data []byte, media *MediaType, isJSON bool) error {
+	if media.Schema == nil || !isJSON {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf(`body: %w`, err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return errors.New(`body: unexpected data after the JSON value`)
+	}
+	return media.Schema.validate(v, `body`)
+}
+
+// CheckResponse reports whether resp, the answer to r, keeps to r's
+// operation: a documented status, with a body of a documented type that
+// matches its schema, or no body if none is documented. Contract tests use
+// it on what the handlers write. resp.Body is left to be read again.
+func (d *Document) CheckResponse(r *http.Request, resp *http.Response) error {
+	m := d.find(r)
+	if m == nil {
+		return fmt.Errorf(`%s %s: not in the document`, r.Method, r.URL.Path)
+	}
+	at := fmt.Sprintf(`%s %s: %d`, r.Method, r.URL.Path, resp.StatusCode)
+	spec := response(m.op, resp.StatusCode)
+	if spec == nil {
+		return fmt.Errorf(`%s: status not documented`, at)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf(`%s: %w`, at, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	if len(spec.Content) == 0 {
+		if len(data) > 0 {
+			return fmt.Errorf(`%s: body not documented`, at)
+		}
+		return nil
+	}
+	media, isJSON, err := mediaType(resp.Header.Get(`Content-Type`), spec.Content)
+	if err != nil {
+		return fmt.Errorf(`%s: %w`, at, err)
+	}
+	if err := checkContent(data, media, isJSON); err != nil {
+		return fmt.Errorf(`%s: %w`, at, err)
+	}
+	return nil
+}
+
+// response returns op's response for status: its own, its range's such as
+// 4XX, or the default.
+func response(op *Operation, status int) *Response {
+	code := strconv.Itoa(status)
+	for _, key := range []string{code, code[:1] + `XX`, `default`} {
+		if resp, ok := op.Responses[key]; ok {
+			return resp
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Package tlsx serves the example-04 microservices over TLS, or h2c, and
+// reloads renewed certificates without a restart. example-02's services
+// use its certificate loading too.
+package tlsx
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/logging"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// Config turns on TLS for a service's HTTP server, and with it HTTP/2,
+// which clients pick by ALPN.
+type Config struct {
+	// CertFile and KeyFile hold the server's PEM certificate chain and key.
+	// Both empty serves plain HTTP.
+	CertFile string `env:"TLS_CERT_FILE"`
+	KeyFile  string `env:"TLS_KEY_FILE"`
+	// ClientCAFile, when set, requires clients to present a certificate
+	// signed by one of the CAs in it: mutual TLS.
+	ClientCAFile string `env:"TLS_CLIENT_CA_FILE"`
+	// ReloadInterval is how often the files are checked for a renewed
+	// certificate; 0 never reloads.
+	ReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL" default:"1m"`
+	// H2C serves HTTP/2 over plain connections as well as HTTP/1.1, for
+	// traffic that stays inside the cluster. It has no effect with TLS.
+	H2C bool `env:"HTTP_H2C" default:"false"`
+}
+
+// Enabled reports whether a certificate is configured.
+func (c Config) Enabled() bool {
+	return c.CertFile != `` || c.KeyFile != ``
+}
+
+// Validate checks settings that depend on each other.
+func (c Config) Validate() error {
+	if (c.CertFile == ``) != (c.KeyFile == ``) {
+		return errors.New(`TLS_CERT_FILE and TLS_KEY_FILE must be set together`)
+	}
+	if c.ClientCAFile != `` && !c.Enabled() {
+		return errors.New(`TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE`)
+	}
+	return nil
+}
+
+// Secure sets srv up as cfg asks: over TLS with certificates reloaded until
+// ctx is done, or with h2c wrapped around its handler. httpx.Serve then
+// runs it.
+func Secure(ctx context.Context, log *slog.Logger, srv *http.Server, cfg Config) error {
+	if !cfg.Enabled() {
+		if cfg.H2C {
+			srv.Handler = h2c.NewHandler(srv.Handler, &http2.Server{})
+		}
+		return nil
+	}
+	certs, err := LoadCertificates(cfg.CertFile, cfg.KeyFile, cfg.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	if cfg.ReloadInterval > 0 {
+		go certs.Watch(ctx, log, cfg.ReloadInterval)
+	}
+	srv.TLSConfig = certs.ServerConfig()
+	return nil
+}
+
+// Certificates holds a key pair, and optionally a pool of CAs to check
+// peers against, loaded from PEM files. Reload picks up renewed files, so
+// servers and clients using the configs below never need restarting.
+type Certificates struct {
+	certFile, keyFile, caFile string
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+	loaded [][]byte
+}
+
+// LoadCertificates loads the key pair in certFile and keyFile and, if caFile
+// isn't empty, the CAs in it.
+func LoadCertificates(certFile, keyFile, caFile string) (*Certificates, error) {
+	c := &Certificates{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if _, err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again and, if they have changed and still make a
+// valid key pair and pool, starts using them. It reports whether anything
+// changed; on error the certificates in use are kept.
+func (c *Certificates) Reload() (bool, error) {
+	var files [][]byte
+	for _, name := range []string{c.certFile, c.keyFile, c.caFile} {
+		if name == `` {
+			files = append(files, nil)
+			continue
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return false, fmt.Errorf(`tls: %w`, err)
+		}
+		files = append(files, data)
+	}
+
+	c.mu.RLock()
+	same := c.loaded != nil && bytes.Equal(files[0], c.loaded[0]) &&
+		bytes.Equal(files[1], c.loaded[1]) && bytes.Equal(files[2], c.loaded[2])
+	c.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if c.certFile != `` {
+		pair, err := tls.X509KeyPair(files[0], files[1])
+		if err != nil {
+			return false, fmt.Errorf(`tls: %s: %w`, c.certFile, err)
+		}
+		cert = &pair
+	}
+	var pool *x509.CertPool
+	if c.caFile != `` {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(files[2]) {
+			return false, fmt.Errorf(`tls: %s: no certificates found`, c.caFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert, c.pool, c.loaded = cert, pool, files
+	c.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the files every interval until ctx is done, logging what
+// happens. A broken renewal is logged and the old certificates kept.
+func (c *Certificates) Watch(ctx context.Context, log *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := c.Reload()
+		switch {
+		case err != nil:
+			log.ErrorContext(ctx, `reload certificates`, `cert`, c.certFile, logging.KeyError, err)
+		case changed:
+			log.InfoContext(ctx, `reloaded certificates`, `cert`, c.certFile)
+		}
+	}
+}
+
+func (c *Certificates) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, c.pool
+}
+
+// ServerConfig returns a server config presenting the current certificate
+// and offering HTTP/2. With a CA file, clients must present a certificate
+// signed by one of its CAs.
+func (c *Certificates) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{`h2`, `http/1.1`},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			return cert, nil
+		},
+		// Each handshake gets a config built from what's loaded now, so a
+		// reload changes the certificate and the client CAs together.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := c.current()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{`h2`, `http/1.1`},
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = pool
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns a client config presenting the current certificate
+// if the server asks for one, and checking the server's against the CA file
+// if there is one, or the system roots otherwise.
+func (c *Certificates) ClientConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert, _ := c.current(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+	if c.caFile == `` {
+		return cfg
+	}
+	// RootCAs is fixed once a config is in use, so the chain is checked by
+	// hand against whichever pool is loaded now instead.
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New(`tls: server presented no certificate`)
+		}
+		_, pool := c.current()
+		opts := x509.VerifyOptions{DNSName: cs.ServerName, Roots: pool, Intermediates: x509.NewCertPool()}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+	return cfg
+}
+
+// NewH2CTransport returns a transport speaking HTTP/2 over plain TCP to
+// servers set up with Config.H2C, for calls inside the cluster.
+func NewH2CTransport() *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
@@ -0,0 +1,221 @@
+package tlsx
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+	"bootstrapping-microservices-in-go/chapter-05/shared/tlsx/tlsxtest"
+)
+
+// whoAmI answers with the protocol and the client certificate's name.
+var whoAmI = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	client := `-`
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		client = r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	fmt.Fprintf(w, `%s %s`, r.Proto, client)
+})
+
+// serveTLS runs whoAmI set up by Secure with cfg through Serve, returning
+// its URL.
+func serveTLS(t *testing.T, cfg Config) string {
+	t.Helper()
+	// Serve listens on srv.Addr itself; borrow a free port for it.
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{Addr: addr, Handler: whoAmI}
+	if err := Secure(ctx, slog.New(slog.NewTextHandler(io.Discard, nil)), srv, cfg); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- httpx.Serve(ctx, srv) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial(`tcp`, addr); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(`server never started`)
+		}
+	}
+	return `https://` + addr
+}
+
+func get(c *http.Client, url string) (string, error) {
+	resp, err := c.Get(url)
+	if err != nil {
+		return ``, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlsxtest.NewCA(t)
+	certFile, keyFile := ca.Issue(t, dir, `server`, 2)
+	url := serveTLS(t, Config{CertFile: certFile, KeyFile: keyFile, ReloadInterval: 10 * time.Millisecond})
+
+	pool := ca.Pool()
+	var serial *big.Int
+	transport := &http.Transport{
+		ForceAttemptHTTP2: true,
+		TLSClientConfig: &tls.Config{RootCAs: pool, VerifyConnection: func(cs tls.ConnectionState) error {
+			serial = cs.PeerCertificates[0].SerialNumber
+			return nil
+		}},
+	}
+	client := &http.Client{Transport: transport}
+	if got, err := get(client, url); err != nil || got != `HTTP/2.0 -` {
+		t.Fatalf(`GET = %q, %v; want HTTP/2`, got, err)
+	}
+	if serial.Int64() != 2 {
+		t.Fatalf(`serial %d, want 2`, serial)
+	}
+
+	// A renewed certificate is picked up by new connections.
+	renewed, renewedKey := ca.Issue(t, t.TempDir(), `server`, 3)
+	for _, f := range [][2]string{{renewed, certFile}, {renewedKey, keyFile}} {
+		data, err := os.ReadFile(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsxtest.WriteFile(t, f[1], data)
+	}
+	for deadline := time.Now().Add(5 * time.Second); serial.Int64() != 3; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal(`renewed certificate never served`)
+		}
+		transport.CloseIdleConnections()
+		if _, err := get(client, url); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A broken renewal leaves the last good certificate in use.
+	tlsxtest.WriteFile(t, certFile, []byte(`not a certificate`))
+	time.Sleep(50 * time.Millisecond)
+	transport.CloseIdleConnections()
+	if _, err := get(client, url); err != nil || serial.Int64() != 3 {
+		t.Errorf(`after a broken renewal: serial %d, %v`, serial, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := tlsxtest.NewCA(t)
+	caFile := ca.WriteCA(t, dir)
+	certFile, keyFile := ca.Issue(t, dir, `history`, 2)
+	url := serveTLS(t, Config{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+
+	clientDir := t.TempDir()
+	clientCert, clientKey := ca.Issue(t, clientDir, `video-streaming`, 3)
+	certs, err := LoadCertificates(clientCert, clientKey, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &http.Transport{ForceAttemptHTTP2: true, TLSClientConfig: certs.ClientConfig()}
+	client := &http.Client{Transport: transport}
+	if got, err := get(client, url); err != nil || got != `HTTP/2.0 video-streaming` {
+		t.Errorf(`GET with a client certificate = %q, %v`, got, err)
+	}
+
+	// The client's certificate is reloaded too.
+	other, otherKey := ca.Issue(t, t.TempDir(), `admin`, 4)
+	for _, f := range [][2]string{{other, clientCert}, {otherKey, clientKey}} {
+		data, err := os.ReadFile(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsxtest.WriteFile(t, f[1], data)
+	}
+	if changed, err := certs.Reload(); !changed || err != nil {
+		t.Fatalf(`Reload = %t, %v`, changed, err)
+	}
+	transport.CloseIdleConnections()
+	if got, err := get(client, url); err != nil || got != `HTTP/2.0 admin` {
+		t.Errorf(`GET after reload = %q, %v`, got, err)
+	}
+
+	// Without a certificate, or with one from another CA, the handshake
+	// fails.
+	pool := ca.Pool()
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	if _, err := get(anonymous, url); err == nil {
+		t.Error(`GET without a client certificate succeeded`)
+	}
+	strangerCert, strangerKey := tlsxtest.NewCA(t).Issue(t, t.TempDir(), `stranger`, 5)
+	pair, err := tls.LoadX509KeyPair(strangerCert, strangerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{pair}}}}
+	if _, err := get(stranger, url); err == nil {
+		t.Error(`GET with another CA's certificate succeeded`)
+	}
+
+	// The client checks the server against the CA file, not the system.
+	wrongCA := tlsxtest.NewCA(t).WriteCA(t, t.TempDir())
+	distrustful, err := LoadCertificates(clientCert, clientKey, wrongCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(&http.Client{Transport: &http.Transport{TLSClientConfig: distrustful.ClientConfig()}}, url); err == nil {
+		t.Error(`GET trusting another CA succeeded`)
+	}
+}
+
+func TestH2C(t *testing.T) {
+	srv := &http.Server{Handler: whoAmI}
+	if err := Secure(context.Background(), slog.Default(), srv, Config{H2C: true}); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	if got, err := get(&http.Client{Transport: NewH2CTransport()}, ts.URL); err != nil || got != `HTTP/2.0 -` {
+		t.Errorf(`h2c GET = %q, %v`, got, err)
+	}
+	if got, err := get(ts.Client(), ts.URL); err != nil || got != `HTTP/1.1 -` {
+		t.Errorf(`HTTP/1.1 GET = %q, %v`, got, err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, bad := range []Config{
+		{CertFile: `a.crt`},
+		{KeyFile: `a.key`},
+		{ClientCAFile: `ca.crt`},
+	} {
+		if bad.Validate() == nil {
+			t.Errorf(`%+v passed Validate`, bad)
+		}
+	}
+	if err := (Config{CertFile: `a.crt`, KeyFile: `a.key`, ClientCAFile: `ca.crt`}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
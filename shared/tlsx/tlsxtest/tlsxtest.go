@@ -0,0 +1,108 @@
+// Package tlsxtest issues throwaway certificates for tests of services
+// using tlsx.
+package tlsxtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// CA signs certificates valid for an hour either side of its creation.
+type CA struct {
+	Cert *x509.Certificate
+	// PEM is Cert encoded for a CA file.
+	PEM []byte
+	key *ecdsa.PrivateKey
+}
+
+// NewCA returns a new self-signed CA.
+func NewCA(t testing.TB) *CA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: `test CA`},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &CA{Cert: cert, PEM: pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der}), key: key}
+}
+
+// Pool returns a pool holding just the CA.
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// WriteCA writes the CA's certificate to ca.crt in dir and returns its
+// path.
+func (ca *CA) WriteCA(t testing.TB, dir string) string {
+	t.Helper()
+	name := filepath.Join(dir, `ca.crt`)
+	WriteFile(t, name, ca.PEM)
+	return name
+}
+
+// Issue writes a certificate for name, valid for 127.0.0.1 as a server
+// and as a client, and its key to dir, returning their paths.
+func (ca *CA) Issue(t testing.TB, dir, name string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+`.crt`), filepath.Join(dir, name+`.key`)
+	WriteFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: `CERTIFICATE`, Bytes: der}))
+	WriteFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: `EC PRIVATE KEY`, Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+// WriteFile writes data to name, failing t on error.
+func WriteFile(t testing.TB, name string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// AMQPHeaders adapts amqp.Table to a propagation.TextMapCarrier so the
+// traceparent travels inside amqp.Publishing.Headers.
+type AMQPHeaders amqp.Table
+
+var _ propagation.TextMapCarrier = AMQPHeaders{}
+
+// Get returns the value stored for key, or "" if it is missing.
+func (h AMQPHeaders) Get(key string) string {
+	switch v := h[key].(type) {
+	case nil:
+		return ``
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Set stores value under key.
+func (h AMQPHeaders) Set(key, value string) {
+	h[key] = value
+}
+
+// Keys lists every header name.
+func (h AMQPHeaders) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// InjectAMQP writes the span context carried by ctx into headers, allocating
+// the table if needed, and returns it.
+func InjectAMQP(ctx context.Context, headers amqp.Table) amqp.Table {
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, AMQPHeaders(headers))
+	return headers
+}
+
+// ExtractAMQP returns a copy of ctx carrying the remote span context found in
+// a delivery's headers.
+func ExtractAMQP(ctx context.Context, headers amqp.Table) context.Context {
+	if headers == nil {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, AMQPHeaders(headers))
+}
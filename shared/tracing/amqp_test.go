@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestAMQPPropagation carries a span from a publisher to a consumer in
+// message headers, as video-streaming and history do.
+func TestAMQPPropagation(t *testing.T) {
+	exporter := inMemory(t)
+
+	ctx, publish := Tracer(`test`).Start(context.Background(), `publish viewed`)
+	headers := InjectAMQP(ctx, nil)
+	publish.End()
+	if headers[`traceparent`] == nil {
+		t.Fatalf(`headers %v carry no traceparent`, headers)
+	}
+
+	// The broker may hand string headers back as bytes.
+	delivered := amqp.Table{`traceparent`: []byte(headers[`traceparent`].(string)), `x-other`: int32(1)}
+	consumeCtx := ExtractAMQP(context.Background(), delivered)
+	_, consume := Tracer(`test`).Start(consumeCtx, `consume viewed`)
+	consume.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf(`%d spans, want 2`, len(spans))
+	}
+	if spans[1].SpanContext.TraceID() != spans[0].SpanContext.TraceID() || spans[1].Parent.SpanID() != spans[0].SpanContext.SpanID() {
+		t.Error(`the consumer's span doesn't continue the publisher's`)
+	}
+	if !spans[1].Parent.IsRemote() {
+		t.Error(`the consumer's parent isn't remote`)
+	}
+
+	// Without headers nothing is extracted.
+	if trace.SpanContextFromContext(ExtractAMQP(context.Background(), nil)).IsValid() {
+		t.Error(`span extracted from nil headers`)
+	}
+	if got := AMQPHeaders(delivered).Get(`x-other`); got != `1` {
+		t.Errorf(`Get(x-other) = %q, want 1`, got)
+	}
+}
@@ -0,0 +1,53 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"bootstrapping-microservices-in-go/chapter-05/shared/httpx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts a server span for every request, continuing any trace
+// whose traceparent arrived in the request headers.
+func Middleware(next http.Handler) http.Handler {
+	tracer := Tracer(`net/http`)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := httpx.NewRecorder(w)
+		r = r.WithContext(ctx)
+		next.ServeHTTP(rec, r)
+
+		// The mux fills in the matched pattern while routing, so the
+		// low-cardinality span name is only known afterwards.
+		if route := rec.Route(r); route != `` {
+			span.SetName(route)
+			span.SetAttributes(attribute.String(`http.route`, route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.Status))
+		if rec.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status))
+		}
+	})
+}
+
+// InjectHTTP writes the span context carried by ctx into outgoing request
+// headers.
+func InjectHTTP(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
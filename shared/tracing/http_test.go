@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddleware(t *testing.T) {
+	exporter := inMemory(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(`GET /videos/{id}`, func(w http.ResponseWriter, r *http.Request) {
+		if !trace.SpanContextFromContext(r.Context()).IsValid() {
+			t.Error(`handler context carries no span`)
+		}
+		if r.PathValue(`id`) == `broken` {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	handler := Middleware(mux)
+
+	// A caller's trace is continued.
+	const traceID = `4bf92f3577b34da6a3ce929d0e0e4736`
+	req := httptest.NewRequest(http.MethodGet, `/videos/1`, nil)
+	req.Header.Set(`traceparent`, `00-`+traceID+`-00f067aa0ba902b7-01`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, `/videos/broken`, nil))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf(`%d spans, want 2`, len(spans))
+	}
+	continued, failed := spans[0], spans[1]
+	if got := continued.SpanContext.TraceID().String(); got != traceID {
+		t.Errorf(`trace ID %s, want the caller's %s`, got, traceID)
+	}
+	if !continued.Parent.IsRemote() || continued.Parent.SpanID().String() != `00f067aa0ba902b7` {
+		t.Errorf(`parent %v, want the caller's span`, continued.Parent)
+	}
+	if continued.Name != `GET /videos/{id}` || continued.SpanKind != trace.SpanKindServer {
+		t.Errorf(`span %q kind %v, want the route as a server span`, continued.Name, continued.SpanKind)
+	}
+	if !hasAttribute(continued.Attributes, attribute.Int(`http.response.status_code`, 200)) {
+		t.Errorf(`attributes %v lack status 200`, continued.Attributes)
+	}
+	if failed.Parent.IsValid() {
+		t.Error(`a request without traceparent has a parent`)
+	}
+	if failed.Status.Code != codes.Error {
+		t.Errorf(`5xx span status %v, want Error`, failed.Status)
+	}
+}
+
+func TestInjectHTTP(t *testing.T) {
+	inMemory(t)
+	ctx, span := Tracer(`test`).Start(context.Background(), `call history`)
+	defer span.End()
+
+	header := http.Header{}
+	InjectHTTP(ctx, header)
+	want := `00-` + span.SpanContext().TraceID().String() + `-` + span.SpanContext().SpanID().String() + `-01`
+	if got := header.Get(`traceparent`); got != want {
+		t.Errorf(`traceparent %q, want %q`, got, want)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, a := range attrs {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
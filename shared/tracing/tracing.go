@@ -0,0 +1,96 @@
+// Package tracing wires OpenTelemetry into the example-04 microservices so a
+// single view can be followed from GET /video, through RabbitMQ, into the
+// history and recommendations consumers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporter names accepted by OTEL_TRACES_EXPORTER.
+const (
+	ExporterNone   = `none`
+	ExporterStdout = `stdout`
+	ExporterOTLP   = `otlp`
+)
+
+// Config selects where finished spans are sent; see package config.
+// Tracing is disabled by default.
+type Config struct {
+	// ServiceName is reported as the service.name resource attribute.
+	ServiceName string
+	// Exporter is one of ExporterNone, ExporterStdout or ExporterOTLP.
+	Exporter string `env:"OTEL_TRACES_EXPORTER" default:"none" validate:"oneof=none stdout otlp"`
+	// Endpoint is the OTLP/HTTP collector URL, e.g. http://collector:4318.
+	Endpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT" validate:"url"`
+}
+
+// Setup installs a global tracer provider and the W3C trace-context
+// propagator. The returned function flushes pending spans and must be called
+// before the process exits.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	// Always propagate, even when we don't export, so that a service with
+	// tracing switched off doesn't break the chain for its neighbours.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// Schemaless, as the SDK's defaults follow a newer semconv schema than
+	// the attributes used here and Merge refuses to mix the two.
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(cfg.ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf(`tracing resource: %w`, err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case ``, ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != `` {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf(`unknown trace exporter %q`, cfg.Exporter)
+	}
+}
+
+// Tracer returns the named tracer from the global provider.
+func Tracer(name string) trace.Tracer {
+	return otel.Tracer(name)
+}
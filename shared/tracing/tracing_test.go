@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// inMemory installs a tracer provider recording finished spans in memory,
+// and the propagator Setup installs, for the length of the test.
+func inMemory(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevPropagator := otel.GetTextMapPropagator()
+	if _, err := Setup(context.Background(), Config{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return exporter
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	if _, err := Setup(context.Background(), Config{Exporter: `jaeger`}); err == nil {
+		t.Error(`Setup accepted an unknown exporter`)
+	}
+	// Setup installs the W3C propagator even when nothing is exported.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	if _, err := Setup(context.Background(), Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if fields := otel.GetTextMapPropagator().Fields(); !slices.Contains(fields, `traceparent`) {
+		t.Errorf(`propagator fields %v lack traceparent`, fields)
+	}
+}
+
+// TestOTLPExport sends a span through Setup's OTLP exporter to a collector
+// running in the test.
+func TestOTLPExport(t *testing.T) {
+	received := make(chan *coltracepb.ExportTraceServiceRequest, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != `/v1/traces` || r.Header.Get(`Content-Type`) != `application/x-protobuf` {
+			t.Errorf(`collector got %s %s`, r.URL.Path, r.Header.Get(`Content-Type`))
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var req coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+			return
+		}
+		received <- &req
+		w.Header().Set(`Content-Type`, `application/x-protobuf`)
+		out, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+		w.Write(out)
+	}))
+	defer collector.Close()
+
+	prevPropagator := otel.GetTextMapPropagator()
+	defer otel.SetTextMapPropagator(prevPropagator)
+	shutdown, err := Setup(context.Background(), Config{ServiceName: `history`, Exporter: ExporterOTLP, Endpoint: collector.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := Tracer(`test`).Start(context.Background(), `consume viewed`)
+	span.End()
+	// Shutting down flushes the batch.
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var req *coltracepb.ExportTraceServiceRequest
+	select {
+	case req = <-received:
+	default:
+		t.Fatal(`the collector received nothing`)
+	}
+	if len(req.ResourceSpans) != 1 {
+		t.Fatalf(`%d resource spans, want 1`, len(req.ResourceSpans))
+	}
+	rs := req.ResourceSpans[0]
+	var service string
+	for _, attr := range rs.GetResource().GetAttributes() {
+		if attr.GetKey() == `service.name` {
+			service = attr.GetValue().GetStringValue()
+		}
+	}
+	if service != `history` {
+		t.Errorf(`service.name %q, want history`, service)
+	}
+	if len(rs.ScopeSpans) != 1 || len(rs.ScopeSpans[0].Spans) != 1 || rs.ScopeSpans[0].Spans[0].GetName() != `consume viewed` {
+		t.Errorf(`exported %v, want one span named "consume viewed"`, rs.ScopeSpans)
+	}
+}